@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/mkevac/markodownloadbot/stats"
+)
+
+// Config keys of the lists changed with /access, which take precedence
+// over ALLOWED_USERS and BLOCKED_USERS.
+const (
+	allowedUsersConfigKey = "allowed_users"
+	blockedUsersConfigKey = "blocked_users"
+)
+
+// userList holds usernames, lowercased and without the @, and numeric user
+// IDs.
+type userList struct {
+	mu      sync.RWMutex
+	entries map[string]bool
+}
+
+func newUserList() *userList {
+	return &userList{entries: make(map[string]bool)}
+}
+
+// normalizeUserEntry turns "@Name" into "name". IDs stay as they are.
+func normalizeUserEntry(entry string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(entry), "@"))
+}
+
+// Set replaces the list with the comma-separated entries in value.
+func (l *userList) Set(value string) {
+	entries := make(map[string]bool)
+	for _, entry := range splitList(value) {
+		if entry = normalizeUserEntry(entry); entry != "" {
+			entries[entry] = true
+		}
+	}
+
+	l.mu.Lock()
+	l.entries = entries
+	l.mu.Unlock()
+}
+
+func (l *userList) Add(entry string) {
+	l.mu.Lock()
+	l.entries[normalizeUserEntry(entry)] = true
+	l.mu.Unlock()
+}
+
+// Remove deletes entry and reports whether it was there.
+func (l *userList) Remove(entry string) bool {
+	entry = normalizeUserEntry(entry)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	found := l.entries[entry]
+	delete(l.entries, entry)
+	return found
+}
+
+func (l *userList) Empty() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.entries) == 0
+}
+
+// Contains reports whether the user is on the list by username or ID.
+func (l *userList) Contains(user *models.User) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if user.Username != "" && l.entries[strings.ToLower(user.Username)] {
+		return true
+	}
+	return user.ID != 0 && l.entries[strconv.FormatInt(user.ID, 10)]
+}
+
+// String returns the entries sorted and comma-separated, as Set reads them.
+func (l *userList) String() string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	entries := make([]string, 0, len(l.entries))
+	for entry := range l.entries {
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+var (
+	allowedUsers = newUserList()
+	blockedUsers = newUserList()
+)
+
+// loadAccessLists sets the allowlist and blocklist from ALLOWED_USERS and
+// BLOCKED_USERS, unless the admin changed them with /access before a
+// restart.
+func loadAccessLists() {
+	for _, list := range []struct {
+		users  *userList
+		env    string
+		config string
+	}{
+		{allowedUsers, "ALLOWED_USERS", allowedUsersConfigKey},
+		{blockedUsers, "BLOCKED_USERS", blockedUsersConfigKey},
+	} {
+		value := os.Getenv(list.env)
+		if saved, ok := stats.GetConfig(list.config); ok {
+			log.Printf("Using %s changed with /access", strings.ToLower(list.env))
+			value = saved
+		}
+		list.users.Set(value)
+	}
+
+	if !allowedUsers.Empty() {
+		log.Printf("Only these users may download: %s", allowedUsers)
+	}
+}
+
+// saveAccessLists persists both lists so they survive restarts.
+func saveAccessLists() error {
+	if err := stats.SetConfig(allowedUsersConfigKey, allowedUsers.String()); err != nil {
+		return err
+	}
+	return stats.SetConfig(blockedUsersConfigKey, blockedUsers.String())
+}
+
+// isAdminUser reports whether user is the configured admin. Nobody is when
+// ADMIN_USERNAME isn't set.
+func isAdminUser(user *models.User) bool {
+	return adminUsername != "" && user.Username == adminUsername
+}
+
+// canDownload reports whether user may download: the admin always can,
+// blocked users never, and with an allowlist only the users on it.
+func canDownload(user *models.User) bool {
+	if isAdminUser(user) {
+		return true
+	}
+	if blockedUsers.Contains(user) {
+		return false
+	}
+	return allowedUsers.Empty() || allowedUsers.Contains(user)
+}
+
+func accessText() string {
+	allowed := allowedUsers.String()
+	if allowed == "" {
+		allowed = "everyone"
+	}
+	blocked := blockedUsers.String()
+	if blocked == "" {
+		blocked = "nobody"
+	}
+	return fmt.Sprintf("Allowed: %s\nBlocked: %s", allowed, blocked)
+}
+
+// accessHandler shows and changes the allowlist and blocklist:
+// "/access allow <user>", "/access block <user>" and "/access remove
+// <user>", where user is a username or a numeric user ID.
+func accessHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Printf("[%s]: received access command", update.Message.From.Username)
+
+	if !requireAdmin(ctx, b, update, "/access") {
+		return
+	}
+
+	args := strings.Fields(strings.TrimPrefix(update.Message.Text, "/access"))
+
+	var text string
+	switch {
+	case len(args) == 0:
+		text = accessText()
+	case len(args) != 2 || normalizeUserEntry(args[1]) == "":
+		text = "Usage: /access, /access allow <user>, /access block <user> or /access remove <user>. A user is a username or a numeric user ID."
+	default:
+		action, user := args[0], args[1]
+		switch action {
+		case "allow":
+			blockedUsers.Remove(user)
+			allowedUsers.Add(user)
+		case "block":
+			allowedUsers.Remove(user)
+			blockedUsers.Add(user)
+		case "remove":
+			allowedUsers.Remove(user)
+			blockedUsers.Remove(user)
+		default:
+			text = fmt.Sprintf("Unknown action '%s', use allow, block or remove.", action)
+		}
+
+		if text == "" {
+			log.Printf("[%s]: access %s %s", update.Message.From.Username, action, user)
+			text = accessText()
+			if err := saveAccessLists(); err != nil {
+				log.Printf("Error saving access lists: %s", err)
+				text += "\n\nThe change applies until the bot restarts, it couldn't be saved."
+			}
+		}
+	}
+
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   text,
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+)
+
+const (
+	defaultAckTemplate      = "I will download the {media} and send it to you shortly."
+	defaultAckTitleTemplate = "Downloading '{title}'..."
+)
+
+// ackEmojis maps hosts to an emoji that starts the acknowledgement. Hosts
+// without one get the plain message.
+var ackEmojis = map[string]string{
+	"youtube.com":   "▶️",
+	"youtu.be":      "▶️",
+	"tiktok.com":    "🎵",
+	"instagram.com": "📸",
+	"vimeo.com":     "🎬",
+	"twitter.com":   "🐦",
+	"x.com":         "🐦",
+}
+
+// loadAckEmojis merges ACK_EMOJIS, a JSON object mapping hosts to emojis,
+// over the built-in ones. An empty emoji removes a built-in entry.
+func loadAckEmojis() {
+	value := os.Getenv("ACK_EMOJIS")
+	if value == "" {
+		return
+	}
+
+	var custom map[string]string
+	if err := json.Unmarshal([]byte(value), &custom); err != nil {
+		log.Printf("Invalid ACK_EMOJIS, using built-in emojis: %s", err)
+		return
+	}
+
+	for host, emoji := range custom {
+		host = strings.ToLower(host)
+		if emoji == "" {
+			delete(ackEmojis, host)
+		} else {
+			ackEmojis[host] = emoji
+		}
+	}
+}
+
+// ackMessage renders the acknowledgement sent before a download starts.
+// The title template is used only when metadata with a title is available,
+// and the message starts with the emoji of the link's platform, if any.
+func ackMessage(mediaType string, meta *Metadata, host string) string {
+	template := ackTemplate
+	title := ""
+
+	if meta != nil && meta.Title != "" {
+		template = ackTitleTemplate
+		title = truncateCaption(meta.Title)
+	}
+
+	text := strings.NewReplacer("{media}", mediaType, "{title}", title).Replace(template)
+
+	if emoji, ok := matchHost(host, ackEmojis); ok {
+		text = emoji + " " + text
+	}
+
+	return text
+}
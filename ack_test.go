@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAckMessage(t *testing.T) {
+	oldAck, oldTitle, oldCaption := ackTemplate, ackTitleTemplate, maxCaptionLength
+	defer func() { ackTemplate, ackTitleTemplate, maxCaptionLength = oldAck, oldTitle, oldCaption }()
+	ackTemplate, ackTitleTemplate = defaultAckTemplate, defaultAckTitleTemplate
+	maxCaptionLength = telegramCaptionLimit
+
+	tests := []struct {
+		name      string
+		mediaType string
+		meta      *Metadata
+		want      string
+	}{
+		{"no metadata", "video", nil, "I will download the video and send it to you shortly."},
+		{"audio", "audio", nil, "I will download the audio and send it to you shortly."},
+		{"no title", "video", &Metadata{}, "I will download the video and send it to you shortly."},
+		{"title", "video", &Metadata{Title: "Cats"}, "Downloading 'Cats'..."},
+	}
+
+	for _, tt := range tests {
+		if got := ackMessage(tt.mediaType, tt.meta, "example.org"); got != tt.want {
+			t.Errorf("%s: ackMessage = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAckMessageTemplates(t *testing.T) {
+	oldAck, oldTitle, oldCaption := ackTemplate, ackTitleTemplate, maxCaptionLength
+	defer func() { ackTemplate, ackTitleTemplate, maxCaptionLength = oldAck, oldTitle, oldCaption }()
+	ackTemplate = "Getting your {media}"
+	ackTitleTemplate = "Getting {title} as {media}"
+	maxCaptionLength = 10
+
+	if got, want := ackMessage("audio", nil, "example.org"), "Getting your audio"; got != want {
+		t.Errorf("ackMessage = %q, want %q", got, want)
+	}
+
+	got := ackMessage("video", &Metadata{Title: strings.Repeat("long title ", 10)}, "example.org")
+	if want := "Getting long titl… as video"; got != want {
+		t.Errorf("ackMessage with a long title = %q, want %q", got, want)
+	}
+}
+
+func TestAckMessageEmoji(t *testing.T) {
+	oldAck, oldTitle, oldEmojis := ackTemplate, ackTitleTemplate, ackEmojis
+	defer func() { ackTemplate, ackTitleTemplate, ackEmojis = oldAck, oldTitle, oldEmojis }()
+	ackTemplate, ackTitleTemplate = "Getting your {media}", defaultAckTitleTemplate
+	ackEmojis = map[string]string{"youtube.com": "▶️", "tiktok.com": "🎵", "vimeo.com": "🎬"}
+
+	t.Setenv("ACK_EMOJIS", `{"Example.com": "⭐", "tiktok.com": ""}`)
+	loadAckEmojis()
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"www.youtube.com", "▶️ Getting your video"},
+		{"vimeo.com", "🎬 Getting your video"},
+		{"example.com", "⭐ Getting your video"},
+		{"tiktok.com", "Getting your video"},
+		{"example.org", "Getting your video"},
+		{"", "Getting your video"},
+	}
+
+	for _, tt := range tests {
+		if got := ackMessage("video", nil, tt.host); got != tt.want {
+			t.Errorf("ackMessage for %q = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestLoadAckEmojisInvalid(t *testing.T) {
+	oldEmojis := ackEmojis
+	defer func() { ackEmojis = oldEmojis }()
+	ackEmojis = map[string]string{"youtube.com": "▶️"}
+
+	t.Setenv("ACK_EMOJIS", `{"youtube.com": `)
+	loadAckEmojis()
+
+	if len(ackEmojis) != 1 || ackEmojis["youtube.com"] != "▶️" {
+		t.Errorf("emojis after invalid ACK_EMOJIS: %v", ackEmojis)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Thresholds used by decideConcurrency. CPU load is the 1-minute load
+// average per CPU, memory is the fraction of memory in use.
+const (
+	highCPULoad     = 0.9
+	lowCPULoad      = 0.5
+	highMemoryUsage = 0.9
+	lowMemoryUsage  = 0.7
+)
+
+type loadSample struct {
+	CPU    float64
+	Memory float64
+}
+
+// decideConcurrency returns the new worker count given the current one and
+// the sampled host load. It steps down by one under pressure, up by one when
+// the host is idle, and always stays within [min, max].
+func decideConcurrency(current, min, max int, sample loadSample) int {
+	next := current
+
+	switch {
+	case sample.CPU > highCPULoad || sample.Memory > highMemoryUsage:
+		next = current - 1
+	case sample.CPU < lowCPULoad && sample.Memory < lowMemoryUsage:
+		next = current + 1
+	}
+
+	if next < min {
+		next = min
+	}
+	if next > max {
+		next = max
+	}
+
+	return next
+}
+
+// sampleLoad reads the host load from /proc.
+func sampleLoad() (loadSample, error) {
+	var sample loadSample
+
+	buf, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return sample, fmt.Errorf("error reading loadavg: %s", err)
+	}
+
+	fields := strings.Fields(string(buf))
+	if len(fields) == 0 {
+		return sample, fmt.Errorf("empty loadavg")
+	}
+
+	load, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return sample, fmt.Errorf("error parsing loadavg: %s", err)
+	}
+	sample.CPU = load / float64(runtime.NumCPU())
+
+	memory, err := readMemoryUsage()
+	if err != nil {
+		return sample, err
+	}
+	sample.Memory = memory
+
+	return sample, nil
+}
+
+func readMemoryUsage() (float64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, fmt.Errorf("error reading meminfo: %s", err)
+	}
+	defer f.Close()
+
+	var total, available float64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+
+		switch fields[0] {
+		case "MemTotal:":
+			total = value
+		case "MemAvailable:":
+			available = value
+		}
+	}
+
+	if total == 0 {
+		return 0, fmt.Errorf("MemTotal not found in meminfo")
+	}
+
+	return 1 - available/total, nil
+}
+
+// runAdaptiveConcurrency periodically takes a sample of the host load and
+// resizes l. main passes sampleLoad.
+func runAdaptiveConcurrency(ctx context.Context, l *limiter, min, max int, interval time.Duration, sample func() (loadSample, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			load, err := sample()
+			if err != nil {
+				log.Printf("Error sampling host load: %s", err)
+				continue
+			}
+
+			current := l.Limit()
+			next := decideConcurrency(current, min, max, load)
+			if next != current {
+				log.Printf("Adjusting concurrent downloads from %d to %d (cpu: %.2f, memory: %.2f)", current, next, load.CPU, load.Memory)
+				l.SetLimit(next)
+			}
+		}
+	}
+}
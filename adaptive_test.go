@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDecideConcurrency(t *testing.T) {
+	tests := []struct {
+		name    string
+		current int
+		sample  loadSample
+		want    int
+	}{
+		{"idle host steps up", 2, loadSample{CPU: 0.1, Memory: 0.3}, 3},
+		{"idle host stays at max", 4, loadSample{CPU: 0.1, Memory: 0.3}, 4},
+		{"busy cpu steps down", 3, loadSample{CPU: 1.5, Memory: 0.3}, 2},
+		{"busy memory steps down", 3, loadSample{CPU: 0.1, Memory: 0.95}, 2},
+		{"busy host stays at min", 1, loadSample{CPU: 2, Memory: 0.95}, 1},
+		{"moderate cpu holds", 3, loadSample{CPU: 0.7, Memory: 0.3}, 3},
+		{"moderate memory holds", 3, loadSample{CPU: 0.1, Memory: 0.8}, 3},
+		{"below min is raised", 0, loadSample{CPU: 0.7, Memory: 0.8}, 1},
+		{"above max is lowered", 9, loadSample{CPU: 0.7, Memory: 0.8}, 4},
+	}
+
+	for _, tt := range tests {
+		if got := decideConcurrency(tt.current, 1, 4, tt.sample); got != tt.want {
+			t.Errorf("%s: decideConcurrency(%d) = %d, want %d", tt.name, tt.current, got, tt.want)
+		}
+	}
+}
+
+func TestRunAdaptiveConcurrency(t *testing.T) {
+	l := newLimiter(2)
+
+	samples := make(chan loadSample)
+	sample := func() (loadSample, error) {
+		s, ok := <-samples
+		if !ok {
+			return loadSample{}, fmt.Errorf("no more samples")
+		}
+		return s, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runAdaptiveConcurrency(ctx, l, 1, 3, time.Millisecond, sample)
+		close(done)
+	}()
+
+	idle := loadSample{CPU: 0.1, Memory: 0.1}
+	busy := loadSample{CPU: 2, Memory: 0.1}
+	for _, step := range []struct {
+		sample loadSample
+		want   int
+	}{
+		{idle, 3},
+		{idle, 3},
+		{busy, 2},
+		{busy, 1},
+		{busy, 1},
+	} {
+		samples <- step.sample
+		// the next sample is only taken after this one was applied
+		samples <- loadSample{CPU: 0.7, Memory: 0.8}
+		if got := l.Limit(); got != step.want {
+			t.Fatalf("limit = %d, want %d", got, step.want)
+		}
+	}
+
+	cancel()
+	close(samples)
+	<-done
+}
+
+func TestRunAdaptiveConcurrencyWakesWaiters(t *testing.T) {
+	l := newLimiter(1)
+	if err := l.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		l.Acquire(context.Background())
+		close(acquired)
+	}()
+	waitForWaiters(t, l, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go runAdaptiveConcurrency(ctx, l, 1, 2, time.Millisecond, func() (loadSample, error) {
+		return loadSample{CPU: 0.1, Memory: 0.1}, nil
+	})
+
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiter not let in after the limit was raised")
+	}
+}
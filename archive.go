@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxArchiveNameLength bounds the title part of archived file names.
+const maxArchiveNameLength = 100
+
+// uploadTime parses yt-dlp's "YYYYMMDD" upload_date. It reports false when
+// the date is missing or malformed.
+func uploadTime(uploadDate string) (time.Time, bool) {
+	if uploadDate == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse("20060102", uploadDate)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// archiveName builds a file system friendly name from the media title,
+// falling back to the random download name.
+func archiveName(title string, fallback string) string {
+	name := strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		if r < 0x20 {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(title))
+
+	if runes := []rune(name); len(runes) > maxArchiveNameLength {
+		name = string(runes[:maxArchiveNameLength])
+	}
+	name = strings.Trim(name, ". ")
+	if name == "" {
+		return fallback
+	}
+	return name
+}
+
+// Archive moves the media into dir instead of deleting it. The file gets
+// the upload date as its modification time, when known, and a .txt sidecar
+// with the title and source URL. Subtitles are removed as in Delete.
+func (media *Media) Archive(dir string) (string, error) {
+	defer activeDownloads.Remove(media.randomName)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("error creating archive directory: %s", err)
+	}
+
+	ext := filepath.Ext(media.Path)
+	base := archiveName(media.Title, media.randomName)
+	dest := filepath.Join(dir, base+ext)
+	if _, err := os.Stat(dest); err == nil {
+		base = base + " " + media.randomName
+		dest = filepath.Join(dir, base+ext)
+	}
+
+	if err := moveFile(media.Path, dest); err != nil {
+		return "", fmt.Errorf("error moving file to archive: %s", err)
+	}
+
+	media.removeExtras()
+
+	sidecar := filepath.Join(dir, base+".txt")
+	if err := os.WriteFile(sidecar, []byte(media.Title+"\n"+media.url+"\n"), 0644); err != nil {
+		log.Printf("[%s]: error writing archive sidecar: %s", media.user, err)
+		sidecar = ""
+	}
+
+	if t, ok := uploadTime(media.UploadDate); ok {
+		for _, path := range []string{dest, sidecar} {
+			if path == "" {
+				continue
+			}
+			if err := os.Chtimes(path, t, t); err != nil {
+				log.Printf("[%s]: error setting archive file time: %s", media.user, err)
+			}
+		}
+	} else {
+		log.Printf("[%s]: no upload date for archived file, keeping the current time", media.user)
+	}
+
+	return dest, nil
+}
+
+// moveFile renames src to dst, copying when they are on different file
+// systems.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+// copyFile copies src to dst, removing dst if the copy fails.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadTime(t *testing.T) {
+	tests := []struct {
+		date string
+		want time.Time
+		ok   bool
+	}{
+		{"20240315", time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC), true},
+		{"", time.Time{}, false},
+		{"2024-03-15", time.Time{}, false},
+		{"20241315", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := uploadTime(tt.date)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("uploadTime(%q) = %s, %v, want %s, %v", tt.date, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestArchiveName(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"My Video", "My Video"},
+		{"  padded  ", "padded"},
+		{"a/b\\c:d*e?f\"g<h>i|j", "a_b_c_d_e_f_g_h_i_j"},
+		{"tab\there\nnewline", "tabherenewline"},
+		{"...", "fallback"},
+		{"", "fallback"},
+		{"ends with dots...", "ends with dots"},
+		{strings.Repeat("я", 150), strings.Repeat("я", maxArchiveNameLength)},
+	}
+
+	for _, tt := range tests {
+		if got := archiveName(tt.title, "fallback"); got != tt.want {
+			t.Errorf("archiveName(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestMediaArchive(t *testing.T) {
+	tmp := t.TempDir()
+	archive := filepath.Join(t.TempDir(), "archive")
+
+	newMedia := func(name string) *Media {
+		path := filepath.Join(tmp, name+".mp4")
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return &Media{user: "test", tmpDir: tmp, randomName: name, Path: path, Title: "Cats: the movie", UploadDate: "20240315", url: "https://example.com/cats"}
+	}
+
+	dest, err := newMedia("abc").Archive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(archive, "Cats_ the movie.mp4"); dest != want {
+		t.Errorf("archived to %s, want %s", dest, want)
+	}
+
+	info, err := os.Stat(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC); !info.ModTime().Equal(want) {
+		t.Errorf("archived file time = %s, want the upload date %s", info.ModTime(), want)
+	}
+
+	sidecar, err := os.ReadFile(filepath.Join(archive, "Cats_ the movie.txt"))
+	if err != nil || string(sidecar) != "Cats: the movie\nhttps://example.com/cats\n" {
+		t.Errorf("sidecar = %q, %v", sidecar, err)
+	}
+
+	// the same title again doesn't overwrite the first file
+	second := newMedia("def")
+	second.UploadDate = ""
+	dest, err = second.Archive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(archive, "Cats_ the movie def.mp4"); dest != want {
+		t.Errorf("second file archived to %s, want %s", dest, want)
+	}
+	if content, _ := os.ReadFile(filepath.Join(archive, "Cats_ the movie.mp4")); string(content) != "abc" {
+		t.Errorf("first archived file overwritten with %q", content)
+	}
+
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("files left in tmpDir: %v", entries)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// maxAudioLadderAttempts caps how many times audio is re-encoded while
+// stepping down the bitrate ladder.
+const maxAudioLadderAttempts = 4
+
+var defaultAudioBitrateLadder = []int{192, 128, 96, 64}
+
+// estimateAudioSize returns the approximate size in bytes of duration
+// seconds of audio at kbps, with some headroom for container overhead.
+func estimateAudioSize(kbps int, duration int) int64 {
+	return int64(float64(kbps) * 1000 / 8 * float64(duration) * 1.02)
+}
+
+// nextAudioBitrateIndex returns the index of the first bitrate in ladder,
+// starting at from, whose estimated size fits limit. When the duration is
+// unknown every step is a candidate. It returns -1 if no step fits.
+func nextAudioBitrateIndex(ladder []int, from int, duration int, limit int64) int {
+	for i := from; i < len(ladder); i++ {
+		if duration <= 0 || estimateAudioSize(ladder[i], duration) <= limit {
+			return i
+		}
+	}
+	return -1
+}
+
+// fitAudioToSize re-encodes the downloaded audio at decreasing bitrates
+// until it fits limit. The downloaded file is used as the source for every
+// attempt, so nothing is fetched again.
+func (media *Media) fitAudioToSize(ctx context.Context, limit int64) error {
+	size, err := media.GetFileSize()
+	if err != nil {
+		return err
+	}
+	if size <= limit {
+		return nil
+	}
+
+	log.Printf("[%s]: audio is %d bytes, over the %d bytes limit, reducing bitrate", media.user, size, limit)
+
+	if err := conversionLimiter.Acquire(ctx); err != nil {
+		return fmt.Errorf("gave up waiting for a conversion slot: %s", err)
+	}
+	defer conversionLimiter.Release()
+
+	source := media.Path
+	idx := 0
+	for attempt := 0; attempt < maxAudioLadderAttempts; attempt++ {
+		idx = nextAudioBitrateIndex(audioBitrateLadder, idx, int(media.Duration), limit)
+		if idx < 0 {
+			break
+		}
+
+		kbps := audioBitrateLadder[idx]
+		outputPath := filepath.Join(media.tmpDir, media.randomName+"_"+strconv.Itoa(kbps)+"k.mp3")
+
+		cmdSlice := []string{
+			"ffmpeg",
+			"-y",
+			"-i", source,
+			"-vn",
+			"-map_metadata", "0",
+			"-c:a", "libmp3lame",
+			"-b:a", strconv.Itoa(kbps) + "k",
+			outputPath,
+		}
+
+		if _, err := runCommand(ctx, media.user, cmdSlice); err != nil {
+			os.Remove(outputPath)
+			return fmt.Errorf("error re-encoding audio at %d kbps: %s", kbps, err)
+		}
+
+		info, err := os.Stat(outputPath)
+		if err != nil {
+			return fmt.Errorf("error getting file info: %s", err)
+		}
+
+		if info.Size() <= limit {
+			log.Printf("[%s]: audio re-encoded at %d kbps (%d bytes)", media.user, kbps, info.Size())
+			if err := os.Remove(source); err != nil {
+				log.Printf("error deleting original file: %s", err)
+			}
+			media.Path = outputPath
+			media.FileName = filepath.Base(outputPath)
+			media.ReducedBitrate = kbps
+			return nil
+		}
+
+		os.Remove(outputPath)
+		idx++
+	}
+
+	return fmt.Errorf("audio is too large to send even at a reduced bitrate")
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestEstimateAudioSize(t *testing.T) {
+	tests := []struct {
+		kbps, duration int
+		want           int64
+	}{
+		{128, 60, 979200},
+		{192, 3600, 88128000},
+		{64, 0, 0},
+	}
+
+	for _, tt := range tests {
+		if got := estimateAudioSize(tt.kbps, tt.duration); got != tt.want {
+			t.Errorf("estimateAudioSize(%d, %d) = %d, want %d", tt.kbps, tt.duration, got, tt.want)
+		}
+	}
+}
+
+func TestNextAudioBitrateIndex(t *testing.T) {
+	ladder := []int{192, 128, 96, 64}
+	const mb = 1024 * 1024
+
+	tests := []struct {
+		name     string
+		from     int
+		duration int
+		limit    int64
+		want     int
+	}{
+		{"first fits", 0, 60, 50 * mb, 0},
+		{"skips steps that can't fit", 0, 3600, 50 * mb, 2},
+		{"starts at from", 2, 60, 50 * mb, 2},
+		{"nothing fits", 0, 36000, 50 * mb, -1},
+		{"unknown duration tries every step", 1, 0, 1, 1},
+		{"past the end", 4, 0, 50 * mb, -1},
+	}
+
+	for _, tt := range tests {
+		if got := nextAudioBitrateIndex(ladder, tt.from, tt.duration, tt.limit); got != tt.want {
+			t.Errorf("%s: nextAudioBitrateIndex = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseIntList(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []int
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"192, 128,96", []int{192, 128, 96}, false},
+		{"128,,64", []int{128, 64}, false},
+		{"128,abc", nil, true},
+		{"128,0", nil, true},
+		{"-64", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseIntList(tt.value)
+		if (err != nil) != tt.wantErr || len(got) != len(tt.want) {
+			t.Errorf("parseIntList(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("parseIntList(%q) = %v, want %v", tt.value, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+// fakeEncoder is an ffmpeg stand-in that writes 10 bytes per kbps of the
+// requested audio bitrate to its output file.
+const fakeEncoder = `for arg; do
+	if [ "$prev" = "-b:a" ]; then kbps=${arg%k}; fi
+	prev=$arg
+	out=$arg
+done
+head -c $((kbps * 10)) /dev/zero > "$out"`
+
+func TestMediaFitAudioToSize(t *testing.T) {
+	oldLadder, oldConversions := audioBitrateLadder, conversionLimiter
+	defer func() { audioBitrateLadder, conversionLimiter = oldLadder, oldConversions }()
+	audioBitrateLadder = []int{192, 128, 96, 64}
+	conversionLimiter = newLimiter(1)
+
+	tests := []struct {
+		name     string
+		encoder  string
+		size     int
+		duration int
+		limit    int64
+		want     int
+		wantErr  string
+	}{
+		{"already fits", fakeEncoder, 5000, 0, 5000, 0, ""},
+		{"steps down", fakeEncoder, 5000, 0, 1000, 96, ""},
+		{"skips by estimate", fakeEncoder, 20000, 1, 17000, 128, ""},
+		{"never fits", fakeEncoder, 5000, 0, 500, 0, "too large"},
+		{"encoder fails", "exit 1", 5000, 0, 1000, 0, "192 kbps"},
+	}
+
+	for _, tt := range tests {
+		fakeCommand(t, "ffmpeg", tt.encoder)
+
+		dir := t.TempDir()
+		source := filepath.Join(dir, "abc.mp3")
+		if err := os.WriteFile(source, make([]byte, tt.size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		media := &Media{user: "test", tmpDir: dir, randomName: "abc", Path: source, FileName: "abc.mp3", Duration: CustomDuration(tt.duration)}
+
+		err := media.fitAudioToSize(context.Background(), tt.limit)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: fitAudioToSize error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+		} else if err != nil {
+			t.Errorf("%s: fitAudioToSize: %s", tt.name, err)
+		}
+
+		if media.ReducedBitrate != tt.want {
+			t.Errorf("%s: reduced bitrate = %d, want %d", tt.name, media.ReducedBitrate, tt.want)
+		}
+		if tt.want != 0 {
+			if _, err := os.Stat(source); !os.IsNotExist(err) {
+				t.Errorf("%s: original file kept", tt.name)
+			}
+			if media.FileName != filepath.Base(media.Path) || !strings.HasSuffix(media.Path, "_"+strconv.Itoa(tt.want)+"k.mp3") {
+				t.Errorf("%s: path = %s, file name = %s", tt.name, media.Path, media.FileName)
+			}
+		}
+
+		entries, _ := os.ReadDir(dir)
+		if len(entries) != 1 {
+			t.Errorf("%s: files left: %v", tt.name, entries)
+		}
+	}
+}
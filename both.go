@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// extractAudio re-encodes the audio track of the downloaded video into a
+// separate mp3, so /both doesn't fetch the media twice. The video file is
+// only read.
+func (media *Media) extractAudio(ctx context.Context) (*Media, error) {
+	audio := *media
+	audio.randomName = media.randomName + "_audio"
+	audio.Path = filepath.Join(media.tmpDir, audio.randomName+".mp3")
+	audio.FileName = filepath.Base(audio.Path)
+	audio.audioOnly = true
+	audio.SubtitlePath = ""
+	audio.SupportsStreaming = false
+	audio.ReducedBitrate = 0
+
+	cmdSlice := []string{
+		"ffmpeg",
+		"-y",
+		"-i", media.Path,
+		"-vn",
+		"-map_metadata", "0",
+		"-c:a", "libmp3lame",
+		"-q:a", "2",
+		audio.Path,
+	}
+
+	if err := conversionLimiter.Acquire(ctx); err != nil {
+		return nil, fmt.Errorf("gave up waiting for a conversion slot: %s", err)
+	}
+	_, err := runCommand(ctx, media.user, cmdSlice)
+	conversionLimiter.Release()
+
+	if err != nil {
+		os.Remove(audio.Path)
+		return nil, fmt.Errorf("error extracting audio: %s", err)
+	}
+
+	if err := audio.fitAudioToSize(ctx, maxFileSize); err != nil {
+		os.Remove(audio.Path)
+		return nil, err
+	}
+
+	return &audio, nil
+}
+
+// audioExtraction runs extractAudio in the background while the video is
+// being sent.
+type audioExtraction struct {
+	done  chan struct{}
+	media *Media
+	err   error
+}
+
+func startAudioExtraction(ctx context.Context, video *Media) *audioExtraction {
+	e := &audioExtraction{done: make(chan struct{})}
+	go func() {
+		defer close(e.done)
+		e.media, e.err = video.extractAudio(ctx)
+	}()
+	return e
+}
+
+// wait blocks until the extraction finished. The video file may only be
+// removed after that.
+func (e *audioExtraction) wait() (*Media, error) {
+	<-e.done
+	return e.media, e.err
+}
+
+// discard waits for the extraction and removes its result unsent.
+func (e *audioExtraction) discard() {
+	if audio, err := e.wait(); err == nil {
+		if err := audio.Delete(); err != nil {
+			log.Printf("Error removing extracted audio: %s", err)
+		}
+	}
+}
+
+// send waits for the extraction, sends the audio and removes it.
+func (e *audioExtraction) send(ctx context.Context, b *bot.Bot, chatID int64) {
+	audio, err := e.wait()
+	if err != nil {
+		log.Printf("%s", err)
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   "I'm sorry, I couldn't extract the audio from this video.",
+		})
+		return
+	}
+	defer func() {
+		if err := audio.Delete(); err != nil {
+			log.Printf("Error removing extracted audio: %s", err)
+		}
+	}()
+
+	if _, err := sendMedia(ctx, b, chatID, audio, true, nil); err != nil {
+		log.Printf("[%s]: error sending extracted audio: %s", audio.user, err)
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   "I'm sorry, I couldn't send the audio.",
+		})
+		return
+	}
+
+	log.Printf("[%s]: extracted audio sent", audio.user)
+}
+
+func bothHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		log.Println("Received both command with nil Message")
+		return
+	}
+	input := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/both"))
+	handleDownload(ctx, b, update, input, DownloadOptions{WithAudio: true}, "")
+}
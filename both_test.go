@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMediaExtractAudio(t *testing.T) {
+	oldConversions, oldMaxSize := conversionLimiter, maxFileSize
+	defer func() { conversionLimiter, maxFileSize = oldConversions, oldMaxSize }()
+	conversionLimiter = newLimiter(1)
+	maxFileSize = 1 << 20
+
+	tests := []struct {
+		name    string
+		ffmpeg  string
+		wantErr string
+	}{
+		{"extracted", `for arg; do out=$arg; done; head -c 100 /dev/zero > "$out"`, ""},
+		{"ffmpeg fails", `for arg; do out=$arg; done; echo partial > "$out"; exit 1`, "error extracting audio"},
+	}
+
+	for _, tt := range tests {
+		fakeCommand(t, "ffmpeg", tt.ffmpeg)
+
+		dir := t.TempDir()
+		video := &Media{user: "test", tmpDir: dir, randomName: "abc", Path: filepath.Join(dir, "abc.mp4"),
+			FileName: "abc.mp4", SubtitlePath: filepath.Join(dir, "abc.en.vtt"), SupportsStreaming: true}
+		if err := os.WriteFile(video.Path, []byte("video"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		audio, err := video.extractAudio(context.Background())
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: extractAudio error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "abc_audio.mp3")); !os.IsNotExist(err) {
+				t.Errorf("%s: failed extraction left its output behind", tt.name)
+			}
+		} else if err != nil {
+			t.Errorf("%s: extractAudio: %s", tt.name, err)
+		} else {
+			if audio.Path != filepath.Join(dir, "abc_audio.mp3") || audio.FileName != "abc_audio.mp3" {
+				t.Errorf("%s: audio at %s named %s", tt.name, audio.Path, audio.FileName)
+			}
+			if !audio.audioOnly || audio.SubtitlePath != "" || audio.SupportsStreaming {
+				t.Errorf("%s: audio keeps the video's settings: %+v", tt.name, audio)
+			}
+		}
+
+		if _, err := os.Stat(video.Path); err != nil {
+			t.Errorf("%s: video file gone after extraction: %s", tt.name, err)
+		}
+	}
+}
+
+func TestAudioExtractionSend(t *testing.T) {
+	oldConversions, oldMaxSize := conversionLimiter, maxFileSize
+	defer func() { conversionLimiter, maxFileSize = oldConversions, oldMaxSize }()
+	conversionLimiter = newLimiter(1)
+	maxFileSize = 1 << 20
+
+	tests := []struct {
+		name   string
+		ffmpeg string
+		audios int
+		reply  string
+	}{
+		{"sent", `for arg; do out=$arg; done; head -c 100 /dev/zero > "$out"`, 1, ""},
+		{"extraction failed", "exit 1", 0, "couldn't extract the audio"},
+	}
+
+	for _, tt := range tests {
+		fakeCommand(t, "ffmpeg", tt.ffmpeg)
+		b := newTestBot(t)
+
+		dir := t.TempDir()
+		video := &Media{user: "test", tmpDir: dir, randomName: "abc", Path: filepath.Join(dir, "abc.mp4"), FileName: "abc.mp4"}
+		if err := os.WriteFile(video.Path, []byte("video"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		startAudioExtraction(context.Background(), video).send(context.Background(), b.Bot, 1)
+
+		if n := len(b.calls("sendAudio")); n != tt.audios {
+			t.Errorf("%s: sent %d audios, want %d", tt.name, n, tt.audios)
+		}
+		texts := b.sentTexts()
+		if tt.reply == "" && len(texts) > 0 || tt.reply != "" && (len(texts) != 1 || !strings.Contains(texts[0], tt.reply)) {
+			t.Errorf("%s: sent %q, want %q", tt.name, texts, tt.reply)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "abc_audio.mp3")); !os.IsNotExist(err) {
+			t.Errorf("%s: extracted audio not removed", tt.name)
+		}
+	}
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	maxCaptionLength int
+
+	maxConcurrentDownloads int
+	minConcurrentDownloads int
+	adaptiveConcurrency    bool
+
+	maxConcurrentConversions int
+
+	postDownloadHook         []string
+	postDownloadHookStage    string
+	postDownloadHookBlocking bool
+	postDownloadHookTimeout  time.Duration
+
+	ackTemplate        string
+	ackTitleTemplate   string
+	ackFetchTitle      bool
+	ackMetadataTimeout time.Duration
+
+	padAspectRatio *aspectRatio
+
+	sendTranscripts     bool
+	transcriptLangs     string
+	transcriptMaxLength int
+
+	twoPassEncoding bool
+
+	trustedUsers []string
+
+	downloadRateLimit string
+
+	maxFileSize        int64
+	audioBitrateLadder []int
+
+	tiktokPhotoAudio bool
+
+	logChannelID   int64
+	logChannelMode string
+
+	archiveDir string
+
+	minVideoBitrate int
+	maxVideoBitrate int
+
+	shareBaseURL string
+	shareMode    string
+	shareTTL     time.Duration
+
+	quietPeriod *quietHours
+
+	summarySchedule string
+	summaryTime     int
+
+	customFilters string
+
+	extractorAlertThreshold int
+	extractorAlertWindow    time.Duration
+
+	qualityKeyboard bool
+
+	transcribeCommand     []string
+	transcribeTimeout     time.Duration
+	transcribeMaxDuration time.Duration
+
+	maxFPS int
+
+	videoWatermark *watermark
+
+	autoReferer bool
+
+	voiceMaxDuration time.Duration
+
+	// normalizeAudio runs audio downloads through ffmpeg's loudnorm.
+	normalizeAudio bool
+
+	gifMaxDuration time.Duration
+	gifMaxSize     int64
+
+	downloadArchive    bool
+	downloadArchiveDir string
+
+	userErrorDetail string
+
+	uploadTimeout time.Duration
+
+	hashSentFiles bool
+
+	thumbnailOffsetSetting thumbnailOffset
+
+	deleteUserMessage bool
+
+	progressInterval time.Duration
+
+	shutdownTimeout time.Duration
+
+	maxURLsPerMessage int
+
+	statsRetention   time.Duration
+	requestRetention time.Duration
+
+	convertMode   string
+	convertCRF    int
+	convertPreset string
+
+	ffmpegHWAccel string
+	vaapiDevice   string
+
+	minFreeDiskSpace uint64
+
+	tempMaxAge time.Duration
+
+	botMode       string
+	webhookURL    string
+	webhookSecret string
+	webhookListen string
+
+	replyToRequest string
+
+	downloadTimeout time.Duration
+	ffmpegTimeout   time.Duration
+
+	checkLiveStreams bool
+	liveCheckTimeout time.Duration
+
+	playlistMaxItems int
+
+	requireTools bool
+
+	// formatFallbacks are the format selectors tried after the site's own
+	// one fails, before a simplified download.
+	formatFallbacks []string
+
+	ytDlpAutoUpdate  bool
+	ytDlpUpdateCheck bool
+)
+
+const defaultMetadataCacheSize = 100
+
+var rateLimitPattern = regexp.MustCompile(`^\d+(\.\d+)?[KMG]?$`)
+
+func loadConfig() {
+	maxCaptionLength = getEnvInt("MAX_CAPTION_LENGTH", telegramCaptionLimit)
+	if maxCaptionLength <= 0 || maxCaptionLength > telegramCaptionLimit {
+		log.Printf("MAX_CAPTION_LENGTH must be between 1 and %d, using %d", telegramCaptionLimit, telegramCaptionLimit)
+		maxCaptionLength = telegramCaptionLimit
+	}
+
+	maxConcurrentDownloads = getEnvInt("MAX_CONCURRENT_DOWNLOADS", 3)
+	if maxConcurrentDownloads < 1 {
+		maxConcurrentDownloads = 1
+	}
+	minConcurrentDownloads = getEnvInt("MIN_CONCURRENT_DOWNLOADS", 1)
+	if minConcurrentDownloads < 1 || minConcurrentDownloads > maxConcurrentDownloads {
+		minConcurrentDownloads = 1
+	}
+	adaptiveConcurrency = os.Getenv("ADAPTIVE_CONCURRENCY") == "true"
+
+	maxConcurrentConversions = getEnvInt("MAX_CONCURRENT_CONVERSIONS", 1)
+	if maxConcurrentConversions < 1 {
+		maxConcurrentConversions = 1
+	}
+
+	postDownloadHook = strings.Fields(os.Getenv("POST_DOWNLOAD_HOOK"))
+	postDownloadHookStage = os.Getenv("POST_DOWNLOAD_HOOK_STAGE")
+	if postDownloadHookStage == "" {
+		postDownloadHookStage = hookBeforeSend
+	}
+	if postDownloadHookStage != hookBeforeSend && postDownloadHookStage != hookAfterSend {
+		log.Printf("Invalid POST_DOWNLOAD_HOOK_STAGE '%s', using %s", postDownloadHookStage, hookBeforeSend)
+		postDownloadHookStage = hookBeforeSend
+	}
+	postDownloadHookBlocking = os.Getenv("POST_DOWNLOAD_HOOK_BLOCKING") == "true"
+	postDownloadHookTimeout = time.Duration(getEnvInt("POST_DOWNLOAD_HOOK_TIMEOUT", 300)) * time.Second
+	if len(postDownloadHook) > 0 {
+		log.Printf("Post-download hook: '%s' (stage: %s, blocking: %t)", strings.Join(postDownloadHook, " "), postDownloadHookStage, postDownloadHookBlocking)
+	}
+
+	ackTemplate = getEnvString("ACK_MESSAGE", defaultAckTemplate)
+	ackTitleTemplate = getEnvString("ACK_TITLE_MESSAGE", defaultAckTitleTemplate)
+	ackFetchTitle = os.Getenv("ACK_FETCH_TITLE") == "true"
+	ackMetadataTimeout = time.Duration(getEnvInt("ACK_METADATA_TIMEOUT", 15)) * time.Second
+
+	if value := os.Getenv("PAD_ASPECT_RATIO"); value != "" {
+		ratio, err := parseAspectRatio(value)
+		if err != nil {
+			log.Printf("Ignoring PAD_ASPECT_RATIO: %s", err)
+		} else {
+			padAspectRatio = &ratio
+		}
+	}
+
+	sendTranscripts = os.Getenv("SEND_TRANSCRIPT") == "true"
+	transcriptLangs = getEnvString("TRANSCRIPT_LANGS", "en.*,en")
+	transcriptMaxLength = getEnvInt("TRANSCRIPT_MAX_LENGTH", 20000)
+
+	twoPassEncoding = os.Getenv("TWO_PASS_ENCODING") == "true"
+
+	trustedUsers = splitList(os.Getenv("TRUSTED_USERS"))
+
+	if value := os.Getenv("RATE_LIMIT"); value != "" {
+		if rateLimitPattern.MatchString(value) {
+			downloadRateLimit = value
+			log.Printf("Download rate limit: %s bytes/s", downloadRateLimit)
+		} else {
+			log.Printf("Ignoring invalid RATE_LIMIT '%s', expected a number of bytes per second like 500K or 5M", value)
+		}
+	}
+
+	maxFileSize = int64(getEnvInt("MAX_FILE_SIZE_MB", 2000)) * 1024 * 1024
+
+	audioBitrateLadder = defaultAudioBitrateLadder
+	if value := os.Getenv("AUDIO_BITRATE_LADDER"); value != "" {
+		ladder, err := parseIntList(value)
+		if err != nil || len(ladder) == 0 {
+			log.Printf("Ignoring invalid AUDIO_BITRATE_LADDER '%s'", value)
+		} else {
+			sort.Sort(sort.Reverse(sort.IntSlice(ladder)))
+			audioBitrateLadder = ladder
+		}
+	}
+
+	tiktokPhotoAudio = os.Getenv("TIKTOK_PHOTO_AUDIO") != "false"
+
+	if value := os.Getenv("LOG_CHANNEL_ID"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			log.Printf("Ignoring invalid LOG_CHANNEL_ID '%s'", value)
+		} else {
+			logChannelID = id
+		}
+	}
+	logChannelMode = getEnvString("LOG_CHANNEL_MODE", "media")
+	if logChannelMode != "media" && logChannelMode != "text" {
+		log.Printf("Invalid LOG_CHANNEL_MODE '%s', using media", logChannelMode)
+		logChannelMode = "media"
+	}
+
+	archiveDir = os.Getenv("ARCHIVE_DIR")
+
+	minVideoBitrate = getEnvInt("MIN_VIDEO_BITRATE", defaultMinVideoBitrate)
+	maxVideoBitrate = getEnvInt("MAX_VIDEO_BITRATE", defaultMaxVideoBitrate)
+	if minVideoBitrate < 1 || maxVideoBitrate < minVideoBitrate {
+		log.Printf("Invalid video bitrate bounds %d-%d, using %d-%d", minVideoBitrate, maxVideoBitrate, defaultMinVideoBitrate, defaultMaxVideoBitrate)
+		minVideoBitrate = defaultMinVideoBitrate
+		maxVideoBitrate = defaultMaxVideoBitrate
+	}
+
+	shareBaseURL = os.Getenv("SHARE_BASE_URL")
+	shareMode = getEnvString("SHARE_MODE", shareAlso)
+	if shareMode != shareAlso && shareMode != shareInstead {
+		log.Printf("Invalid SHARE_MODE '%s', using %s", shareMode, shareAlso)
+		shareMode = shareAlso
+	}
+	shareTTL = time.Duration(getEnvInt("SHARE_TTL_HOURS", 24)) * time.Hour
+	if shareTTL <= 0 {
+		shareTTL = 24 * time.Hour
+	}
+
+	if value := os.Getenv("QUIET_HOURS"); value != "" {
+		q, err := parseQuietHours(value)
+		if err != nil {
+			log.Printf("Ignoring invalid QUIET_HOURS '%s': %s", value, err)
+		} else {
+			quietPeriod = q
+		}
+	}
+
+	summarySchedule = os.Getenv("STATS_SUMMARY")
+	if summarySchedule != "" && summarySchedule != summaryDaily && summarySchedule != summaryWeekly {
+		log.Printf("Ignoring invalid STATS_SUMMARY '%s', expected %s or %s", summarySchedule, summaryDaily, summaryWeekly)
+		summarySchedule = ""
+	}
+	summaryTime = 9 * 60
+	if value := os.Getenv("STATS_SUMMARY_TIME"); value != "" {
+		clock, err := parseClock(value)
+		if err != nil {
+			log.Printf("Ignoring STATS_SUMMARY_TIME: %s", err)
+		} else {
+			summaryTime = clock
+		}
+	}
+
+	if value := strings.Trim(os.Getenv("CONVERSION_VF"), " ,"); value != "" {
+		if err := checkCustomFilters(value); err != nil {
+			log.Printf("Ignoring CONVERSION_VF: %s", err)
+		} else {
+			customFilters = value
+		}
+	}
+
+	extractorAlertThreshold = getEnvInt("EXTRACTOR_ALERT_THRESHOLD", 3)
+	if extractorAlertThreshold < 1 {
+		extractorAlertThreshold = 1
+	}
+	extractorAlertWindow = time.Duration(getEnvInt("EXTRACTOR_ALERT_WINDOW_MINUTES", 60)) * time.Minute
+	if extractorAlertWindow <= 0 {
+		extractorAlertWindow = time.Hour
+	}
+
+	qualityKeyboard = os.Getenv("QUALITY_KEYBOARD") == "true"
+
+	transcribeCommand = strings.Fields(os.Getenv("TRANSCRIBE_COMMAND"))
+	transcribeTimeout = time.Duration(getEnvInt("TRANSCRIBE_TIMEOUT_MINUTES", 30)) * time.Minute
+	transcribeMaxDuration = time.Duration(getEnvInt("TRANSCRIBE_MAX_MINUTES", 30)) * time.Minute
+
+	maxFPS = getEnvInt("MAX_FPS", 0)
+
+	loadErrorMessages()
+	userErrorDetail = getEnvString("USER_ERROR_DETAIL", errorDetailFull)
+	switch userErrorDetail {
+	case errorDetailMinimal, errorDetailFriendly, errorDetailFull:
+	default:
+		log.Printf("Invalid USER_ERROR_DETAIL '%s', using %s", userErrorDetail, errorDetailFull)
+		userErrorDetail = errorDetailFull
+	}
+
+	loadWatermark()
+
+	autoReferer = os.Getenv("AUTO_REFERER") == "true"
+
+	voiceMaxDuration = time.Duration(getEnvInt("VOICE_MAX_MINUTES", 10)) * time.Minute
+	normalizeAudio = os.Getenv("NORMALIZE_AUDIO") == "true"
+
+	gifMaxDuration = time.Duration(getEnvInt("GIF_MAX_SECONDS", 15)) * time.Second
+	if gifMaxDuration <= 0 {
+		gifMaxDuration = 15 * time.Second
+	}
+	gifMaxSize = int64(getEnvInt("GIF_MAX_MB", 20)) * 1024 * 1024
+	if gifMaxSize <= 0 || gifMaxSize > maxFileSize {
+		gifMaxSize = maxFileSize
+	}
+
+	downloadArchive = os.Getenv("DOWNLOAD_ARCHIVE") == "true"
+
+	uploadTimeout = time.Duration(getEnvInt("UPLOAD_TIMEOUT_MINUTES", 50)) * time.Minute
+	if uploadTimeout <= 0 {
+		uploadTimeout = 50 * time.Minute
+	}
+
+	hashSentFiles = os.Getenv("HASH_SENT_FILES") == "true"
+	deleteUserMessage = os.Getenv("DELETE_USER_MESSAGE") == "true"
+	progressInterval = time.Duration(getEnvInt("PROGRESS_INTERVAL_SECONDS", 3)) * time.Second
+	shutdownTimeout = time.Duration(getEnvInt("SHUTDOWN_TIMEOUT_SECONDS", 30)) * time.Second
+
+	convertMode = getEnvString("CONVERT_MODE", convertModeBitrate)
+	if convertMode != convertModeBitrate && convertMode != convertModeCRF {
+		log.Printf("Ignoring invalid CONVERT_MODE '%s', using %s", convertMode, convertModeBitrate)
+		convertMode = convertModeBitrate
+	}
+	convertCRF = getEnvInt("CONVERT_CRF", defaultCRF)
+	if convertCRF < 0 || convertCRF > 51 {
+		log.Printf("Ignoring invalid CONVERT_CRF %d, using %d", convertCRF, defaultCRF)
+		convertCRF = defaultCRF
+	}
+	convertPreset = os.Getenv("CONVERT_PRESET")
+
+	ffmpegHWAccel = strings.ToLower(os.Getenv("FFMPEG_HWACCEL"))
+	if ffmpegHWAccel == "none" {
+		ffmpegHWAccel = ""
+	}
+	if _, ok := videoEncoders[ffmpegHWAccel]; !ok {
+		log.Printf("Ignoring invalid FFMPEG_HWACCEL '%s', encoding in software", ffmpegHWAccel)
+		ffmpegHWAccel = ""
+	}
+	vaapiDevice = getEnvString("FFMPEG_VAAPI_DEVICE", "/dev/dri/renderD128")
+
+	if mb := getEnvInt("MIN_FREE_DISK_MB", 0); mb > 0 {
+		minFreeDiskSpace = uint64(mb) * 1024 * 1024
+	}
+
+	tempMaxAge = time.Duration(getEnvInt("TEMP_MAX_AGE_MINUTES", 60)) * time.Minute
+
+	botMode = getEnvString("BOT_MODE", botModePolling)
+	webhookURL = os.Getenv("WEBHOOK_URL")
+	switch {
+	case botMode != botModePolling && botMode != botModeWebhook:
+		log.Printf("Ignoring invalid BOT_MODE '%s', using %s", botMode, botModePolling)
+		botMode = botModePolling
+	case botMode == botModeWebhook && webhookURL == "":
+		log.Printf("BOT_MODE=webhook needs WEBHOOK_URL, using %s", botModePolling)
+		botMode = botModePolling
+	}
+	// a new secret on every start is fine, the webhook is set on every start
+	webhookSecret = getEnvString("WEBHOOK_SECRET", strings.ReplaceAll(uuid.New().String(), "-", ""))
+	webhookListen = getEnvString("WEBHOOK_LISTEN", ":8443")
+
+	downloadTimeout = time.Duration(getEnvInt("DOWNLOAD_TIMEOUT_MINUTES", 10)) * time.Minute
+	if downloadTimeout <= 0 {
+		downloadTimeout = 10 * time.Minute
+	}
+	ffmpegTimeout = time.Duration(getEnvInt("FFMPEG_TIMEOUT_MINUTES", 20)) * time.Minute
+	if ffmpegTimeout <= 0 {
+		ffmpegTimeout = 20 * time.Minute
+	}
+
+	checkLiveStreams = os.Getenv("CHECK_LIVE_STREAMS") == "true"
+	liveCheckTimeout = time.Duration(getEnvInt("LIVE_CHECK_TIMEOUT", 30)) * time.Second
+
+	playlistMaxItems = getEnvInt("PLAYLIST_MAX_ITEMS", 10)
+	if playlistMaxItems <= 0 {
+		playlistMaxItems = 10
+	}
+
+	requireTools = os.Getenv("REQUIRE_TOOLS") == "true"
+
+	formatFallbacks = splitList(getEnvString("FORMAT_FALLBACKS", defaultFormatFallbacks))
+	if len(formatFallbacks) == 1 && formatFallbacks[0] == "none" {
+		formatFallbacks = nil
+	}
+
+	ytDlpAutoUpdate = os.Getenv("YTDLP_AUTOUPDATE") == "true"
+	ytDlpUpdateCheck = os.Getenv("YTDLP_UPDATE_CHECK") == "true"
+
+	replyToRequest = getEnvString("REPLY_TO_REQUEST", replyGroups)
+	if replyToRequest != replyAlways && replyToRequest != replyGroups && replyToRequest != replyNever {
+		log.Printf("Ignoring invalid REPLY_TO_REQUEST '%s', using %s", replyToRequest, replyGroups)
+		replyToRequest = replyGroups
+	}
+
+	statsRetention = time.Duration(getEnvInt("STATS_RETENTION_DAYS", 365)) * 24 * time.Hour
+	requestRetention = time.Duration(getEnvInt("REQUEST_RETENTION_DAYS", 90)) * 24 * time.Hour
+
+	maxURLsPerMessage = getEnvInt("MAX_URLS_PER_MESSAGE", 5)
+	if maxURLsPerMessage < 1 {
+		maxURLsPerMessage = 1
+	}
+
+	if perMinute := getEnvInt("USER_RATE_LIMIT", 0); perMinute > 0 {
+		requestLimiter = newUserLimiter(perMinute)
+	}
+
+	if value := os.Getenv("THUMBNAIL_OFFSET"); value != "" {
+		offset, err := parseThumbnailOffset(value)
+		if err != nil {
+			log.Printf("Ignoring invalid THUMBNAIL_OFFSET '%s': %s", value, err)
+		} else {
+			thumbnailOffsetSetting = offset
+		}
+	}
+
+	if ttl := getEnvInt("METADATA_CACHE_SECONDS", 300); ttl > 0 {
+		size := getEnvInt("METADATA_CACHE_SIZE", defaultMetadataCacheSize)
+		if size <= 0 {
+			size = defaultMetadataCacheSize
+		}
+		metaCache = newMetadataCache(time.Duration(ttl)*time.Second, size)
+	}
+
+	loadHostFormats()
+	loadHostReferers()
+	loadAckEmojis()
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(value string) []string {
+	var res []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
+}
+
+// parseIntList parses a comma-separated list of positive integers.
+func parseIntList(value string) ([]int, error) {
+	var res []int
+	for _, item := range splitList(value) {
+		n, err := strconv.Atoi(item)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid number '%s'", item)
+		}
+		res = append(res, n)
+	}
+	return res, nil
+}
+
+// getEnvString returns the value of the environment variable name, or def
+// if it is unset.
+func getEnvString(name string, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// getEnvInt returns the integer value of the environment variable name,
+// or def if it is unset or malformed.
+func getEnvInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid value for %s: '%s', using default %d", name, value, def)
+		return def
+	}
+
+	return n
+}
+
+// loadWatermark reads the WATERMARK_* settings. An image that doesn't exist
+// disables the watermark rather than failing every conversion later.
+func loadWatermark() {
+	w := &watermark{
+		Text:     os.Getenv("WATERMARK_TEXT"),
+		Image:    os.Getenv("WATERMARK_IMAGE"),
+		Font:     os.Getenv("WATERMARK_FONT"),
+		Position: getEnvString("WATERMARK_POSITION", "bottom-right"),
+		Opacity:  0.5,
+	}
+	if w.Text == "" && w.Image == "" {
+		return
+	}
+
+	if _, ok := watermarkPositions[w.Position]; !ok {
+		log.Printf("Invalid WATERMARK_POSITION '%s', using bottom-right", w.Position)
+		w.Position = "bottom-right"
+	}
+
+	if value := os.Getenv("WATERMARK_OPACITY"); value != "" {
+		opacity, err := strconv.ParseFloat(value, 64)
+		if err != nil || opacity <= 0 || opacity > 1 {
+			log.Printf("Invalid WATERMARK_OPACITY '%s', using 0.5", value)
+		} else {
+			w.Opacity = opacity
+		}
+	}
+
+	if w.Image != "" {
+		if _, err := os.Stat(w.Image); err != nil {
+			log.Printf("Watermark disabled, can't use WATERMARK_IMAGE: %s", err)
+			return
+		}
+	}
+
+	videoWatermark = w
+	log.Printf("Watermarking converted videos (%s)", w.Position)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{"alice", []string{"alice"}},
+		{"alice, bob ,carol", []string{"alice", "bob", "carol"}},
+		{",alice,,bob,", []string{"alice", "bob"}},
+		{" , ", nil},
+	}
+
+	for _, tt := range tests {
+		got := splitList(tt.value)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+			t.Errorf("splitList(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimitPattern(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"500K", true},
+		{"5M", true},
+		{"1.5M", true},
+		{"1G", true},
+		{"1048576", true},
+		{"5m", false},
+		{"5 M", false},
+		{"5MB", false},
+		{"-1M", false},
+		{"M", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := rateLimitPattern.MatchString(tt.value); got != tt.want {
+			t.Errorf("rateLimitPattern matches %q = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
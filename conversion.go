@@ -0,0 +1,141 @@
+package main
+
+import (
+	"log"
+	"strconv"
+)
+
+// Default video bitrate bounds in kbps, overridable with MIN_VIDEO_BITRATE
+// and MAX_VIDEO_BITRATE. The maximum is also the bitrate used for short
+// videos that would fit the size limit at any bitrate.
+const (
+	defaultMinVideoBitrate = 200
+	defaultMaxVideoBitrate = 5000
+	// minKbpsPerMegapixel raises the floor for larger frames: about 1000
+	// kbps for 1080p and 460 kbps for 720p.
+	minKbpsPerMegapixel = 500
+	// audioBitrate is ffmpeg's default for aac, reserved from the budget
+	audioBitrate = 128
+	defaultCRF   = 23
+)
+
+// conversionStrategy describes how the video is encoded: either at a target
+// bitrate so it fits the size limit, or at a constant quality (CRF) when
+// the size can't be predicted.
+type conversionStrategy struct {
+	Bitrate int
+	CRF     int
+
+	// HWAccel is the hardware encoder to use, "" for libx264.
+	HWAccel string
+}
+
+func (s conversionStrategy) isCRF() bool {
+	return s.Bitrate == 0
+}
+
+// bitrateFloor returns the lowest acceptable bitrate in kbps for a frame of
+// width x height pixels. It never drops below minVideoBitrate or exceeds
+// maxVideoBitrate.
+func bitrateFloor(width, height int) int {
+	floor := width * height * minKbpsPerMegapixel / 1000000
+	if floor < minVideoBitrate {
+		floor = minVideoBitrate
+	}
+	if floor > maxVideoBitrate {
+		floor = maxVideoBitrate
+	}
+	return floor
+}
+
+// calculateTargetBitrate returns the video bitrate in kbps that makes
+// duration seconds of video, plus audio, fit into limit bytes. 5% of the
+// budget is kept for container overhead. The result is kept above the
+// floor for the frame size, even if the file then exceeds limit.
+func calculateTargetBitrate(limit int64, duration int, width, height int) int {
+	if duration <= 0 {
+		duration = 1
+	}
+
+	total := float64(limit) * 8 / 1000 / float64(duration) * 0.95
+	bitrate := int(total) - audioBitrate
+
+	if floor := bitrateFloor(width, height); bitrate < floor {
+		bitrate = floor
+	}
+	if bitrate > maxVideoBitrate {
+		bitrate = maxVideoBitrate
+	}
+
+	return bitrate
+}
+
+// Values of CONVERT_MODE.
+const (
+	convertModeBitrate = "bitrate"
+	convertModeCRF     = "crf"
+)
+
+// Values of FFMPEG_HWACCEL. Software encoding is "".
+const (
+	hwaccelNVENC = "nvenc"
+	hwaccelVAAPI = "vaapi"
+)
+
+// videoEncoders maps FFMPEG_HWACCEL to the H.264 encoder it uses.
+var videoEncoders = map[string]string{
+	"":           "libx264",
+	hwaccelNVENC: "h264_nvenc",
+	hwaccelVAAPI: "h264_vaapi",
+}
+
+// videoCodecArgs returns the ffmpeg video encoder options for strategy.
+// preset is the speed/quality trade-off, left to ffmpeg when empty; VAAPI
+// has no presets. Each encoder names its constant quality option
+// differently.
+func videoCodecArgs(strategy conversionStrategy, preset string) []string {
+	args := []string{"-c:v", videoEncoders[strategy.HWAccel]}
+	if preset != "" && strategy.HWAccel != hwaccelVAAPI {
+		args = append(args, "-preset", preset)
+	}
+
+	if !strategy.isCRF() {
+		return append(args, "-b:v", strconv.Itoa(strategy.Bitrate)+"k")
+	}
+
+	crf := strconv.Itoa(strategy.CRF)
+	switch strategy.HWAccel {
+	case hwaccelNVENC:
+		return append(args, "-cq", crf)
+	case hwaccelVAAPI:
+		return append(args, "-qp", crf)
+	default:
+		return append(args, "-crf", crf)
+	}
+}
+
+// determineConversionStrategy picks bitrate-targeted encoding when the
+// duration is known, so the size is predictable. Without it the bitrate
+// math is meaningless, so the video is encoded at a constant quality
+// instead, as it always is with CONVERT_MODE=crf.
+func (media *Media) determineConversionStrategy() conversionStrategy {
+	if convertMode == convertModeCRF {
+		log.Printf("[%s]: converting with CRF %d", media.user, convertCRF)
+		return conversionStrategy{CRF: convertCRF, HWAccel: ffmpegHWAccel}
+	}
+
+	if media.Duration <= 0 {
+		log.Printf("[%s]: duration is unknown, converting with CRF %d", media.user, convertCRF)
+		return conversionStrategy{CRF: convertCRF, HWAccel: ffmpegHWAccel}
+	}
+
+	// the floor depends on the frame that is encoded, after scaling
+	width, height := media.Width, media.Height
+	if width > 0 && height > 0 {
+		width, height = convertWidth, scaledHeight(width, height, convertWidth)
+	}
+
+	bitrate := calculateTargetBitrate(maxFileSize, int(media.Duration), width, height)
+	log.Printf("[%s]: converting at %d kbps", media.user, bitrate)
+	return conversionStrategy{Bitrate: bitrate, HWAccel: ffmpegHWAccel}
+}
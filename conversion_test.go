@@ -0,0 +1,170 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCalculateTargetBitrate(t *testing.T) {
+	oldMin, oldMax := minVideoBitrate, maxVideoBitrate
+	defer func() { minVideoBitrate, maxVideoBitrate = oldMin, oldMax }()
+	minVideoBitrate, maxVideoBitrate = defaultMinVideoBitrate, defaultMaxVideoBitrate
+
+	const mb = 1024 * 1024
+
+	tests := []struct {
+		name     string
+		limit    int64
+		duration int
+		want     int
+	}{
+		{"fits the budget", 50 * mb, 600, 536},
+		{"long video", 2000 * mb, 3600, 4299},
+		{"short video capped at the maximum", 50 * mb, 60, defaultMaxVideoBitrate},
+		{"too long for the limit stays at the minimum", 50 * mb, 3600, defaultMinVideoBitrate},
+		{"unknown duration", 50 * mb, 0, defaultMaxVideoBitrate},
+	}
+
+	for _, tt := range tests {
+		if got := calculateTargetBitrate(tt.limit, tt.duration, 0, 0); got != tt.want {
+			t.Errorf("%s: calculateTargetBitrate(%d, %d) = %d, want %d", tt.name, tt.limit, tt.duration, got, tt.want)
+		}
+	}
+}
+
+func TestMediaDetermineConversionStrategy(t *testing.T) {
+	oldMode, oldCRF, oldHWAccel, oldMaxSize := convertMode, convertCRF, ffmpegHWAccel, maxFileSize
+	oldMin, oldMax := minVideoBitrate, maxVideoBitrate
+	defer func() {
+		convertMode, convertCRF, ffmpegHWAccel, maxFileSize = oldMode, oldCRF, oldHWAccel, oldMaxSize
+		minVideoBitrate, maxVideoBitrate = oldMin, oldMax
+	}()
+	convertCRF, ffmpegHWAccel, maxFileSize = 28, "", 50*1024*1024
+	minVideoBitrate, maxVideoBitrate = defaultMinVideoBitrate, defaultMaxVideoBitrate
+
+	tests := []struct {
+		name     string
+		mode     string
+		duration int
+		want     conversionStrategy
+	}{
+		{"known duration", convertModeBitrate, 600, conversionStrategy{Bitrate: 536}},
+		{"unknown duration", convertModeBitrate, 0, conversionStrategy{CRF: 28}},
+		{"crf mode", convertModeCRF, 600, conversionStrategy{CRF: 28}},
+	}
+
+	for _, tt := range tests {
+		convertMode = tt.mode
+		media := &Media{user: "test", Duration: CustomDuration(tt.duration)}
+		got := media.determineConversionStrategy()
+		if got != tt.want {
+			t.Errorf("%s: determineConversionStrategy() = %+v, want %+v", tt.name, got, tt.want)
+		}
+		if got.isCRF() != (tt.want.Bitrate == 0) {
+			t.Errorf("%s: isCRF() = %v", tt.name, got.isCRF())
+		}
+	}
+}
+
+func TestBitrateFloor(t *testing.T) {
+	oldMin, oldMax := minVideoBitrate, maxVideoBitrate
+	defer func() { minVideoBitrate, maxVideoBitrate = oldMin, oldMax }()
+	minVideoBitrate, maxVideoBitrate = defaultMinVideoBitrate, defaultMaxVideoBitrate
+
+	tests := []struct {
+		width, height int
+		want          int
+	}{
+		{1920, 1080, 1036},
+		{1280, 720, 460},
+		{3840, 2160, 4147},
+		{640, 360, defaultMinVideoBitrate},
+		{7680, 4320, defaultMaxVideoBitrate},
+		{0, 0, defaultMinVideoBitrate},
+	}
+
+	for _, tt := range tests {
+		if got := bitrateFloor(tt.width, tt.height); got != tt.want {
+			t.Errorf("bitrateFloor(%d, %d) = %d, want %d", tt.width, tt.height, got, tt.want)
+		}
+	}
+}
+
+func TestMediaCalculateTargetBitrate(t *testing.T) {
+	oldMode, oldMaxSize := convertMode, maxFileSize
+	oldMin, oldMax := minVideoBitrate, maxVideoBitrate
+	defer func() {
+		convertMode, maxFileSize = oldMode, oldMaxSize
+		minVideoBitrate, maxVideoBitrate = oldMin, oldMax
+	}()
+	convertMode, maxFileSize = convertModeBitrate, 50*1024*1024
+
+	tests := []struct {
+		name          string
+		width, height int
+		duration      int
+		min, max      int
+		want          int
+	}{
+		// an hour doesn't fit 50 MB at any useful bitrate, so the floor
+		// for the frame after scaling to convertWidth decides
+		{"landscape", 1920, 1080, 3600, 200, 5000, 328},
+		{"4k is scaled down first", 3840, 2160, 3600, 200, 5000, 328},
+		{"small videos are scaled up", 640, 360, 3600, 200, 5000, 328},
+		{"square", 1080, 1080, 3600, 200, 5000, 583},
+		{"portrait", 1080, 1920, 3600, 200, 5000, 1036},
+		{"unknown dimensions", 0, 0, 3600, 200, 5000, 200},
+		{"floor under MIN_VIDEO_BITRATE", 1920, 1080, 3600, 400, 5000, 400},
+		{"floor over MAX_VIDEO_BITRATE", 1080, 1920, 3600, 200, 800, 800},
+		// the budget wins when it is above the floor
+		{"budget above the floor", 1920, 1080, 600, 200, 5000, 536},
+		{"budget below the portrait floor", 1080, 1920, 600, 200, 5000, 1036},
+		{"short video", 1920, 1080, 10, 200, 5000, 5000},
+	}
+
+	for _, tt := range tests {
+		minVideoBitrate, maxVideoBitrate = tt.min, tt.max
+		media := &Media{user: "test", Width: tt.width, Height: tt.height, Duration: CustomDuration(tt.duration)}
+		if got := media.determineConversionStrategy().Bitrate; got != tt.want {
+			t.Errorf("%s: %dx%d, %ds: bitrate = %d, want %d", tt.name, tt.width, tt.height, tt.duration, got, tt.want)
+		}
+	}
+}
+
+func TestVideoCodecArgs(t *testing.T) {
+	tests := []struct {
+		strategy conversionStrategy
+		preset   string
+		want     string
+	}{
+		{conversionStrategy{Bitrate: 2000}, "", "-c:v libx264 -b:v 2000k"},
+		{conversionStrategy{Bitrate: 2000}, "slow", "-c:v libx264 -preset slow -b:v 2000k"},
+		{conversionStrategy{CRF: 23}, "", "-c:v libx264 -crf 23"},
+		{conversionStrategy{CRF: 28}, "veryfast", "-c:v libx264 -preset veryfast -crf 28"},
+	}
+
+	for _, tt := range tests {
+		if got := strings.Join(videoCodecArgs(tt.strategy, tt.preset), " "); got != tt.want {
+			t.Errorf("videoCodecArgs(%+v, %q) = %q, want %q", tt.strategy, tt.preset, got, tt.want)
+		}
+	}
+}
+
+func TestVideoCodecArgsHWAccel(t *testing.T) {
+	tests := []struct {
+		strategy conversionStrategy
+		preset   string
+		want     string
+	}{
+		{conversionStrategy{Bitrate: 2000, HWAccel: hwaccelNVENC}, "p5", "-c:v h264_nvenc -preset p5 -b:v 2000k"},
+		{conversionStrategy{CRF: 28, HWAccel: hwaccelNVENC}, "", "-c:v h264_nvenc -cq 28"},
+		{conversionStrategy{Bitrate: 2000, HWAccel: hwaccelVAAPI}, "slow", "-c:v h264_vaapi -b:v 2000k"},
+		{conversionStrategy{CRF: 28, HWAccel: hwaccelVAAPI}, "", "-c:v h264_vaapi -qp 28"},
+	}
+
+	for _, tt := range tests {
+		if got := strings.Join(videoCodecArgs(tt.strategy, tt.preset), " "); got != tt.want {
+			t.Errorf("videoCodecArgs(%+v, %q) = %q, want %q", tt.strategy, tt.preset, got, tt.want)
+		}
+	}
+}
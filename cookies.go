@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/google/uuid"
+)
+
+// maxCookiesFileSize protects against replies to arbitrary large documents.
+const maxCookiesFileSize = 1 << 20
+
+// defaultCookiesFile returns the cookies file used unless a trusted user
+// provides one.
+func defaultCookiesFile() string {
+	if path := os.Getenv("COOKIES_FILE"); path != "" {
+		return path
+	}
+	return "/app/cookies.txt"
+}
+
+// isTrustedUser reports whether username may use features that are unsafe
+// to expose to everyone, like custom cookies. The admin is always trusted.
+func isTrustedUser(username string) bool {
+	if username == "" {
+		return false
+	}
+	if adminUsername != "" && username == adminUsername {
+		return true
+	}
+	for _, trusted := range trustedUsers {
+		if strings.EqualFold(trusted, username) {
+			return true
+		}
+	}
+	return false
+}
+
+// replyCookiesDocument returns the document the message replies to, if any.
+func replyCookiesDocument(msg *models.Message) *models.Document {
+	if msg.ReplyToMessage == nil {
+		return nil
+	}
+	return msg.ReplyToMessage.Document
+}
+
+// downloadCookiesFile saves a cookies document uploaded by the user into
+// tmpDir and returns its path. The caller is responsible for removing it.
+func downloadCookiesFile(ctx context.Context, b *bot.Bot, doc *models.Document) (string, error) {
+	if doc.FileSize > maxCookiesFileSize {
+		return "", fmt.Errorf("cookies file is too large (%d bytes)", doc.FileSize)
+	}
+
+	file, err := b.GetFile(ctx, &bot.GetFileParams{FileID: doc.FileID})
+	if err != nil {
+		return "", fmt.Errorf("error getting file info: %s", err)
+	}
+
+	var src io.ReadCloser
+	if filepath.IsAbs(file.FilePath) {
+		// the local Bot API server returns a path on its own file system
+		src, err = os.Open(file.FilePath)
+		if err != nil {
+			return "", fmt.Errorf("error opening file: %s", err)
+		}
+	} else {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.FileDownloadLink(file), nil)
+		if err != nil {
+			return "", fmt.Errorf("error creating request: %s", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("error downloading file: %s", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return "", fmt.Errorf("error downloading file: status %s", resp.Status)
+		}
+		src = resp.Body
+	}
+	defer src.Close()
+
+	path := filepath.Join(tmpDir, uuid.New().String()+".cookies.txt")
+	dst, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return "", fmt.Errorf("error creating cookies file: %s", err)
+	}
+
+	_, err = io.Copy(dst, io.LimitReader(src, maxCookiesFileSize))
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("error saving cookies file: %s", err)
+	}
+
+	return path, nil
+}
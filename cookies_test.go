@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestIsTrustedUser(t *testing.T) {
+	oldAdmin, oldTrusted := adminUsername, trustedUsers
+	defer func() { adminUsername, trustedUsers = oldAdmin, oldTrusted }()
+	adminUsername = "admin"
+	trustedUsers = []string{"Alice", "bob"}
+
+	tests := []struct {
+		username string
+		want     bool
+	}{
+		{"admin", true},
+		{"alice", true},
+		{"ALICE", true},
+		{"bob", true},
+		{"carol", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := isTrustedUser(tt.username); got != tt.want {
+			t.Errorf("isTrustedUser(%q) = %v, want %v", tt.username, got, tt.want)
+		}
+	}
+
+	adminUsername = ""
+	if isTrustedUser("") {
+		t.Error("isTrustedUser(\"\") = true without an admin")
+	}
+}
+
+func TestReplyCookiesDocument(t *testing.T) {
+	doc := &models.Document{FileID: "cookies"}
+
+	tests := []struct {
+		name string
+		msg  *models.Message
+		want *models.Document
+	}{
+		{"no reply", &models.Message{}, nil},
+		{"reply without a document", &models.Message{ReplyToMessage: &models.Message{Text: "hi"}}, nil},
+		{"reply to a document", &models.Message{ReplyToMessage: &models.Message{Document: doc}}, doc},
+	}
+
+	for _, tt := range tests {
+		if got := replyCookiesDocument(tt.msg); got != tt.want {
+			t.Errorf("%s: replyCookiesDocument() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadCookiesFile(t *testing.T) {
+	oldTmp := tmpDir
+	defer func() { tmpDir = oldTmp }()
+	tmpDir = t.TempDir()
+
+	const cookies = "# Netscape HTTP Cookie File\n.example.com\tTRUE\t/\tTRUE\t0\tsid\tabc\n"
+
+	local := filepath.Join(t.TempDir(), "local.txt")
+	if err := os.WriteFile(local, []byte(cookies), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		filePath string
+		size     int64
+		wantErr  string
+	}{
+		{"local Bot API server", local, int64(len(cookies)), ""},
+		{"downloaded", "documents/remote.txt", int64(len(cookies)), ""},
+		{"missing", "documents/missing.txt", int64(len(cookies)), "status 404"},
+		{"too large", "documents/remote.txt", maxCookiesFileSize + 1, "too large"},
+	}
+
+	for _, tt := range tests {
+		b := newTestBot(t)
+		b.respond("getFile", map[string]any{"file_id": "cookies", "file_path": tt.filePath})
+		b.serveFile("remote.txt", cookies)
+
+		path, err := downloadCookiesFile(context.Background(), b.Bot, &models.Document{FileID: "cookies", FileSize: tt.size})
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: downloadCookiesFile error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: downloadCookiesFile: %s", tt.name, err)
+			continue
+		}
+
+		if filepath.Dir(path) != tmpDir {
+			t.Errorf("%s: cookies saved to %s, outside of %s", tt.name, path, tmpDir)
+		}
+		got, err := os.ReadFile(path)
+		if err != nil || string(got) != cookies {
+			t.Errorf("%s: saved cookies = %q, %v", tt.name, got, err)
+		}
+		if info, err := os.Stat(path); err == nil && info.Mode().Perm() != 0600 {
+			t.Errorf("%s: cookies file mode = %v, want 0600", tt.name, info.Mode().Perm())
+		}
+		os.Remove(path)
+	}
+
+	entries, _ := os.ReadDir(tmpDir)
+	if len(entries) != 0 {
+		t.Errorf("files left in tmpDir: %v", entries)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-telegram/bot"
+)
+
+// diskAlertInterval keeps the admin from getting a message for every
+// request while the disk stays full.
+const diskAlertInterval = time.Hour
+
+var (
+	diskAlertMu   sync.Mutex
+	lastDiskAlert time.Time
+)
+
+// freeDiskSpace returns the bytes available to the bot on the filesystem
+// that holds path.
+func freeDiskSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, fmt.Errorf("error checking free space on %s: %s", path, err)
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
+
+// hasEnoughDiskSpace reports whether tmpDir has at least MIN_FREE_DISK_MB
+// free. The admin is told when it hasn't, at most once per
+// diskAlertInterval. If the free space can't be checked, downloads go
+// ahead.
+func hasEnoughDiskSpace(ctx context.Context, b *bot.Bot) bool {
+	if minFreeDiskSpace == 0 {
+		return true
+	}
+
+	free, err := freeDiskSpace(tmpDir)
+	if err != nil {
+		log.Print(err)
+		return true
+	}
+	if free >= minFreeDiskSpace {
+		return true
+	}
+
+	const mb = 1024 * 1024
+	log.Printf("Only %d MB free in %s, refusing downloads", free/mb, tmpDir)
+
+	diskAlertMu.Lock()
+	alert := time.Since(lastDiskAlert) >= diskAlertInterval
+	if alert {
+		lastDiskAlert = time.Now()
+	}
+	diskAlertMu.Unlock()
+
+	if alert {
+		sendMessageToAdmin(ctx, b, fmt.Sprintf("Only %d MB free in %s, downloads are refused until at least %d MB are free.", free/mb, tmpDir, minFreeDiskSpace/mb))
+	}
+	return false
+}
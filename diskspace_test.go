@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFreeDiskSpace(t *testing.T) {
+	free, err := freeDiskSpace(t.TempDir())
+	if err != nil || free == 0 {
+		t.Errorf("freeDiskSpace = %d, %v, want some space", free, err)
+	}
+
+	if _, err := freeDiskSpace(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("freeDiskSpace of a missing directory succeeded")
+	}
+}
+
+func TestHasEnoughDiskSpace(t *testing.T) {
+	oldTmp, oldMinFree, oldChat := tmpDir, minFreeDiskSpace, adminChatID.Load()
+	defer func() {
+		tmpDir, minFreeDiskSpace = oldTmp, oldMinFree
+		adminChatID.Store(oldChat)
+		diskAlertMu.Lock()
+		lastDiskAlert = time.Time{}
+		diskAlertMu.Unlock()
+	}()
+	adminChatID.Store(99)
+
+	dir := t.TempDir()
+	tests := []struct {
+		name      string
+		dir       string
+		minFree   uint64
+		lastAlert time.Duration
+		want      bool
+		alerts    int
+	}{
+		{"not checked", dir, 0, 0, true, 0},
+		{"enough", dir, 1, 0, true, 0},
+		{"can't check", filepath.Join(dir, "missing"), 1 << 62, 0, true, 0},
+		{"low, admin told", dir, 1 << 62, 2 * time.Hour, false, 1},
+		{"low, admin told recently", dir, 1 << 62, 10 * time.Minute, false, 0},
+	}
+
+	for _, tt := range tests {
+		b := newTestBot(t)
+		tmpDir, minFreeDiskSpace = tt.dir, tt.minFree
+		diskAlertMu.Lock()
+		lastDiskAlert = time.Now().Add(-tt.lastAlert)
+		diskAlertMu.Unlock()
+
+		if got := hasEnoughDiskSpace(context.Background(), b.Bot); got != tt.want {
+			t.Errorf("%s: hasEnoughDiskSpace = %v, want %v", tt.name, got, tt.want)
+		}
+
+		texts := b.sentTexts()
+		if len(texts) != tt.alerts {
+			t.Errorf("%s: sent %q, want %d alerts", tt.name, texts, tt.alerts)
+		} else if tt.alerts > 0 && !strings.Contains(texts[0], "downloads are refused") {
+			t.Errorf("%s: alert %q", tt.name, texts[0])
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errorCategory is a coarse reason a yt-dlp download failed.
+type errorCategory string
+
+const (
+	errorOther       errorCategory = "other"
+	errorExtractor   errorCategory = "extractor"
+	errorUnsupported errorCategory = "unsupported"
+	errorUnavailable errorCategory = "unavailable"
+	errorPrivate     errorCategory = "private"
+	errorMembersOnly errorCategory = "members_only"
+	errorNetwork     errorCategory = "network"
+	errorPassword    errorCategory = "password"
+	errorTimeout     errorCategory = "timeout"
+)
+
+// errorPatterns maps fragments of yt-dlp's stderr to a category. The first
+// match wins, so more specific fragments come first.
+var errorPatterns = []struct {
+	fragment string
+	category errorCategory
+}{
+	{"Unsupported URL", errorUnsupported},
+	{"--video-password", errorPassword},
+	{"Wrong password", errorPassword},
+	{"Verifying the password failed", errorPassword},
+	{"members-only", errorMembersOnly},
+	{"Join this channel to get access", errorMembersOnly},
+	{"available to this channel's members", errorMembersOnly},
+	{"Private video", errorPrivate},
+	{"This video is private", errorPrivate},
+	{"This account is private", errorPrivate},
+	{"Video unavailable", errorUnavailable},
+	{"This video is not available", errorUnavailable},
+	{"This video is no longer available", errorUnavailable},
+	{"This video has been removed", errorUnavailable},
+	{"has been deleted", errorUnavailable},
+	{"HTTP Error 404", errorUnavailable},
+	{"Unable to extract", errorExtractor},
+	{"Signature extraction failed", errorExtractor},
+	{"nsig extraction failed", errorExtractor},
+	{"Failed to parse JSON", errorExtractor},
+	{"please report this issue", errorExtractor},
+	{"Unable to download webpage", errorNetwork},
+	{"timed out", errorNetwork},
+	{"Connection reset", errorNetwork},
+	{"Temporary failure in name resolution", errorNetwork},
+}
+
+// classifyDownloadError picks the category for yt-dlp's stderr.
+func classifyDownloadError(stderr string) errorCategory {
+	lower := strings.ToLower(stderr)
+	for _, p := range errorPatterns {
+		if strings.Contains(lower, strings.ToLower(p.fragment)) {
+			return p.category
+		}
+	}
+	return errorOther
+}
+
+// defaultErrorMessages are shown to the user instead of yt-dlp's output for
+// the most common failures. They can be overridden with
+// ERROR_MESSAGE_<CATEGORY>, e.g. ERROR_MESSAGE_PRIVATE.
+var defaultErrorMessages = map[errorCategory]string{
+	errorPrivate:     "This video is private, so I can't download it.",
+	errorUnavailable: "This video is unavailable. It may have been deleted or blocked.",
+	errorMembersOnly: "This video is only available to channel members, so I can't download it.",
+	errorPassword:    "This video is protected by a password. Send /pw <password> <link> to download it.",
+	errorTimeout:     "This took too long, so I stopped. Very long videos and live streams can't be downloaded.",
+}
+
+// errorMessages holds the friendly message per category, set by loadConfig.
+var errorMessages = map[errorCategory]string{}
+
+// loadErrorMessages applies the ERROR_MESSAGE_* overrides to the defaults.
+func loadErrorMessages() {
+	for category, message := range defaultErrorMessages {
+		errorMessages[category] = getEnvString("ERROR_MESSAGE_"+strings.ToUpper(string(category)), message)
+	}
+}
+
+// friendlyErrorMessage returns the message for a download error that has
+// one, or "".
+func friendlyErrorMessage(err error) string {
+	var dlErr *DownloadError
+	if !errors.As(err, &dlErr) {
+		return ""
+	}
+	return errorMessages[dlErr.Category]
+}
+
+// Levels of USER_ERROR_DETAIL.
+const (
+	errorDetailMinimal  = "minimal"
+	errorDetailFriendly = "friendly"
+	errorDetailFull     = "full"
+)
+
+const genericErrorMessage = "I'm sorry, I couldn't download that."
+
+// userErrorMessage picks what a user sees about a failed download. full is
+// the complete error text, used when no friendly message is known.
+func userErrorMessage(level string, err error, full string) string {
+	friendly := friendlyErrorMessage(err)
+
+	switch level {
+	case errorDetailMinimal:
+		return genericErrorMessage
+	case errorDetailFriendly:
+		if friendly != "" {
+			return friendly
+		}
+		return genericErrorMessage
+	default:
+		if friendly != "" {
+			return friendly
+		}
+		return full
+	}
+}
+
+// DownloadError is a failed yt-dlp run along with why it failed.
+type DownloadError struct {
+	Category errorCategory
+	Stderr   string
+	Err      error
+}
+
+func newDownloadError(err error) *DownloadError {
+	var stderr string
+	var cmdErr *commandError
+	if errors.As(err, &cmdErr) {
+		stderr = cmdErr.stderr
+	}
+
+	return &DownloadError{
+		Category: classifyDownloadError(stderr),
+		Stderr:   stderr,
+		Err:      fmt.Errorf("command execution failed with %s", err),
+	}
+}
+
+// newTimeoutError reports that command was killed after running for
+// timeout.
+func newTimeoutError(command string, timeout time.Duration) *DownloadError {
+	return &DownloadError{
+		Category: errorTimeout,
+		Err:      fmt.Errorf("%s timed out after %s", command, timeout),
+	}
+}
+
+func (e *DownloadError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DownloadError) Unwrap() error {
+	return e.Err
+}
+
+// failureDetector counts extractor failures per host in a sliding window.
+// It reports a host once when its failures reach the threshold, and again
+// only after they dropped below it, so the admin isn't flooded.
+type failureDetector struct {
+	mu        sync.Mutex
+	threshold int
+	window    time.Duration
+	failures  map[string][]time.Time
+	alerted   map[string]bool
+}
+
+func newFailureDetector(threshold int, window time.Duration) *failureDetector {
+	return &failureDetector{
+		threshold: threshold,
+		window:    window,
+		failures:  make(map[string][]time.Time),
+		alerted:   make(map[string]bool),
+	}
+}
+
+// Record adds a failure for host at now and reports whether the threshold
+// was just crossed.
+func (d *failureDetector) Record(host string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	recent := d.failures[host][:0]
+	for _, t := range d.failures[host] {
+		if now.Sub(t) < d.window {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	d.failures[host] = recent
+
+	if len(recent) < d.threshold {
+		d.alerted[host] = false
+		return false
+	}
+	if d.alerted[host] {
+		return false
+	}
+	d.alerted[host] = true
+	return true
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClassifyDownloadError(t *testing.T) {
+	tests := []struct {
+		stderr string
+		want   errorCategory
+	}{
+		{"ERROR: Unsupported URL: https://example.com/", errorUnsupported},
+		{"ERROR: [youtube] abc: Unable to extract uploader id; please report this issue on https://github.com/yt-dlp/yt-dlp/issues", errorExtractor},
+		{"ERROR: [youtube] abc: Signature extraction failed: Some formats may be missing", errorExtractor},
+		{"WARNING: [youtube] nsig extraction failed: You may experience throttling", errorExtractor},
+		{"ERROR: [instagram] abc: Failed to parse JSON", errorExtractor},
+		{"ERROR: [youtube] abc: Private video. Sign in if you've been granted access", errorPrivate},
+		{"ERROR: [youtube] abc: Video unavailable", errorUnavailable},
+		{"ERROR: unable to download video data: HTTP Error 404: Not Found", errorUnavailable},
+		{"ERROR: [generic] Unable to download webpage: <urlopen error timed out>", errorNetwork},
+		{"ERROR: [vimeo] abc: This video is protected by a password, use the --video-password option", errorPassword},
+		{"ERROR: [youtube] abc: Join this channel to get access to members-only content", errorMembersOnly},
+		{"ERROR: something new", errorOther},
+		{"", errorOther},
+	}
+
+	for _, tt := range tests {
+		if got := classifyDownloadError(tt.stderr); got != tt.want {
+			t.Errorf("classifyDownloadError(%q) = %s, want %s", tt.stderr, got, tt.want)
+		}
+	}
+}
+
+func TestNewDownloadError(t *testing.T) {
+	fakeCommand(t, "yt-dlp", "echo 'ERROR: [youtube] abc: Unable to extract uploader id' >&2; exit 1")
+
+	_, err := runCommand(context.Background(), "test", []string{"yt-dlp", "https://youtu.be/abc"})
+	dlErr := newDownloadError(err)
+
+	if dlErr.Category != errorExtractor {
+		t.Errorf("category = %s, want %s", dlErr.Category, errorExtractor)
+	}
+	if !strings.Contains(dlErr.Stderr, "Unable to extract") {
+		t.Errorf("stderr = %q", dlErr.Stderr)
+	}
+	if !strings.HasPrefix(dlErr.Error(), "command execution failed with exit status 1") {
+		t.Errorf("Error() = %q", dlErr.Error())
+	}
+
+	var wrapped error = dlErr
+	var target *DownloadError
+	if !errors.As(wrapped, &target) || target != dlErr {
+		t.Error("errors.As doesn't find the DownloadError")
+	}
+
+	// an error that isn't from a command can't be classified
+	if got := newDownloadError(errors.New("boom")); got.Category != errorOther || got.Stderr != "" {
+		t.Errorf("newDownloadError(boom) = %+v", got)
+	}
+}
+
+func TestFailureDetector(t *testing.T) {
+	start := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	d := newFailureDetector(3, time.Hour)
+
+	steps := []struct {
+		host string
+		at   time.Duration
+		want bool
+	}{
+		{"youtube.com", 0, false},
+		{"youtube.com", 10 * time.Minute, false},
+		{"vimeo.com", 15 * time.Minute, false},
+		{"youtube.com", 20 * time.Minute, true},
+		// already reported
+		{"youtube.com", 25 * time.Minute, false},
+		{"youtube.com", 30 * time.Minute, false},
+		// the failures at 0 to 25 minutes have left the window, so the
+		// count drops below the threshold before it is reached again
+		{"youtube.com", 95 * time.Minute, false},
+		{"youtube.com", 100 * time.Minute, false},
+		{"youtube.com", 105 * time.Minute, true},
+		{"vimeo.com", 30 * time.Minute, false},
+		{"vimeo.com", 40 * time.Minute, true},
+	}
+
+	for i, step := range steps {
+		if got := d.Record(step.host, start.Add(step.at)); got != step.want {
+			t.Errorf("step %d: Record(%s, +%s) = %v, want %v", i, step.host, step.at, got, step.want)
+		}
+	}
+}
+
+func TestHostOf(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"https://www.youtube.com/watch?v=abc", "youtube.com"},
+		{"https://WWW.YouTube.com/watch?v=abc", "youtube.com"},
+		{"https://vimeo.com:443/123", "vimeo.com"},
+		{"https://m.youtube.com/watch", "m.youtube.com"},
+		{"not a url", ""},
+		{"://", ""},
+	}
+
+	for _, tt := range tests {
+		if got := hostOf(tt.input); got != tt.want {
+			t.Errorf("hostOf(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestFriendlyErrorMessage(t *testing.T) {
+	oldMessages := errorMessages
+	defer func() { errorMessages = oldMessages }()
+	errorMessages = map[errorCategory]string{}
+
+	t.Setenv("ERROR_MESSAGE_PRIVATE", "Private, sorry.")
+	loadErrorMessages()
+
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&DownloadError{Category: errorPrivate}, "Private, sorry."},
+		{&DownloadError{Category: errorUnavailable}, defaultErrorMessages[errorUnavailable]},
+		{&DownloadError{Category: errorMembersOnly}, defaultErrorMessages[errorMembersOnly]},
+		{fmt.Errorf("downloading: %w", &DownloadError{Category: errorUnavailable}), defaultErrorMessages[errorUnavailable]},
+		{&DownloadError{Category: errorExtractor}, ""},
+		{errors.New("disk full"), ""},
+	}
+
+	for _, tt := range tests {
+		if got := friendlyErrorMessage(tt.err); got != tt.want {
+			t.Errorf("friendlyErrorMessage(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestUserErrorMessage(t *testing.T) {
+	oldMessages := errorMessages
+	defer func() { errorMessages = oldMessages }()
+	errorMessages = map[errorCategory]string{errorPrivate: "Private, sorry."}
+
+	private := &DownloadError{Category: errorPrivate}
+	other := &DownloadError{Category: errorOther}
+	full := "Error downloading video: exit status 1"
+
+	tests := []struct {
+		level string
+		err   error
+		want  string
+	}{
+		{errorDetailMinimal, private, genericErrorMessage},
+		{errorDetailMinimal, other, genericErrorMessage},
+		{errorDetailFriendly, private, "Private, sorry."},
+		{errorDetailFriendly, other, genericErrorMessage},
+		{errorDetailFull, private, "Private, sorry."},
+		{errorDetailFull, other, full},
+		{errorDetailFull, errors.New("disk full"), full},
+	}
+
+	for _, tt := range tests {
+		if got := userErrorMessage(tt.level, tt.err, full); got != tt.want {
+			t.Errorf("userErrorMessage(%s, %v) = %q, want %q", tt.level, tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+)
+
+var (
+	extractorsMu sync.RWMutex
+	extractors   []string
+)
+
+// extractorAliases maps short and alternative domains to the extractor
+// that handles them.
+var extractorAliases = map[string]string{
+	"youtu.be":   "youtube",
+	"x.com":      "twitter",
+	"fb.watch":   "facebook",
+	"instagr.am": "instagram",
+}
+
+// loadExtractors caches the list of yt-dlp extractors. Listing them takes a
+// while, so it is done once at startup.
+func loadExtractors(ctx context.Context) {
+	out, err := runCommand(ctx, "system", []string{"yt-dlp", "--list-extractors"})
+	if err != nil {
+		log.Printf("Error listing yt-dlp extractors: %s", err)
+		return
+	}
+
+	var names []string
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			names = append(names, line)
+		}
+	}
+
+	extractorsMu.Lock()
+	extractors = names
+	extractorsMu.Unlock()
+
+	log.Printf("Loaded %d yt-dlp extractors", len(names))
+}
+
+func getExtractors() []string {
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+	return extractors
+}
+
+// matchExtractor finds an extractor for host. Extractor names are matched
+// against the host's labels, so "www.youtube.com" matches "youtube" and
+// "youtube:tab". The generic extractor never counts as a match.
+func matchExtractor(host string, names []string) (string, bool) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+
+	labels := strings.Split(host, ".")
+	if len(labels) > 1 {
+		// the top-level domain never identifies a site
+		labels = labels[:len(labels)-1]
+	}
+	if alias, ok := matchHost(host, extractorAliases); ok {
+		labels = append([]string{alias}, labels...)
+	}
+
+	for _, label := range labels {
+		if label == "www" || label == "m" || label == "" {
+			continue
+		}
+
+		for _, name := range names {
+			base := strings.ToLower(strings.SplitN(name, ":", 2)[0])
+			if base == "generic" {
+				continue
+			}
+			if normalizeExtractorName(base) == label {
+				return name, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+func normalizeExtractorName(name string) string {
+	var sb strings.Builder
+	for _, r := range name {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
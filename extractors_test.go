@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+)
+
+var testExtractors = []string{
+	"generic",
+	"Vimeo",
+	"vimeo:album",
+	"youtube",
+	"youtube:tab",
+	"twitter",
+	"TikTok",
+	"Dailymotion",
+	"9gag",
+}
+
+func TestMatchExtractor(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+		ok   bool
+	}{
+		{"youtube.com", "youtube", true},
+		{"www.youtube.com", "youtube", true},
+		{"m.youtube.com", "youtube", true},
+		{"youtu.be", "youtube", true},
+		{"x.com", "twitter", true},
+		{"VIMEO.COM", "Vimeo", true},
+		{"player.vimeo.com:443", "Vimeo", true},
+		{"www.tiktok.com.", "TikTok", true},
+		{"9gag.com", "9gag", true},
+		{"example.com", "", false},
+		{"generic.com", "", false},
+		{"com", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := matchExtractor(tt.host, testExtractors)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("matchExtractor(%q) = %q, %v, want %q, %v", tt.host, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLoadExtractors(t *testing.T) {
+	oldExtractors := extractors
+	defer func() { extractors = oldExtractors }()
+
+	fakeCommand(t, "yt-dlp", `printf 'generic\n\n  Vimeo  \nyoutube:tab\n'`)
+	loadExtractors(context.Background())
+
+	if got, want := strings.Join(getExtractors(), ","), "generic,Vimeo,youtube:tab"; got != want {
+		t.Errorf("extractors = %q, want %q", got, want)
+	}
+
+	// a failed listing keeps what was loaded before
+	fakeCommand(t, "yt-dlp", "exit 1")
+	loadExtractors(context.Background())
+	if got := len(getExtractors()); got != 3 {
+		t.Errorf("%d extractors after a failed listing, want 3", got)
+	}
+}
+
+func TestSupportedHandler(t *testing.T) {
+	oldExtractors := extractors
+	defer func() { extractors = oldExtractors }()
+
+	tests := []struct {
+		text       string
+		extractors []string
+		reply      string
+	}{
+		{"/supported", testExtractors, "Usage"},
+		{"/supported some site", testExtractors, "Usage"},
+		{"/supported vimeo.com", testExtractors, "Yes, vimeo.com is supported (extractor: Vimeo)."},
+		{"/supported https://www.youtube.com/watch?v=x", testExtractors, "Yes, www.youtube.com is supported (extractor: youtube)."},
+		{"/supported example.com", testExtractors, "No, example.com is not among the 9 sites"},
+		{"/supported vimeo.com", nil, "isn't available"},
+	}
+
+	for _, tt := range tests {
+		b := newTestBot(t)
+		extractors = tt.extractors
+
+		update := &models.Update{Message: &models.Message{
+			Text: tt.text,
+			From: &models.User{ID: 1, Username: "test"},
+			Chat: models.Chat{ID: 1},
+		}}
+		supportedHandler(context.Background(), b.Bot, update)
+
+		texts := b.sentTexts()
+		if len(texts) != 1 || !strings.Contains(texts[0], tt.reply) {
+			t.Errorf("%q: sent %q, want a message containing %q", tt.text, texts, tt.reply)
+		}
+	}
+}
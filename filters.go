@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// convertWidth is the width videos are scaled to when converting.
+const convertWidth = 1080
+
+type aspectRatio struct {
+	W, H int
+}
+
+// parseAspectRatio parses ratios like "16:9".
+func parseAspectRatio(s string) (aspectRatio, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return aspectRatio{}, fmt.Errorf("invalid aspect ratio '%s'", s)
+	}
+
+	w, err := strconv.Atoi(parts[0])
+	if err != nil || w <= 0 {
+		return aspectRatio{}, fmt.Errorf("invalid aspect ratio '%s'", s)
+	}
+	h, err := strconv.Atoi(parts[1])
+	if err != nil || h <= 0 {
+		return aspectRatio{}, fmt.Errorf("invalid aspect ratio '%s'", s)
+	}
+
+	return aspectRatio{W: w, H: h}, nil
+}
+
+// scaledHeight returns the height ffmpeg's "scale=<width>:-2" produces for a
+// video of the given dimensions.
+func scaledHeight(width, height, scaleWidth int) int {
+	return int(math.Round(float64(scaleWidth)*float64(height)/float64(width)/2)) * 2
+}
+
+// padFilter returns an ffmpeg pad filter that adds black bars to a video of
+// the given dimensions, after it was scaled to scaleWidth, so that it has
+// the target aspect ratio. It returns "" when the video is already within
+// 1% of the target or its dimensions are unknown.
+func padFilter(width, height, scaleWidth int, target aspectRatio) string {
+	if width <= 0 || height <= 0 || target.W <= 0 || target.H <= 0 {
+		return ""
+	}
+
+	w := scaleWidth
+	h := scaledHeight(width, height, scaleWidth)
+
+	current := float64(w) / float64(h)
+	wanted := float64(target.W) / float64(target.H)
+	if math.Abs(current-wanted)/wanted < 0.01 {
+		return ""
+	}
+
+	padW, padH := w, h
+	if current < wanted {
+		padW = int(math.Ceil(float64(h)*wanted/2)) * 2
+	} else {
+		padH = int(math.Ceil(float64(w)/wanted/2)) * 2
+	}
+
+	return fmt.Sprintf("pad=%d:%d:(ow-iw)/2:(oh-ih)/2:black", padW, padH)
+}
+
+// checkCustomFilters validates a CONVERSION_VF filter chain. The chain is
+// appended after the bot's own filters as is, so it must not scale or pad
+// the video again: that would undo the sizing the bitrate is computed for.
+func checkCustomFilters(chain string) error {
+	for _, f := range strings.Split(chain, ",") {
+		name, _, _ := strings.Cut(strings.TrimSpace(f), "=")
+		switch name {
+		case "scale", "zscale", "pad":
+			return fmt.Errorf("filter '%s' conflicts with the built-in scaling", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import "testing"
+
+func TestParseAspectRatio(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    aspectRatio
+		wantErr bool
+	}{
+		{"16:9", aspectRatio{16, 9}, false},
+		{"4:5", aspectRatio{4, 5}, false},
+		{"1:1", aspectRatio{1, 1}, false},
+		{"16x9", aspectRatio{}, true},
+		{"16:9:1", aspectRatio{}, true},
+		{"0:9", aspectRatio{}, true},
+		{"16:-9", aspectRatio{}, true},
+		{"a:b", aspectRatio{}, true},
+		{"", aspectRatio{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseAspectRatio(tt.s)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseAspectRatio(%q) = %v, %v, want %v, error %v", tt.s, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestScaledHeight(t *testing.T) {
+	tests := []struct {
+		width, height, scaleWidth int
+		want                      int
+	}{
+		{1920, 1080, 1080, 608},
+		{1280, 720, 1080, 608},
+		{640, 480, 1080, 810},
+		{1080, 1920, 1080, 1920},
+		{1000, 333, 1080, 360},
+	}
+
+	for _, tt := range tests {
+		if got := scaledHeight(tt.width, tt.height, tt.scaleWidth); got != tt.want {
+			t.Errorf("scaledHeight(%d, %d, %d) = %d, want %d", tt.width, tt.height, tt.scaleWidth, got, tt.want)
+		}
+	}
+}
+
+func TestPadFilter(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		target        aspectRatio
+		want          string
+	}{
+		{"already 16:9", 1920, 1080, aspectRatio{16, 9}, ""},
+		{"landscape to square", 1920, 1080, aspectRatio{1, 1}, "pad=1080:1080:(ow-iw)/2:(oh-ih)/2:black"},
+		{"landscape to 4:5", 1920, 1080, aspectRatio{4, 5}, "pad=1080:1350:(ow-iw)/2:(oh-ih)/2:black"},
+		{"portrait to 16:9", 1080, 1920, aspectRatio{16, 9}, "pad=3414:1920:(ow-iw)/2:(oh-ih)/2:black"},
+		{"unknown dimensions", 0, 0, aspectRatio{16, 9}, ""},
+		{"no target", 1920, 1080, aspectRatio{}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := padFilter(tt.width, tt.height, convertWidth, tt.target); got != tt.want {
+			t.Errorf("%s: padFilter(%d, %d) = %q, want %q", tt.name, tt.width, tt.height, got, tt.want)
+		}
+	}
+}
+
+func TestCheckCustomFilters(t *testing.T) {
+	tests := []struct {
+		chain   string
+		wantErr bool
+	}{
+		{"eq=brightness=0.05", false},
+		{"hqdn3d,unsharp=5:5:0.8", false},
+		{"eq=contrast=1.1, hue=s=0", false},
+		{"scale=640:-2", true},
+		{"eq=gamma=1.2,zscale=t=linear", true},
+		{"hqdn3d, pad=1920:1080", true},
+		{"scale", true},
+	}
+
+	for _, tt := range tests {
+		if err := checkCustomFilters(tt.chain); (err != nil) != tt.wantErr {
+			t.Errorf("checkCustomFilters(%q) = %v, want error %v", tt.chain, err, tt.wantErr)
+		}
+	}
+}
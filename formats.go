@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/google/uuid"
+)
+
+// listFormats returns yt-dlp's table of the formats available for
+// mediaUrl.
+func listFormats(ctx context.Context, mediaUrl string, user string, cookiesFile string) (string, error) {
+	cmdSlice := []string{"yt-dlp", "-F", "--no-playlist", mediaUrl}
+	if cookiesFile != "" {
+		cmdSlice = append(cmdSlice, "--cookies", cookiesFile)
+	}
+
+	out, err := runCommand(ctx, user, cmdSlice)
+	if err != nil {
+		return "", newDownloadError(err)
+	}
+
+	table := formatsTable(string(out))
+	if table == "" {
+		return "", fmt.Errorf("yt-dlp listed no formats")
+	}
+	return table, nil
+}
+
+// formatsTable drops the progress lines yt-dlp prints before the table of
+// formats, like "[youtube] abc: Downloading webpage".
+func formatsTable(out string) string {
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "ID ") || strings.HasPrefix(line, "format code") {
+			return strings.Join(lines[i:], "\n")
+		}
+	}
+	return ""
+}
+
+// formatIDPattern matches format IDs like "137", "hls-720p" or "137+140".
+// They are passed to yt-dlp as an argument, never through a shell, but
+// anything else isn't a format yt-dlp listed.
+var formatIDPattern = regexp.MustCompile(`^[A-Za-z0-9+-]+$`)
+
+// parseFormatArgs splits the arguments of /format into the format ID and
+// the link.
+func parseFormatArgs(args string) (formatID string, input string, err error) {
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("expected a format ID and a link")
+	}
+	if !formatIDPattern.MatchString(fields[0]) {
+		return "", "", fmt.Errorf("invalid format ID '%s'", fields[0])
+	}
+	return fields[0], fields[1], nil
+}
+
+// formatHandler downloads the format picked from the /formats list. It is
+// registered for the "/format" prefix, which /formats shares, so it also
+// dispatches /formats.
+func formatHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		log.Println("Received format command with nil Message")
+		return
+	}
+
+	if strings.HasPrefix(update.Message.Text, "/formats") {
+		formatsHandler(ctx, b, update)
+		return
+	}
+
+	formatID, input, err := parseFormatArgs(strings.TrimPrefix(update.Message.Text, "/format"))
+	if err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Usage: /format <id> <link>, with an ID from /formats (%s)", err),
+		})
+		return
+	}
+
+	handleDownload(ctx, b, update, input, DownloadOptions{FormatID: formatID}, "")
+}
+
+// formatsHandler answers /formats <url> with the formats yt-dlp can
+// download, as a message if it fits or as a text file otherwise.
+func formatsHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		log.Println("Received formats command with nil Message")
+		return
+	}
+	log.Printf("[%s]: received message: '%s'", update.Message.From.Username, update.Message.Text)
+
+	if !downloadAllowed(ctx, b, update.Message, true) {
+		return
+	}
+
+	input, err := cleanupAndVerifyInput(strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/formats")))
+	if err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Usage: /formats <link>",
+		})
+		return
+	}
+
+	table, err := listFormats(ctx, input, update.Message.From.Username, defaultCookiesFile())
+	if err != nil {
+		log.Printf("[%s]: error listing formats: %s", update.Message.From.Username, err)
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   userErrorMessage(userErrorDetail, err, fmt.Sprintf("I couldn't list the formats: %s", err)),
+		})
+		return
+	}
+
+	text := "<pre>" + html.EscapeString(table) + "</pre>"
+	if len([]rune(text)) <= telegramMessageLimit {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:    update.Message.Chat.ID,
+			Text:      text,
+			ParseMode: models.ParseModeHTML,
+		})
+		return
+	}
+
+	path := filepath.Join(tmpDir, uuid.New().String()+".formats.txt")
+	if err := os.WriteFile(path, []byte(table+"\n"), 0644); err != nil {
+		log.Printf("[%s]: error writing formats: %s", update.Message.From.Username, err)
+		return
+	}
+	defer os.Remove(path)
+
+	if _, err := sendAsDocument(ctx, b, update.Message.Chat.ID, path, "Available formats"); err != nil {
+		log.Printf("[%s]: error sending formats: %s", update.Message.From.Username, err)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// gifStep is one size a GIF is tried at. Each step is smaller than the one
+// before, until the GIF fits GIF_MAX_MB.
+type gifStep struct {
+	width int
+	fps   int
+}
+
+var gifSteps = []gifStep{
+	{width: 480, fps: 15},
+	{width: 360, fps: 12},
+	{width: 240, fps: 10},
+}
+
+// gifTooLongMessage tells the user the clip is over GIF_MAX_SECONDS.
+func gifTooLongMessage() string {
+	return fmt.Sprintf("That's too long for a GIF, I only make GIFs of clips up to %d seconds. Try /clip for a part of it.", int(gifMaxDuration.Seconds()))
+}
+
+// gifFilters returns the filters shared by both ffmpeg passes, so the
+// palette is made from the same frames it is applied to.
+func gifFilters(step gifStep) string {
+	return fmt.Sprintf("fps=%d,scale=%d:-2:flags=lanczos", step.fps, step.width)
+}
+
+// gifPaletteArgs returns the first pass, which picks the 256 colors that
+// suit this clip best.
+func gifPaletteArgs(input string, palette string, step gifStep) []string {
+	return []string{
+		"ffmpeg",
+		"-y",
+		"-i", input,
+		"-vf", gifFilters(step) + ",palettegen=stats_mode=diff",
+		palette,
+	}
+}
+
+// gifArgs returns the second pass, which encodes the GIF with the palette.
+func gifArgs(input string, palette string, output string, step gifStep) []string {
+	return []string{
+		"ffmpeg",
+		"-y",
+		"-i", input,
+		"-i", palette,
+		"-lavfi", gifFilters(step) + " [x]; [x][1:v] paletteuse=dither=bayer:bayer_scale=5:diff_mode=rectangle",
+		"-loop", "0",
+		output,
+	}
+}
+
+// convertToGIF replaces the downloaded video with an animated GIF of at
+// most GIF_MAX_MB, going down gifSteps until it fits. Videos longer than
+// GIF_MAX_SECONDS are rejected.
+func (media *Media) convertToGIF(ctx context.Context) error {
+	if max := int(gifMaxDuration.Seconds()); int(media.Duration) > max {
+		return fmt.Errorf("video is too long for a GIF, the limit is %d seconds", max)
+	}
+
+	if err := conversionLimiter.Acquire(ctx); err != nil {
+		return fmt.Errorf("gave up waiting for a conversion slot: %s", err)
+	}
+	defer conversionLimiter.Release()
+
+	palettePath := filepath.Join(media.tmpDir, media.randomName+".palette.png")
+	outputPath := filepath.Join(media.tmpDir, media.randomName+".gif")
+	defer os.Remove(palettePath)
+
+	for i, step := range gifSteps {
+		if media.Width > 0 && step.width > media.Width {
+			step.width = media.Width
+		}
+
+		if err := media.encodeGIF(ctx, palettePath, outputPath, step); err != nil {
+			os.Remove(outputPath)
+			return err
+		}
+
+		info, err := os.Stat(outputPath)
+		if err != nil {
+			return fmt.Errorf("error checking GIF size: %s", err)
+		}
+		if info.Size() <= gifMaxSize {
+			log.Printf("[%s]: GIF is %d bytes at %dpx and %d fps", media.user, info.Size(), step.width, step.fps)
+			if media.Width > 0 && media.Height > 0 {
+				media.Height = scaledHeight(media.Width, media.Height, step.width)
+				media.Width = step.width
+			}
+			break
+		}
+
+		log.Printf("[%s]: GIF is %d bytes at %dpx, over the %d bytes limit", media.user, info.Size(), step.width, gifMaxSize)
+		os.Remove(outputPath)
+		if i == len(gifSteps)-1 {
+			return fmt.Errorf("the GIF is too large even at %dpx, try a shorter clip", step.width)
+		}
+	}
+
+	if err := os.Remove(media.Path); err != nil {
+		log.Printf("error deleting original file: %s", err)
+	}
+	media.Path = outputPath
+	media.FileName = filepath.Base(outputPath)
+
+	return nil
+}
+
+// encodeGIF runs both ffmpeg passes for one step.
+func (media *Media) encodeGIF(ctx context.Context, palettePath string, outputPath string, step gifStep) error {
+	convCtx, cancel := context.WithTimeout(ctx, ffmpegTimeout)
+	defer cancel()
+
+	if _, err := runCommand(convCtx, media.user, gifPaletteArgs(media.Path, palettePath, step)); err != nil {
+		if ctx.Err() == nil && convCtx.Err() == context.DeadlineExceeded {
+			return newTimeoutError("ffmpeg", ffmpegTimeout)
+		}
+		return fmt.Errorf("error generating GIF palette: %s", err)
+	}
+
+	if _, err := runCommand(convCtx, media.user, gifArgs(media.Path, palettePath, outputPath, step)); err != nil {
+		if ctx.Err() == nil && convCtx.Err() == context.DeadlineExceeded {
+			return newTimeoutError("ffmpeg", ffmpegTimeout)
+		}
+		return fmt.Errorf("error converting to GIF: %s", err)
+	}
+
+	return nil
+}
+
+// animationParams returns the parameters for sending media as an animation.
+func animationParams(chatID int64, media *Media) *bot.SendAnimationParams {
+	return &bot.SendAnimationParams{
+		ChatID:    chatID,
+		Animation: &models.InputFileString{Data: "file://" + localPath(media.Path)},
+		Duration:  (int)(media.Duration),
+		Width:     media.Width,
+		Height:    media.Height,
+		Caption:   media.caption(),
+	}
+}
+
+func gifHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		log.Println("Received gif command with nil Message")
+		return
+	}
+	input := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/gif"))
+	handleDownload(ctx, b, update, input, DownloadOptions{GIF: true}, "")
+}
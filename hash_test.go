@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileSHA256(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{"abc", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(dir, "file")
+		if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := fileSHA256(path)
+		if err != nil || got != tt.want {
+			t.Errorf("fileSHA256 of %q = %s, %v, want %s", tt.content, got, err, tt.want)
+		}
+	}
+
+	if _, err := fileSHA256(filepath.Join(dir, "missing")); err == nil {
+		t.Error("fileSHA256 of a missing file succeeded")
+	}
+}
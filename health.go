@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os/exec"
+	"time"
+
+	"github.com/mkevac/markodownloadbot/stats"
+)
+
+// healthStatus is the JSON body of /health.
+type healthStatus struct {
+	Status        string `json:"status"`
+	YtDlp         bool   `json:"yt_dlp"`
+	FFmpeg        bool   `json:"ffmpeg"`
+	Database      bool   `json:"database"`
+	FreeDiskBytes uint64 `json:"free_disk_bytes"`
+	LowDiskSpace  bool   `json:"low_disk_space"`
+}
+
+// checkHealth looks at what the bot needs to download anything. Low disk
+// space is reported but isn't critical, a restart wouldn't fix it.
+func checkHealth(ctx context.Context) healthStatus {
+	var h healthStatus
+
+	_, err := exec.LookPath("yt-dlp")
+	h.YtDlp = err == nil
+	_, err = exec.LookPath("ffmpeg")
+	h.FFmpeg = err == nil
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := stats.Ping(ctx); err != nil {
+		log.Printf("Health check: database error: %s", err)
+	} else {
+		h.Database = true
+	}
+
+	if free, err := freeDiskSpace(tmpDir); err != nil {
+		log.Printf("Health check: %s", err)
+	} else {
+		h.FreeDiskBytes = free
+		h.LowDiskSpace = free < minFreeDiskSpace
+	}
+
+	h.Status = "ok"
+	if !h.YtDlp || !h.FFmpeg || !h.Database {
+		h.Status = "unavailable"
+	}
+	return h
+}
+
+// healthHandler serves /health for load balancers and uptime checks. It
+// answers 503 when a critical dependency is missing.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	h := checkHealth(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	if h.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(h)
+}
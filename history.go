@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/mkevac/markodownloadbot/stats"
+)
+
+// historyLimit is how many requests /history lists.
+const historyLimit = 10
+
+// historyMessage formats requests for /history. The username is shown when
+// the list covers more than one user.
+func historyMessage(requests []stats.PendingRequest, withUsername bool) string {
+	if len(requests) == 0 {
+		return "No requests yet."
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Recent requests:\n")
+	for _, r := range requests {
+		fmt.Fprintf(&sb, "\n#%d %s %s, %s", r.ID, r.Created.UTC().Format("2006-01-02 15:04"), r.Mode, r.Status)
+		if withUsername {
+			fmt.Fprintf(&sb, " by @%s", r.Username)
+		}
+		fmt.Fprintf(&sb, "\n%s\n", r.URL)
+	}
+	sb.WriteString("\nUse /retry <id> to try a failed request again.")
+	return sb.String()
+}
+
+// historyHandler lists the user's latest requests, or everyone's for the
+// admin.
+func historyHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		log.Println("Received history command with nil Message")
+		return
+	}
+
+	user := update.Message.From
+	isAdmin := isAdminUser(user)
+
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   historyMessage(stats.RequestHistory(user.ID, isAdmin, historyLimit), isAdmin),
+	})
+}
+
+// findRetryableRequest looks up a failed request the user may retry: one of
+// their own, or anyone's for the admin.
+func findRetryableRequest(id int64, user *models.User) (stats.PendingRequest, error) {
+	r, ok := stats.GetRequest(id)
+	if !ok || (r.UserID != user.ID && !isAdminUser(user)) {
+		return stats.PendingRequest{}, fmt.Errorf("there is no request #%d in your history", id)
+	}
+	if r.Status != stats.RequestFailed {
+		return stats.PendingRequest{}, fmt.Errorf("request #%d didn't fail, it is %s", id, r.Status)
+	}
+	return r, nil
+}
+
+// retryHandler downloads a failed request from /history again.
+func retryHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		log.Println("Received retry command with nil Message")
+		return
+	}
+
+	arg := strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/retry")), "#")
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Usage: /retry <id>, see /history for the ids",
+		})
+		return
+	}
+
+	r, err := findRetryableRequest(id, update.Message.From)
+	if err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Can't retry: %s.", err),
+		})
+		return
+	}
+
+	log.Printf("[%s]: retrying request #%d", update.Message.From.Username, r.ID)
+	handleDownload(ctx, b, update, r.URL, savedRequestOptions(r), "")
+}
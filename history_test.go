@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/mkevac/markodownloadbot/stats"
+)
+
+func TestHistoryMessage(t *testing.T) {
+	created := time.Date(2024, 3, 15, 12, 30, 0, 0, time.UTC)
+	requests := []stats.PendingRequest{
+		{ID: 2, Username: "bob", URL: "https://example.com/b", Mode: "audio", Status: stats.RequestFailed, Created: created},
+		{ID: 1, Username: "alice", URL: "https://example.com/a", Mode: "video", Status: stats.RequestDone, Created: created},
+	}
+
+	tests := []struct {
+		requests     []stats.PendingRequest
+		withUsername bool
+		want         string
+	}{
+		{nil, false, "No requests yet."},
+		{requests[:1], false, "Recent requests:\n\n#2 2024-03-15 12:30 audio, failed\nhttps://example.com/b\n\nUse /retry <id> to try a failed request again."},
+		{requests, true, "Recent requests:\n\n#2 2024-03-15 12:30 audio, failed by @bob\nhttps://example.com/b\n" +
+			"\n#1 2024-03-15 12:30 video, done by @alice\nhttps://example.com/a\n\nUse /retry <id> to try a failed request again."},
+	}
+
+	for _, tt := range tests {
+		if got := historyMessage(tt.requests, tt.withUsername); got != tt.want {
+			t.Errorf("historyMessage(%d requests, %v) =\n%s\nwant\n%s", len(tt.requests), tt.withUsername, got, tt.want)
+		}
+	}
+}
+
+func TestFindRetryableRequest(t *testing.T) {
+	oldAdmin := adminUsername
+	defer func() { adminUsername = oldAdmin }()
+
+	failed := stats.AddPendingRequest(1, 10, "alice", "https://example.com/a", modeVideo, "")
+	stats.SetRequestStatus(failed, stats.RequestFailed)
+	done := stats.AddPendingRequest(1, 10, "alice", "https://example.com/b", modeVideo, "")
+	stats.SetRequestStatus(done, stats.RequestDone)
+	anonymous := stats.AddPendingRequest(2, 20, "", "https://example.com/a", modeVideo, "")
+	stats.SetRequestStatus(anonymous, stats.RequestFailed)
+	if failed == 0 || done == 0 || anonymous == 0 {
+		t.Fatal("requests not saved")
+	}
+
+	alice := &models.User{ID: 10, Username: "alice"}
+	admin := &models.User{ID: 99, Username: "admin"}
+	noName := &models.User{ID: 30}
+
+	tests := []struct {
+		id      int64
+		user    *models.User
+		admin   string
+		wantErr string
+	}{
+		{failed, alice, "admin", ""},
+		{failed, &models.User{ID: 10, Username: "renamed"}, "admin", ""},
+		{failed, admin, "admin", ""},
+		{failed, &models.User{ID: 11, Username: "bob"}, "admin", fmt.Sprintf("no request #%d", failed)},
+		{failed, &models.User{ID: 11, Username: "alice"}, "admin", fmt.Sprintf("no request #%d", failed)},
+		{anonymous, &models.User{ID: 20}, "admin", ""},
+		{anonymous, noName, "admin", fmt.Sprintf("no request #%d", anonymous)},
+		{anonymous, noName, "", fmt.Sprintf("no request #%d", anonymous)},
+		{failed, admin, "", fmt.Sprintf("no request #%d", failed)},
+		{done, alice, "admin", "didn't fail, it is done"},
+		{-1, alice, "admin", "no request #-1"},
+	}
+
+	for _, tt := range tests {
+		adminUsername = tt.admin
+		r, err := findRetryableRequest(tt.id, tt.user)
+		if tt.wantErr == "" {
+			if err != nil || r.ID != tt.id || r.URL != "https://example.com/a" {
+				t.Errorf("findRetryableRequest(%d, %+v) = %+v, %v", tt.id, tt.user, r, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("findRetryableRequest(%d, %+v) error = %v, want %q", tt.id, tt.user, err, tt.wantErr)
+		}
+	}
+}
+
+func TestHistoryHandler(t *testing.T) {
+	oldAdmin := adminUsername
+	defer func() { adminUsername = oldAdmin }()
+
+	const mine, others = "https://example.com/history-mine", "https://example.com/history-others"
+	stats.AddPendingRequest(1, 40, "", mine, modeVideo, "")
+	stats.AddPendingRequest(1, 41, "", others, modeVideo, "")
+	stats.AddPendingRequest(1, 42, "carol", others, modeVideo, "")
+
+	tests := []struct {
+		user   models.User
+		admin  string
+		shown  []string
+		hidden []string
+	}{
+		{models.User{ID: 40}, "admin", []string{mine}, []string{others}},
+		{models.User{ID: 40}, "", []string{mine}, []string{others, "by @"}},
+		{models.User{ID: 43}, "", []string{"No requests yet."}, []string{mine, others}},
+		{models.User{ID: 99, Username: "admin"}, "admin", []string{mine, others, "by @carol"}, nil},
+	}
+
+	for _, tt := range tests {
+		adminUsername = tt.admin
+		b := newTestBot(t)
+		user := tt.user
+		update := &models.Update{Message: &models.Message{
+			Text: "/history",
+			From: &user,
+			Chat: models.Chat{ID: 1},
+		}}
+
+		historyHandler(context.Background(), b.Bot, update)
+
+		texts := b.sentTexts()
+		if len(texts) != 1 {
+			t.Errorf("user %d: sent %q, want one message", user.ID, texts)
+			continue
+		}
+		for _, s := range tt.shown {
+			if !strings.Contains(texts[0], s) {
+				t.Errorf("user %d with admin %q: history %q doesn't show %q", user.ID, tt.admin, texts[0], s)
+			}
+		}
+		for _, s := range tt.hidden {
+			if strings.Contains(texts[0], s) {
+				t.Errorf("user %d with admin %q: history %q shows %q", user.ID, tt.admin, texts[0], s)
+			}
+		}
+	}
+}
+
+func TestRetryHandlerErrors(t *testing.T) {
+	tests := []struct {
+		text  string
+		reply string
+	}{
+		{"/retry", "Usage: /retry <id>"},
+		{"/retry abc", "Usage: /retry <id>"},
+		{"/retry #-1", "Can't retry: there is no request #-1"},
+	}
+
+	for _, tt := range tests {
+		b := newTestBot(t)
+		update := &models.Update{Message: &models.Message{
+			Text: tt.text,
+			From: &models.User{ID: 1, Username: "alice"},
+			Chat: models.Chat{ID: 1},
+		}}
+
+		retryHandler(context.Background(), b.Bot, update)
+
+		if texts := b.sentTexts(); len(texts) != 1 || !strings.HasPrefix(texts[0], tt.reply) {
+			t.Errorf("%q: sent %q, want %q", tt.text, texts, tt.reply)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	hookBeforeSend = "before_send"
+	hookAfterSend  = "after_send"
+)
+
+// runPostDownloadHook runs POST_DOWNLOAD_HOOK with the file path as its last
+// argument. The command is executed directly, without a shell, and is killed
+// if it runs longer than POST_DOWNLOAD_HOOK_TIMEOUT.
+func runPostDownloadHook(ctx context.Context, user string, path string) error {
+	if len(postDownloadHook) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, postDownloadHookTimeout)
+	defer cancel()
+
+	cmdSlice := append(append([]string{}, postDownloadHook...), path)
+
+	start := time.Now()
+	out, err := runCommand(ctx, user, cmdSlice)
+	elapsed := time.Since(start).Round(time.Millisecond)
+
+	if err != nil {
+		exitCode := -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		}
+		log.Printf("[%s]: post-download hook failed after %s with exit code %d: %s", user, elapsed, exitCode, err)
+		return fmt.Errorf("post-download hook failed with exit code %d", exitCode)
+	}
+
+	log.Printf("[%s]: post-download hook finished in %s with exit code 0, output: %s", user, elapsed, strings.TrimSpace(string(out)))
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunPostDownloadHook(t *testing.T) {
+	oldHook, oldTimeout := postDownloadHook, postDownloadHookTimeout
+	defer func() { postDownloadHook, postDownloadHookTimeout = oldHook, oldTimeout }()
+
+	tests := []struct {
+		name    string
+		hook    []string
+		timeout time.Duration
+		wantErr string
+	}{
+		{name: "no hook"},
+		{name: "success", hook: []string{"true"}},
+		{name: "gets the path last", hook: []string{"sh", "-c", `test "$0" = /data/file.mp4`}},
+		{name: "exit code", hook: []string{"sh", "-c", "exit 3"}, wantErr: "exit code 3"},
+		{name: "missing command", hook: []string{"/nonexistent/hook"}, wantErr: "exit code -1"},
+		{name: "killed on timeout", hook: []string{"sleep", "5"}, timeout: 50 * time.Millisecond, wantErr: "failed"},
+	}
+
+	for _, tt := range tests {
+		postDownloadHook = tt.hook
+		postDownloadHookTimeout = time.Minute
+		if tt.timeout > 0 {
+			postDownloadHookTimeout = tt.timeout
+		}
+
+		start := time.Now()
+		err := runPostDownloadHook(context.Background(), "test", "/data/file.mp4")
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: runPostDownloadHook = %v, want nil", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: runPostDownloadHook = %v, want an error containing %q", tt.name, err, tt.wantErr)
+		}
+		if elapsed := time.Since(start); elapsed > 3*time.Second {
+			t.Errorf("%s: took %s", tt.name, elapsed)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// defaultHostKey is the HOST_FORMATS entry used for hosts without a match.
+const defaultHostKey = "default"
+
+// hostFormat holds the yt-dlp format selection for a site. Format and Sort
+// are passed as -f and -S for video downloads, AudioFormat as -f for audio.
+// "{res}" in Sort is replaced with the default resolution.
+type hostFormat struct {
+	Format      string `json:"format"`
+	Sort        string `json:"sort"`
+	AudioFormat string `json:"audio_format"`
+}
+
+var youtubeFormat = hostFormat{
+	Format: "bv[filesize<=1700M]+ba[filesize<=300M]",
+	Sort:   "ext,res:{res}",
+}
+
+var tiktokFormat = hostFormat{
+	Format:      "b[url!^=\"https://www.tiktok.com/\"]",
+	AudioFormat: "b[url!^=\"https://www.tiktok.com/\"]",
+}
+
+// instagramFormat prefers the separate H.264 and AAC streams, which are
+// often better than the combined ones, and falls back to the best combined
+// mp4. Most Instagram posts need cookies, see COOKIES_FILE.
+var instagramFormat = hostFormat{
+	Format:      "bv*[ext=mp4]+ba[ext=m4a]/b[ext=mp4]/b",
+	Sort:        "vcodec:h264,res:{res}",
+	AudioFormat: "ba[ext=m4a]/ba/b",
+}
+
+var hostFormats = map[string]hostFormat{
+	"youtube.com":   youtubeFormat,
+	"youtu.be":      youtubeFormat,
+	"tiktok.com":    tiktokFormat,
+	"instagram.com": instagramFormat,
+	"instagr.am":    instagramFormat,
+}
+
+// loadHostFormats merges HOST_FORMATS, a JSON object mapping hosts to
+// format settings, over the built-in defaults. For example:
+//
+//	{"vimeo.com": {"sort": "res:720"}, "default": {"sort": "ext"}}
+func loadHostFormats() {
+	loadGenericFormat()
+
+	value := os.Getenv("HOST_FORMATS")
+	if value == "" {
+		return
+	}
+
+	var custom map[string]hostFormat
+	if err := json.Unmarshal([]byte(value), &custom); err != nil {
+		log.Printf("Invalid HOST_FORMATS, using built-in formats: %s", err)
+		return
+	}
+
+	for host, format := range custom {
+		hostFormats[strings.ToLower(host)] = format
+	}
+
+	log.Printf("Loaded %d custom host formats", len(custom))
+}
+
+// loadGenericFormat sets the format for hosts without an entry of their own
+// from GENERIC_FORMAT and GENERIC_FORMAT_SORT, e.g. "ext:mp4:m4a,res:{res}"
+// to prefer mp4 and cap the resolution. Without them yt-dlp picks its own
+// default. A "default" entry in HOST_FORMATS takes precedence.
+func loadGenericFormat() {
+	format := hostFormat{
+		Format: os.Getenv("GENERIC_FORMAT"),
+		Sort:   os.Getenv("GENERIC_FORMAT_SORT"),
+	}
+	if format.Format == "" && format.Sort == "" {
+		return
+	}
+
+	hostFormats[defaultHostKey] = format
+}
+
+// hostReferers maps hosts to the Referer sent with their downloads, for
+// sites that only serve media to their embedding pages.
+var hostReferers = map[string]string{}
+
+// loadHostReferers reads HOST_REFERERS, a JSON object mapping hosts to
+// referers. For example:
+//
+//	{"player.example.com": "https://blog.example.com/"}
+func loadHostReferers() {
+	value := os.Getenv("HOST_REFERERS")
+	if value == "" {
+		return
+	}
+
+	var custom map[string]string
+	if err := json.Unmarshal([]byte(value), &custom); err != nil {
+		log.Printf("Invalid HOST_REFERERS, ignoring: %s", err)
+		return
+	}
+
+	for host, referer := range custom {
+		hostReferers[strings.ToLower(host)] = referer
+	}
+
+	log.Printf("Loaded %d host referers", len(custom))
+}
+
+// refererFor returns the Referer for a download from u: the configured one
+// for its host or, with auto set, the URL's own origin. It returns "" when
+// yt-dlp should decide.
+func refererFor(u *url.URL, auto bool) string {
+	if referer, ok := matchHost(u.Host, hostReferers); ok {
+		return referer
+	}
+	if auto && u.Scheme != "" && u.Host != "" {
+		return u.Scheme + "://" + u.Host + "/"
+	}
+	return ""
+}
+
+// lookupHostFormat returns the format settings for host, falling back to
+// the default entry (if any) when no host matches.
+func lookupHostFormat(host string) hostFormat {
+	if format, ok := matchHost(host, hostFormats); ok {
+		return format
+	}
+	return hostFormats[defaultHostKey]
+}
+
+// matchHost finds the entry for host in entries. A key matches the host
+// itself and all of its subdomains; the longest matching key wins.
+func matchHost[T any](host string, entries map[string]T) (T, bool) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+
+	var best T
+	bestLen := -1
+
+	for key, value := range entries {
+		if key == defaultHostKey {
+			continue
+		}
+		if (host == key || strings.HasSuffix(host, "."+key)) && len(key) > bestLen {
+			best = value
+			bestLen = len(key)
+		}
+	}
+
+	return best, bestLen >= 0
+}
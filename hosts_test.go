@@ -0,0 +1,188 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestMatchHost(t *testing.T) {
+	entries := map[string]string{
+		"example.com":       "example",
+		"video.example.com": "video",
+		"tiktok.com":        "tiktok",
+		defaultHostKey:      "default",
+	}
+
+	tests := []struct {
+		host string
+		want string
+		ok   bool
+	}{
+		{"example.com", "example", true},
+		{"www.example.com", "example", true},
+		{"video.example.com", "video", true},
+		{"cdn.video.example.com", "video", true},
+		{"EXAMPLE.COM", "example", true},
+		{"example.com.", "example", true},
+		{"example.com:8080", "example", true},
+		{"vm.tiktok.com", "tiktok", true},
+		{"notexample.com", "", false},
+		{"example.com.evil.org", "", false},
+		{"default", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := matchHost(tt.host, entries)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("matchHost(%q) = %q, %v, want %q, %v", tt.host, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLookupHostFormat(t *testing.T) {
+	oldFormats := hostFormats
+	defer func() { hostFormats = oldFormats }()
+
+	vimeo := hostFormat{Sort: "res:720"}
+	generic := hostFormat{Sort: "ext"}
+	hostFormats = map[string]hostFormat{
+		"youtube.com": youtubeFormat,
+		"vimeo.com":   vimeo,
+	}
+
+	tests := []struct {
+		host string
+		want hostFormat
+	}{
+		{"www.youtube.com", youtubeFormat},
+		{"m.youtube.com", youtubeFormat},
+		{"player.vimeo.com", vimeo},
+		{"example.com", hostFormat{}},
+	}
+	for _, tt := range tests {
+		if got := lookupHostFormat(tt.host); got != tt.want {
+			t.Errorf("lookupHostFormat(%q) = %+v, want %+v", tt.host, got, tt.want)
+		}
+	}
+
+	hostFormats[defaultHostKey] = generic
+	if got := lookupHostFormat("example.com"); got != generic {
+		t.Errorf("lookupHostFormat without a match = %+v, want the default %+v", got, generic)
+	}
+	if got := lookupHostFormat("youtube.com"); got != youtubeFormat {
+		t.Errorf("lookupHostFormat(youtube.com) with a default = %+v, want %+v", got, youtubeFormat)
+	}
+}
+
+func TestLoadHostFormats(t *testing.T) {
+	oldFormats := hostFormats
+	defer func() { hostFormats = oldFormats }()
+
+	tests := []struct {
+		name  string
+		value string
+		host  string
+		want  hostFormat
+	}{
+		{"unset", "", "youtube.com", youtubeFormat},
+		{"new host", `{"Vimeo.com": {"sort": "res:720"}}`, "vimeo.com", hostFormat{Sort: "res:720"}},
+		{"override", `{"youtube.com": {"format": "b"}}`, "youtu.be", youtubeFormat},
+		{"override replaces", `{"youtube.com": {"format": "b"}}`, "youtube.com", hostFormat{Format: "b"}},
+		{"audio", `{"example.com": {"audio_format": "ba"}}`, "example.com", hostFormat{AudioFormat: "ba"}},
+		{"default", `{"default": {"sort": "ext"}}`, "example.org", hostFormat{Sort: "ext"}},
+		{"invalid", `{"vimeo.com": `, "youtube.com", youtubeFormat},
+	}
+
+	for _, tt := range tests {
+		hostFormats = map[string]hostFormat{
+			"youtube.com": youtubeFormat,
+			"youtu.be":    youtubeFormat,
+		}
+		t.Setenv("HOST_FORMATS", tt.value)
+		t.Setenv("GENERIC_FORMAT", "")
+		t.Setenv("GENERIC_FORMAT_SORT", "")
+
+		loadHostFormats()
+		if got := lookupHostFormat(tt.host); got != tt.want {
+			t.Errorf("%s: format for %s = %+v, want %+v", tt.name, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestRefererFor(t *testing.T) {
+	oldReferers := hostReferers
+	defer func() { hostReferers = oldReferers }()
+	hostReferers = map[string]string{}
+
+	t.Setenv("HOST_REFERERS", `{"Player.Example.com": "https://blog.example.com/"}`)
+	loadHostReferers()
+
+	tests := []struct {
+		url  string
+		auto bool
+		want string
+	}{
+		{"https://player.example.com/embed/1", false, "https://blog.example.com/"},
+		{"https://player.example.com/embed/1", true, "https://blog.example.com/"},
+		{"https://cdn.player.example.com/v.mp4", false, "https://blog.example.com/"},
+		{"https://other.com/video", false, ""},
+		{"https://other.com/video", true, "https://other.com/"},
+		{"other.com/video", true, ""},
+	}
+
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := refererFor(u, tt.auto); got != tt.want {
+			t.Errorf("refererFor(%q, %v) = %q, want %q", tt.url, tt.auto, got, tt.want)
+		}
+	}
+}
+
+func TestLoadHostReferersInvalid(t *testing.T) {
+	oldReferers := hostReferers
+	defer func() { hostReferers = oldReferers }()
+	hostReferers = map[string]string{}
+
+	t.Setenv("HOST_REFERERS", `["not", "an", "object"]`)
+	loadHostReferers()
+
+	if len(hostReferers) != 0 {
+		t.Errorf("referers loaded from invalid HOST_REFERERS: %v", hostReferers)
+	}
+}
+
+func TestLoadGenericFormat(t *testing.T) {
+	oldFormats := hostFormats
+	defer func() { hostFormats = oldFormats }()
+
+	tests := []struct {
+		name        string
+		format      string
+		sort        string
+		hostFormats string
+		host        string
+		want        hostFormat
+	}{
+		{"unset", "", "", "", "example.org", hostFormat{}},
+		{"format", "bv*+ba/b", "", "", "example.org", hostFormat{Format: "bv*+ba/b"}},
+		{"sort", "", "ext:mp4:m4a,res:{res}", "", "example.org", hostFormat{Sort: "ext:mp4:m4a,res:{res}"}},
+		{"host entry wins", "bv*+ba/b", "", "", "youtube.com", youtubeFormat},
+		{"HOST_FORMATS default wins", "bv*+ba/b", "", `{"default": {"sort": "ext"}}`, "example.org", hostFormat{Sort: "ext"}},
+	}
+
+	for _, tt := range tests {
+		hostFormats = map[string]hostFormat{"youtube.com": youtubeFormat}
+		t.Setenv("GENERIC_FORMAT", tt.format)
+		t.Setenv("GENERIC_FORMAT_SORT", tt.sort)
+		t.Setenv("HOST_FORMATS", tt.hostFormats)
+
+		loadHostFormats()
+		if got := lookupHostFormat(tt.host); got != tt.want {
+			t.Errorf("%s: format for %s = %+v, want %+v", tt.name, tt.host, got, tt.want)
+		}
+	}
+}
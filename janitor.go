@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tempJanitorInterval is how often tmpDir is scanned for orphaned files.
+const tempJanitorInterval = 5 * time.Minute
+
+// activeDownloads holds the random names of the downloads whose files are
+// still in use, from DownloadMedia until the media is deleted or archived.
+var activeDownloads = newNameSet()
+
+type nameSet struct {
+	mu    sync.Mutex
+	names map[string]struct{}
+}
+
+func newNameSet() *nameSet {
+	return &nameSet{names: make(map[string]struct{})}
+}
+
+func (s *nameSet) Add(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.names[name] = struct{}{}
+}
+
+func (s *nameSet) Remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.names, name)
+}
+
+// ownsFile reports whether file belongs to one of the names, which every
+// file of a download starts with.
+func (s *nameSet) ownsFile(file string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name := range s.names {
+		if strings.HasPrefix(file, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanTempDir removes the files in dir last modified before now-maxAge
+// that don't belong to an active download, and returns their names.
+func cleanTempDir(dir string, maxAge time.Duration, now time.Time, active *nameSet) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("Error reading temporary directory: %s", err)
+		return nil
+	}
+
+	var removed []string
+	for _, entry := range entries {
+		if entry.IsDir() || active.ownsFile(entry.Name()) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || now.Sub(info.ModTime()) < maxAge {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			log.Printf("Error removing orphaned file '%s': %s", entry.Name(), err)
+			continue
+		}
+		removed = append(removed, entry.Name())
+	}
+	return removed
+}
+
+// removeOldTempDirs deletes the temporary directories that previous runs
+// left behind when the bot was killed, if they are older than maxAge.
+func removeOldTempDirs(dirBase string, current string, maxAge time.Duration) {
+	matches, err := filepath.Glob(filepath.Join(dirBase, "telegram-bot-api-*"))
+	if err != nil {
+		return
+	}
+
+	for _, dir := range matches {
+		info, err := os.Stat(dir)
+		if dir == current || err != nil || !info.IsDir() || time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+		log.Printf("Removing temporary directory of a previous run: %s", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("Error removing temporary directory: %s", err)
+		}
+	}
+}
+
+// runTempJanitor removes orphaned files from dir every tempJanitorInterval
+// until ctx is done. The downloads clean up after themselves, this catches
+// what a crash or a missed error path leaves.
+func runTempJanitor(ctx context.Context, dir string, maxAge time.Duration) {
+	ticker := time.NewTicker(tempJanitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, name := range cleanTempDir(dir, maxAge, now, activeDownloads) {
+				log.Printf("Removed orphaned temporary file: %s", name)
+			}
+		}
+	}
+}
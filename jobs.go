@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// jobStopGrace is how long Shutdown waits for the jobs it cancelled, so
+// they can delete their files before the temporary directory goes.
+const jobStopGrace = 5 * time.Second
+
+// jobTracker counts the requests being handled, so a shutdown can wait for
+// them. Handlers run with its context instead of the bot's, which is
+// cancelled as soon as the signal arrives.
+type jobTracker struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	active atomic.Int64
+
+	// mu guards closed, so no job is added once Shutdown started waiting.
+	mu     sync.Mutex
+	closed bool
+}
+
+func newJobTracker() *jobTracker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &jobTracker{ctx: ctx, cancel: cancel}
+}
+
+// Go runs fn as a tracked job. It reports false, and doesn't run fn, once
+// Shutdown has been called.
+func (t *jobTracker) Go(fn func(ctx context.Context)) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+
+	t.wg.Add(1)
+	t.active.Add(1)
+	go func() {
+		defer t.wg.Done()
+		defer t.active.Add(-1)
+		fn(t.ctx)
+	}()
+	return true
+}
+
+// Middleware runs every update handler as a tracked job. The library
+// handles updates one at a time, so without this one long download would
+// hold up everyone else's requests and never let the download and
+// conversion limiters see a second caller.
+func (t *jobTracker) Middleware(next bot.HandlerFunc) bot.HandlerFunc {
+	return func(_ context.Context, b *bot.Bot, update *models.Update) {
+		if !t.Go(func(ctx context.Context) {
+			next(ctx, b, update)
+		}) {
+			log.Printf("Dropping update %d received during shutdown", update.ID)
+		}
+	}
+}
+
+// Shutdown stops taking new jobs, waits up to timeout for the running
+// ones and then cancels the ones that are left, giving them jobStopGrace
+// to clean up.
+func (t *jobTracker) Shutdown(timeout time.Duration) {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+	defer t.cancel()
+
+	running := t.active.Load()
+	if running == 0 {
+		return
+	}
+	log.Printf("Waiting up to %s for %d running requests", timeout, running)
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Println("All running requests finished")
+		return
+	case <-time.After(timeout):
+		log.Printf("%d requests still running after %s, stopping them", t.active.Load(), timeout)
+	}
+
+	t.cancel()
+	select {
+	case <-done:
+	case <-time.After(jobStopGrace):
+		log.Printf("%d requests didn't stop within %s", t.active.Load(), jobStopGrace)
+	}
+}
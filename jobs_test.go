@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+func TestJobTrackerMiddlewareRunsHandlersConcurrently(t *testing.T) {
+	jobs := newJobTracker()
+	defer jobs.Shutdown(time.Second)
+
+	// each handler waits for the other one, which only works when they
+	// don't run one after the other
+	var ready sync.WaitGroup
+	ready.Add(2)
+	done := make(chan struct{}, 2)
+	handler := jobs.Middleware(func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		ready.Done()
+		ready.Wait()
+		done <- struct{}{}
+	})
+
+	handler(context.Background(), nil, &models.Update{ID: 1})
+	handler(context.Background(), nil, &models.Update{ID: 2})
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("handlers didn't run concurrently")
+		}
+	}
+}
+
+func TestJobTrackerShutdownWaitsForJobs(t *testing.T) {
+	jobs := newJobTracker()
+
+	finished := make(chan struct{})
+	jobs.Go(func(ctx context.Context) {
+		time.Sleep(50 * time.Millisecond)
+		close(finished)
+	})
+
+	jobs.Shutdown(5 * time.Second)
+
+	select {
+	case <-finished:
+	default:
+		t.Fatal("Shutdown returned before the job finished")
+	}
+}
+
+func TestJobTrackerShutdownCancelsAfterTimeout(t *testing.T) {
+	jobs := newJobTracker()
+
+	stopped := make(chan struct{})
+	jobs.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		close(stopped)
+	})
+
+	start := time.Now()
+	jobs.Shutdown(50 * time.Millisecond)
+
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("Shutdown returned before the cancelled job stopped")
+	}
+	if elapsed := time.Since(start); elapsed > jobStopGrace {
+		t.Errorf("Shutdown took %s", elapsed)
+	}
+}
+
+func TestJobTrackerGoAfterShutdown(t *testing.T) {
+	jobs := newJobTracker()
+	jobs.Shutdown(time.Second)
+
+	if jobs.Go(func(ctx context.Context) { t.Error("job ran after shutdown") }) {
+		t.Error("Go = true after shutdown")
+	}
+	if n := jobs.active.Load(); n != 0 {
+		t.Errorf("active = %d, want 0", n)
+	}
+}
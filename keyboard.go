@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/google/uuid"
+)
+
+const (
+	qualityCallbackPrefix = "q:"
+	qualityVideo          = "video"
+	qualityAudio          = "audio"
+
+	// qualityChoiceTTL is how long a keyboard can be answered. Telegram
+	// limits callback data to 64 bytes, so the link stays on our side.
+	qualityChoiceTTL = 10 * time.Minute
+)
+
+// qualityChoices are the buttons offered: the video in the default
+// resolution, the audio, and the video in a specific resolution.
+var qualityChoices = []string{qualityVideo, qualityAudio, "480", "720", "1080"}
+
+// pendingChoice is a link waiting for the user to pick a quality.
+type pendingChoice struct {
+	message *models.Message
+	expires time.Time
+}
+
+var (
+	pendingChoicesMu sync.Mutex
+	pendingChoices   = make(map[string]pendingChoice)
+)
+
+func encodeQualityCallback(token string, choice string) string {
+	return qualityCallbackPrefix + token + ":" + choice
+}
+
+func decodeQualityCallback(data string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(data, qualityCallbackPrefix)
+	if !ok {
+		return "", "", false
+	}
+	token, choice, ok := strings.Cut(rest, ":")
+	if !ok || token == "" || choice == "" {
+		return "", "", false
+	}
+	return token, choice, true
+}
+
+// qualityOptions turns a keyboard choice into download options.
+func qualityOptions(choice string) (DownloadOptions, bool) {
+	switch choice {
+	case qualityVideo:
+		return DownloadOptions{}, true
+	case qualityAudio:
+		return DownloadOptions{AudioOnly: true}, true
+	}
+	res, err := strconv.Atoi(choice)
+	if err != nil || !isAllowedResolution(res) {
+		return DownloadOptions{}, false
+	}
+	return DownloadOptions{Resolution: res}, true
+}
+
+func addPendingChoice(msg *models.Message, now time.Time) string {
+	pendingChoicesMu.Lock()
+	defer pendingChoicesMu.Unlock()
+
+	for token, p := range pendingChoices {
+		if now.After(p.expires) {
+			delete(pendingChoices, token)
+		}
+	}
+
+	token := strings.ReplaceAll(uuid.New().String(), "-", "")
+	pendingChoices[token] = pendingChoice{message: msg, expires: now.Add(qualityChoiceTTL)}
+	return token
+}
+
+// takePendingChoice removes and returns the link for token, unless it
+// expired. A keyboard can only be answered once, and only by the user who
+// sent the link: for anyone else it stays pending and ok is false.
+func takePendingChoice(token string, userID int64, now time.Time) (msg *models.Message, ok bool, expired bool) {
+	pendingChoicesMu.Lock()
+	defer pendingChoicesMu.Unlock()
+
+	p, found := pendingChoices[token]
+	if !found || now.After(p.expires) {
+		delete(pendingChoices, token)
+		return nil, false, true
+	}
+	if p.message.From == nil || p.message.From.ID != userID {
+		return nil, false, false
+	}
+	delete(pendingChoices, token)
+	return p.message, true, false
+}
+
+// sendQualityKeyboard asks which quality the link in msg should be
+// downloaded in.
+func sendQualityKeyboard(ctx context.Context, b *bot.Bot, msg *models.Message) {
+	token := addPendingChoice(msg, time.Now())
+
+	var row []models.InlineKeyboardButton
+	for _, choice := range qualityChoices {
+		text := choice + "p"
+		switch choice {
+		case qualityVideo:
+			text = "Video"
+		case qualityAudio:
+			text = "Audio"
+		}
+		row = append(row, models.InlineKeyboardButton{Text: text, CallbackData: encodeQualityCallback(token, choice)})
+	}
+
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      msg.Chat.ID,
+		Text:        "Which format do you want?",
+		ReplyMarkup: &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{row}},
+	})
+}
+
+func qualityCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	query := update.CallbackQuery
+	if query == nil {
+		return
+	}
+
+	token, choice, ok := decodeQualityCallback(query.Data)
+	opts, valid := qualityOptions(choice)
+	if !ok || !valid {
+		b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: query.ID})
+		return
+	}
+
+	msg, ok, expired := takePendingChoice(token, query.From.ID, time.Now())
+	if expired {
+		b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            "This choice has expired, please send the link again.",
+			ShowAlert:       true,
+		})
+		return
+	}
+	if !ok {
+		b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            "Only the person who sent the link can choose.",
+		})
+		return
+	}
+
+	b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: query.ID})
+
+	if query.Message.Message != nil {
+		b.EditMessageText(ctx, &bot.EditMessageTextParams{
+			ChatID:    query.Message.Message.Chat.ID,
+			MessageID: query.Message.Message.ID,
+			Text:      "Format: " + choice,
+		})
+	}
+
+	log.Printf("[%s]: chose %s", msg.From.Username, choice)
+	handleDownload(ctx, b, &models.Update{Message: msg}, msg.Text, opts, "")
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestQualityCallback(t *testing.T) {
+	data := encodeQualityCallback("abc123", "720")
+	if data != "q:abc123:720" {
+		t.Errorf("encodeQualityCallback = %q", data)
+	}
+	if len(data) > 64 {
+		t.Errorf("callback data is %d bytes, over Telegram's 64", len(data))
+	}
+
+	tests := []struct {
+		data   string
+		token  string
+		choice string
+		ok     bool
+	}{
+		{"q:abc123:720", "abc123", "720", true},
+		{"q:abc123:audio", "abc123", "audio", true},
+		{"q:abc123", "", "", false},
+		{"q::720", "", "", false},
+		{"q:abc123:", "", "", false},
+		{"x:abc123:720", "", "", false},
+		{"", "", "", false},
+	}
+
+	for _, tt := range tests {
+		token, choice, ok := decodeQualityCallback(tt.data)
+		if token != tt.token || choice != tt.choice || ok != tt.ok {
+			t.Errorf("decodeQualityCallback(%q) = %q, %q, %v, want %q, %q, %v", tt.data, token, choice, ok, tt.token, tt.choice, tt.ok)
+		}
+	}
+}
+
+func TestQualityOptions(t *testing.T) {
+	tests := []struct {
+		choice string
+		want   DownloadOptions
+		ok     bool
+	}{
+		{qualityVideo, DownloadOptions{}, true},
+		{qualityAudio, DownloadOptions{AudioOnly: true}, true},
+		{"480", DownloadOptions{Resolution: 480}, true},
+		{"1080", DownloadOptions{Resolution: 1080}, true},
+		{"1000", DownloadOptions{}, false},
+		{"best", DownloadOptions{}, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := qualityOptions(tt.choice)
+		if ok != tt.ok || got.AudioOnly != tt.want.AudioOnly || got.Resolution != tt.want.Resolution {
+			t.Errorf("qualityOptions(%q) = %+v, %v, want %+v, %v", tt.choice, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestTakePendingChoice(t *testing.T) {
+	now := time.Now()
+	msg := &models.Message{Text: "https://example.com/v", From: &models.User{ID: 1}}
+
+	token := addPendingChoice(msg, now)
+
+	if _, ok, expired := takePendingChoice(token, 2, now); ok || expired {
+		t.Errorf("another user took the choice: ok %v, expired %v", ok, expired)
+	}
+	got, ok, expired := takePendingChoice(token, 1, now.Add(time.Minute))
+	if !ok || expired || got != msg {
+		t.Errorf("takePendingChoice by the sender = %v, %v, %v", got, ok, expired)
+	}
+	if _, ok, expired := takePendingChoice(token, 1, now); ok || !expired {
+		t.Errorf("choice answered twice: ok %v, expired %v", ok, expired)
+	}
+
+	token = addPendingChoice(msg, now)
+	if _, ok, expired := takePendingChoice(token, 1, now.Add(qualityChoiceTTL+time.Second)); ok || !expired {
+		t.Errorf("expired choice taken: ok %v, expired %v", ok, expired)
+	}
+
+	// adding a choice drops the expired ones
+	stale := addPendingChoice(msg, now.Add(-2*qualityChoiceTTL))
+	addPendingChoice(msg, now)
+	pendingChoicesMu.Lock()
+	_, found := pendingChoices[stale]
+	pendingChoicesMu.Unlock()
+	if found {
+		t.Error("expired choice kept")
+	}
+}
+
+func TestSendQualityKeyboard(t *testing.T) {
+	b := newTestBot(t)
+	msg := &models.Message{Text: "https://example.com/v", From: &models.User{ID: 1}, Chat: models.Chat{ID: 5}}
+
+	sendQualityKeyboard(context.Background(), b.Bot, msg)
+
+	calls := b.calls("sendMessage")
+	if len(calls) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(calls))
+	}
+
+	var markup models.InlineKeyboardMarkup
+	if err := json.Unmarshal([]byte(calls[0].fields["reply_markup"]), &markup); err != nil {
+		t.Fatalf("reply_markup: %s", err)
+	}
+	if len(markup.InlineKeyboard) != 1 || len(markup.InlineKeyboard[0]) != len(qualityChoices) {
+		t.Fatalf("keyboard = %+v", markup.InlineKeyboard)
+	}
+
+	var texts []string
+	for i, button := range markup.InlineKeyboard[0] {
+		texts = append(texts, button.Text)
+		token, choice, ok := decodeQualityCallback(button.CallbackData)
+		if !ok || choice != qualityChoices[i] {
+			t.Errorf("button %q: callback data %q", button.Text, button.CallbackData)
+		}
+		if got, ok, _ := takePendingChoice(token, 1, time.Now()); ok && got != msg {
+			t.Errorf("button %q: pending link %v", button.Text, got)
+		}
+	}
+	if got, want := strings.Join(texts, ","), "Video,Audio,480p,720p,1080p"; got != want {
+		t.Errorf("buttons %s, want %s", got, want)
+	}
+}
+
+func TestHandlerQualityKeyboardAccess(t *testing.T) {
+	oldKeyboard, oldBlocked := qualityKeyboard, blockedUsers.String()
+	oldAdminChat := adminChatID.Swap(0)
+	defer func() {
+		qualityKeyboard = oldKeyboard
+		blockedUsers.Set(oldBlocked)
+		adminChatID.Store(oldAdminChat)
+	}()
+	qualityKeyboard = true
+	blockedUsers.Set("mallory")
+
+	tests := []struct {
+		user     models.User
+		text     string
+		keyboard bool
+		reply    string
+	}{
+		{models.User{ID: 31, Username: "carol"}, "https://example.com/v", true, "Which format"},
+		{models.User{ID: 32, Username: "mallory"}, "https://example.com/v", false, "not authorized"},
+		{models.User{ID: 31, Username: "carol"}, "hello", false, "valid video or audio link"},
+	}
+
+	for _, tt := range tests {
+		b := newTestBot(t)
+		user := tt.user
+		handler(context.Background(), b.Bot, &models.Update{Message: &models.Message{
+			Text: tt.text,
+			From: &user,
+			Chat: models.Chat{ID: 5},
+		}})
+
+		calls := b.calls("sendMessage")
+		if len(calls) != 1 {
+			t.Errorf("%s %q: sent %q, want one message", user.Username, tt.text, b.sentTexts())
+			continue
+		}
+		if keyboard := calls[0].fields["reply_markup"] != ""; keyboard != tt.keyboard {
+			t.Errorf("%s %q: keyboard sent %v, want %v", user.Username, tt.text, keyboard, tt.keyboard)
+		}
+		if !strings.Contains(calls[0].fields["text"], tt.reply) {
+			t.Errorf("%s %q: sent %q, want %q", user.Username, tt.text, calls[0].fields["text"], tt.reply)
+		}
+	}
+}
+
+func TestQualityCallbackHandler(t *testing.T) {
+	oldBlocked := blockedUsers.String()
+	defer blockedUsers.Set(oldBlocked)
+	// the download itself stops at the access check
+	blockedUsers.Set("alice")
+
+	newQuery := func(data string, userID int64) *models.Update {
+		return &models.Update{CallbackQuery: &models.CallbackQuery{
+			ID:   "query",
+			From: models.User{ID: userID},
+			Data: data,
+			Message: models.MaybeInaccessibleMessage{
+				Type:    models.MaybeInaccessibleMessageTypeMessage,
+				Message: &models.Message{ID: 7, Chat: models.Chat{ID: 5}},
+			},
+		}}
+	}
+	link := func() string {
+		return addPendingChoice(&models.Message{
+			Text: "https://example.com/v",
+			From: &models.User{ID: 1, Username: "alice"},
+			Chat: models.Chat{ID: 5},
+		}, time.Now())
+	}
+
+	tests := []struct {
+		name   string
+		data   string
+		userID int64
+		answer string
+		edited string
+	}{
+		{"invalid data", "garbage", 1, "", ""},
+		{"invalid choice", encodeQualityCallback(link(), "best"), 1, "", ""},
+		{"expired", encodeQualityCallback("unknown", "720"), 1, "This choice has expired, please send the link again.", ""},
+		{"someone else", encodeQualityCallback(link(), "720"), 2, "Only the person who sent the link can choose.", ""},
+		{"chosen", encodeQualityCallback(link(), "720"), 1, "", "Format: 720"},
+	}
+
+	for _, tt := range tests {
+		b := newTestBot(t)
+		qualityCallbackHandler(context.Background(), b.Bot, newQuery(tt.data, tt.userID))
+
+		answers := b.calls("answerCallbackQuery")
+		if len(answers) != 1 || answers[0].fields["text"] != tt.answer {
+			t.Errorf("%s: answers %v, want one with %q", tt.name, answers, tt.answer)
+		}
+
+		edits := b.calls("editMessageText")
+		if tt.edited == "" && len(edits) > 0 || tt.edited != "" && (len(edits) != 1 || edits[0].fields["text"] != tt.edited) {
+			t.Errorf("%s: edits %v, want %q", tt.name, edits, tt.edited)
+		}
+
+		// only a chosen link goes on to the download
+		texts := b.sentTexts()
+		if downloaded := len(texts) == 1 && strings.Contains(texts[0], "not authorized"); downloaded != (tt.edited != "") {
+			t.Errorf("%s: sent %q", tt.name, texts)
+		}
+	}
+}
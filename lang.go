@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"golang.org/x/text/language"
+)
+
+// parseLanguage turns an ISO 639 code, like "de", "deu" or "pt-BR", into
+// the two-letter base language yt-dlp and most containers use.
+func parseLanguage(code string) (string, error) {
+	tag, err := language.Parse(code)
+	if err != nil {
+		return "", fmt.Errorf("unknown language '%s'", code)
+	}
+	base, confidence := tag.Base()
+	if confidence == language.No || base.String() == "und" {
+		return "", fmt.Errorf("unknown language '%s'", code)
+	}
+	return base.String(), nil
+}
+
+// languageMatches reports whether a stream's language tag, in any ISO 639
+// form, is the base language want.
+func languageMatches(tag string, want string) bool {
+	if tag == "" || want == "" {
+		return false
+	}
+	base, err := parseLanguage(tag)
+	return err == nil && base == want
+}
+
+// languageFormat returns the format selector for the audio in lang, with
+// the best video unless audioOnly.
+func languageFormat(lang string, audioOnly bool) string {
+	filter := fmt.Sprintf("[language^=%s]", lang)
+	if audioOnly {
+		return "ba" + filter + "/b" + filter
+	}
+	return "bv*+ba" + filter + "/b" + filter
+}
+
+// keepAudioTrack remuxes a video with several audio tracks so only the
+// chosen one is left, since Telegram players always play the first.
+func (media *Media) keepAudioTrack(ctx context.Context) error {
+	outputPath := filepath.Join(media.tmpDir, media.randomName+"_track.mp4")
+
+	cmdSlice := []string{"ffmpeg", "-y", "-i", media.Path}
+	cmdSlice = append(cmdSlice, media.streamMapArgs()...)
+	cmdSlice = append(cmdSlice, "-c", "copy", "-movflags", "+faststart", outputPath)
+
+	convCtx, cancel := context.WithTimeout(ctx, ffmpegTimeout)
+	defer cancel()
+
+	if _, err := runCommand(convCtx, media.user, cmdSlice); err != nil {
+		os.Remove(outputPath)
+		if ctx.Err() == nil && convCtx.Err() == context.DeadlineExceeded {
+			return newTimeoutError("ffmpeg", ffmpegTimeout)
+		}
+		return fmt.Errorf("error selecting audio track: %s", err)
+	}
+
+	if err := os.Remove(media.Path); err != nil {
+		log.Printf("error deleting original file: %s", err)
+	}
+	media.Path = outputPath
+	media.FileName = filepath.Base(outputPath)
+
+	return nil
+}
+
+// parseLangArgs splits "/lang <code> <link>" into the language and the link.
+func parseLangArgs(text string) (string, string, error) {
+	fields := strings.Fields(text)
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("expected a language and a link")
+	}
+
+	lang, err := parseLanguage(fields[0])
+	if err != nil {
+		return "", "", err
+	}
+	return lang, fields[1], nil
+}
+
+func langHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		log.Println("Received lang command with nil Message")
+		return
+	}
+
+	lang, input, err := parseLangArgs(strings.TrimPrefix(update.Message.Text, "/lang"))
+	if err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Usage: /lang <language> <link>, e.g. /lang de <link> (%s)", err),
+		})
+		return
+	}
+
+	handleDownload(ctx, b, update, input, DownloadOptions{Language: lang}, "")
+}
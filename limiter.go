@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// limiter is a semaphore whose capacity can be changed at runtime.
+// Waiters are served in FIFO order.
+type limiter struct {
+	mu      sync.Mutex
+	limit   int
+	active  int
+	waiters []chan struct{}
+}
+
+func newLimiter(limit int) *limiter {
+	if limit < 1 {
+		limit = 1
+	}
+	return &limiter{limit: limit}
+}
+
+// Acquire blocks until a slot is available or ctx is done.
+func (l *limiter) Acquire(ctx context.Context) error {
+	return l.AcquireQueued(ctx, nil)
+}
+
+// AcquireQueued is Acquire that calls onQueued with the caller's place in
+// the queue, starting at 1, when no slot is free right away.
+func (l *limiter) AcquireQueued(ctx context.Context, onQueued func(position int)) error {
+	l.mu.Lock()
+	if l.active < l.limit && len(l.waiters) == 0 {
+		l.active++
+		l.mu.Unlock()
+		return nil
+	}
+
+	ch := make(chan struct{})
+	l.waiters = append(l.waiters, ch)
+	position := len(l.waiters)
+	l.mu.Unlock()
+
+	if onQueued != nil {
+		onQueued(position)
+	}
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		for i, w := range l.waiters {
+			if w == ch {
+				l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+				l.mu.Unlock()
+				return ctx.Err()
+			}
+		}
+		l.mu.Unlock()
+
+		// the slot was granted while we were giving up, hand it back
+		l.Release()
+		return ctx.Err()
+	}
+}
+
+func (l *limiter) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.active--
+	l.wake()
+}
+
+// SetLimit changes the capacity. Lowering it doesn't interrupt running
+// holders, new ones just wait until enough slots are released.
+func (l *limiter) SetLimit(limit int) {
+	if limit < 1 {
+		limit = 1
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = limit
+	l.wake()
+}
+
+func (l *limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+func (l *limiter) wake() {
+	for l.active < l.limit && len(l.waiters) > 0 {
+		ch := l.waiters[0]
+		l.waiters = l.waiters[1:]
+		l.active++
+		close(ch)
+	}
+}
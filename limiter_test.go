@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitForWaiters waits until n callers are queued in l.
+func waitForWaiters(t *testing.T, l *limiter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l.mu.Lock()
+		queued := len(l.waiters)
+		l.mu.Unlock()
+		if queued == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d callers queued, want %d", queued, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLimiterParallelAcquire(t *testing.T) {
+	tests := []struct {
+		limit   int
+		callers int
+	}{
+		{limit: 1, callers: 10},
+		{limit: 3, callers: 20},
+		{limit: 8, callers: 8},
+	}
+
+	for _, tt := range tests {
+		l := newLimiter(tt.limit)
+
+		var running, peak atomic.Int64
+		var wg sync.WaitGroup
+		for i := 0; i < tt.callers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := l.Acquire(context.Background()); err != nil {
+					t.Errorf("Acquire: %s", err)
+					return
+				}
+				n := running.Add(1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				running.Add(-1)
+				l.Release()
+			}()
+		}
+		wg.Wait()
+
+		if p := peak.Load(); p > int64(tt.limit) {
+			t.Errorf("limit %d: %d callers held a slot at once", tt.limit, p)
+		}
+		if l.active != 0 || len(l.waiters) != 0 {
+			t.Errorf("limit %d: %d active and %d waiting after all released", tt.limit, l.active, len(l.waiters))
+		}
+	}
+}
+
+func TestLimiterFIFO(t *testing.T) {
+	l := newLimiter(1)
+	if err := l.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	const callers = 5
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 1; i <= callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l.Acquire(context.Background())
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			l.Release()
+		}(i)
+		// queue them one at a time so the order is known
+		waitForWaiters(t, l, i)
+	}
+
+	l.Release()
+	wg.Wait()
+
+	for i, got := range order {
+		if got != i+1 {
+			t.Fatalf("order = %v, want callers served in the order they queued", order)
+		}
+	}
+}
+
+func TestLimiterAcquireQueuedPosition(t *testing.T) {
+	l := newLimiter(2)
+
+	var positions []int
+	for i := 0; i < 2; i++ {
+		err := l.AcquireQueued(context.Background(), func(position int) {
+			positions = append(positions, position)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(positions) != 0 {
+		t.Fatalf("onQueued called with %v while slots were free", positions)
+	}
+
+	got := make(chan int, 3)
+	var wg sync.WaitGroup
+	for i := 1; i <= 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.AcquireQueued(context.Background(), func(position int) { got <- position })
+			l.Release()
+		}()
+		waitForWaiters(t, l, i)
+		if position := <-got; position != i {
+			t.Errorf("caller %d queued at position %d", i, position)
+		}
+	}
+
+	l.Release()
+	l.Release()
+	wg.Wait()
+}
+
+func TestLimiterCancelledWaiter(t *testing.T) {
+	l := newLimiter(1)
+	if err := l.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() { errs <- l.Acquire(ctx) }()
+	waitForWaiters(t, l, 1)
+
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("Acquire = %v, want %v", err, context.Canceled)
+	}
+	if len(l.waiters) != 0 {
+		t.Fatalf("%d waiters left after cancelling", len(l.waiters))
+	}
+
+	// the slot still goes to the next caller
+	l.Release()
+	timeout, stop := context.WithTimeout(context.Background(), time.Second)
+	defer stop()
+	if err := l.Acquire(timeout); err != nil {
+		t.Fatalf("Acquire after cancel: %s", err)
+	}
+}
+
+func TestLimiterCancelRacesRelease(t *testing.T) {
+	l := newLimiter(1)
+
+	for i := 0; i < 200; i++ {
+		if err := l.Acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errs := make(chan error)
+		go func() { errs <- l.Acquire(ctx) }()
+		waitForWaiters(t, l, 1)
+
+		// the slot may be handed to the waiter as it gives up
+		go cancel()
+		l.Release()
+		if err := <-errs; err == nil {
+			l.Release()
+		}
+
+		l.mu.Lock()
+		active := l.active
+		l.mu.Unlock()
+		if active != 0 {
+			t.Fatalf("iteration %d: %d slots held after everyone let go", i, active)
+		}
+	}
+}
+
+func TestLimiterSetLimit(t *testing.T) {
+	l := newLimiter(1)
+	if err := l.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan struct{}, 2)
+	for i := 1; i <= 2; i++ {
+		go func() {
+			l.Acquire(context.Background())
+			acquired <- struct{}{}
+		}()
+		waitForWaiters(t, l, i)
+	}
+
+	// raising the limit lets the waiters in right away
+	l.SetLimit(3)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-acquired:
+		case <-time.After(5 * time.Second):
+			t.Fatal("waiter not woken by SetLimit")
+		}
+	}
+
+	// lowering it keeps the holders, and new callers wait until enough
+	// are released
+	l.SetLimit(1)
+	go func() {
+		l.Acquire(context.Background())
+		acquired <- struct{}{}
+	}()
+	waitForWaiters(t, l, 1)
+
+	l.Release()
+	l.Release()
+	select {
+	case <-acquired:
+		t.Fatal("acquired while over the lowered limit")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	l.Release()
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiter not woken once under the lowered limit")
+	}
+}
+
+func TestNewLimiterMinimum(t *testing.T) {
+	for _, limit := range []int{-1, 0, 1} {
+		if got := newLimiter(limit).Limit(); got != 1 {
+			t.Errorf("newLimiter(%d).Limit() = %d, want 1", limit, got)
+		}
+	}
+}
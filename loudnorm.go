@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Loudness targets for NORMALIZE_AUDIO, per EBU R128 as used by streaming
+// services: integrated loudness in LUFS, true peak in dBTP and loudness
+// range in LU.
+const (
+	loudnormTargetLUFS = -16
+	loudnormTruePeak   = -1.5
+	loudnormRange      = 11
+)
+
+// loudnormQuality is yt-dlp's default --audio-quality, so the normalized
+// mp3 is about the size of the downloaded one.
+const loudnormQuality = "5"
+
+// loudnormFilter returns the ffmpeg audio filter for the loudness targets.
+func loudnormFilter() string {
+	return fmt.Sprintf("loudnorm=I=%d:TP=%g:LRA=%d", loudnormTargetLUFS, loudnormTruePeak, loudnormRange)
+}
+
+// loudnormArgs returns the ffmpeg command that writes a normalized copy of
+// input to output. loudnorm works at 192 kHz internally, so the sample rate
+// is set back to one mp3 supports.
+func loudnormArgs(input string, output string) []string {
+	return []string{
+		"ffmpeg",
+		"-y",
+		"-i", input,
+		"-vn",
+		"-map_metadata", "0",
+		"-af", loudnormFilter(),
+		"-ar", "44100",
+		"-c:a", "libmp3lame",
+		"-q:a", loudnormQuality,
+		output,
+	}
+}
+
+// normalizeLoudness replaces the downloaded audio with a copy normalized to
+// loudnormTargetLUFS. On failure the audio is sent as downloaded.
+func (media *Media) normalizeLoudness(ctx context.Context) {
+	outputPath := filepath.Join(media.tmpDir, media.randomName+"_loudnorm.mp3")
+
+	if err := conversionLimiter.Acquire(ctx); err != nil {
+		log.Printf("[%s]: gave up waiting for a conversion slot, not normalizing: %s", media.user, err)
+		return
+	}
+	convCtx, cancel := context.WithTimeout(ctx, ffmpegTimeout)
+	_, err := runCommand(convCtx, media.user, loudnormArgs(media.Path, outputPath))
+	cancel()
+	conversionLimiter.Release()
+
+	if err != nil {
+		os.Remove(outputPath)
+		log.Printf("[%s]: error normalizing loudness, sending as is: %s", media.user, err)
+		return
+	}
+
+	log.Printf("[%s]: loudness normalized to %d LUFS", media.user, loudnormTargetLUFS)
+	if err := os.Remove(media.Path); err != nil {
+		log.Printf("error deleting original file: %s", err)
+	}
+	media.Path = outputPath
+	media.FileName = filepath.Base(outputPath)
+}
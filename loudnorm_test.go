@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoudnormArgs(t *testing.T) {
+	want := "ffmpeg -y -i in.mp3 -vn -map_metadata 0 -af loudnorm=I=-16:TP=-1.5:LRA=11 -ar 44100 -c:a libmp3lame -q:a 5 out.mp3"
+	if got := strings.Join(loudnormArgs("in.mp3", "out.mp3"), " "); got != want {
+		t.Errorf("loudnormArgs() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMediaNormalizeLoudness(t *testing.T) {
+	oldConversions, oldTimeout := conversionLimiter, ffmpegTimeout
+	defer func() { conversionLimiter, ffmpegTimeout = oldConversions, oldTimeout }()
+	conversionLimiter = newLimiter(1)
+	ffmpegTimeout = time.Minute
+
+	tests := []struct {
+		name       string
+		ffmpeg     string
+		normalized bool
+	}{
+		{"normalized", `for arg; do out=$arg; done; echo normalized > "$out"`, true},
+		{"ffmpeg fails", `for arg; do out=$arg; done; echo partial > "$out"; exit 1`, false},
+	}
+
+	for _, tt := range tests {
+		fakeCommand(t, "ffmpeg", tt.ffmpeg)
+
+		dir := t.TempDir()
+		source := filepath.Join(dir, "abc.mp3")
+		if err := os.WriteFile(source, []byte("mp3"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		media := &Media{user: "test", tmpDir: dir, randomName: "abc", Path: source, FileName: "abc.mp3"}
+		normalized := filepath.Join(dir, "abc_loudnorm.mp3")
+
+		media.normalizeLoudness(context.Background())
+
+		if tt.normalized {
+			if media.Path != normalized || media.FileName != "abc_loudnorm.mp3" {
+				t.Errorf("%s: media at %s named %s", tt.name, media.Path, media.FileName)
+			}
+			if _, err := os.Stat(source); !os.IsNotExist(err) {
+				t.Errorf("%s: original audio not removed", tt.name)
+			}
+			continue
+		}
+
+		if media.Path != source || media.FileName != "abc.mp3" {
+			t.Errorf("%s: media at %s named %s, want the download kept", tt.name, media.Path, media.FileName)
+		}
+		if _, err := os.Stat(normalized); !os.IsNotExist(err) {
+			t.Errorf("%s: failed normalization left its output behind", tt.name)
+		}
+	}
+}
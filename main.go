@@ -2,15 +2,19 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -18,15 +22,19 @@ import (
 	"github.com/go-telegram/bot/models"
 	"github.com/joho/godotenv"
 	"github.com/mkevac/markodownloadbot/stats"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 )
 
 var (
-	adminUsername string
-	adminChatID   int64
-	tmpDir        string
-	isLocal       bool
+	adminUsername     string
+	adminChatID       atomic.Int64
+	tmpDir            string
+	isLocal           bool
+	downloadLimiter   *limiter
+	conversionLimiter *limiter
+	extractorFailures *failureDetector
 )
 
 func main() {
@@ -38,10 +46,32 @@ func main() {
 	defer cancel()
 
 	adminUsername = os.Getenv("ADMIN_USERNAME")
+	log.Printf("MarkoDownloadBot %s", version)
 	log.Printf("Admin username: %s", adminUsername)
 
 	isLocal = os.Getenv("IS_LOCAL") == "true"
 
+	loadConfig()
+
+	if err := checkTools(ctx); err != nil {
+		if requireTools {
+			log.Fatalf("Required tools are missing: %s", err)
+		}
+		log.Printf("Warning: required tools are missing, downloads will fail: %s", err)
+	}
+
+	downloadLimiter = newLimiter(maxConcurrentDownloads)
+	if adaptiveConcurrency {
+		log.Printf("Adaptive concurrency enabled: %d-%d concurrent downloads", minConcurrentDownloads, maxConcurrentDownloads)
+		go runAdaptiveConcurrency(ctx, downloadLimiter, minConcurrentDownloads, maxConcurrentDownloads, 30*time.Second, sampleLoad)
+	} else {
+		log.Printf("Max concurrent downloads: %d", maxConcurrentDownloads)
+	}
+	extractorFailures = newFailureDetector(extractorAlertThreshold, extractorAlertWindow)
+
+	conversionLimiter = newLimiter(maxConcurrentConversions)
+	log.Printf("Max concurrent conversions: %d", maxConcurrentConversions)
+
 	dirBase := "/app/data"
 	if isLocal {
 		dirBase = "./data"
@@ -50,6 +80,22 @@ func main() {
 	// Initialize the stats package with the calculated dirBase
 	stats.Init(dirBase)
 
+	if statsRetention > 0 || requestRetention > 0 {
+		stats.StartCleanup(ctx, statsRetention, requestRetention)
+	}
+
+	loadDefaultResolution()
+	loadAccessLists()
+	loadAdminChatID()
+
+	if downloadArchive {
+		downloadArchiveDir = filepath.Join(dirBase, "download-archive")
+		if err := os.MkdirAll(downloadArchiveDir, 0755); err != nil {
+			log.Fatalf("Failed to create download archive directory: %v", err)
+		}
+		log.Printf("Skipping playlist items users already got, archives in %s", downloadArchiveDir)
+	}
+
 	var err error
 	tmpDir, err = os.MkdirTemp(dirBase, "telegram-bot-api-*")
 	if err != nil {
@@ -67,11 +113,28 @@ func main() {
 
 	log.Printf("Using temporary directory: %s", tmpDir)
 
+	if tempMaxAge > 0 {
+		removeOldTempDirs(dirBase, tmpDir, tempMaxAge)
+		go runTempJanitor(ctx, tmpDir, tempMaxAge)
+	}
+
 	// Use http.FileServer to serve files from the specified directory
 	fileServer := http.FileServer(http.Dir(tmpDir))
 
 	// Handle all requests by serving the file from the directory
 	http.Handle("/", fileServer)
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/health", healthHandler)
+
+	if shareBaseURL != "" {
+		shares, err = newShareStore(filepath.Join(dirBase, "share"), shareBaseURL, shareTTL)
+		if err != nil {
+			log.Fatalf("Failed to create share directory: %v", err)
+		}
+		http.Handle("/share/", shares)
+		go shares.runJanitor(ctx, time.Minute)
+		log.Printf("Download links enabled for trusted users (%s, valid for %s)", shareMode, shareTTL)
+	}
 
 	log.Println("Serving files on :8080")
 	go http.ListenAndServe(":8080", nil)
@@ -81,9 +144,13 @@ func main() {
 		serverURL = "http://localhost:8081"
 	}
 
+	jobs := newJobTracker()
+
 	opts := []bot.Option{
 		bot.WithDefaultHandler(handler),
 		bot.WithServerURL(serverURL),
+		bot.WithHTTPClient(botPollTimeout, newBotHTTPClient()),
+		bot.WithMiddlewares(jobs.Middleware, recordUserMiddleware),
 	}
 
 	var b *bot.Bot
@@ -98,8 +165,29 @@ func main() {
 		}
 	}
 
-	b.RegisterHandler(bot.HandlerTypeMessageText, "/stats", bot.MatchTypeExact, statsHandler)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/stats", bot.MatchTypePrefix, statsHandler)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/setres", bot.MatchTypePrefix, setResolutionHandler)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/access", bot.MatchTypePrefix, accessHandler)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/export", bot.MatchTypeExact, exportHandler)
 	b.RegisterHandler(bot.HandlerTypeMessageText, "/audio", bot.MatchTypePrefix, audioHandler)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/video720", bot.MatchTypePrefix, resolutionHandler(720))
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/video1080", bot.MatchTypePrefix, resolutionHandler(1080))
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/both", bot.MatchTypePrefix, bothHandler)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/voice", bot.MatchTypePrefix, voiceHandler)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/gif", bot.MatchTypePrefix, gifHandler)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/lang", bot.MatchTypePrefix, langHandler)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/settings", bot.MatchTypePrefix, settingsHandler)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/transcribe", bot.MatchTypePrefix, transcribeHandler)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/supported", bot.MatchTypePrefix, supportedHandler)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/format", bot.MatchTypePrefix, formatHandler)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/chapter", bot.MatchTypePrefix, chapterHandler)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/clip", bot.MatchTypePrefix, clipHandler)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/pw", bot.MatchTypePrefix, passwordHandler)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/history", bot.MatchTypePrefix, historyHandler)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/retry", bot.MatchTypePrefix, retryHandler)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, qualityCallbackPrefix, bot.MatchTypePrefix, qualityCallbackHandler)
+	b.RegisterHandler(bot.HandlerTypeCallbackQueryData, settingsCallbackPrefix, bot.MatchTypePrefix, settingsCallbackHandler)
+	b.RegisterHandler(bot.HandlerTypeMessageText, "/version", bot.MatchTypeExact, versionHandler)
 	b.RegisterHandler(bot.HandlerTypeMessageText, "/help", bot.MatchTypeExact, helpHandler)
 	b.RegisterHandler(bot.HandlerTypeMessageText, "/start", bot.MatchTypeExact, helpHandler)
 
@@ -107,8 +195,28 @@ func main() {
 		Commands: []models.BotCommand{
 			{Command: "start", Description: "Start the bot"},
 			{Command: "help", Description: "Show help information"},
+			{Command: "version", Description: "Show the bot, yt-dlp and ffmpeg versions"},
+			{Command: "video720", Description: "Download video in up to 720p"},
+			{Command: "video1080", Description: "Download video in up to 1080p"},
 			{Command: "audio", Description: "Download audio"},
+			{Command: "both", Description: "Download video and audio"},
+			{Command: "voice", Description: "Get audio as a voice message"},
+			{Command: "gif", Description: "Get a short clip as a GIF"},
+			{Command: "lang", Description: "Get a video with the audio in a language"},
+			{Command: "settings", Description: "Choose your default type, resolution and language"},
+			{Command: "transcribe", Description: "Transcribe the speech in a video"},
+			{Command: "chapter", Description: "Download a single chapter"},
+			{Command: "clip", Description: "Download part of a video"},
+			{Command: "pw", Description: "Download a password-protected video"},
+			{Command: "supported", Description: "Check if a site is supported"},
+			{Command: "formats", Description: "List the available formats of a video"},
+			{Command: "format", Description: "Download a format listed by /formats"},
+			{Command: "history", Description: "Show your recent requests"},
+			{Command: "retry", Description: "Retry a failed request"},
 			{Command: "stats", Description: "Show stats (admin only)"},
+			{Command: "setres", Description: "Set default video resolution (admin only)"},
+			{Command: "access", Description: "Allow or block users (admin only)"},
+			{Command: "export", Description: "Export stats events as CSV (admin only)"},
 		},
 	})
 	if err != nil {
@@ -119,26 +227,87 @@ func main() {
 		log.Println("Bot commands set successfully")
 	}
 
-	go b.Start(ctx)
+	if summarySchedule != "" {
+		log.Printf("Sending a %s stats summary to the admin at %s", summarySchedule, formatClock(summaryTime))
+		go runStatsSummary(ctx, b, summarySchedule, summaryTime)
+	}
+
+	if requestLimiter != nil {
+		go requestLimiter.runJanitor(ctx, 10*time.Minute)
+	}
+
+	go loadExtractors(ctx)
+
+	if ytDlpAutoUpdate || ytDlpUpdateCheck {
+		go runYtDlpUpdates(ctx, b)
+	}
+
+	go resumePendingRequests(b, jobs)
+
+	if botMode == botModeWebhook {
+		if err := startWebhook(ctx, b); err != nil {
+			log.Fatalf("Failed to start webhook: %v", err)
+		}
+		log.Printf("Receiving updates with a webhook at %s", webhookURL)
+	} else {
+		go b.Start(ctx)
+	}
 
 	<-ctx.Done()
 	log.Println("Received interrupt signal")
+
+	if botMode == botModeWebhook {
+		deleteWebhook(b)
+	}
+
+	jobs.Shutdown(shutdownTimeout)
+
+	stats.Close(5 * time.Second)
 }
 
+const adminChatIDConfigKey = "admin_chat_id"
+
+// saveAdminChatID remembers the admin's chat, and saves it so admin
+// messages can be sent right after a restart.
 func saveAdminChatID(username string, chatID int64) {
-	if adminUsername != "" && adminUsername == username {
-		adminChatID = chatID
+	if adminUsername == "" || adminUsername != username {
+		return
 	}
+
+	if adminChatID.Swap(chatID) != chatID {
+		if err := stats.SetConfig(adminChatIDConfigKey, strconv.FormatInt(chatID, 10)); err != nil {
+			log.Printf("Error saving admin chat ID: %s", err)
+		}
+	}
+}
+
+// loadAdminChatID restores the admin chat saved before a restart.
+func loadAdminChatID() {
+	value, ok := stats.GetConfig(adminChatIDConfigKey)
+	if !ok {
+		return
+	}
+
+	chatID, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Printf("Ignoring invalid saved admin chat ID '%s'", value)
+		return
+	}
+
+	adminChatID.Store(chatID)
+	log.Printf("Using saved admin chat ID %d", chatID)
 }
 
 func sendMessageToAdmin(ctx context.Context, b *bot.Bot, text string) {
-	if adminChatID == 0 {
+	chatID := adminChatID.Load()
+	if chatID == 0 {
+		log.Printf("Admin chat is unknown until the admin writes to the bot, not sending: %s", text)
 		return
 	}
 
 	b.SendMessage(ctx, &bot.SendMessageParams{
-		ChatID: adminChatID,
-		Text:   text,
+		ChatID: chatID,
+		Text:   truncateText(text, telegramMessageLimit),
 	})
 }
 
@@ -160,12 +329,53 @@ func cleanupAndVerifyInput(input string) (string, error) {
 		return "", fmt.Errorf("invalid URL")
 	}
 
+	if normalizeURL(u) {
+		return u.String(), nil
+	}
 	return input, nil
 }
 
-func statsHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
-	log.Printf("[%s]: received stats command", update.Message.From.Username)
+// extractURLs returns the distinct links in text, which may contain
+// several separated by spaces or new lines.
+func extractURLs(text string) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, field := range strings.Fields(text) {
+		u, err := cleanupAndVerifyInput(field)
+		if err != nil || seen[u] {
+			continue
+		}
+		seen[u] = true
+		urls = append(urls, u)
+	}
+	return urls
+}
 
+// downloadEach downloads several links from one message, one after another.
+// Each link is handled on its own, so a failing one doesn't stop the rest.
+// Links beyond MAX_URLS_PER_MESSAGE are skipped.
+func downloadEach(ctx context.Context, b *bot.Bot, update *models.Update, urls []string, opts DownloadOptions) {
+	if len(urls) > maxURLsPerMessage {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("You sent %d links, I only take %d per message. I'll download the first %d, please send the rest separately.", len(urls), maxURLsPerMessage, maxURLsPerMessage),
+		})
+		urls = urls[:maxURLsPerMessage]
+	}
+
+	log.Printf("[%s]: downloading %d links from one message", update.Message.From.Username, len(urls))
+
+	for _, u := range urls {
+		if ctx.Err() != nil {
+			return
+		}
+		handleDownload(ctx, b, update, u, opts, "")
+	}
+}
+
+// requireAdmin checks that the message comes from the admin and tells the
+// user and the admin otherwise.
+func requireAdmin(ctx context.Context, b *bot.Bot, update *models.Update, command string) bool {
 	saveAdminChatID(update.Message.From.Username, update.Message.Chat.ID)
 
 	if update.Message.From.Username != adminUsername {
@@ -173,7 +383,54 @@ func statsHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
 			ChatID: update.Message.Chat.ID,
 			Text:   "You are not authorized to use this command",
 		})
-		sendMessageToAdmin(ctx, b, fmt.Sprintf("Unauthorized access to /stats command from @%s", update.Message.From.Username))
+		sendMessageToAdmin(ctx, b, fmt.Sprintf("Unauthorized access to %s command from @%s", command, update.Message.From.Username))
+		return false
+	}
+
+	return true
+}
+
+func setResolutionHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Printf("[%s]: received setres command", update.Message.From.Username)
+
+	if !requireAdmin(ctx, b, update, "/setres") {
+		return
+	}
+
+	arg := strings.TrimSuffix(strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/setres")), "p")
+
+	var text string
+	if arg == "" {
+		text = fmt.Sprintf("Default resolution is %dp. Allowed values: %v", getDefaultResolution(), allowedResolutions)
+	} else if res, err := strconv.Atoi(arg); err != nil {
+		text = fmt.Sprintf("Invalid resolution '%s'. Allowed values: %v", arg, allowedResolutions)
+	} else if err := setDefaultResolution(res, true); err != nil {
+		text = fmt.Sprintf("Error: %s", err)
+	} else {
+		log.Printf("[%s]: default resolution set to %dp", update.Message.From.Username, res)
+		text = fmt.Sprintf("Default resolution set to %dp.", res)
+	}
+
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   text,
+	})
+}
+
+func statsHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Printf("[%s]: received stats command", update.Message.From.Username)
+
+	if !requireAdmin(ctx, b, update, "/stats") {
+		return
+	}
+
+	arg := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/stats"))
+	if period, ok := strings.CutPrefix(arg, "domains"); ok {
+		domainStatsHandler(ctx, b, update, strings.TrimSpace(period))
+		return
+	}
+	if arg != "" {
+		dateStatsHandler(ctx, b, update, arg)
 		return
 	}
 
@@ -189,11 +446,12 @@ func statsHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
 		totalAudioRequests := sum(stats.AudioRequests)
 
 		caser := cases.Title(language.English)
-		summaryMsg.WriteString(fmt.Sprintf("*%s:* V:`%d` A:`%d` E:`%d`\n",
-			caser.String(period),
+		summaryMsg.WriteString(fmt.Sprintf("*%s:* V:`%d` A:`%d` E:`%d` L:`%d`\n",
+			escapeMarkdown(caser.String(period)),
 			totalVideoRequests,
 			totalAudioRequests,
-			sum(stats.DownloadErrors)))
+			sum(stats.DownloadErrors),
+			sum(stats.FilesTooLarge)))
 	}
 
 	b.SendMessage(ctx, &bot.SendMessageParams{
@@ -204,54 +462,220 @@ func statsHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
 
 	// Send detailed per-period stats
 	for _, period := range periods {
-		stats := stats.GetStats(period)
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:    update.Message.Chat.ID,
+			Text:      truncateFormatted(detailedStatsMessage(cases.Title(language.English).String(period), stats.GetStats(period)), telegramMessageLimit, models.ParseModeMarkdown),
+			ParseMode: models.ParseModeMarkdown,
+		})
+	}
+}
 
-		var detailMsg strings.Builder
-		detailMsg.WriteString(fmt.Sprintf("*Detailed Stats \\- %s*\n\n", cases.Title(language.English).String(period)))
+// exportHandler sends the admin every stats event as a CSV file.
+func exportHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Printf("[%s]: received export command", update.Message.From.Username)
 
-		// Get top 10 users by total activity
-		type userStats struct {
-			username string
-			total    int
-		}
+	if !requireAdmin(ctx, b, update, "/export") {
+		return
+	}
 
-		users := make([]userStats, 0)
-		for username, videoCount := range stats.VideoRequests {
-			total := videoCount +
-				stats.AudioRequests[username] +
-				stats.DownloadErrors[username] +
-				stats.UnrecognizedCommands[username]
-			users = append(users, userStats{username, total})
-		}
+	f, err := os.CreateTemp(tmpDir, "stats-*.csv")
+	if err != nil {
+		log.Printf("Error creating export file: %s", err)
+		return
+	}
+	defer os.Remove(f.Name())
 
-		// Sort users by total activity
-		sort.Slice(users, func(i, j int) bool {
-			return users[i].total > users[j].total
+	err = stats.ExportCSV(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Printf("Error exporting stats: %s", err)
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Error exporting stats: %s", err),
 		})
+		return
+	}
 
-		// Show top 10 users
-		maxUsers := 10
-		if len(users) < maxUsers {
-			maxUsers = len(users)
-		}
+	if _, err := sendAsDocument(ctx, b, update.Message.Chat.ID, f.Name(), "Stats events"); err != nil {
+		log.Printf("Error sending stats export: %s", err)
+	}
+}
 
-		detailMsg.WriteString("Top Users:\n")
-		for i := 0; i < maxUsers; i++ {
-			username := users[i].username
-			escapedUsername := bot.EscapeMarkdown(username)
-			detailMsg.WriteString(fmt.Sprintf("@%s: V:`%d` A:`%d` E:`%d`\n",
-				escapedUsername,
-				stats.VideoRequests[username],
-				stats.AudioRequests[username],
-				stats.DownloadErrors[username]))
-		}
+// dateStatsHandler answers "/stats YYYY-MM-DD" and "/stats YYYY-MM" with
+// the stats of that calendar day or month, and "/stats <start> <end>" with
+// those of the range.
+func dateStatsHandler(ctx context.Context, b *bot.Bot, update *models.Update, arg string) {
+	from, to, err := stats.ParseDateRange(arg, time.Now())
+	if err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("%s. Usage: /stats [YYYY-MM-DD | YYYY-MM] [end date]", err),
+		})
+		return
+	}
 
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    update.Message.Chat.ID,
+		Text:      truncateFormatted(periodStatsMessage(arg, stats.GetStatsRange(from, to)), telegramMessageLimit, models.ParseModeMarkdown),
+		ParseMode: models.ParseModeMarkdown,
+	})
+}
+
+// maxStatsDomains is how many sites /stats domains lists.
+const maxStatsDomains = 20
+
+// domainStatsHandler answers /stats domains [day|week|month] with the
+// requests, errors and traffic per site.
+func domainStatsHandler(ctx context.Context, b *bot.Bot, update *models.Update, period string) {
+	title := "Overall"
+	switch period {
+	case "day", "week", "month":
+		title = cases.Title(language.English).String(period)
+	case "":
+	default:
 		b.SendMessage(ctx, &bot.SendMessageParams{
-			ChatID:    update.Message.Chat.ID,
-			Text:      detailMsg.String(),
-			ParseMode: models.ParseModeMarkdown,
+			ChatID: update.Message.Chat.ID,
+			Text:   "Usage: /stats domains [day | week | month]",
 		})
+		return
 	}
+
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    update.Message.Chat.ID,
+		Text:      truncateFormatted(domainStatsMessage(title, stats.GetDomainStats(period)), telegramMessageLimit, models.ParseModeMarkdown),
+		ParseMode: models.ParseModeMarkdown,
+	})
+}
+
+// domainStatsMessage lists the most requested domains under title as
+// MarkdownV2.
+func domainStatsMessage(title string, domains []stats.DomainStats) string {
+	var msg strings.Builder
+	msg.WriteString(fmt.Sprintf("*%s*\n\n", escapeMarkdown("Domains - "+title)))
+
+	if len(domains) == 0 {
+		msg.WriteString("No downloads yet\\.\n")
+		return msg.String()
+	}
+
+	if len(domains) > maxStatsDomains {
+		domains = domains[:maxStatsDomains]
+	}
+	for _, d := range domains {
+		msg.WriteString(fmt.Sprintf("%s: R:`%d` E:`%d` L:`%d` `%.2f GB`\n",
+			escapeMarkdown(d.Domain),
+			d.Requests,
+			d.DownloadErrors,
+			d.FilesTooLarge,
+			float64(d.BytesServed)/(1024*1024*1024)))
+	}
+
+	return msg.String()
+}
+
+// periodStatsMessage combines the totals and the top users of a single
+// period as MarkdownV2.
+func periodStatsMessage(title string, st *stats.Stats) string {
+	var msg strings.Builder
+	msg.WriteString(fmt.Sprintf("*%s:* V:`%d` A:`%d` E:`%d` L:`%d`\n\n",
+		escapeMarkdown(title),
+		sum(st.VideoRequests),
+		sum(st.AudioRequests),
+		sum(st.DownloadErrors),
+		sum(st.FilesTooLarge)))
+	msg.WriteString(detailedStatsMessage(title, st))
+	return msg.String()
+}
+
+// detailedStatsMessage lists the top users of stats under title as
+// MarkdownV2.
+func detailedStatsMessage(title string, stats *stats.Stats) string {
+	var detailMsg strings.Builder
+	detailMsg.WriteString(fmt.Sprintf("*%s*\n\n", escapeMarkdown("Detailed Stats - "+title)))
+
+	if stats.Downloads > 0 {
+		detailMsg.WriteString(fmt.Sprintf("Avg download time: `%.1fs` Total served: `%.2f GB`\n\n",
+			stats.AvgDownloadTime.Seconds(),
+			float64(stats.BytesServed)/(1024*1024*1024)))
+	}
+
+	// Get top 10 users by total activity
+	type userStats struct {
+		username string
+		total    int
+	}
+
+	users := make([]userStats, 0)
+	for username, videoCount := range stats.VideoRequests {
+		total := videoCount +
+			stats.AudioRequests[username] +
+			stats.DownloadErrors[username] +
+			stats.UnrecognizedCommands[username] +
+			stats.FilesTooLarge[username]
+		users = append(users, userStats{username, total})
+	}
+
+	// Sort users by total activity
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].total > users[j].total
+	})
+
+	// Show top 10 users
+	maxUsers := 10
+	if len(users) < maxUsers {
+		maxUsers = len(users)
+	}
+
+	detailMsg.WriteString("Top Users:\n")
+	for i := 0; i < maxUsers; i++ {
+		username := users[i].username
+		detailMsg.WriteString(fmt.Sprintf("@%s: V:`%d` A:`%d` E:`%d`\n",
+			escapeMarkdown(username),
+			stats.VideoRequests[username],
+			stats.AudioRequests[username],
+			stats.DownloadErrors[username]))
+	}
+
+	return detailMsg.String()
+}
+
+func supportedHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		log.Println("Received supported command with nil Message")
+		return
+	}
+	log.Printf("[%s]: received message: '%s'", update.Message.From.Username, update.Message.Text)
+
+	input := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/supported"))
+	host := input
+	if u, err := url.Parse(input); err == nil && u.Host != "" {
+		host = u.Host
+	}
+
+	if host == "" || strings.ContainsAny(host, " /") {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Usage: /supported <domain>, e.g. /supported vimeo.com",
+		})
+		return
+	}
+
+	names := getExtractors()
+	var text string
+	if len(names) == 0 {
+		text = "The list of supported sites isn't available right now, please try again later."
+	} else if name, ok := matchExtractor(host, names); ok {
+		text = fmt.Sprintf("Yes, %s is supported (extractor: %s).", host, name)
+	} else {
+		text = fmt.Sprintf("No, %s is not among the %d sites I know. I can still try to download from it, sometimes it works.", host, len(names))
+	}
+
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   text,
+	})
 }
 
 func handler(ctx context.Context, b *bot.Bot, update *models.Update) {
@@ -259,7 +683,23 @@ func handler(ctx context.Context, b *bot.Bot, update *models.Update) {
 		log.Println("Received update with nil Message")
 		return
 	}
-	handleDownload(ctx, b, update, update.Message.Text, false)
+
+	opts := userOptions(update.Message.From)
+
+	if urls := extractURLs(update.Message.Text); len(urls) > 1 {
+		downloadEach(ctx, b, update, urls, opts)
+		return
+	}
+
+	// users who may not download are refused by handleDownload instead
+	if qualityKeyboard && canDownload(update.Message.From) && !hasUserPrefs(update.Message.From) {
+		if _, err := cleanupAndVerifyInput(update.Message.Text); err == nil {
+			sendQualityKeyboard(ctx, b, update.Message)
+			return
+		}
+	}
+
+	handleDownload(ctx, b, update, update.Message.Text, opts, "")
 }
 
 func audioHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
@@ -268,14 +708,116 @@ func audioHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
 		return
 	}
 	input := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/audio"))
-	handleDownload(ctx, b, update, input, true)
+	if urls := extractURLs(input); len(urls) > 1 {
+		downloadEach(ctx, b, update, urls, DownloadOptions{AudioOnly: true})
+		return
+	}
+	handleDownload(ctx, b, update, input, DownloadOptions{AudioOnly: true}, "")
+}
+
+// resolutionHandler returns the handler for a /video<height> command,
+// which downloads the video at up to that height.
+func resolutionHandler(height int) bot.HandlerFunc {
+	command := fmt.Sprintf("/video%d", height)
+
+	return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		if update.Message == nil {
+			log.Printf("Received %s command with nil Message", command)
+			return
+		}
+		input := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, command))
+		handleDownload(ctx, b, update, input, DownloadOptions{Resolution: height}, "")
+	}
 }
 
-func handleDownload(ctx context.Context, b *bot.Bot, update *models.Update, input string, audioOnly bool) {
+func clipHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		log.Println("Received clip command with nil Message")
+		return
+	}
+
+	input, section, err := parseClipArgs(strings.TrimPrefix(update.Message.Text, "/clip"))
+	if err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fmt.Sprintf("Please send me a valid video link and times, e.g. /clip <link> 00:01:30 00:02:00 (%s)", err),
+		})
+		return
+	}
+
+	handleDownload(ctx, b, update, input, DownloadOptions{Section: &section}, "")
+}
+
+func chapterHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		log.Println("Received chapter command with nil Message")
+		return
+	}
+
+	name, input, err := parseChapterArgs(strings.TrimPrefix(update.Message.Text, "/chapter"))
+	if err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Usage: /chapter \"Chapter name\" <link>",
+		})
+		return
+	}
+
+	handleDownload(ctx, b, update, input, DownloadOptions{}, name)
+}
+
+// queuedMessage tells the user their place in the download queue.
+func queuedMessage(position int) string {
+	return fmt.Sprintf("You are #%d in the queue, your download starts when a slot frees up.", position)
+}
+
+// downloadAllowed runs the checks every command that downloads or probes a
+// link goes through: the allowlist and blocklist, quiet hours, the per-user
+// rate limit and free disk space. It tells the user why when one fails.
+// rateLimit is false for requests that were already counted, like the
+// items of a playlist.
+func downloadAllowed(ctx context.Context, b *bot.Bot, msg *models.Message, rateLimit bool) bool {
+	username := msg.From.Username
+
+	var text string
+	switch {
+	case !canDownload(msg.From):
+		log.Printf("[%s]: user %d is not authorized to download", username, msg.From.ID)
+		text = "You are not authorized to use this bot."
+	case username != adminUsername && quietPeriod.contains(time.Now()):
+		log.Printf("[%s]: request deferred during quiet hours", username)
+		text = quietPeriod.message()
+	case !hasEnoughDiskSpace(ctx, b):
+		text = "Not enough disk space, try again later."
+	}
+
+	if text == "" && requestLimiter != nil && rateLimit && msg.From.ID != 0 && username != adminUsername {
+		if ok, wait := requestLimiter.Allow(msg.From.ID, time.Now()); !ok {
+			log.Printf("[%s]: request rate limited", username)
+			text = fmt.Sprintf("You're doing that too much, try again in %d seconds.", int(math.Ceil(wait.Seconds())))
+		}
+	}
+
+	if text == "" {
+		return true
+	}
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: msg.Chat.ID,
+		Text:   text,
+	})
+	return false
+}
+
+// handleDownload downloads input and sends it to the chat. When chapter is
+// set, only the chapter with that name is downloaded.
+func handleDownload(ctx context.Context, b *bot.Bot, update *models.Update, input string, opts DownloadOptions, chapter string) {
+	audioOnly := opts.AudioOnly
+
 	log.Printf("[%s]: received message: '%s'", update.Message.From.Username, update.Message.Text)
 
 	saveAdminChatID(update.Message.From.Username, update.Message.Chat.ID)
 
+	// checked first, so chat text doesn't use up the rate limit
 	input, err := cleanupAndVerifyInput(input)
 	if err != nil {
 		b.SendMessage(ctx, &bot.SendMessageParams{
@@ -287,46 +829,225 @@ func handleDownload(ctx context.Context, b *bot.Bot, update *models.Update, inpu
 		return
 	}
 
+	if !downloadAllowed(ctx, b, update.Message, !opts.playlistItem) {
+		return
+	}
+
+	applyUserPrefs(update.Message.From, &opts)
+
+	domain := hostOf(input)
+
+	// with the download archive, playlists are fetched one new item per
+	// request instead
+	if !opts.playlistItem && downloadArchiveDir == "" && isPlaylistInput(input) {
+		downloadPlaylist(ctx, b, update, input, opts)
+		return
+	}
+
 	if audioOnly {
-		stats.AddAudioRequest(update.Message.From.Username)
+		stats.AddAudioRequest(update.Message.From.Username, domain)
+		audioRequestsTotal.Inc()
 	} else {
-		stats.AddVideoRequest(update.Message.From.Username)
+		stats.AddVideoRequest(update.Message.From.Username, domain)
+		videoRequestsTotal.Inc()
 	}
 
 	var mediaType string
-	if audioOnly {
+	if opts.Voice {
+		mediaType = "voice message"
+	} else if opts.GIF {
+		mediaType = "GIF"
+	} else if audioOnly {
 		mediaType = "audio"
 	} else {
 		mediaType = "video"
 	}
 	log.Printf("[%s]: %s url: '%s'", update.Message.From.Username, mediaType, input)
 
-	b.SendMessage(ctx, &bot.SendMessageParams{
-		ChatID: update.Message.Chat.ID,
-		Text:   fmt.Sprintf("I will download the %s and send it to you shortly.", mediaType),
+	cookiesFile := defaultCookiesFile()
+
+	if doc := replyCookiesDocument(update.Message); doc != nil {
+		if isTrustedUser(update.Message.From.Username) {
+			path, err := downloadCookiesFile(ctx, b, doc)
+			if err != nil {
+				log.Printf("[%s]: error downloading cookies document: %s", update.Message.From.Username, err)
+				b.SendMessage(ctx, &bot.SendMessageParams{
+					ChatID: update.Message.Chat.ID,
+					Text:   "I couldn't read the cookies file you replied to, using the default cookies.",
+				})
+			} else {
+				defer func() {
+					if err := os.Remove(path); err != nil {
+						log.Printf("Error removing cookies file: %s", err)
+					}
+				}()
+				cookiesFile = path
+			}
+		} else {
+			log.Printf("[%s]: untrusted user tried to use custom cookies", update.Message.From.Username)
+			b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: update.Message.Chat.ID,
+				Text:   "Only trusted users can use custom cookies, using the default cookies.",
+			})
+		}
+	}
+	log.Printf("Using cookies file: %s", cookiesFile)
+
+	opts.CookiesFile = cookiesFile
+
+	var meta *Metadata
+	if chapter != "" {
+		meta, err = FetchMetadata(ctx, input, update.Message.From.Username, cookiesFile)
+		if err != nil {
+			log.Printf("[%s]: error fetching metadata: %s", update.Message.From.Username, err)
+			b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: update.Message.Chat.ID,
+				Text:   "I couldn't get the list of chapters for this link.",
+			})
+			return
+		}
+
+		if !replyChapter(ctx, b, update, meta, chapter, &opts) {
+			return
+		}
+	}
+
+	if checkLiveStreams && meta == nil && !isPlaylistInput(input) {
+		// the metadata is reused below, e.g. for the title in the ack
+		metaCtx, cancel := context.WithTimeout(ctx, liveCheckTimeout)
+		meta, err = FetchMetadata(metaCtx, input, update.Message.From.Username, cookiesFile)
+		cancel()
+		if err != nil {
+			log.Printf("[%s]: error fetching metadata: %s", update.Message.From.Username, err)
+		}
+	}
+
+	if msg := meta.liveStatusMessage(); msg != "" {
+		log.Printf("[%s]: refusing live stream %s", update.Message.From.Username, input)
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   msg,
+		})
+		return
+	}
+
+	if opts.GIF {
+		if meta == nil {
+			meta, err = FetchMetadata(ctx, input, update.Message.From.Username, cookiesFile)
+			if err != nil {
+				log.Printf("[%s]: error fetching metadata: %s", update.Message.From.Username, err)
+			}
+		}
+
+		// convertToGIF checks again when the duration is only known
+		// after downloading
+		if meta != nil && meta.Duration > gifMaxDuration.Seconds() {
+			log.Printf("[%s]: %s is %.0f seconds, too long for a GIF", update.Message.From.Username, input, meta.Duration)
+			b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: update.Message.Chat.ID,
+				Text:   gifTooLongMessage(),
+			})
+			return
+		}
+	}
+
+	if ackFetchTitle && meta == nil {
+		metaCtx, cancel := context.WithTimeout(ctx, ackMetadataTimeout)
+		meta, err = FetchMetadata(metaCtx, input, update.Message.From.Username, cookiesFile)
+		cancel()
+		if err != nil {
+			log.Printf("[%s]: error fetching metadata: %s", update.Message.From.Username, err)
+		}
+	}
+
+	if u, err := url.Parse(input); err == nil && isTikTokHost(u.Host) && !audioOnly {
+		if meta == nil {
+			meta, err = FetchMetadata(ctx, input, update.Message.From.Username, cookiesFile)
+			if err != nil {
+				log.Printf("[%s]: error fetching metadata: %s", update.Message.From.Username, err)
+			}
+		}
+
+		if isTikTokPhotoPost(u, meta) {
+			log.Printf("[%s]: TikTok photo slideshow", update.Message.From.Username)
+			sendTikTokPhotoPost(ctx, b, update, input, meta, cookiesFile)
+			return
+		}
+	}
+
+	ackText := ackMessage(mediaType, meta, hostOf(input))
+	ack, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:          update.Message.Chat.ID,
+		Text:            ackText,
+		ReplyParameters: replyParameters(update.Message),
 	})
 
-	cookiesFile := os.Getenv("COOKIES_FILE")
-	if cookiesFile == "" {
-		cookiesFile = "/app/cookies.txt"
+	requestID := stats.AddPendingRequest(update.Message.Chat.ID, update.Message.From.ID, update.Message.From.Username, input, requestMode(opts), encodeRequestOptions(opts))
+	opts.OnQueued = func(position int) {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   queuedMessage(position),
+		})
+	}
+	var started time.Time
+	opts.OnStart = func() {
+		started = time.Now()
+		stats.SetRequestStatus(requestID, stats.RequestStarted)
+	}
+
+	if progressInterval > 0 && err == nil && ack != nil {
+		progress := newProgressMessage(ctx, b, update.Message.Chat.ID, ack.ID, ackText)
+		opts.OnProgress = progress.Update
+		opts.OnDownloaded = progress.Done
 	}
-	log.Printf("Using cookies file: %s", cookiesFile)
 
-	media, err := DownloadMedia(input, update.Message.From.Username, tmpDir, cookiesFile, audioOnly)
+	opts.Metadata = meta
+	media, err := DownloadMedia(ctx, input, update.Message.From.Username, tmpDir, opts)
+	downloadTime := time.Since(started)
+	switch {
+	case err == nil:
+		stats.SetRequestStatus(requestID, stats.RequestDone)
+	case ctx.Err() == nil:
+		stats.SetRequestStatus(requestID, stats.RequestFailed)
+	default:
+		// shutting down, a request that didn't start yet is resumed
+	}
+	if errors.Is(err, errNoNewItems) {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "There is nothing new in this playlist since your last request.",
+		})
+		return
+	}
 	if err != nil {
 		log.Printf("Error downloading %s: %s", mediaType, err)
-		stats.AddDownloadError(update.Message.From.Username)
+		stats.AddDownloadError(update.Message.From.Username, domain)
+		downloadErrorsTotal.Inc()
 
 		errorMsg := fmt.Sprintf("I'm sorry, @%s. I'm afraid I can't do that. Error downloading %s from %s: %s",
 			update.Message.From.Username, mediaType, input, err.Error())
 
+		userMsg := errorMsg
+		if update.Message.From.Username != adminUsername {
+			userMsg = userErrorMessage(userErrorDetail, err, errorMsg)
+		}
+
 		b.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: update.Message.Chat.ID,
-			Text:   errorMsg,
+			Text:   truncateText(userMsg, telegramMessageLimit),
 		})
 
 		sendMessageToAdmin(ctx, b, errorMsg)
 
+		var dlErr *DownloadError
+		if errors.As(err, &dlErr) && dlErr.Category == errorExtractor {
+			host := hostOf(input)
+			if extractorFailures.Record(host, time.Now()) {
+				sendMessageToAdmin(ctx, b, fmt.Sprintf("yt-dlp failed to extract %d links from %s within %s. It probably needs updating.",
+					extractorAlertThreshold, host, extractorAlertWindow))
+			}
+		}
+
 		return
 	}
 
@@ -337,32 +1058,160 @@ func handleDownload(ctx context.Context, b *bot.Bot, update *models.Update, inpu
 		log.Printf("[%s]: %s downloaded to '%s' (size: %d bytes)", update.Message.From.Username, mediaType, media.Path, fileSize)
 	}
 
-	// fix media path if local
-	var pathToSend string
-	if isLocal {
-		pathToSend = filepath.Join("/app", media.Path)
+	refuseTooLarge := func() {
+		log.Printf("[%s]: %s is %d bytes, over the %d bytes limit, not sending", update.Message.From.Username, mediaType, fileSize, maxFileSize)
+		stats.AddFileTooLarge(update.Message.From.Username, domain)
+		stats.SetRequestStatus(requestID, stats.RequestFailed)
+
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   fileTooLargeMessage(mediaType, fileSize, maxFileSize, audioOnly),
+		})
+
+		if err := media.Delete(); err != nil {
+			log.Printf("Error removing %s file: %s", mediaType, err)
+		}
+	}
+
+	// files too large to upload can still be shared as a link
+	canShare := shares != nil && isTrustedUser(update.Message.From.Username)
+	tooLarge := err == nil && fileSize > maxFileSize
+	if tooLarge && !canShare {
+		refuseTooLarge()
+		return
+	}
+
+	log.Printf("[%s]: media path to send: %s", update.Message.From.Username, localPath(media.Path))
+
+	if postDownloadHookStage == hookBeforeSend {
+		if err := runPostDownloadHook(ctx, update.Message.From.Username, media.Path); err != nil && postDownloadHookBlocking {
+			stats.AddDownloadError(update.Message.From.Username, domain)
+			downloadErrorsTotal.Inc()
+			stats.SetRequestStatus(requestID, stats.RequestFailed)
+
+			b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: update.Message.Chat.ID,
+				Text:   fmt.Sprintf("I'm sorry, @%s. The %s could not be sent because post-processing failed.", update.Message.From.Username, mediaType),
+			})
+			sendMessageToAdmin(ctx, b, fmt.Sprintf("Post-download hook blocked %s from %s for @%s: %s", mediaType, input, update.Message.From.Username, err))
+
+			if err := media.Delete(); err != nil {
+				log.Printf("Error removing %s file: %s", mediaType, err)
+			}
+			return
+		}
+	}
+
+	var extraction *audioExtraction
+	if opts.WithAudio && !audioOnly {
+		extraction = startAudioExtraction(ctx, media)
+	}
+
+	var fileHash string
+	if hashSentFiles {
+		var err error
+		if fileHash, err = fileSHA256(media.Path); err != nil {
+			log.Printf("[%s]: error hashing %s: %s", update.Message.From.Username, mediaType, err)
+		} else {
+			log.Printf("[%s]: %s sha256 %s", update.Message.From.Username, mediaType, fileHash)
+		}
+	}
+
+	shared := false
+	if canShare {
+		shared = sendShareLink(ctx, b, update.Message.Chat.ID, media)
+	}
+
+	if tooLarge && !shared {
+		if extraction != nil {
+			extraction.discard()
+		}
+		refuseTooLarge()
+		return
+	}
+
+	if shared && (shareMode == shareInstead || tooLarge) {
+		log.Printf("[%s]: %s shared as a link instead of uploading", update.Message.From.Username, mediaType)
 	} else {
-		pathToSend = media.Path
+		sent, linked, err := deliverMedia(ctx, b, update.Message.Chat.ID, media, audioOnly, shared, replyParameters(update.Message))
+		if err != nil {
+			log.Printf("[%s]: error sending %s: %s", update.Message.From.Username, mediaType, err)
+			stats.AddDownloadError(update.Message.From.Username, domain)
+			downloadErrorsTotal.Inc()
+			stats.SetRequestStatus(requestID, stats.RequestFailed)
+
+			b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: update.Message.Chat.ID,
+				Text:   fmt.Sprintf("I'm sorry, @%s. I downloaded the %s but couldn't send it to you.", update.Message.From.Username, mediaType),
+			})
+			sendMessageToAdmin(ctx, b, fmt.Sprintf("Error sending %s from %s to @%s: %s", mediaType, input, update.Message.From.Username, err))
+
+			if extraction != nil {
+				extraction.discard()
+			}
+			if err := media.Delete(); err != nil {
+				log.Printf("Error removing %s file: %s", mediaType, err)
+			}
+			return
+		}
+
+		if linked {
+			log.Printf("[%s]: %s sent as a link after the upload timed out", update.Message.From.Username, mediaType)
+		} else {
+			log.Printf("[%s]: %s sent", update.Message.From.Username, mediaType)
+		}
+
+		logToChannel(ctx, b, update.Message.From.Username, input, sent)
+
+		if media.usesDownloadArchive() {
+			b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: update.Message.Chat.ID,
+				Text:   "That was 1 new item from this playlist. Send the link again for the next one.",
+			})
+		}
 	}
 
-	log.Printf("[%s]: media path to send: %s", update.Message.From.Username, pathToSend)
+	stats.AddDownload(update.Message.From.Username, mediaType, domain, downloadTime, fileSize)
 
-	if audioOnly {
-		b.SendAudio(ctx, &bot.SendAudioParams{
+	if fileHash != "" {
+		stats.AddSentFile(update.Message.From.Username, fileHash)
+	}
+
+	if opts.OnDelivered != nil {
+		opts.OnDelivered()
+	}
+
+	if deleteUserMessage && !opts.playlistItem {
+		deleteRequestMessage(ctx, b, update.Message)
+	}
+
+	if extraction != nil {
+		extraction.send(ctx, b, update.Message.Chat.ID)
+	}
+
+	if media.ReducedBitrate > 0 {
+		b.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: update.Message.Chat.ID,
-			Audio:  &models.InputFileString{Data: "file://" + pathToSend},
-		})
-	} else {
-		b.SendVideo(ctx, &bot.SendVideoParams{
-			ChatID:   update.Message.Chat.ID,
-			Video:    &models.InputFileString{Data: "file://" + pathToSend},
-			Width:    media.Width,
-			Height:   media.Height,
-			Duration: (int)(media.Duration),
+			Text:   fmt.Sprintf("The audio was too large for Telegram, so I reduced its quality to %d kbps.", media.ReducedBitrate),
 		})
 	}
 
-	log.Printf("[%s]: %s sent", update.Message.From.Username, mediaType)
+	if sendTranscripts && !opts.GIF {
+		sendTranscript(ctx, b, update.Message.Chat.ID, media)
+	}
+
+	if postDownloadHookStage == hookAfterSend {
+		runPostDownloadHook(ctx, update.Message.From.Username, media.Path)
+	}
+
+	if archiveDir != "" {
+		path, err := media.Archive(archiveDir)
+		if err == nil {
+			log.Printf("[%s]: %s archived to %s", update.Message.From.Username, mediaType, path)
+			return
+		}
+		log.Printf("[%s]: error archiving %s: %s", update.Message.From.Username, mediaType, err)
+	}
 
 	if err := media.Delete(); err != nil {
 		log.Printf("Error removing %s file: %s", mediaType, err)
@@ -371,6 +1220,54 @@ func handleDownload(ctx context.Context, b *bot.Bot, update *models.Update, inpu
 	log.Printf("[%s]: %s removed", update.Message.From.Username, mediaType)
 }
 
+// replyChapter looks up the chapter called name and limits opts to it. If
+// there is no such chapter it lists the available ones and returns false.
+func replyChapter(ctx context.Context, b *bot.Bot, update *models.Update, meta *Metadata, name string, opts *DownloadOptions) bool {
+	if len(meta.Chapters) == 0 {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "This video has no chapters.",
+		})
+		return false
+	}
+
+	chapter, err := matchChapter(meta.Chapters, name)
+	if err != nil {
+		var list strings.Builder
+		for _, c := range meta.Chapters {
+			list.WriteString("\n• " + c.Title)
+		}
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   truncateText(fmt.Sprintf("Sorry, %s. Available chapters:%s", err, list.String()), telegramMessageLimit),
+		})
+		return false
+	}
+
+	section := chapter.section()
+	opts.Section = &section
+	log.Printf("[%s]: chapter '%s' (%s)", update.Message.From.Username, chapter.Title, section.downloadSectionsArg())
+	return true
+}
+
+// hostOf returns the host of a URL, without a leading "www.".
+func hostOf(input string) string {
+	u, err := url.Parse(input)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// localPath fixes the path of a file in tmpDir for the Bot API server,
+// which sees the data directory under /app when running locally.
+func localPath(path string) string {
+	if isLocal {
+		return filepath.Join("/app", path)
+	}
+	return path
+}
+
 func helpHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
 	log.Printf("[%s]: received message: '%s'", update.Message.From.Username, update.Message.Text)
 
@@ -381,15 +1278,75 @@ Here's how you can use me:
 1. <b>Download Video:</b> 
    Simply send a video URL, and I'll download and send the video to you.
 
+   <code>/video720 [URL]</code> or <code>/video1080 [URL]</code>: 
+   Download the video in up to this resolution.
+
+   <code>/chapter "name" [URL]</code>: 
+   Download only the chapter with this name.
+
+   <code>/clip [URL] [start] [end]</code>: 
+   Download only the part between two times, e.g. 00:01:30 00:02:00.
+
+   <code>/pw [password] [URL]</code>: 
+   Download a password-protected video, e.g. from Vimeo.
+
+   <code>/lang [language] [URL]</code>: 
+   Download the video with the audio in this language, e.g. de.
+
 2. <code>/audio [URL]</code>: 
    Use this command followed by an audio URL to download and receive audio files.
 
-3. <code>/stats</code>: 
-   (Admin only) View usage statistics of the bot.
+   <code>/both [URL]</code>: 
+   Get the video and its audio as a separate file.
+
+   <code>/voice [URL]</code>: 
+   Get the audio as a voice message, for short clips.
+
+   <code>/gif [URL]</code>: 
+   Get a short clip as an animated GIF.
+
+   <code>/transcribe [URL]</code>: 
+   Get the spoken text of a video or audio, if enabled.
 
-4. <code>/help</code> or <code>/start</code>: 
+3. <code>/supported [domain]</code>: 
+   Check whether a site is supported.
+
+   <code>/formats [URL]</code>: 
+   List the formats and qualities available for a link.
+
+   <code>/format [id] [URL]</code>: 
+   Download the format with that ID from the /formats list.
+
+   <code>/history</code>: 
+   List your recent requests.
+
+   <code>/retry [id]</code>: 
+   Try a failed request from /history again.
+
+   <code>/settings</code>: 
+   Choose whether plain links get you video or audio, the resolution and the audio language.
+
+4. <code>/stats [YYYY-MM-DD | YYYY-MM] [end date]</code>: 
+   (Admin only) View usage statistics of the bot, optionally for a single day or month, or a range of them.
+
+   <code>/stats domains [day | week | month]</code>: 
+   (Admin only) View requests, errors and traffic per site.
+
+   <code>/setres [resolution]</code>: 
+   (Admin only) Show or change the default video resolution.
+
+   <code>/access [allow | block | remove] [user]</code>: 
+   (Admin only) Show or change who may download.
+
+   <code>/export</code>: 
+   (Admin only) Get all stats events as a CSV file.
+
+5. <code>/help</code> or <code>/start</code>: 
    Display this help message.
 
+   <code>/version</code>: 
+   Show the versions of the bot, yt-dlp and ffmpeg, useful when reporting a problem.
+
 To download media, just send me a valid video or audio link. I'll take care of the rest!
 
 Note: Please ensure you have the rights to download and use the media you request.`
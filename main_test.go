@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/mkevac/markodownloadbot/stats"
+)
+
+// TestMain gives the handlers under test a stats database of their own.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "markodownloadbot-test-*")
+	if err != nil {
+		log.Fatal(err)
+	}
+	stats.Init(dir)
+
+	code := m.Run()
+
+	stats.Close(5 * time.Second)
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestDownloadAllowed(t *testing.T) {
+	oldAdmin, oldQuiet, oldLimiter := adminUsername, quietPeriod, requestLimiter
+	oldTmp, oldMinFree := tmpDir, minFreeDiskSpace
+	defer func() {
+		adminUsername, quietPeriod, requestLimiter = oldAdmin, oldQuiet, oldLimiter
+		tmpDir, minFreeDiskSpace = oldTmp, oldMinFree
+		allowedUsers.Set("")
+		blockedUsers.Set("")
+	}()
+	adminUsername = "admin"
+	tmpDir = t.TempDir()
+
+	allDay := &quietHours{start: 0, end: 24 * 60}
+
+	tests := []struct {
+		name      string
+		user      models.User
+		allowed   string
+		blocked   string
+		quiet     *quietHours
+		minFree   uint64
+		perMinute int
+		requests  int
+		rateLimit bool
+		want      bool
+		reply     string
+	}{
+		{name: "anyone", user: models.User{ID: 1, Username: "alice"}, want: true},
+		{name: "blocked", user: models.User{ID: 1, Username: "alice"}, blocked: "alice", reply: "not authorized"},
+		{name: "blocked by id", user: models.User{ID: 7, Username: "alice"}, blocked: "7", reply: "not authorized"},
+		{name: "not on the allowlist", user: models.User{ID: 1, Username: "bob"}, allowed: "alice", reply: "not authorized"},
+		{name: "on the allowlist", user: models.User{ID: 1, Username: "alice"}, allowed: "alice", want: true},
+		{name: "admin ignores the lists", user: models.User{ID: 1, Username: "admin"}, allowed: "alice", blocked: "admin", want: true},
+		{name: "quiet hours", user: models.User{ID: 1, Username: "alice"}, quiet: allDay, reply: "rests"},
+		{name: "admin ignores quiet hours", user: models.User{ID: 1, Username: "admin"}, quiet: allDay, want: true},
+		{name: "low disk space", user: models.User{ID: 1, Username: "alice"}, minFree: 1 << 62, reply: "disk space"},
+		{name: "within the rate limit", user: models.User{ID: 1, Username: "alice"}, perMinute: 2, requests: 2, rateLimit: true, want: true},
+		{name: "over the rate limit", user: models.User{ID: 1, Username: "alice"}, perMinute: 2, requests: 3, rateLimit: true, reply: "too much"},
+		{name: "already counted", user: models.User{ID: 1, Username: "alice"}, perMinute: 1, requests: 3, want: true},
+		{name: "admin isn't rate limited", user: models.User{ID: 1, Username: "admin"}, perMinute: 1, requests: 3, rateLimit: true, want: true},
+	}
+
+	for _, tt := range tests {
+		b := newTestBot(t)
+		allowedUsers.Set(tt.allowed)
+		blockedUsers.Set(tt.blocked)
+		quietPeriod = tt.quiet
+		minFreeDiskSpace = tt.minFree
+		requestLimiter = nil
+		if tt.perMinute > 0 {
+			requestLimiter = newUserLimiter(tt.perMinute)
+		}
+
+		user := tt.user
+		msg := &models.Message{From: &user, Chat: models.Chat{ID: 1}}
+
+		requests := tt.requests
+		if requests == 0 {
+			requests = 1
+		}
+		got := true
+		for i := 0; i < requests; i++ {
+			got = downloadAllowed(context.Background(), b.Bot, msg, tt.rateLimit)
+		}
+
+		if got != tt.want {
+			t.Errorf("%s: downloadAllowed = %v, want %v", tt.name, got, tt.want)
+		}
+		texts := b.sentTexts()
+		switch {
+		case tt.want && len(texts) > 0:
+			t.Errorf("%s: sent %q to an allowed user", tt.name, texts)
+		case !tt.want && (len(texts) != 1 || !strings.Contains(texts[0], tt.reply)):
+			t.Errorf("%s: sent %q, want one message containing %q", tt.name, texts, tt.reply)
+		}
+	}
+}
+
+func TestStatsHandlerDates(t *testing.T) {
+	oldAdmin := adminUsername
+	defer func() { adminUsername = oldAdmin }()
+	adminUsername = "admin"
+
+	tests := []struct {
+		text      string
+		reply     string
+		parseMode string
+	}{
+		{"/stats 2024-03", `*2024\-03:* V:`, "MarkdownV2"},
+		{"/stats 2024-03-01", `*2024\-03\-01:* V:`, "MarkdownV2"},
+		{"/stats 2024-01 2024-02", `*2024\-01 2024\-02:* V:`, "MarkdownV2"},
+		{"/stats 2999-01", "date '2999-01' is in the future. Usage: /stats", ""},
+		{"/stats march", "invalid date 'march'", ""},
+	}
+
+	for _, tt := range tests {
+		b := newTestBot(t)
+		update := &models.Update{Message: &models.Message{
+			Text: tt.text,
+			From: &models.User{ID: 1, Username: "admin"},
+			Chat: models.Chat{ID: 1},
+		}}
+
+		statsHandler(context.Background(), b.Bot, update)
+
+		calls := b.calls("sendMessage")
+		if len(calls) != 1 || !strings.HasPrefix(calls[0].fields["text"], tt.reply) {
+			t.Errorf("%q: sent %q, want one message starting with %q", tt.text, b.sentTexts(), tt.reply)
+			continue
+		}
+		if got := calls[0].fields["parse_mode"]; got != tt.parseMode {
+			t.Errorf("%q: parse mode %q, want %q", tt.text, got, tt.parseMode)
+		}
+	}
+}
+
+func TestExportHandler(t *testing.T) {
+	oldAdmin, oldTmp := adminUsername, tmpDir
+	defer func() { adminUsername, tmpDir = oldAdmin, oldTmp }()
+	adminUsername = "admin"
+	tmpDir = t.TempDir()
+
+	tests := []struct {
+		username  string
+		documents int
+		reply     string
+	}{
+		{"admin", 1, ""},
+		{"alice", 0, "not authorized"},
+	}
+
+	for _, tt := range tests {
+		b := newTestBot(t)
+		update := &models.Update{Message: &models.Message{
+			Text: "/export",
+			From: &models.User{ID: 1, Username: tt.username},
+			Chat: models.Chat{ID: 1},
+		}}
+
+		exportHandler(context.Background(), b.Bot, update)
+
+		if n := len(b.calls("sendDocument")); n != tt.documents {
+			t.Errorf("%s: sent %d documents, want %d", tt.username, n, tt.documents)
+		}
+		texts := b.sentTexts()
+		if tt.reply != "" && (len(texts) == 0 || !strings.Contains(texts[0], tt.reply)) {
+			t.Errorf("%s: sent %q, want %q", tt.username, texts, tt.reply)
+		}
+
+		if entries, _ := os.ReadDir(tmpDir); len(entries) != 0 {
+			t.Errorf("%s: export file left in the temp directory", tt.username)
+		}
+	}
+}
+
+func TestStatsMessagesEscaping(t *testing.T) {
+	st := &stats.Stats{
+		VideoRequests:  map[string]int{"john_doe.1": 2},
+		AudioRequests:  map[string]int{},
+		DownloadErrors: map[string]int{},
+		FilesTooLarge:  map[string]int{},
+	}
+
+	tests := []struct {
+		title string
+		want  []string
+	}{
+		{"2024-03", []string{"*2024\\-03:* V:`2`", "*Detailed Stats \\- 2024\\-03*", "@john\\_doe\\.1: V:`2`"}},
+		{"Daily (UTC)", []string{"*Daily \\(UTC\\):*", "*Detailed Stats \\- Daily \\(UTC\\)*"}},
+	}
+
+	for _, tt := range tests {
+		msg := periodStatsMessage(tt.title, st)
+		for _, want := range tt.want {
+			if !strings.Contains(msg, want) {
+				t.Errorf("periodStatsMessage(%q) = %q, want it to contain %q", tt.title, msg, want)
+			}
+		}
+	}
+}
+
+func TestHandleDownloadInvalidInput(t *testing.T) {
+	oldQuiet, oldLimiter := quietPeriod, requestLimiter
+	// no admin chat, so only the replies to the user are sent
+	oldAdminChat := adminChatID.Swap(0)
+	defer func() {
+		quietPeriod, requestLimiter = oldQuiet, oldLimiter
+		adminChatID.Store(oldAdminChat)
+	}()
+
+	tests := []struct {
+		name  string
+		quiet *quietHours
+	}{
+		{"rate limited", nil},
+		{"quiet hours", &quietHours{start: 0, end: 24 * 60}},
+	}
+
+	for _, tt := range tests {
+		quietPeriod = tt.quiet
+		requestLimiter = newUserLimiter(1)
+		b := newTestBot(t)
+		user := models.User{ID: 1, Username: "alice"}
+
+		for _, text := range []string{"hello", "how are you?", "not a link"} {
+			update := &models.Update{Message: &models.Message{Text: text, From: &user, Chat: models.Chat{ID: 1}}}
+			handleDownload(context.Background(), b.Bot, update, text, DownloadOptions{}, "")
+		}
+
+		texts := b.sentTexts()
+		if len(texts) != 3 {
+			t.Errorf("%s: sent %q, want a reply to each message", tt.name, texts)
+		}
+		for _, text := range texts {
+			if !strings.Contains(text, "valid video or audio link") {
+				t.Errorf("%s: replied %q to chat text", tt.name, text)
+			}
+		}
+		if ok, _ := requestLimiter.Allow(user.ID, time.Now()); !ok {
+			t.Errorf("%s: chat text used up the rate limit", tt.name)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// metadataCache keeps recently fetched metadata, so features that describe
+// the same URL shortly after each other run yt-dlp once. Entries are shared
+// between requests and must not be modified.
+type metadataCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]metadataCacheEntry
+}
+
+type metadataCacheEntry struct {
+	meta    *Metadata
+	expires time.Time
+}
+
+// metaCache is nil when METADATA_CACHE_SECONDS is 0.
+var metaCache *metadataCache
+
+func newMetadataCache(ttl time.Duration, maxEntries int) *metadataCache {
+	return &metadataCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]metadataCacheEntry),
+	}
+}
+
+// metadataCacheKey includes the cookies file, since cookies can change what
+// a site returns.
+func metadataCacheKey(mediaUrl string, cookiesFile string) string {
+	return cookiesFile + "\x00" + mediaUrl
+}
+
+// Get returns the cached metadata for key if it hasn't expired.
+func (c *metadataCache) Get(key string, now time.Time) (*Metadata, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !now.Before(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.meta, true
+}
+
+// Put stores meta under key. When the cache is full, expired entries are
+// dropped first and then the one closest to expiring.
+func (c *metadataCache) Put(key string, meta *Metadata, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		c.evict(now)
+	}
+	c.entries[key] = metadataCacheEntry{meta: meta, expires: now.Add(c.ttl)}
+}
+
+func (c *metadataCache) evict(now time.Time) {
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+			continue
+		}
+		if oldestKey == "" || entry.expires.Before(oldest) {
+			oldestKey, oldest = key, entry.expires
+		}
+	}
+
+	if len(c.entries) >= c.maxEntries && oldestKey != "" {
+		delete(c.entries, oldestKey)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetadataCacheGet(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	c := newMetadataCache(time.Minute, 10)
+	meta := &Metadata{Title: "Cats"}
+	c.Put("a", meta, now)
+
+	tests := []struct {
+		key   string
+		after time.Duration
+		found bool
+	}{
+		{"a", 0, true},
+		{"a", 59 * time.Second, true},
+		{"b", 0, false},
+		{"a", time.Minute, false},
+		{"a", 0, false}, // dropped when it was found expired
+	}
+
+	for _, tt := range tests {
+		got, ok := c.Get(tt.key, now.Add(tt.after))
+		if ok != tt.found || ok && got != meta {
+			t.Errorf("Get(%q) after %s = %v, %v, want found %v", tt.key, tt.after, got, ok, tt.found)
+		}
+	}
+}
+
+func TestMetadataCacheEviction(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		puts []string
+		at   []time.Duration
+		size int
+		kept []string
+		gone []string
+	}{
+		{"under the limit", []string{"a", "b"}, []time.Duration{0, 0}, 2, []string{"a", "b"}, nil},
+		{"closest to expiring dropped", []string{"a", "b", "c", "d"}, []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Second}, 3, []string{"b", "c", "d"}, []string{"a"}},
+		{"all expired dropped", []string{"a", "b", "c", "d"}, []time.Duration{0, time.Second, 50 * time.Second, 62 * time.Second}, 2, []string{"c", "d"}, []string{"a", "b"}},
+		{"existing key replaced", []string{"a", "b", "c", "a"}, []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Second}, 3, []string{"a", "b", "c"}, nil},
+	}
+
+	for _, tt := range tests {
+		c := newMetadataCache(time.Minute, 3)
+		for i, key := range tt.puts {
+			c.Put(key, &Metadata{Title: key}, now.Add(tt.at[i]))
+		}
+
+		if n := len(c.entries); n != tt.size {
+			t.Errorf("%s: %d entries, want %d", tt.name, n, tt.size)
+		}
+
+		last := now.Add(tt.at[len(tt.at)-1])
+		for _, key := range tt.kept {
+			if _, ok := c.Get(key, last); !ok {
+				t.Errorf("%s: %q evicted", tt.name, key)
+			}
+		}
+		for _, key := range tt.gone {
+			if _, ok := c.Get(key, last); ok {
+				t.Errorf("%s: %q kept", tt.name, key)
+			}
+		}
+	}
+}
+
+func TestFetchMetadataCached(t *testing.T) {
+	oldCache := metaCache
+	defer func() { metaCache = oldCache }()
+
+	calls := filepath.Join(t.TempDir(), "calls")
+	fakeCommand(t, "yt-dlp", `echo call >> `+calls+`; echo '{"title": "Cats"}'`)
+
+	tests := []struct {
+		cache   *metadataCache
+		fetches []string
+		calls   int
+	}{
+		{nil, []string{"", ""}, 2},
+		{newMetadataCache(time.Minute, 10), []string{"", ""}, 1},
+		{newMetadataCache(time.Minute, 10), []string{"", "cookies.txt"}, 2},
+	}
+
+	for _, tt := range tests {
+		metaCache = tt.cache
+		os.Remove(calls)
+
+		for _, cookies := range tt.fetches {
+			meta, err := FetchMetadata(context.Background(), "https://example.com/v", "test", cookies)
+			if err != nil || meta.Title != "Cats" {
+				t.Fatalf("FetchMetadata = %+v, %v", meta, err)
+			}
+		}
+
+		data, _ := os.ReadFile(calls)
+		if n := strings.Count(string(data), "call"); n != tt.calls {
+			t.Errorf("cache %v, cookies %q: yt-dlp ran %d times, want %d", tt.cache != nil, tt.fetches, n, tt.calls)
+		}
+	}
+}
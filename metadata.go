@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Metadata is the part of yt-dlp's JSON description of a URL that the bot
+// uses before downloading anything.
+type Metadata struct {
+	ID         string           `json:"id"`
+	Title      string           `json:"title"`
+	Uploader   string           `json:"uploader"`
+	Duration   float64          `json:"duration"`
+	Ext        string           `json:"ext"`
+	VCodec     string           `json:"vcodec"`
+	ACodec     string           `json:"acodec"`
+	URL        string           `json:"url"`
+	WebpageURL string           `json:"webpage_url"`
+	Formats    []MetadataFormat `json:"formats"`
+	Chapters   []Chapter        `json:"chapters"`
+	Entries    []Metadata       `json:"entries"`
+
+	// LiveStatus is "is_live", "is_upcoming", "was_live", "post_live" or
+	// "not_live". Older extractors only set IsLive.
+	IsLive     bool   `json:"is_live"`
+	LiveStatus string `json:"live_status"`
+}
+
+type MetadataFormat struct {
+	FormatID string `json:"format_id"`
+	Ext      string `json:"ext"`
+	VCodec   string `json:"vcodec"`
+	ACodec   string `json:"acodec"`
+}
+
+// FetchMetadata asks yt-dlp to describe mediaUrl without downloading it.
+// Recent results come from the metadata cache.
+func FetchMetadata(ctx context.Context, mediaUrl string, user string, cookiesFile string) (*Metadata, error) {
+	if metaCache == nil {
+		return fetchMetadata(ctx, mediaUrl, user, cookiesFile)
+	}
+
+	key := metadataCacheKey(mediaUrl, cookiesFile)
+	if meta, ok := metaCache.Get(key, time.Now()); ok {
+		log.Printf("[%s]: using cached metadata for %s", user, mediaUrl)
+		return meta, nil
+	}
+
+	meta, err := fetchMetadata(ctx, mediaUrl, user, cookiesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	metaCache.Put(key, meta, time.Now())
+	return meta, nil
+}
+
+func fetchMetadata(ctx context.Context, mediaUrl string, user string, cookiesFile string) (*Metadata, error) {
+	cmdSlice := []string{
+		"yt-dlp",
+		"--dump-json",
+		"--no-download",
+		"--no-playlist",
+		mediaUrl,
+	}
+
+	if cookiesFile != "" {
+		cmdSlice = append(cmdSlice, "--cookies", cookiesFile)
+	}
+
+	out, err := runCommand(ctx, user, cmdSlice)
+	if err != nil {
+		return nil, fmt.Errorf("metadata fetch failed with %s", err)
+	}
+
+	var meta Metadata
+	if err := json.Unmarshal(out, &meta); err != nil {
+		return nil, fmt.Errorf("error parsing metadata: %s", err)
+	}
+
+	return &meta, nil
+}
+
+// liveStatusMessage returns why a live stream can't be downloaded, or ""
+// if it can. Streams that have ended are ordinary videos.
+func (meta *Metadata) liveStatusMessage() string {
+	switch {
+	case meta == nil:
+		return ""
+	case meta.LiveStatus == "is_upcoming":
+		return "This live stream hasn't started yet. Send the link again once it has ended."
+	case meta.LiveStatus == "is_live" || (meta.LiveStatus == "" && meta.IsLive):
+		return "I can't download ongoing live streams. Send the link again once it has ended."
+	default:
+		return ""
+	}
+}
+
+// isMP4Compatible reports whether the format yt-dlp selected is already an
+// mp4 with H.264 video and AAC audio, which needs no recoding.
+func (meta *Metadata) isMP4Compatible() bool {
+	if meta == nil || meta.Ext != "mp4" {
+		return false
+	}
+	if !strings.HasPrefix(meta.VCodec, "avc1") && !strings.HasPrefix(meta.VCodec, "h264") {
+		return false
+	}
+	return meta.ACodec == "none" || strings.HasPrefix(meta.ACodec, "mp4a") || strings.HasPrefix(meta.ACodec, "aac")
+}
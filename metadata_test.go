@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeCommand puts an executable shell script called name first in PATH
+// for the rest of the test.
+func fakeCommand(t *testing.T, name string, script string) {
+	t.Helper()
+
+	dir := filepath.Join(t.TempDir(), "bin")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestFetchMetadata(t *testing.T) {
+	oldCache := metaCache
+	defer func() { metaCache = oldCache }()
+	metaCache = nil
+
+	args := filepath.Join(t.TempDir(), "args")
+	fakeCommand(t, "yt-dlp", `echo "$@" > `+args+`
+echo '{"id": "abc", "title": "Cats", "duration": 61.5, "ext": "mp4"}'`)
+
+	meta, err := FetchMetadata(context.Background(), "https://example.com/v", "test", "/app/cookies.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ID != "abc" || meta.Title != "Cats" || meta.Duration != 61.5 || meta.Ext != "mp4" {
+		t.Errorf("FetchMetadata = %+v", meta)
+	}
+
+	got, err := os.ReadFile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "--dump-json --no-download --no-playlist https://example.com/v --cookies /app/cookies.txt\n"; string(got) != want {
+		t.Errorf("yt-dlp args = %q, want %q", got, want)
+	}
+}
+
+func TestFetchMetadataErrors(t *testing.T) {
+	oldCache := metaCache
+	defer func() { metaCache = oldCache }()
+	metaCache = nil
+
+	fakeCommand(t, "yt-dlp", "echo 'ERROR: Unsupported URL' >&2; exit 1")
+	if _, err := FetchMetadata(context.Background(), "https://example.com/v", "test", ""); err == nil {
+		t.Error("FetchMetadata succeeded when yt-dlp failed")
+	}
+
+	fakeCommand(t, "yt-dlp", "echo 'not json'")
+	if _, err := FetchMetadata(context.Background(), "https://example.com/v", "test", ""); err == nil {
+		t.Error("FetchMetadata succeeded with invalid JSON")
+	}
+}
+
+func TestMetadataIsMP4Compatible(t *testing.T) {
+	tests := []struct {
+		name string
+		meta *Metadata
+		want bool
+	}{
+		{"h264 and aac", &Metadata{Ext: "mp4", VCodec: "avc1.64001F", ACodec: "mp4a.40.2"}, true},
+		{"h264 named as such", &Metadata{Ext: "mp4", VCodec: "h264", ACodec: "aac"}, true},
+		{"no audio", &Metadata{Ext: "mp4", VCodec: "avc1.4d401e", ACodec: "none"}, true},
+		{"vp9", &Metadata{Ext: "mp4", VCodec: "vp09.00.40.08", ACodec: "mp4a.40.2"}, false},
+		{"av1", &Metadata{Ext: "mp4", VCodec: "av01.0.08M.08", ACodec: "mp4a.40.2"}, false},
+		{"opus audio", &Metadata{Ext: "mp4", VCodec: "avc1.64001F", ACodec: "opus"}, false},
+		{"webm", &Metadata{Ext: "webm", VCodec: "avc1.64001F", ACodec: "mp4a.40.2"}, false},
+		{"unknown codecs", &Metadata{Ext: "mp4"}, false},
+		{"no metadata", nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.meta.isMP4Compatible(); got != tt.want {
+			t.Errorf("%s: isMP4Compatible() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
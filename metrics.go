@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics, served on /metrics by promhttp.
+var (
+	videoRequestsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "markodownloadbot_video_requests_total",
+		Help: "Video download requests.",
+	})
+	audioRequestsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "markodownloadbot_audio_requests_total",
+		Help: "Audio download requests.",
+	})
+	downloadErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "markodownloadbot_download_errors_total",
+		Help: "Requests that failed to download or send.",
+	})
+	conversionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "markodownloadbot_conversions_total",
+		Help: "Videos converted with ffmpeg.",
+	})
+	downloadDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "markodownloadbot_download_duration_seconds",
+		Help:    "Time yt-dlp took per download, without waiting for a slot.",
+		Buckets: []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200, 1800},
+	})
+)
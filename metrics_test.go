@@ -0,0 +1,119 @@
+package main
+
+import (
+	"math"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// scrapeMetrics parses what /metrics serves.
+func scrapeMetrics(t *testing.T) map[string]*dto.MetricFamily {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(rec.Body)
+	if err != nil {
+		t.Fatalf("parsing /metrics: %s", err)
+	}
+	return families
+}
+
+func TestMetricsCounters(t *testing.T) {
+	counters := []struct {
+		name string
+		inc  func()
+	}{
+		{"markodownloadbot_video_requests_total", videoRequestsTotal.Inc},
+		{"markodownloadbot_audio_requests_total", audioRequestsTotal.Inc},
+		{"markodownloadbot_download_errors_total", downloadErrorsTotal.Inc},
+		{"markodownloadbot_conversions_total", conversionsTotal.Inc},
+	}
+
+	before := scrapeMetrics(t)
+	for i, c := range counters {
+		for n := 0; n <= i; n++ {
+			c.inc()
+		}
+	}
+	after := scrapeMetrics(t)
+
+	for i, c := range counters {
+		family, ok := after[c.name]
+		if !ok || family.GetType() != dto.MetricType_COUNTER || family.GetHelp() == "" {
+			t.Errorf("%s: family %v, want a counter with help", c.name, family)
+			continue
+		}
+		got := family.GetMetric()[0].GetCounter().GetValue() - before[c.name].GetMetric()[0].GetCounter().GetValue()
+		if got != float64(i+1) {
+			t.Errorf("%s went up by %v, want %d", c.name, got, i+1)
+		}
+	}
+}
+
+func TestMetricsDownloadDuration(t *testing.T) {
+	const name = "markodownloadbot_download_duration_seconds"
+	histogram := func(families map[string]*dto.MetricFamily) *dto.Histogram {
+		family, ok := families[name]
+		if !ok || family.GetType() != dto.MetricType_HISTOGRAM {
+			t.Fatalf("%s: family %v, want a histogram", name, family)
+		}
+		return family.GetMetric()[0].GetHistogram()
+	}
+
+	before := histogram(scrapeMetrics(t))
+	for _, v := range []float64{0.5, 5, 45, 4000} {
+		downloadDuration.Observe(v)
+	}
+	after := histogram(scrapeMetrics(t))
+
+	if got := after.GetSampleCount() - before.GetSampleCount(); got != 4 {
+		t.Errorf("_count went up by %d, want 4", got)
+	}
+	if got := after.GetSampleSum() - before.GetSampleSum(); got != 4050.5 {
+		t.Errorf("_sum went up by %v, want 4050.5", got)
+	}
+
+	// buckets are cumulative, 4000 is only in +Inf
+	tests := []struct {
+		upper float64
+		added uint64
+	}{
+		{1, 1},
+		{5, 2},
+		{10, 2},
+		{30, 2},
+		{60, 3},
+		{1800, 3},
+		{math.Inf(1), 4},
+	}
+
+	buckets := make(map[float64]uint64)
+	for _, b := range before.GetBucket() {
+		buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+	}
+	if len(after.GetBucket()) != 11 {
+		t.Errorf("%d buckets, want 10 and +Inf", len(after.GetBucket()))
+	}
+	for _, tt := range tests {
+		found := false
+		for _, b := range after.GetBucket() {
+			if b.GetUpperBound() != tt.upper {
+				continue
+			}
+			found = true
+			if got := b.GetCumulativeCount() - buckets[tt.upper]; got != tt.added {
+				t.Errorf("bucket le=%v went up by %d, want %d", tt.upper, got, tt.added)
+			}
+		}
+		if !found {
+			t.Errorf("no bucket le=%v", tt.upper)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// parsePasswordArgs splits the arguments of /pw into the password and the
+// link.
+func parsePasswordArgs(args string) (password string, input string, ok bool) {
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		return "", "", false
+	}
+	return fields[0], fields[1], true
+}
+
+// passwordHandler downloads a password-protected video. The password is
+// only passed to yt-dlp for this request: it isn't saved, logged or shown
+// to the admin, and the message containing it is deleted when possible.
+func passwordHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		log.Println("Received pw command with nil Message")
+		return
+	}
+
+	password, input, ok := parsePasswordArgs(strings.TrimPrefix(update.Message.Text, "/pw"))
+	if !ok {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   "Usage: /pw <password> <link>",
+		})
+		return
+	}
+
+	deleted, err := b.DeleteMessage(ctx, &bot.DeleteMessageParams{
+		ChatID:    update.Message.Chat.ID,
+		MessageID: update.Message.ID,
+	})
+	if err != nil {
+		log.Printf("[%s]: couldn't delete the message with the password: %s", update.Message.From.Username, err)
+	}
+
+	// handleDownload logs the message text, so it gets one without the password
+	msg := *update.Message
+	msg.Text = "/pw *** " + input
+	if deleted {
+		msg.ID = 0
+	}
+	redacted := *update
+	redacted.Message = &msg
+
+	handleDownload(ctx, b, &redacted, input, DownloadOptions{VideoPassword: password}, "")
+}
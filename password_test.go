@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestParsePasswordArgs(t *testing.T) {
+	tests := []struct {
+		args     string
+		password string
+		input    string
+		ok       bool
+	}{
+		{" secret https://vimeo.com/1", "secret", "https://vimeo.com/1", true},
+		{"  secret   https://vimeo.com/1  ", "secret", "https://vimeo.com/1", true},
+		{"", "", "", false},
+		{" https://vimeo.com/1", "", "", false},
+		{" two words https://vimeo.com/1", "", "", false},
+	}
+
+	for _, tt := range tests {
+		password, input, ok := parsePasswordArgs(tt.args)
+		if password != tt.password || input != tt.input || ok != tt.ok {
+			t.Errorf("parsePasswordArgs(%q) = %q, %q, %v, want %q, %q, %v", tt.args, password, input, ok, tt.password, tt.input, tt.ok)
+		}
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"yt-dlp", "https://vimeo.com/1"}, "yt-dlp https://vimeo.com/1"},
+		{[]string{"yt-dlp", "--video-password", "secret", "https://vimeo.com/1"}, "yt-dlp --video-password *** https://vimeo.com/1"},
+		{[]string{"yt-dlp", "--video-password"}, "yt-dlp --video-password"},
+	}
+
+	for _, tt := range tests {
+		original := strings.Join(tt.args, " ")
+		if got := strings.Join(redactArgs(tt.args), " "); got != tt.want {
+			t.Errorf("redactArgs(%q) = %q, want %q", original, got, tt.want)
+		}
+		if strings.Join(tt.args, " ") != original {
+			t.Errorf("redactArgs changed its argument to %q", tt.args)
+		}
+	}
+}
+
+func TestMediaGetCommandStringPassword(t *testing.T) {
+	u, _ := url.Parse("https://vimeo.com/1")
+	media := &Media{url: u.String(), parsedUrl: u, tmpDir: "/tmp", randomName: "abc", password: "secret"}
+
+	got := " " + strings.Join(media.getCommandString(), " ") + " "
+	if !strings.Contains(got, " --video-password secret ") {
+		t.Errorf("getCommandString() = %q, want the password", got)
+	}
+}
+
+func TestPasswordHandlerUsage(t *testing.T) {
+	b := newTestBot(t)
+	update := &models.Update{Message: &models.Message{
+		Text: "/pw https://vimeo.com/1",
+		From: &models.User{ID: 1, Username: "alice"},
+		Chat: models.Chat{ID: 1},
+	}}
+
+	passwordHandler(context.Background(), b.Bot, update)
+
+	if texts := b.sentTexts(); len(texts) != 1 || texts[0] != "Usage: /pw <password> <link>" {
+		t.Errorf("sent %q, want the usage", texts)
+	}
+	if n := len(b.calls("deleteMessage")); n != 0 {
+		t.Errorf("deleted %d messages without a password", n)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// maxDownloadsExitCode is yt-dlp's exit code when --max-downloads stopped
+// it, which is not a failure.
+const maxDownloadsExitCode = 101
+
+// errNoNewItems is returned when every item of a playlist is already in the
+// user's download archive.
+var errNoNewItems = errors.New("no new items in this playlist")
+
+// isPlaylistURL reports whether u points at a playlist rather than a single
+// item.
+func isPlaylistURL(u *url.URL) bool {
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	switch {
+	case host == "youtube.com" || host == "m.youtube.com" || host == "music.youtube.com":
+		return u.Path == "/playlist" && u.Query().Get("list") != ""
+	case host == "soundcloud.com":
+		return strings.Contains(u.Path, "/sets/")
+	default:
+		return false
+	}
+}
+
+// isPlaylistInput is isPlaylistURL for a link that may not parse.
+func isPlaylistInput(input string) bool {
+	u, err := url.Parse(input)
+	return err == nil && isPlaylistURL(u)
+}
+
+// downloadArchivePath returns the yt-dlp archive file of user, which lists
+// the playlist items already sent to them.
+func downloadArchivePath(dir string, user string) string {
+	name := archiveName(user, "unknown")
+	return filepath.Join(dir, name+".txt")
+}
+
+// usesDownloadArchive reports whether this download skips playlist items
+// the user already got.
+func (media *Media) usesDownloadArchive() bool {
+	return downloadArchiveDir != "" && media.parsedUrl != nil && isPlaylistURL(media.parsedUrl)
+}
+
+// isMaxDownloadsExit reports whether err is yt-dlp stopping at the
+// --max-downloads limit.
+func isMaxDownloadsExit(err error) bool {
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr) && exitErr.ExitCode() == maxDownloadsExitCode
+}
+
+// noNewItems reports whether a playlist download with the archive finished
+// without producing a file, because all items were already fetched.
+func (media *Media) noNewItems() bool {
+	if !media.usesDownloadArchive() {
+		return false
+	}
+	_, err := os.Stat(media.Path)
+	return os.IsNotExist(err)
+}
+
+// playlistListing is the part of yt-dlp's flat playlist JSON the bot uses.
+type playlistListing struct {
+	Title   string `json:"title"`
+	Count   int    `json:"playlist_count"`
+	Entries []struct {
+		URL        string `json:"url"`
+		WebpageURL string `json:"webpage_url"`
+	} `json:"entries"`
+}
+
+// listPlaylist returns the links of the first limit items of the playlist
+// at mediaUrl, its title and how many items it has in total. Only the
+// listing is fetched, not the items themselves.
+func listPlaylist(ctx context.Context, mediaUrl string, user string, cookiesFile string, limit int) ([]string, string, int, error) {
+	cmdSlice := []string{
+		"yt-dlp",
+		"--flat-playlist",
+		"--yes-playlist",
+		"--dump-single-json",
+		"--playlist-items", "1-" + strconv.Itoa(limit),
+		mediaUrl,
+	}
+	if cookiesFile != "" {
+		cmdSlice = append(cmdSlice, "--cookies", cookiesFile)
+	}
+
+	out, err := runCommand(ctx, user, cmdSlice)
+	if err != nil {
+		return nil, "", 0, newDownloadError(err)
+	}
+
+	var listing playlistListing
+	if err := json.Unmarshal(out, &listing); err != nil {
+		return nil, "", 0, fmt.Errorf("error parsing playlist: %s", err)
+	}
+
+	var urls []string
+	for _, entry := range listing.Entries {
+		link := entry.WebpageURL
+		if link == "" {
+			link = entry.URL
+		}
+		if link != "" {
+			urls = append(urls, link)
+		}
+	}
+
+	total := listing.Count
+	if total < len(urls) {
+		total = len(urls)
+	}
+	return urls, listing.Title, total, nil
+}
+
+// downloadPlaylist sends the first PLAYLIST_MAX_ITEMS items of a playlist
+// one by one, each handled like a link of its own, and then tells the user
+// how many were sent.
+func downloadPlaylist(ctx context.Context, b *bot.Bot, update *models.Update, input string, opts DownloadOptions) {
+	username := update.Message.From.Username
+
+	urls, title, total, err := listPlaylist(ctx, input, username, defaultCookiesFile(), playlistMaxItems)
+	if err == nil && len(urls) == 0 {
+		err = fmt.Errorf("the playlist is empty")
+	}
+	if err != nil {
+		log.Printf("[%s]: error listing playlist: %s", username, err)
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: update.Message.Chat.ID,
+			Text:   userErrorMessage(userErrorDetail, err, "I couldn't get the items of this playlist."),
+		})
+		return
+	}
+
+	log.Printf("[%s]: playlist '%s' with %d items, downloading %d", username, title, total, len(urls))
+
+	text := fmt.Sprintf("This playlist has %d items, I'll send them one by one.", total)
+	if len(urls) < total {
+		text = fmt.Sprintf("This playlist has %d items, I'll send the first %d one by one.", total, len(urls))
+	}
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:          update.Message.Chat.ID,
+		Text:            text,
+		ReplyParameters: replyParameters(update.Message),
+	})
+
+	sent := 0
+	opts.playlistItem = true
+	opts.OnDelivered = func() { sent++ }
+
+	for _, u := range urls {
+		if ctx.Err() != nil {
+			return
+		}
+		handleDownload(ctx, b, update, u, opts, "")
+	}
+
+	summary := fmt.Sprintf("Sent %d of %d items.", sent, total)
+	if len(urls) < total {
+		summary = fmt.Sprintf("Sent %d of %d items (capped at %d).", sent, total, playlistMaxItems)
+	}
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:          update.Message.Chat.ID,
+		Text:            summary,
+		ReplyParameters: replyParameters(update.Message),
+	})
+
+	if deleteUserMessage && sent > 0 {
+		deleteRequestMessage(ctx, b, update.Message)
+	}
+}
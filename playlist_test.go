@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsPlaylistURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://www.youtube.com/playlist?list=PL123", true},
+		{"https://music.youtube.com/playlist?list=PL123", true},
+		{"https://m.youtube.com/playlist?list=PL123", true},
+		{"https://www.youtube.com/playlist", false},
+		{"https://www.youtube.com/watch?v=abc&list=PL123", false},
+		{"https://soundcloud.com/artist/sets/album", true},
+		{"https://soundcloud.com/artist/track", false},
+		{"https://example.com/playlist?list=PL123", false},
+	}
+
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := isPlaylistURL(u); got != tt.want {
+			t.Errorf("isPlaylistURL(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadArchivePath(t *testing.T) {
+	tests := []struct {
+		user string
+		want string
+	}{
+		{"alice", "/archives/alice.txt"},
+		{"", "/archives/unknown.txt"},
+		{"../bob", "/archives/_bob.txt"},
+	}
+
+	for _, tt := range tests {
+		if got := downloadArchivePath("/archives", tt.user); got != tt.want {
+			t.Errorf("downloadArchivePath(%q) = %q, want %q", tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestIsMaxDownloadsExit(t *testing.T) {
+	tests := []struct {
+		script string
+		want   bool
+	}{
+		{"exit 101", true},
+		{"exit 1", false},
+	}
+
+	for _, tt := range tests {
+		fakeCommand(t, "yt-dlp", tt.script)
+		_, err := runCommand(context.Background(), "test", []string{"yt-dlp"})
+		if got := isMaxDownloadsExit(err); got != tt.want {
+			t.Errorf("%q: isMaxDownloadsExit(%v) = %v, want %v", tt.script, err, got, tt.want)
+		}
+	}
+
+	if isMaxDownloadsExit(errors.New("exit status 101")) {
+		t.Error("isMaxDownloadsExit is true for an error without an exit code")
+	}
+}
+
+func TestMediaDownloadArchive(t *testing.T) {
+	oldDir := downloadArchiveDir
+	defer func() { downloadArchiveDir = oldDir }()
+
+	dir := t.TempDir()
+	tests := []struct {
+		name       string
+		archiveDir string
+		url        string
+		file       bool
+		uses       bool
+		noNewItems bool
+	}{
+		{"disabled", "", "https://www.youtube.com/playlist?list=PL123", false, false, false},
+		{"single video", dir, "https://www.youtube.com/watch?v=abc", false, false, false},
+		{"new item", dir, "https://www.youtube.com/playlist?list=PL123", true, true, false},
+		{"nothing new", dir, "https://www.youtube.com/playlist?list=PL123", false, true, true},
+	}
+
+	for _, tt := range tests {
+		downloadArchiveDir = tt.archiveDir
+		u, _ := url.Parse(tt.url)
+		media := &Media{user: "alice", url: tt.url, parsedUrl: u, tmpDir: dir, randomName: "abc", Path: filepath.Join(dir, "abc.mp4")}
+		os.Remove(media.Path)
+		if tt.file {
+			if err := os.WriteFile(media.Path, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if got := media.usesDownloadArchive(); got != tt.uses {
+			t.Errorf("%s: usesDownloadArchive() = %v, want %v", tt.name, got, tt.uses)
+		}
+		if got := media.noNewItems(); got != tt.noNewItems {
+			t.Errorf("%s: noNewItems() = %v, want %v", tt.name, got, tt.noNewItems)
+		}
+
+		args := " " + strings.Join(media.getCommandString(), " ") + " "
+		want := " --download-archive " + filepath.Join(dir, "alice.txt") + " --max-downloads 1 "
+		if got := strings.Contains(args, want); got != tt.uses {
+			t.Errorf("%s: getCommandString() = %q, archive arguments %v, want %v", tt.name, args, got, tt.uses)
+		}
+	}
+}
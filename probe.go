@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type FFProbeOutput struct {
+	Streams []FFProbeStream `json:"streams"`
+	Format  FFProbeFormat   `json:"format"`
+}
+
+type FFProbeStream struct {
+	Index      int    `json:"index"`
+	CodecName  string `json:"codec_name"`
+	CodecType  string `json:"codec_type"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	Duration   string `json:"duration"`
+	FieldOrder string `json:"field_order"`
+	RFrameRate string `json:"r_frame_rate"`
+
+	Tags         map[string]string `json:"tags"`
+	Disposition  map[string]int    `json:"disposition"`
+	SideDataList []FFProbeSideData `json:"side_data_list"`
+}
+
+type FFProbeSideData struct {
+	SideDataType string `json:"side_data_type"`
+	Rotation     int    `json:"rotation"`
+}
+
+type FFProbeFormat struct {
+	FormatName string `json:"format_name"`
+	Duration   string `json:"duration"`
+	Size       string `json:"size"`
+}
+
+func runFFProbe(ctx context.Context, user string, path string) (*FFProbeOutput, error) {
+	cmdSlice := []string{
+		"ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		path,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ffmpegTimeout)
+	defer cancel()
+
+	out, err := runCommand(ctx, user, cmdSlice)
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed with %s", err)
+	}
+
+	var probe FFProbeOutput
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return nil, fmt.Errorf("error parsing ffprobe output: %s", err)
+	}
+
+	return &probe, nil
+}
+
+// bestVideoStream returns the video stream with the most pixels, or nil if
+// there is none. Cover art, which ffprobe lists as a video stream marked as
+// an attached picture, doesn't count.
+func (probe *FFProbeOutput) bestVideoStream() *FFProbeStream {
+	var best *FFProbeStream
+	for i := range probe.Streams {
+		stream := &probe.Streams[i]
+		if stream.CodecType != "video" || stream.Disposition["attached_pic"] == 1 {
+			continue
+		}
+		if best == nil || stream.Width*stream.Height > best.Width*best.Height {
+			best = stream
+		}
+	}
+	return best
+}
+
+// audioStreams returns the audio streams in file order.
+func (probe *FFProbeOutput) audioStreams() []*FFProbeStream {
+	var streams []*FFProbeStream
+	for i := range probe.Streams {
+		if probe.Streams[i].CodecType == "audio" {
+			streams = append(streams, &probe.Streams[i])
+		}
+	}
+	return streams
+}
+
+// selectBestAudioStream picks the first audio stream in lang, a base
+// language like "de". Without lang or a match it picks the stream marked
+// as default, or else the first one. It returns nil if there is no audio.
+func (probe *FFProbeOutput) selectBestAudioStream(lang string) *FFProbeStream {
+	streams := probe.audioStreams()
+	if len(streams) == 0 {
+		return nil
+	}
+
+	for _, stream := range streams {
+		if languageMatches(stream.Tags["language"], lang) {
+			return stream
+		}
+	}
+
+	for _, stream := range streams {
+		if stream.Disposition["default"] == 1 {
+			return stream
+		}
+	}
+	return streams[0]
+}
+
+// isInterlaced reports whether an ffprobe field_order value describes
+// interlaced video. "progressive", "unknown" and an empty value don't.
+func isInterlaced(fieldOrder string) bool {
+	switch fieldOrder {
+	case "tt", "bb", "tb", "bt":
+		return true
+	default:
+		return false
+	}
+}
+
+// rotation returns how many degrees clockwise the stream has to be turned
+// for display: 0, 90, 180 or 270. Newer ffmpeg reports it in the display
+// matrix side data, counterclockwise; older versions use the "rotate" tag.
+func (stream *FFProbeStream) rotation() int {
+	degrees := 0
+	if tag, ok := stream.Tags["rotate"]; ok {
+		degrees, _ = strconv.Atoi(tag)
+	} else {
+		for _, sd := range stream.SideDataList {
+			if sd.SideDataType == "Display Matrix" {
+				degrees = -sd.Rotation
+				break
+			}
+		}
+	}
+
+	degrees %= 360
+	if degrees < 0 {
+		degrees += 360
+	}
+
+	// only right angles can be represented without reencoding artifacts
+	switch degrees {
+	case 90, 180, 270:
+		return degrees
+	default:
+		return 0
+	}
+}
+
+// parseFrameRate parses ffprobe's frame rate fraction, such as
+// "60000/1001" or "30/1". It reports false for "0/0" and malformed values.
+func parseFrameRate(value string) (float64, bool) {
+	num, den, found := strings.Cut(value, "/")
+	if !found {
+		den = "1"
+	}
+
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, false
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 || n <= 0 {
+		return 0, false
+	}
+
+	return n / d, true
+}
+
+// frameRateCap returns the frame rate to convert a video of frameRate fps
+// to, or 0 when it is within maxFPS. Videos are never converted to a
+// higher frame rate.
+func frameRateCap(frameRate float64, maxFPS int) int {
+	if maxFPS <= 0 || frameRate <= 0 {
+		return 0
+	}
+	// allow for rounding, e.g. 30.001 fps isn't over 30
+	if frameRate <= float64(maxFPS)+0.01 {
+		return 0
+	}
+	return maxFPS
+}
+
+// duration returns the container duration in seconds, falling back to the
+// longest stream duration.
+func (probe *FFProbeOutput) duration() float64 {
+	if d, err := strconv.ParseFloat(probe.Format.Duration, 64); err == nil && d > 0 {
+		return d
+	}
+
+	var longest float64
+	for _, stream := range probe.Streams {
+		if d, err := strconv.ParseFloat(stream.Duration, 64); err == nil && d > longest {
+			longest = d
+		}
+	}
+	return longest
+}
+
+// isFastStart reports whether the mp4 file at path has its moov atom before
+// the media data, which lets clients start playback and seek before the
+// whole file is downloaded.
+func isFastStart(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	var offset int64
+	header := make([]byte, 16)
+
+	for {
+		if _, err := f.ReadAt(header[:8], offset); err != nil {
+			return false
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+
+		switch boxType {
+		case "moov":
+			return true
+		case "mdat":
+			return false
+		}
+
+		switch size {
+		case 0:
+			// box extends to the end of the file
+			return false
+		case 1:
+			if _, err := f.ReadAt(header[8:16], offset+8); err != nil {
+				return false
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+		}
+
+		if size < 8 {
+			return false
+		}
+		offset += size
+	}
+}
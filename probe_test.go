@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBestVideoStream(t *testing.T) {
+	cover := FFProbeStream{Index: 0, CodecType: "video", Width: 3000, Height: 3000, Disposition: map[string]int{"attached_pic": 1}}
+	small := FFProbeStream{Index: 1, CodecType: "video", Width: 640, Height: 360}
+	large := FFProbeStream{Index: 2, CodecType: "video", Width: 1920, Height: 1080}
+	audio := FFProbeStream{Index: 3, CodecType: "audio"}
+
+	tests := []struct {
+		name    string
+		streams []FFProbeStream
+		want    int
+	}{
+		{"no streams", nil, -1},
+		{"audio only", []FFProbeStream{audio}, -1},
+		{"cover art only", []FFProbeStream{cover, audio}, -1},
+		{"single video", []FFProbeStream{small, audio}, 1},
+		{"largest wins", []FFProbeStream{small, large, audio}, 2},
+		{"cover art skipped", []FFProbeStream{cover, small, audio}, 1},
+	}
+
+	for _, tt := range tests {
+		probe := &FFProbeOutput{Streams: tt.streams}
+		got := probe.bestVideoStream()
+		switch {
+		case tt.want < 0 && got != nil:
+			t.Errorf("%s: bestVideoStream() = stream %d, want none", tt.name, got.Index)
+		case tt.want >= 0 && (got == nil || got.Index != tt.want):
+			t.Errorf("%s: bestVideoStream() = %v, want stream %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProbeDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		streams []string
+		want    float64
+	}{
+		{"container", "12.5", []string{"10", "12"}, 12.5},
+		{"longest stream", "", []string{"10.5", "12.25", ""}, 12.25},
+		{"zero container", "0", []string{"3"}, 3},
+		{"unparsable container", "N/A", []string{"4"}, 4},
+		{"unknown", "", []string{"N/A"}, 0},
+		{"no streams", "", nil, 0},
+	}
+
+	for _, tt := range tests {
+		probe := &FFProbeOutput{Format: FFProbeFormat{Duration: tt.format}}
+		for _, d := range tt.streams {
+			probe.Streams = append(probe.Streams, FFProbeStream{Duration: d})
+		}
+		if got := probe.duration(); got != tt.want {
+			t.Errorf("%s: duration() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// mp4Box returns a box of the given type with size bytes of payload.
+func mp4Box(boxType string, size int) []byte {
+	box := make([]byte, 8+size)
+	binary.BigEndian.PutUint32(box, uint32(8+size))
+	copy(box[4:8], boxType)
+	return box
+}
+
+// largeMp4Box returns a box with a 64-bit size.
+func largeMp4Box(boxType string, size int) []byte {
+	box := make([]byte, 16+size)
+	binary.BigEndian.PutUint32(box, 1)
+	copy(box[4:8], boxType)
+	binary.BigEndian.PutUint64(box[8:16], uint64(16+size))
+	return box
+}
+
+func TestIsFastStart(t *testing.T) {
+	tests := []struct {
+		name  string
+		boxes [][]byte
+		want  bool
+	}{
+		{"moov first", [][]byte{mp4Box("ftyp", 16), mp4Box("moov", 32), mp4Box("mdat", 64)}, true},
+		{"mdat first", [][]byte{mp4Box("ftyp", 16), mp4Box("mdat", 64), mp4Box("moov", 32)}, false},
+		{"64-bit box before moov", [][]byte{mp4Box("ftyp", 16), largeMp4Box("free", 8), mp4Box("moov", 32)}, true},
+		{"no moov", [][]byte{mp4Box("ftyp", 16), mp4Box("free", 8)}, false},
+		{"box to the end of the file", [][]byte{mp4Box("ftyp", 16), {0, 0, 0, 0, 'f', 'r', 'e', 'e'}, mp4Box("moov", 8)}, false},
+		{"truncated", [][]byte{{0, 0}}, false},
+		{"empty", nil, false},
+	}
+
+	dir := t.TempDir()
+	for i, tt := range tests {
+		path := filepath.Join(dir, fmt.Sprintf("%d.mp4", i))
+		if err := os.WriteFile(path, bytes.Join(tt.boxes, nil), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := isFastStart(path); got != tt.want {
+			t.Errorf("%s: isFastStart = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if isFastStart(filepath.Join(dir, "missing.mp4")) {
+		t.Error("isFastStart = true for a missing file")
+	}
+}
+
+func TestIsInterlaced(t *testing.T) {
+	tests := []struct {
+		fieldOrder string
+		want       bool
+	}{
+		{"tt", true},
+		{"bb", true},
+		{"tb", true},
+		{"bt", true},
+		{"progressive", false},
+		{"unknown", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := isInterlaced(tt.fieldOrder); got != tt.want {
+			t.Errorf("isInterlaced(%q) = %v, want %v", tt.fieldOrder, got, tt.want)
+		}
+	}
+}
+
+func TestFFProbeStreamRotation(t *testing.T) {
+	displayMatrix := func(rotation int) []FFProbeSideData {
+		return []FFProbeSideData{{SideDataType: "Display Matrix", Rotation: rotation}}
+	}
+
+	tests := []struct {
+		name   string
+		stream FFProbeStream
+		want   int
+	}{
+		{"none", FFProbeStream{}, 0},
+		{"rotate tag", FFProbeStream{Tags: map[string]string{"rotate": "90"}}, 90},
+		{"rotate tag 270", FFProbeStream{Tags: map[string]string{"rotate": "270"}}, 270},
+		{"display matrix counterclockwise", FFProbeStream{SideDataList: displayMatrix(-90)}, 90},
+		{"display matrix clockwise", FFProbeStream{SideDataList: displayMatrix(90)}, 270},
+		{"display matrix upside down", FFProbeStream{SideDataList: displayMatrix(180)}, 180},
+		{"other side data", FFProbeStream{SideDataList: []FFProbeSideData{{SideDataType: "Stereo 3D", Rotation: 90}}}, 0},
+		{"tag wins over the matrix", FFProbeStream{Tags: map[string]string{"rotate": "180"}, SideDataList: displayMatrix(-90)}, 180},
+		{"full turn", FFProbeStream{Tags: map[string]string{"rotate": "360"}}, 0},
+		{"negative tag", FFProbeStream{Tags: map[string]string{"rotate": "-90"}}, 270},
+		{"not a right angle", FFProbeStream{Tags: map[string]string{"rotate": "45"}}, 0},
+		{"invalid tag", FFProbeStream{Tags: map[string]string{"rotate": "up"}}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := tt.stream.rotation(); got != tt.want {
+			t.Errorf("%s: rotation() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseFrameRate(t *testing.T) {
+	tests := []struct {
+		value string
+		want  float64
+		ok    bool
+	}{
+		{"30/1", 30, true},
+		{"60000/1001", 60000.0 / 1001, true},
+		{"25", 25, true},
+		{"0/0", 0, false},
+		{"30/0", 0, false},
+		{"", 0, false},
+		{"abc/1", 0, false},
+		{"30/x", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseFrameRate(tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseFrameRate(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFrameRateCap(t *testing.T) {
+	tests := []struct {
+		frameRate float64
+		maxFPS    int
+		want      int
+	}{
+		{60, 0, 0},
+		{60, 30, 30},
+		{59.94, 30, 30},
+		{30, 30, 0},
+		{30.001, 30, 0},
+		{29.97, 29, 29},
+		{24, 30, 0},
+		{0, 30, 0},
+	}
+
+	for _, tt := range tests {
+		if got := frameRateCap(tt.frameRate, tt.maxFPS); got != tt.want {
+			t.Errorf("frameRateCap(%v, %d) = %d, want %d", tt.frameRate, tt.maxFPS, got, tt.want)
+		}
+	}
+}
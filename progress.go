@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot"
+)
+
+// progressPattern matches yt-dlp's "[download]  42.3% of ..." lines.
+var progressPattern = regexp.MustCompile(`^\[download\]\s+(\d+(?:\.\d+)?)%`)
+
+// parseProgress returns the percentage from a yt-dlp progress line.
+func parseProgress(line string) (float64, bool) {
+	m := progressPattern.FindStringSubmatch(line)
+	if m == nil {
+		return 0, false
+	}
+	percent, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	return percent, true
+}
+
+// lineWriter collects a command's output and passes every complete line
+// to onLine as it arrives.
+type lineWriter struct {
+	out     bytes.Buffer
+	partial []byte
+	onLine  func(string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.out.Write(p)
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.onLine(string(bytes.TrimRight(w.partial[:i], "\r")))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// progressMessage shows the download progress by editing the
+// acknowledgement. Edits are at least progressInterval apart and run in
+// the background, so a slow Bot API never holds up yt-dlp's output.
+type progressMessage struct {
+	ctx       context.Context
+	b         *bot.Bot
+	chatID    int64
+	messageID int
+	text      string
+
+	mu       sync.Mutex
+	last     time.Time
+	lastText string
+	finished bool
+}
+
+func newProgressMessage(ctx context.Context, b *bot.Bot, chatID int64, messageID int, text string) *progressMessage {
+	return &progressMessage{ctx: ctx, b: b, chatID: chatID, messageID: messageID, text: text}
+}
+
+// Update shows percent, unless the last edit was too recent.
+func (p *progressMessage) Update(percent float64) {
+	p.mu.Lock()
+	now := time.Now()
+	if p.finished || now.Sub(p.last) < progressInterval {
+		p.mu.Unlock()
+		return
+	}
+	p.last = now
+	p.mu.Unlock()
+
+	go p.edit(fmt.Sprintf("Downloading… %d%%", int(percent)), false)
+}
+
+// Done replaces the progress with a note that the file is being processed.
+// Later updates are ignored.
+func (p *progressMessage) Done() {
+	p.edit("Processing…", true)
+}
+
+func (p *progressMessage) edit(status string, final bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.finished {
+		return
+	}
+	p.finished = final
+
+	text := p.text + "\n\n" + status
+	// Telegram rejects edits that don't change the text
+	if text == p.lastText {
+		return
+	}
+	p.lastText = text
+
+	if _, err := p.b.EditMessageText(p.ctx, &bot.EditMessageTextParams{
+		ChatID:    p.chatID,
+		MessageID: p.messageID,
+		Text:      text,
+	}); err != nil {
+		log.Printf("Error updating progress message: %s", err)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// quietHours is a daily window, in minutes since midnight local time, in
+// which only the admin is served. The window may wrap around midnight.
+type quietHours struct {
+	start int
+	end   int
+}
+
+// parseQuietHours parses "HH:MM-HH:MM".
+func parseQuietHours(value string) (*quietHours, error) {
+	from, to, ok := strings.Cut(value, "-")
+	if !ok {
+		return nil, fmt.Errorf("expected HH:MM-HH:MM")
+	}
+
+	start, err := parseClock(strings.TrimSpace(from))
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(strings.TrimSpace(to))
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, fmt.Errorf("start and end are the same")
+	}
+
+	return &quietHours{start: start, end: end}, nil
+}
+
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time '%s'", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// contains reports whether now falls into the window. The end is exclusive.
+func (q *quietHours) contains(now time.Time) bool {
+	if q == nil {
+		return false
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+	if q.start < q.end {
+		return minute >= q.start && minute < q.end
+	}
+	return minute >= q.start || minute < q.end
+}
+
+func formatClock(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+// message tells the user when the bot is resting and when it is back.
+func (q *quietHours) message() string {
+	return fmt.Sprintf("The bot rests between %s and %s. Please send your link again after %s.",
+		formatClock(q.start), formatClock(q.end), formatClock(q.end))
+}
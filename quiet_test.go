@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    quietHours
+		wantErr bool
+	}{
+		{"23:00-07:00", quietHours{23 * 60, 7 * 60}, false},
+		{"01:30-05:45", quietHours{90, 5*60 + 45}, false},
+		{" 22:00 - 06:00 ", quietHours{22 * 60, 6 * 60}, false},
+		{"00:00-23:59", quietHours{0, 23*60 + 59}, false},
+		{"23:00", quietHours{}, true},
+		{"23:00-23:00", quietHours{}, true},
+		{"25:00-07:00", quietHours{}, true},
+		{"23:00-7", quietHours{}, true},
+		{"night", quietHours{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseQuietHours(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseQuietHours(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if err == nil && *got != tt.want {
+			t.Errorf("parseQuietHours(%q) = %+v, want %+v", tt.value, *got, tt.want)
+		}
+	}
+}
+
+func TestQuietHoursContains(t *testing.T) {
+	at := func(hour, minute int) time.Time { return time.Date(2024, 3, 15, hour, minute, 0, 0, time.Local) }
+	overnight := &quietHours{start: 23 * 60, end: 7 * 60}
+	daytime := &quietHours{start: 9 * 60, end: 17*60 + 30}
+
+	tests := []struct {
+		name string
+		q    *quietHours
+		now  time.Time
+		want bool
+	}{
+		{"overnight before start", overnight, at(22, 59), false},
+		{"overnight at start", overnight, at(23, 0), true},
+		{"overnight after midnight", overnight, at(3, 0), true},
+		{"overnight last minute", overnight, at(6, 59), true},
+		{"overnight at end", overnight, at(7, 0), false},
+		{"overnight midday", overnight, at(12, 0), false},
+		{"daytime at start", daytime, at(9, 0), true},
+		{"daytime inside", daytime, at(17, 29), true},
+		{"daytime at end", daytime, at(17, 30), false},
+		{"daytime before", daytime, at(8, 59), false},
+		{"not configured", nil, at(3, 0), false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.q.contains(tt.now); got != tt.want {
+			t.Errorf("%s: contains(%s) = %v, want %v", tt.name, tt.now.Format("15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestQuietHoursMessage(t *testing.T) {
+	q := &quietHours{start: 23*60 + 30, end: 7 * 60}
+	want := "The bot rests between 23:30 and 07:00. Please send your link again after 07:00."
+	if got := q.message(); got != want {
+		t.Errorf("message() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// userLimiter is a token bucket per Telegram user ID. Every user can make
+// burst requests at once, and gets a new one every interval.
+type userLimiter struct {
+	mu       sync.Mutex
+	burst    float64
+	interval time.Duration
+	buckets  map[int64]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// requestLimiter is nil when USER_RATE_LIMIT is 0.
+var requestLimiter *userLimiter
+
+// newUserLimiter allows perMinute requests per minute and user.
+func newUserLimiter(perMinute int) *userLimiter {
+	return &userLimiter{
+		burst:    float64(perMinute),
+		interval: time.Minute / time.Duration(perMinute),
+		buckets:  make(map[int64]*bucket),
+	}
+}
+
+// refill adds the tokens earned since the bucket was last used.
+func (l *userLimiter) refill(b *bucket, now time.Time) {
+	earned := float64(now.Sub(b.last)) / float64(l.interval)
+	b.tokens = math.Min(l.burst, b.tokens+earned)
+	b.last = now
+}
+
+// Allow takes a token for userID. When there is none, it returns false and
+// how long until there will be.
+func (l *userLimiter) Allow(userID int64, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[userID]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[userID] = b
+	}
+	l.refill(b, now)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) * float64(l.interval))
+}
+
+// removeFull forgets users whose bucket has refilled completely, they are
+// the same as users never seen.
+func (l *userLimiter) removeFull(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for id, b := range l.buckets {
+		l.refill(b, now)
+		if b.tokens >= l.burst {
+			delete(l.buckets, id)
+		}
+	}
+}
+
+// runJanitor removes idle users every interval until ctx is done.
+func (l *userLimiter) runJanitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.removeFull(now)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/mkevac/markodownloadbot/stats"
+)
+
+// Request modes saved with pending requests.
+const (
+	modeVideo = "video"
+	modeAudio = "audio"
+	modeVoice = "voice"
+	modeBoth  = "both"
+	modeGIF   = "gif"
+)
+
+func requestMode(opts DownloadOptions) string {
+	switch {
+	case opts.Voice:
+		return modeVoice
+	case opts.AudioOnly:
+		return modeAudio
+	case opts.WithAudio:
+		return modeBoth
+	case opts.GIF:
+		return modeGIF
+	default:
+		return modeVideo
+	}
+}
+
+func modeOptions(mode string) DownloadOptions {
+	switch mode {
+	case modeVoice:
+		return DownloadOptions{AudioOnly: true, Voice: true}
+	case modeAudio:
+		return DownloadOptions{AudioOnly: true}
+	case modeBoth:
+		return DownloadOptions{WithAudio: true}
+	case modeGIF:
+		return DownloadOptions{GIF: true}
+	default:
+		return DownloadOptions{}
+	}
+}
+
+// requestOptions are the download options saved with a request besides its
+// mode, so a resumed or retried request downloads the same thing. Cookies
+// and the video password are never saved.
+type requestOptions struct {
+	Section    *Section `json:"section,omitempty"`
+	Resolution int      `json:"resolution,omitempty"`
+	FormatID   string   `json:"format_id,omitempty"`
+	Language   string   `json:"language,omitempty"`
+}
+
+// encodeRequestOptions returns the options of opts to save with the
+// request, or "" if there are none.
+func encodeRequestOptions(opts DownloadOptions) string {
+	saved := requestOptions{
+		Section:    opts.Section,
+		Resolution: opts.Resolution,
+		FormatID:   opts.FormatID,
+		Language:   opts.Language,
+	}
+	if saved == (requestOptions{}) {
+		return ""
+	}
+
+	buf, err := json.Marshal(saved)
+	if err != nil {
+		log.Printf("Error encoding request options: %s", err)
+		return ""
+	}
+	return string(buf)
+}
+
+// savedRequestOptions rebuilds the download options of a saved request.
+func savedRequestOptions(r stats.PendingRequest) DownloadOptions {
+	opts := modeOptions(r.Mode)
+	if r.Options == "" {
+		return opts
+	}
+
+	var saved requestOptions
+	if err := json.Unmarshal([]byte(r.Options), &saved); err != nil {
+		log.Printf("Error decoding options of request %d: %s", r.ID, err)
+		return opts
+	}
+	opts.Section = saved.Section
+	opts.Resolution = saved.Resolution
+	opts.FormatID = saved.FormatID
+	opts.Language = saved.Language
+	return opts
+}
+
+// resumePendingRequests runs the requests that were still waiting for a
+// download slot when the bot stopped.
+func resumePendingRequests(b *bot.Bot, jobs *jobTracker) {
+	requests := stats.TakePendingRequests()
+	if len(requests) == 0 {
+		return
+	}
+
+	log.Printf("Resuming %d requests from before the restart", len(requests))
+
+	for _, r := range requests {
+		msg := &models.Message{
+			From: &models.User{ID: r.UserID, Username: r.Username},
+			Chat: models.Chat{ID: r.ChatID},
+			Text: r.URL,
+		}
+
+		opts := savedRequestOptions(r)
+		jobs.Go(func(ctx context.Context) {
+			b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: msg.Chat.ID,
+				Text:   "Resuming your request from before the restart: " + msg.Text,
+			})
+
+			handleDownload(ctx, b, &models.Update{Message: msg}, msg.Text, opts, "")
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mkevac/markodownloadbot/stats"
+)
+
+func TestRequestMode(t *testing.T) {
+	tests := []struct {
+		opts DownloadOptions
+		mode string
+	}{
+		{DownloadOptions{}, modeVideo},
+		{DownloadOptions{AudioOnly: true}, modeAudio},
+		{DownloadOptions{AudioOnly: true, Voice: true}, modeVoice},
+		{DownloadOptions{WithAudio: true}, modeBoth},
+		{DownloadOptions{GIF: true}, modeGIF},
+	}
+
+	for _, tt := range tests {
+		if got := requestMode(tt.opts); got != tt.mode {
+			t.Errorf("requestMode(%+v) = %q, want %q", tt.opts, got, tt.mode)
+		}
+		opts := modeOptions(tt.mode)
+		if opts.AudioOnly != tt.opts.AudioOnly || opts.Voice != tt.opts.Voice || opts.WithAudio != tt.opts.WithAudio || opts.GIF != tt.opts.GIF {
+			t.Errorf("modeOptions(%q) = %+v, want %+v", tt.mode, opts, tt.opts)
+		}
+	}
+
+	if opts := modeOptions("unknown"); opts.AudioOnly || opts.Voice || opts.WithAudio || opts.GIF {
+		t.Errorf("modeOptions(unknown) = %+v, want a plain video", opts)
+	}
+}
+
+func TestRequestOptionsRoundTrip(t *testing.T) {
+	tests := []DownloadOptions{
+		{},
+		{AudioOnly: true, Language: "de"},
+		{Section: &Section{Start: 90, End: 120.5}},
+		{Section: &Section{Start: 30}, WithAudio: true},
+		{Resolution: 1080},
+		{FormatID: "137+140"},
+		{AudioOnly: true, Voice: true, Section: &Section{Start: 1, End: 2}, Language: "pt-BR"},
+		{GIF: true, Resolution: 480},
+	}
+
+	for _, want := range tests {
+		id := stats.AddPendingRequest(1, 1, "alice", "https://example.com/watch", requestMode(want), encodeRequestOptions(want))
+		r, ok := stats.GetRequest(id)
+		if !ok {
+			t.Fatalf("request %d not saved", id)
+		}
+		got := savedRequestOptions(r)
+
+		if got.AudioOnly != want.AudioOnly || got.Voice != want.Voice || got.WithAudio != want.WithAudio || got.GIF != want.GIF ||
+			got.Resolution != want.Resolution || got.FormatID != want.FormatID || got.Language != want.Language {
+			t.Errorf("%+v came back as %+v", want, got)
+		}
+		switch {
+		case (got.Section == nil) != (want.Section == nil):
+			t.Errorf("%+v: section %v came back as %v", want, want.Section, got.Section)
+		case got.Section != nil && *got.Section != *want.Section:
+			t.Errorf("%+v: section %+v came back as %+v", want, *want.Section, *got.Section)
+		}
+	}
+}
+
+func TestEncodeRequestOptions(t *testing.T) {
+	tests := []struct {
+		opts DownloadOptions
+		want string
+	}{
+		{DownloadOptions{AudioOnly: true, Voice: true}, ""},
+		{DownloadOptions{VideoPassword: "secret", CookiesFile: "/tmp/cookies.txt"}, ""},
+		{DownloadOptions{Resolution: 720, VideoPassword: "secret"}, `{"resolution":720}`},
+	}
+
+	for _, tt := range tests {
+		got := encodeRequestOptions(tt.opts)
+		if got != tt.want {
+			t.Errorf("encodeRequestOptions(%+v) = %q, want %q", tt.opts, got, tt.want)
+		}
+		if strings.Contains(got, "secret") || strings.Contains(got, "cookies") {
+			t.Errorf("encodeRequestOptions(%+v) = %q saves the password or cookies", tt.opts, got)
+		}
+	}
+}
+
+func TestSavedRequestOptionsInvalid(t *testing.T) {
+	opts := savedRequestOptions(stats.PendingRequest{ID: 1, Mode: modeAudio, Options: "{not json"})
+	if !opts.AudioOnly || opts.Section != nil || opts.Resolution != 0 {
+		t.Errorf("savedRequestOptions with invalid options = %+v, want only the mode", opts)
+	}
+}
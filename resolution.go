@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+
+	"github.com/mkevac/markodownloadbot/stats"
+)
+
+const resolutionConfigKey = "default_resolution"
+
+var allowedResolutions = []int{144, 240, 360, 480, 720, 1080, 1440, 2160}
+
+var (
+	resolutionMu      sync.RWMutex
+	defaultResolution = 720
+)
+
+// loadDefaultResolution sets the initial default resolution from
+// MAX_RESOLUTION, unless an admin changed it with /setres before a restart.
+func loadDefaultResolution() {
+	res := getEnvInt("MAX_RESOLUTION", 720)
+
+	if value, ok := stats.GetConfig(resolutionConfigKey); ok {
+		if saved, err := strconv.Atoi(value); err == nil {
+			log.Printf("Using default resolution %dp set with /setres", saved)
+			res = saved
+		}
+	}
+
+	if err := setDefaultResolution(res, false); err != nil {
+		log.Printf("Ignoring default resolution: %s", err)
+	}
+}
+
+func getDefaultResolution() int {
+	resolutionMu.RLock()
+	defer resolutionMu.RUnlock()
+	return defaultResolution
+}
+
+// setDefaultResolution changes the resolution used by new downloads and
+// optionally saves it so it survives restarts.
+func setDefaultResolution(res int, persist bool) error {
+	if !isAllowedResolution(res) {
+		return fmt.Errorf("resolution %d is not one of %v", res, allowedResolutions)
+	}
+
+	resolutionMu.Lock()
+	defaultResolution = res
+	resolutionMu.Unlock()
+
+	if persist {
+		if err := stats.SetConfig(resolutionConfigKey, strconv.Itoa(res)); err != nil {
+			return fmt.Errorf("resolution set but not saved: %s", err)
+		}
+	}
+
+	return nil
+}
+
+func isAllowedResolution(res int) bool {
+	for _, allowed := range allowedResolutions {
+		if res == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/mkevac/markodownloadbot/stats"
+)
+
+func TestSetDefaultResolution(t *testing.T) {
+	old := getDefaultResolution()
+	defer setDefaultResolution(old, false)
+
+	tests := []struct {
+		res     int
+		want    int
+		wantErr bool
+	}{
+		{1080, 1080, false},
+		{144, 144, false},
+		{1000, 144, true},
+		{0, 144, true},
+		{2160, 2160, false},
+	}
+
+	for _, tt := range tests {
+		err := setDefaultResolution(tt.res, false)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("setDefaultResolution(%d) error = %v, want error %v", tt.res, err, tt.wantErr)
+		}
+		if got := getDefaultResolution(); got != tt.want {
+			t.Errorf("after setDefaultResolution(%d): resolution = %d, want %d", tt.res, got, tt.want)
+		}
+	}
+}
+
+func TestLoadDefaultResolution(t *testing.T) {
+	old := getDefaultResolution()
+	defer setDefaultResolution(old, false)
+	defer stats.SetConfig(resolutionConfigKey, "")
+
+	if err := stats.SetConfig(resolutionConfigKey, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("MAX_RESOLUTION", "480")
+	loadDefaultResolution()
+	if got := getDefaultResolution(); got != 480 {
+		t.Errorf("resolution from MAX_RESOLUTION = %d, want 480", got)
+	}
+
+	// what /setres saved wins over the environment
+	if err := setDefaultResolution(1080, true); err != nil {
+		t.Fatal(err)
+	}
+	loadDefaultResolution()
+	if got := getDefaultResolution(); got != 1080 {
+		t.Errorf("resolution after a restart = %d, want the saved 1080", got)
+	}
+
+	t.Setenv("MAX_RESOLUTION", "1000")
+	stats.SetConfig(resolutionConfigKey, "")
+	setDefaultResolution(720, false)
+	loadDefaultResolution()
+	if got := getDefaultResolution(); got != 720 {
+		t.Errorf("resolution with an invalid MAX_RESOLUTION = %d, want 720 kept", got)
+	}
+}
+
+func TestSetResolutionHandler(t *testing.T) {
+	oldRes, oldAdmin, oldAdminChat := getDefaultResolution(), adminUsername, adminChatID.Load()
+	defer func() {
+		setDefaultResolution(oldRes, false)
+		adminUsername = oldAdmin
+		adminChatID.Store(oldAdminChat)
+		stats.SetConfig(resolutionConfigKey, "")
+	}()
+	adminUsername = "admin"
+	adminChatID.Store(99)
+
+	tests := []struct {
+		username string
+		text     string
+		reply    string
+		want     int
+	}{
+		{"admin", "/setres", "Default resolution is 720p", 720},
+		{"admin", "/setres 1080p", "Default resolution set to 1080p.", 1080},
+		{"admin", "/setres 480", "Default resolution set to 480p.", 480},
+		{"admin", "/setres 1000", "Error: resolution 1000 is not one of", 480},
+		{"admin", "/setres high", "Invalid resolution 'high'", 480},
+		{"alice", "/setres 144", "not authorized", 480},
+	}
+
+	setDefaultResolution(720, false)
+	for _, tt := range tests {
+		b := newTestBot(t)
+		update := &models.Update{Message: &models.Message{
+			Text: tt.text,
+			From: &models.User{ID: 1, Username: tt.username},
+			Chat: models.Chat{ID: 99},
+		}}
+		if tt.username != "admin" {
+			update.Message.Chat.ID = 1
+		}
+
+		setResolutionHandler(context.Background(), b.Bot, update)
+
+		texts := b.sentTexts()
+		if len(texts) == 0 || !strings.Contains(texts[0], tt.reply) {
+			t.Errorf("%s %q: sent %q, want %q", tt.username, tt.text, texts, tt.reply)
+		}
+		if tt.username != "admin" && (len(texts) != 2 || !strings.Contains(texts[1], "Unauthorized access to /setres command from @alice")) {
+			t.Errorf("%s %q: admin not told about it, sent %q", tt.username, tt.text, texts)
+		}
+		if got := getDefaultResolution(); got != tt.want {
+			t.Errorf("%s %q: resolution = %d, want %d", tt.username, tt.text, got, tt.want)
+		}
+	}
+
+	if value, _ := stats.GetConfig(resolutionConfigKey); value != "480" {
+		t.Errorf("saved resolution = %q, want 480", value)
+	}
+}
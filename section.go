@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Section is a part of the media, in seconds from the start. An End of 0
+// means until the end.
+type Section struct {
+	Start float64
+	End   float64
+}
+
+// downloadSectionsArg formats the section for yt-dlp's --download-sections.
+func (s Section) downloadSectionsArg() string {
+	end := "inf"
+	if s.End > 0 {
+		end = strconv.FormatFloat(s.End, 'f', -1, 64)
+	}
+	return "*" + strconv.FormatFloat(s.Start, 'f', -1, 64) + "-" + end
+}
+
+// Chapter is a chapter listed in yt-dlp's metadata.
+type Chapter struct {
+	Title     string  `json:"title"`
+	StartTime float64 `json:"start_time"`
+	EndTime   float64 `json:"end_time"`
+}
+
+func (c Chapter) section() Section {
+	return Section{Start: c.StartTime, End: c.EndTime}
+}
+
+// matchChapter finds the chapter called name. An exact, case-insensitive
+// title match wins; otherwise name has to be part of exactly one title.
+func matchChapter(chapters []Chapter, name string) (*Chapter, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return nil, fmt.Errorf("no chapter name given")
+	}
+
+	for i := range chapters {
+		if strings.ToLower(strings.TrimSpace(chapters[i].Title)) == name {
+			return &chapters[i], nil
+		}
+	}
+
+	var found *Chapter
+	for i := range chapters {
+		if strings.Contains(strings.ToLower(chapters[i].Title), name) {
+			if found != nil {
+				return nil, fmt.Errorf("more than one chapter matches '%s'", name)
+			}
+			found = &chapters[i]
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no chapter matches '%s'", name)
+	}
+
+	return found, nil
+}
+
+// parseChapterArgs splits `"Chapter name" <url>` or `Chapter name <url>`
+// into the chapter name and the URL, which always comes last.
+func parseChapterArgs(args string) (string, string, error) {
+	args = strings.TrimSpace(args)
+
+	i := strings.LastIndexAny(args, " \t\n")
+	if i < 0 {
+		return "", "", fmt.Errorf("expected a chapter name and a link")
+	}
+
+	name := strings.TrimSpace(args[:i])
+	name = strings.Trim(name, "\"“”'")
+	link := args[i+1:]
+
+	if name == "" {
+		return "", "", fmt.Errorf("expected a chapter name and a link")
+	}
+
+	return name, link, nil
+}
+
+// parseTimestamp parses HH:MM:SS, MM:SS or SS into seconds. Seconds may
+// have a fraction.
+func parseTimestamp(s string) (float64, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp '%s'", s)
+	}
+
+	var seconds float64
+	for i, part := range parts {
+		last := i == len(parts)-1
+
+		var v float64
+		var err error
+		if last {
+			v, err = strconv.ParseFloat(part, 64)
+		} else {
+			var n int
+			n, err = strconv.Atoi(part)
+			v = float64(n)
+		}
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid timestamp '%s'", s)
+		}
+		// minutes and seconds after an hour or minute field stay below 60
+		if i > 0 && v >= 60 {
+			return 0, fmt.Errorf("invalid timestamp '%s'", s)
+		}
+
+		seconds = seconds*60 + v
+	}
+
+	return seconds, nil
+}
+
+// parseClipArgs parses `<url> <start> <end>` for /clip.
+func parseClipArgs(args string) (string, Section, error) {
+	fields := strings.Fields(args)
+	if len(fields) != 3 {
+		return "", Section{}, fmt.Errorf("expected a link, a start and an end time")
+	}
+
+	start, err := parseTimestamp(fields[1])
+	if err != nil {
+		return "", Section{}, err
+	}
+	end, err := parseTimestamp(fields[2])
+	if err != nil {
+		return "", Section{}, err
+	}
+	if end <= start {
+		return "", Section{}, fmt.Errorf("the end time must be after the start time")
+	}
+
+	return fields[0], Section{Start: start, End: end}, nil
+}
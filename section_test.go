@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestSectionDownloadSectionsArg(t *testing.T) {
+	tests := []struct {
+		section Section
+		want    string
+	}{
+		{Section{Start: 0, End: 90}, "*0-90"},
+		{Section{Start: 61.5, End: 122.25}, "*61.5-122.25"},
+		{Section{Start: 300}, "*300-inf"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.section.downloadSectionsArg(); got != tt.want {
+			t.Errorf("%+v: downloadSectionsArg() = %q, want %q", tt.section, got, tt.want)
+		}
+	}
+}
+
+var testChapters = []Chapter{
+	{Title: "Intro", StartTime: 0, EndTime: 30},
+	{Title: "Part 1: Setup", StartTime: 30, EndTime: 300},
+	{Title: "Part 2: Build", StartTime: 300, EndTime: 600},
+	{Title: "Outro", StartTime: 600, EndTime: 660},
+	{Title: "Intro to the outro", StartTime: 660, EndTime: 700},
+}
+
+func TestMatchChapter(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr string
+	}{
+		{"Outro", "Outro", ""},
+		{"  outro ", "Outro", ""},
+		{"intro", "Intro", ""},
+		{"setup", "Part 1: Setup", ""},
+		{"BUILD", "Part 2: Build", ""},
+		{"part", "", "more than one chapter"},
+		{"credits", "", "no chapter matches"},
+		{"", "", "no chapter name"},
+	}
+
+	for _, tt := range tests {
+		got, err := matchChapter(testChapters, tt.name)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("matchChapter(%q) error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got.Title != tt.want {
+			t.Errorf("matchChapter(%q) = %v, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseChapterArgs(t *testing.T) {
+	tests := []struct {
+		args    string
+		name    string
+		link    string
+		wantErr bool
+	}{
+		{`"Part 1" https://youtu.be/x`, "Part 1", "https://youtu.be/x", false},
+		{"Part 1 https://youtu.be/x", "Part 1", "https://youtu.be/x", false},
+		{"“Outro” https://youtu.be/x", "Outro", "https://youtu.be/x", false},
+		{"  intro\thttps://youtu.be/x  ", "intro", "https://youtu.be/x", false},
+		{"https://youtu.be/x", "", "", true},
+		{`"" https://youtu.be/x`, "", "", true},
+		{"", "", "", true},
+	}
+
+	for _, tt := range tests {
+		name, link, err := parseChapterArgs(tt.args)
+		if (err != nil) != tt.wantErr || name != tt.name || link != tt.link {
+			t.Errorf("parseChapterArgs(%q) = %q, %q, %v, want %q, %q", tt.args, name, link, err, tt.name, tt.link)
+		}
+	}
+}
+
+func TestReplyChapter(t *testing.T) {
+	tests := []struct {
+		name     string
+		chapters []Chapter
+		chapter  string
+		want     *Section
+		reply    string
+	}{
+		{"found", testChapters, "setup", &Section{Start: 30, End: 300}, ""},
+		{"no chapters", nil, "setup", nil, "This video has no chapters."},
+		{"not found", testChapters, "credits", nil, "Sorry, no chapter matches 'credits'. Available chapters:\n• Intro\n• Part 1: Setup"},
+	}
+
+	for _, tt := range tests {
+		b := newTestBot(t)
+		update := &models.Update{Message: &models.Message{
+			From: &models.User{ID: 1, Username: "test"},
+			Chat: models.Chat{ID: 1},
+		}}
+		var opts DownloadOptions
+
+		ok := replyChapter(context.Background(), b.Bot, update, &Metadata{Chapters: tt.chapters}, tt.chapter, &opts)
+		if ok != (tt.want != nil) {
+			t.Errorf("%s: replyChapter = %v", tt.name, ok)
+		}
+		if tt.want != nil && (opts.Section == nil || *opts.Section != *tt.want) {
+			t.Errorf("%s: section = %v, want %v", tt.name, opts.Section, tt.want)
+		}
+
+		texts := b.sentTexts()
+		if tt.reply == "" && len(texts) > 0 || tt.reply != "" && (len(texts) != 1 || !strings.HasPrefix(texts[0], tt.reply)) {
+			t.Errorf("%s: sent %q, want %q", tt.name, texts, tt.reply)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+type sendErrorKind int
+
+const (
+	sendErrorOther sendErrorKind = iota
+	// sendErrorDimensions means Telegram didn't accept the video's
+	// width, height or aspect ratio
+	sendErrorDimensions
+	// sendErrorTooLarge means the file exceeds the upload limit
+	sendErrorTooLarge
+	// sendErrorTimeout means the upload took longer than UPLOAD_TIMEOUT_MINUTES
+	sendErrorTimeout
+)
+
+// classifySendError tells apart the Bot API errors we can recover from.
+func classifySendError(err error) sendErrorKind {
+	if err == nil {
+		return sendErrorOther
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return sendErrorTimeout
+	}
+
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "too large"),
+		strings.Contains(msg, "too big"),
+		strings.Contains(msg, "file_parts_invalid"):
+		return sendErrorTooLarge
+	case strings.Contains(msg, "dimension"),
+		strings.Contains(msg, "width"),
+		strings.Contains(msg, "height"),
+		strings.Contains(msg, "aspect"):
+		return sendErrorDimensions
+	default:
+		return sendErrorOther
+	}
+}
+
+// fileTooLargeMessage explains to the user why a file over limit wasn't
+// sent and how to get something smaller.
+func fileTooLargeMessage(mediaType string, size int64, limit int64, audioOnly bool) string {
+	const mb = 1024 * 1024
+	msg := fmt.Sprintf("I'm sorry, the %s is %d MB, more than the %d MB I can send.", mediaType, (size+mb-1)/mb, limit/mb)
+	if audioOnly {
+		return msg
+	}
+	return msg + " Try /audio for the sound only, or /video720 for a lower resolution."
+}
+
+// caption is the media's title from info.json. Captions are sent without
+// a parse mode, so the title needs no escaping.
+func (media *Media) caption() string {
+	return truncateCaption(strings.TrimSpace(media.Title))
+}
+
+// Values of REPLY_TO_REQUEST.
+const (
+	replyAlways = "always"
+	replyGroups = "groups"
+	replyNever  = "never"
+)
+
+// replyParameters makes a message a reply to the user's request, so in
+// group chats it's clear which download belongs to whom. It returns nil
+// when REPLY_TO_REQUEST is off for this chat or the request has no
+// message to reply to. If the request was deleted in the meantime, the
+// message is sent without the reply.
+func replyParameters(msg *models.Message) *models.ReplyParameters {
+	if msg.ID == 0 || replyToRequest == replyNever {
+		return nil
+	}
+	if replyToRequest == replyGroups && msg.Chat.Type == "private" {
+		return nil
+	}
+	return &models.ReplyParameters{
+		MessageID:                msg.ID,
+		AllowSendingWithoutReply: true,
+	}
+}
+
+// sendMedia uploads the downloaded file to the chat, as a reply if reply
+// isn't nil. Videos that Telegram rejects because of their dimensions are
+// sent again as a plain file.
+func sendMedia(ctx context.Context, b *bot.Bot, chatID int64, media *Media, audioOnly bool, reply *models.ReplyParameters) (*models.Message, error) {
+	pathToSend := localPath(media.Path)
+
+	if media.voice {
+		params := voiceParams(chatID, media)
+		params.ReplyParameters = reply
+		return b.SendVoice(ctx, params)
+	}
+
+	if media.gif {
+		params := animationParams(chatID, media)
+		params.ReplyParameters = reply
+		return b.SendAnimation(ctx, params)
+	}
+
+	if audioOnly {
+		params := &bot.SendAudioParams{
+			ChatID:          chatID,
+			Audio:           &models.InputFileString{Data: "file://" + pathToSend},
+			Duration:        (int)(media.Duration),
+			Caption:         media.caption(),
+			Title:           strings.TrimSpace(media.Title),
+			Performer:       strings.TrimSpace(media.Uploader),
+			ReplyParameters: reply,
+		}
+		if media.coverPath != "" {
+			if thumb, closeThumb, err := thumbnailUpload(media.coverPath); err != nil {
+				log.Printf("[%s]: error opening cover: %s", media.user, err)
+			} else {
+				defer closeThumb()
+				params.Thumbnail = thumb
+			}
+		}
+		return b.SendAudio(ctx, params)
+	}
+
+	params := &bot.SendVideoParams{
+		ChatID:            chatID,
+		Video:             &models.InputFileString{Data: "file://" + pathToSend},
+		Width:             media.Width,
+		Height:            media.Height,
+		Duration:          (int)(media.Duration),
+		SupportsStreaming: media.SupportsStreaming,
+		Caption:           media.caption(),
+		ReplyParameters:   reply,
+	}
+
+	// a video without a thumbnail still sends, Telegram makes its own
+	if thumbPath, err := media.generateThumbnail(ctx); err != nil {
+		log.Printf("[%s]: %s", media.user, err)
+	} else {
+		defer os.Remove(thumbPath)
+		if thumb, closeThumb, err := thumbnailUpload(thumbPath); err == nil {
+			defer closeThumb()
+			params.Thumbnail = thumb
+		}
+	}
+
+	msg, err := b.SendVideo(ctx, params)
+	if err == nil || classifySendError(err) != sendErrorDimensions {
+		return msg, err
+	}
+
+	log.Printf("[%s]: video rejected because of its dimensions (%dx%d), sending as a file: %s", media.user, media.Width, media.Height, err)
+
+	docParams := documentParams(chatID, media.Path, media.caption())
+	docParams.ReplyParameters = reply
+	msg, err = b.SendDocument(ctx, docParams)
+	if err != nil {
+		return nil, err
+	}
+
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   "Telegram didn't accept the video's dimensions, so I sent it as a file instead.",
+	})
+
+	return msg, nil
+}
+
+// deliverMedia sends the media with a bounded upload time. When the upload
+// times out, the user gets a download link instead if links are enabled
+// and one wasn't sent already, otherwise the upload is tried once more.
+// It reports whether the media went out as a link.
+func deliverMedia(ctx context.Context, b *bot.Bot, chatID int64, media *Media, audioOnly bool, linkSent bool, reply *models.ReplyParameters) (*models.Message, bool, error) {
+	sent, err := sendMediaWithTimeout(ctx, b, chatID, media, audioOnly, reply)
+	if classifySendError(err) != sendErrorTimeout || ctx.Err() != nil {
+		return sent, false, err
+	}
+
+	log.Printf("[%s]: upload timed out after %s", media.user, uploadTimeout)
+
+	if shares != nil && !linkSent {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   "The file is large and uploading it to Telegram took too long, so here is a download link instead.",
+		})
+		if sendShareLink(ctx, b, chatID, media) {
+			return nil, true, nil
+		}
+	}
+
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   "The file is large and uploading it to Telegram took too long. Trying once more...",
+	})
+
+	sent, err = sendMediaWithTimeout(ctx, b, chatID, media, audioOnly, reply)
+	return sent, false, err
+}
+
+// botPollTimeout is how long a getUpdates long poll may take, the
+// go-telegram/bot default.
+var botPollTimeout = time.Minute
+
+// newBotHTTPClient returns the client for the Bot API. go-telegram/bot's
+// default client gives up on every request after botPollTimeout, which
+// would cut uploads off long before UPLOAD_TIMEOUT_MINUTES. Uploads are
+// limited by their context in sendMediaWithTimeout instead.
+func newBotHTTPClient() *http.Client {
+	return &http.Client{Timeout: uploadTimeout + botPollTimeout}
+}
+
+func sendMediaWithTimeout(ctx context.Context, b *bot.Bot, chatID int64, media *Media, audioOnly bool, reply *models.ReplyParameters) (*models.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, uploadTimeout)
+	defer cancel()
+	return sendMedia(ctx, b, chatID, media, audioOnly, reply)
+}
+
+// sendAsDocument uploads the file at path as a plain document.
+func sendAsDocument(ctx context.Context, b *bot.Bot, chatID int64, path string, caption string) (*models.Message, error) {
+	return b.SendDocument(ctx, documentParams(chatID, path, caption))
+}
+
+// documentParams returns the parameters for sending the file at path as a
+// plain document.
+func documentParams(chatID int64, path string, caption string) *bot.SendDocumentParams {
+	return &bot.SendDocumentParams{
+		ChatID:   chatID,
+		Document: &models.InputFileString{Data: "file://" + localPath(path)},
+		Caption:  truncateCaption(caption),
+	}
+}
+
+// logToChannel records a successful download in LOG_CHANNEL_ID, either by
+// copying the sent message, which doesn't upload the file again, or as a
+// text line. Failures only affect the log channel, never the user.
+func logToChannel(ctx context.Context, b *bot.Bot, username string, input string, sent *models.Message) {
+	if logChannelID == 0 {
+		return
+	}
+
+	record := fmt.Sprintf("@%s: %s", username, input)
+
+	if logChannelMode == "media" && sent != nil {
+		_, err := b.CopyMessage(ctx, &bot.CopyMessageParams{
+			ChatID:              logChannelID,
+			FromChatID:          strconv.FormatInt(sent.Chat.ID, 10),
+			MessageID:           sent.ID,
+			Caption:             truncateCaption(record),
+			DisableNotification: true,
+		})
+		if err == nil {
+			return
+		}
+		log.Printf("[%s]: error copying media to log channel, logging text only: %s", username, err)
+	}
+
+	if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:              logChannelID,
+		Text:                record,
+		DisableNotification: true,
+	}); err != nil {
+		log.Printf("[%s]: error sending to log channel: %s", username, err)
+	}
+}
+
+// deleteRequestMessage removes the user's message with the link once the
+// media was delivered. The bot needs the right to delete messages in group
+// chats; without it the message is left alone.
+func deleteRequestMessage(ctx context.Context, b *bot.Bot, msg *models.Message) {
+	// resumed requests and deleted /pw messages have no ID
+	if msg.ID == 0 {
+		return
+	}
+
+	if _, err := b.DeleteMessage(ctx, &bot.DeleteMessageParams{
+		ChatID:    msg.Chat.ID,
+		MessageID: msg.ID,
+	}); err != nil {
+		log.Printf("[%s]: couldn't delete the request message: %s", msg.From.Username, err)
+	}
+}
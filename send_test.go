@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestClassifySendError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want sendErrorKind
+	}{
+		{nil, sendErrorOther},
+		{errors.New("bad request, Bad Request: chat not found"), sendErrorOther},
+		{errors.New("bad request, Bad Request: VIDEO_DIMENSIONS_INVALID"), sendErrorDimensions},
+		{errors.New("bad request, Bad Request: wrong video width"), sendErrorDimensions},
+		{errors.New("bad request, Bad Request: invalid aspect ratio"), sendErrorDimensions},
+		{errors.New("Request Entity Too Large"), sendErrorTooLarge},
+		{errors.New("bad request, Bad Request: file is too big"), sendErrorTooLarge},
+		{errors.New("bad request, Bad Request: FILE_PARTS_INVALID"), sendErrorTooLarge},
+		{fmt.Errorf("error call: %w", context.DeadlineExceeded), sendErrorTimeout},
+	}
+
+	for _, tt := range tests {
+		if got := classifySendError(tt.err); got != tt.want {
+			t.Errorf("classifySendError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestSendMediaDimensionsFallback(t *testing.T) {
+	tests := []struct {
+		name      string
+		videoErr  string
+		documents int
+		notice    bool
+		wantErr   bool
+	}{
+		{"accepted", "", 0, false, false},
+		{"rejected dimensions", "Bad Request: VIDEO_DIMENSIONS_INVALID", 1, true, false},
+		{"other error", "Bad Request: chat not found", 0, false, true},
+	}
+
+	for _, tt := range tests {
+		b := newTestBot(t)
+		if tt.videoErr != "" {
+			b.respond("sendVideo", botError{400, tt.videoErr})
+		}
+		media := &Media{user: "test", Path: "/nonexistent/video.mp4", Title: "Cats", Width: 5000, Height: 10}
+
+		_, err := sendMedia(context.Background(), b.Bot, 1, media, false, nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: sendMedia error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+
+		documents := b.calls("sendDocument")
+		if len(documents) != tt.documents {
+			t.Errorf("%s: sent %d documents, want %d", tt.name, len(documents), tt.documents)
+		}
+		if len(documents) == 1 && documents[0].fields["caption"] != "Cats" {
+			t.Errorf("%s: document caption = %q, want the title", tt.name, documents[0].fields["caption"])
+		}
+
+		texts := b.sentTexts()
+		if notice := len(texts) == 1 && strings.Contains(texts[0], "dimensions"); notice != tt.notice || len(texts) > 1 {
+			t.Errorf("%s: sent %q, want a notice %v", tt.name, texts, tt.notice)
+		}
+	}
+}
+
+func TestLogToChannel(t *testing.T) {
+	oldID, oldMode := logChannelID, logChannelMode
+	defer func() { logChannelID, logChannelMode = oldID, oldMode }()
+
+	sent := &models.Message{ID: 42, Chat: models.Chat{ID: 5}}
+
+	tests := []struct {
+		name      string
+		channelID int64
+		mode      string
+		sent      *models.Message
+		copyFails bool
+		copies    int
+		texts     int
+	}{
+		{"disabled", 0, "media", sent, false, 0, 0},
+		{"media", -100123, "media", sent, false, 1, 0},
+		{"media copy fails", -100123, "media", sent, true, 1, 1},
+		{"media without a message", -100123, "media", nil, false, 0, 1},
+		{"text", -100123, "text", sent, false, 0, 1},
+	}
+
+	for _, tt := range tests {
+		b := newTestBot(t)
+		if tt.copyFails {
+			b.respond("copyMessage", botError{400, "Bad Request: message to copy not found"})
+		}
+		logChannelID, logChannelMode = tt.channelID, tt.mode
+
+		logToChannel(context.Background(), b.Bot, "alice", "https://example.com/v", tt.sent)
+
+		copies := b.calls("copyMessage")
+		if len(copies) != tt.copies {
+			t.Errorf("%s: %d copies, want %d", tt.name, len(copies), tt.copies)
+		}
+		for _, c := range copies {
+			if c.fields["chat_id"] != "-100123" || c.fields["from_chat_id"] != "5" || c.fields["message_id"] != "42" || c.fields["caption"] != "@alice: https://example.com/v" {
+				t.Errorf("%s: copyMessage fields = %v", tt.name, c.fields)
+			}
+		}
+
+		texts := b.calls("sendMessage")
+		if len(texts) != tt.texts {
+			t.Errorf("%s: %d messages, want %d", tt.name, len(texts), tt.texts)
+		}
+		for _, m := range texts {
+			if m.fields["chat_id"] != "-100123" || m.fields["text"] != "@alice: https://example.com/v" {
+				t.Errorf("%s: sendMessage fields = %v", tt.name, m.fields)
+			}
+		}
+	}
+}
+
+func TestDeliverMedia(t *testing.T) {
+	oldShares, oldTimeout := shares, uploadTimeout
+	defer func() { shares, uploadTimeout = oldShares, oldTimeout }()
+
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		shares   bool
+		linkSent bool
+		asLink   bool
+		wantErr  bool
+		replies  []string
+	}{
+		{"sent", time.Minute, true, false, false, false, nil},
+		{"timed out, link instead", time.Nanosecond, true, false, true, false, []string{"here is a download link instead", "Download link"}},
+		{"timed out, link already sent", time.Nanosecond, true, true, false, true, []string{"Trying once more"}},
+		{"timed out without links", time.Nanosecond, false, false, false, true, []string{"Trying once more"}},
+	}
+
+	for _, tt := range tests {
+		uploadTimeout = tt.timeout
+		store, path := newTestShareStore(t)
+		shares = nil
+		if tt.shares {
+			shares = store
+		}
+		b := newTestBot(t)
+		media := &Media{user: "test", randomName: "abc", Path: path, FileName: "abc.mp4", Title: "Cats"}
+
+		sent, asLink, err := deliverMedia(context.Background(), b.Bot, 1, media, false, tt.linkSent, nil)
+
+		if asLink != tt.asLink || (err != nil) != tt.wantErr {
+			t.Errorf("%s: deliverMedia = link %v, error %v, want link %v, error %v", tt.name, asLink, err, tt.asLink, tt.wantErr)
+		}
+		if !tt.asLink && !tt.wantErr && sent == nil {
+			t.Errorf("%s: no sent message returned", tt.name)
+		}
+
+		texts := b.sentTexts()
+		if len(texts) != len(tt.replies) {
+			t.Errorf("%s: sent %q, want %q", tt.name, texts, tt.replies)
+			continue
+		}
+		for i, reply := range tt.replies {
+			if !strings.Contains(texts[i], reply) {
+				t.Errorf("%s: message %d is %q, want %q", tt.name, i, texts[i], reply)
+			}
+		}
+	}
+}
+
+func TestSendMediaWithTimeoutSlowUpload(t *testing.T) {
+	oldPoll, oldTimeout := botPollTimeout, uploadTimeout
+	defer func() { botPollTimeout, uploadTimeout = oldPoll, oldTimeout }()
+	botPollTimeout = 50 * time.Millisecond
+
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		delay   time.Duration
+		wantErr bool
+	}{
+		{"longer than a poll", 2 * time.Second, 300 * time.Millisecond, false},
+		{"longer than the upload timeout", 100 * time.Millisecond, time.Second, true},
+	}
+
+	for _, tt := range tests {
+		uploadTimeout = tt.timeout
+		b := newTestBot(t)
+		b.delay("sendVideo", tt.delay)
+		media := &Media{user: "test", randomName: "abc", Path: "/tmp/abc.mp4", FileName: "abc.mp4", Title: "Cats"}
+
+		sent, err := sendMediaWithTimeout(context.Background(), b.Bot, 1, media, false, nil)
+
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: sendMediaWithTimeout error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if !tt.wantErr && sent == nil {
+			t.Errorf("%s: no sent message returned", tt.name)
+		}
+	}
+}
+
+func TestDeleteRequestMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      int
+		result  any
+		deletes int
+	}{
+		{"deleted", 42, nil, 1},
+		{"no rights", 42, botError{400, "Bad Request: message can't be deleted"}, 1},
+		{"no message id", 0, nil, 0},
+	}
+
+	for _, tt := range tests {
+		b := newTestBot(t)
+		if tt.result != nil {
+			b.respond("deleteMessage", tt.result)
+		}
+		msg := &models.Message{ID: tt.id, From: &models.User{Username: "alice"}, Chat: models.Chat{ID: 7}}
+
+		deleteRequestMessage(context.Background(), b.Bot, msg)
+
+		calls := b.calls("deleteMessage")
+		if len(calls) != tt.deletes {
+			t.Errorf("%s: %d deleteMessage calls, want %d", tt.name, len(calls), tt.deletes)
+			continue
+		}
+		if tt.deletes > 0 && (calls[0].fields["chat_id"] != "7" || calls[0].fields["message_id"] != "42") {
+			t.Errorf("%s: deleted %v", tt.name, calls[0].fields)
+		}
+		if texts := b.sentTexts(); len(texts) > 0 {
+			t.Errorf("%s: sent %q", tt.name, texts)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/mkevac/markodownloadbot/stats"
+)
+
+// settingsCallbackPrefix starts the data of the /settings buttons, which is
+// "s:<user ID>:<setting>:<value>". The user ID makes sure only the user
+// whose settings are shown can change them.
+const settingsCallbackPrefix = "s:"
+
+// Settings that can be changed with the buttons.
+const (
+	settingType       = "type"
+	settingResolution = "res"
+	settingLanguage   = "lang"
+	settingReset      = "reset"
+)
+
+// settingsResolutions are the resolutions offered as buttons. Any allowed
+// resolution works through /video<height>.
+var settingsResolutions = []int{480, 720, 1080, 2160}
+
+func encodeSettingsCallback(userID int64, setting string, value string) string {
+	return fmt.Sprintf("%s%d:%s:%s", settingsCallbackPrefix, userID, setting, value)
+}
+
+func decodeSettingsCallback(data string) (int64, string, string, bool) {
+	rest, ok := strings.CutPrefix(data, settingsCallbackPrefix)
+	if !ok {
+		return 0, "", "", false
+	}
+	parts := strings.SplitN(rest, ":", 3)
+	if len(parts) != 3 {
+		return 0, "", "", false
+	}
+	userID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", "", false
+	}
+	return userID, parts[1], parts[2], true
+}
+
+// applySetting changes one of prefs as a button asks to. It reports false
+// for values that aren't valid.
+func applySetting(prefs *stats.UserPrefs, setting string, value string) bool {
+	switch setting {
+	case settingType:
+		if value != qualityVideo && value != qualityAudio {
+			return false
+		}
+		prefs.MediaType = value
+	case settingResolution:
+		res, err := strconv.Atoi(value)
+		if err != nil || (res != 0 && !isAllowedResolution(res)) {
+			return false
+		}
+		prefs.Resolution = res
+	case settingLanguage:
+		if value == "" {
+			prefs.Language = ""
+			return true
+		}
+		lang, err := parseLanguage(value)
+		if err != nil {
+			return false
+		}
+		prefs.Language = lang
+	case settingReset:
+		*prefs = stats.UserPrefs{}
+	default:
+		return false
+	}
+	return true
+}
+
+// userOptions returns the download options for a plain link, which follow
+// the media type the user chose in /settings.
+func userOptions(user *models.User) DownloadOptions {
+	if user == nil || user.ID == 0 {
+		return DownloadOptions{}
+	}
+	return DownloadOptions{AudioOnly: stats.GetUserPrefs(user.ID).MediaType == qualityAudio}
+}
+
+// applyUserPrefs fills in the resolution and language the user chose in
+// /settings where the command didn't ask for its own.
+func applyUserPrefs(user *models.User, opts *DownloadOptions) {
+	if user == nil || user.ID == 0 {
+		return
+	}
+
+	prefs := stats.GetUserPrefs(user.ID)
+	if opts.Resolution == 0 && prefs.Resolution > 0 {
+		opts.Resolution = prefs.Resolution
+	}
+	if opts.Language == "" {
+		opts.Language = prefs.Language
+	}
+}
+
+// hasUserPrefs reports whether the user chose a media type or resolution,
+// in which case plain links skip the quality keyboard.
+func hasUserPrefs(user *models.User) bool {
+	if user == nil || user.ID == 0 {
+		return false
+	}
+	prefs := stats.GetUserPrefs(user.ID)
+	return prefs.MediaType != "" || prefs.Resolution != 0
+}
+
+func settingsText(prefs stats.UserPrefs) string {
+	mediaType := "video"
+	if prefs.MediaType != "" {
+		mediaType = prefs.MediaType
+	}
+	resolution := fmt.Sprintf("default (%dp)", getDefaultResolution())
+	if prefs.Resolution > 0 {
+		resolution = fmt.Sprintf("%dp", prefs.Resolution)
+	}
+	language := "any"
+	if prefs.Language != "" {
+		language = prefs.Language
+	}
+
+	return fmt.Sprintf("Your settings for plain links:\n\nType: %s\nResolution: %s\nAudio language: %s\n\n"+
+		"Commands like /audio or /video720 still do what they say. Send /settings lang <code> to prefer an audio language, e.g. /settings lang de.",
+		mediaType, resolution, language)
+}
+
+// settingsKeyboard has a button per value, the current ones marked.
+func settingsKeyboard(userID int64, prefs stats.UserPrefs) *models.InlineKeyboardMarkup {
+	button := func(text string, current bool, setting string, value string) models.InlineKeyboardButton {
+		if current {
+			text = "✓ " + text
+		}
+		return models.InlineKeyboardButton{Text: text, CallbackData: encodeSettingsCallback(userID, setting, value)}
+	}
+
+	types := []models.InlineKeyboardButton{
+		button("Video", prefs.MediaType != qualityAudio, settingType, qualityVideo),
+		button("Audio", prefs.MediaType == qualityAudio, settingType, qualityAudio),
+	}
+
+	resolutions := []models.InlineKeyboardButton{button("Default", prefs.Resolution == 0, settingResolution, "0")}
+	for _, res := range settingsResolutions {
+		resolutions = append(resolutions, button(fmt.Sprintf("%dp", res), prefs.Resolution == res, settingResolution, strconv.Itoa(res)))
+	}
+
+	other := []models.InlineKeyboardButton{
+		button("Any language", prefs.Language == "", settingLanguage, ""),
+		{Text: "Reset", CallbackData: encodeSettingsCallback(userID, settingReset, "")},
+	}
+
+	return &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{types, resolutions, other}}
+}
+
+func settingsHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil || update.Message.From == nil {
+		log.Println("Received settings command with nil Message")
+		return
+	}
+	userID := update.Message.From.ID
+	prefs := stats.GetUserPrefs(userID)
+
+	args := strings.Fields(strings.TrimPrefix(update.Message.Text, "/settings"))
+	if len(args) > 0 {
+		value := ""
+		if len(args) == 2 && args[0] == settingLanguage && args[1] != "any" {
+			value = args[1]
+		}
+		if len(args) != 2 || args[0] != settingLanguage || !applySetting(&prefs, settingLanguage, value) {
+			b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: update.Message.Chat.ID,
+				Text:   "Usage: /settings, or /settings lang <code> to prefer an audio language, e.g. /settings lang de. Use /settings lang any to clear it.",
+			})
+			return
+		}
+
+		if err := stats.SetUserPrefs(userID, prefs); err != nil {
+			log.Printf("[%s]: error saving settings: %s", update.Message.From.Username, err)
+			b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: update.Message.Chat.ID,
+				Text:   "I couldn't save your settings, please try again later.",
+			})
+			return
+		}
+		log.Printf("[%s]: audio language set to '%s'", update.Message.From.Username, prefs.Language)
+	}
+
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      update.Message.Chat.ID,
+		Text:        settingsText(prefs),
+		ReplyMarkup: settingsKeyboard(userID, prefs),
+	})
+}
+
+func settingsCallbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	query := update.CallbackQuery
+	if query == nil {
+		return
+	}
+
+	userID, setting, value, ok := decodeSettingsCallback(query.Data)
+	if !ok {
+		b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: query.ID})
+		return
+	}
+	if userID != query.From.ID {
+		b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            "These are someone else's settings, send /settings for yours.",
+		})
+		return
+	}
+
+	prefs := stats.GetUserPrefs(userID)
+	if !applySetting(&prefs, setting, value) {
+		b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: query.ID})
+		return
+	}
+
+	if err := stats.SetUserPrefs(userID, prefs); err != nil {
+		log.Printf("[%s]: error saving settings: %s", query.From.Username, err)
+		b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+			Text:            "I couldn't save your settings, please try again later.",
+			ShowAlert:       true,
+		})
+		return
+	}
+	log.Printf("[%s]: settings changed, %s = '%s'", query.From.Username, setting, value)
+
+	b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{CallbackQueryID: query.ID, Text: "Saved"})
+
+	if query.Message.Message != nil {
+		b.EditMessageText(ctx, &bot.EditMessageTextParams{
+			ChatID:      query.Message.Message.Chat.ID,
+			MessageID:   query.Message.Message.ID,
+			Text:        settingsText(prefs),
+			ReplyMarkup: settingsKeyboard(userID, prefs),
+		})
+	}
+}
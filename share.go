@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/google/uuid"
+)
+
+const (
+	shareAlso    = "also"
+	shareInstead = "instead"
+)
+
+// shares holds the files reachable through download links. It is nil when
+// SHARE_BASE_URL isn't set.
+var shares *shareStore
+
+type shareEntry struct {
+	path    string
+	name    string
+	expires time.Time
+}
+
+// shareStore keeps copies of downloaded files for a limited time and serves
+// them under /share/<token>/<name>. Only files registered in the store can
+// be fetched, unlike the plain file server for the temporary directory.
+type shareStore struct {
+	mu      sync.Mutex
+	dir     string
+	baseURL string
+	ttl     time.Duration
+	entries map[string]shareEntry
+}
+
+// newShareStore creates the store in dir. Leftovers from a previous run are
+// removed, since their tokens are gone.
+func newShareStore(dir string, baseURL string, ttl time.Duration) (*shareStore, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &shareStore{
+		dir:     dir,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		ttl:     ttl,
+		entries: make(map[string]shareEntry),
+	}, nil
+}
+
+// shareLink returns the public URL of a shared file.
+func shareLink(baseURL string, token string, name string) string {
+	return baseURL + "/share/" + token + "/" + url.PathEscape(name)
+}
+
+// Add copies the file at path into the store and returns its link and
+// expiry time.
+func (s *shareStore) Add(path string, name string, now time.Time) (string, time.Time, error) {
+	token := uuid.New().String()
+	dest := filepath.Join(s.dir, token+filepath.Ext(path))
+
+	if err := os.Link(path, dest); err != nil {
+		if err := copyFile(path, dest); err != nil {
+			return "", time.Time{}, fmt.Errorf("error copying file for sharing: %s", err)
+		}
+	}
+
+	entry := shareEntry{path: dest, name: name, expires: now.Add(s.ttl)}
+
+	s.mu.Lock()
+	s.entries[token] = entry
+	s.mu.Unlock()
+
+	return shareLink(s.baseURL, token, name), entry.expires, nil
+}
+
+// lookup returns the entry for token if it hasn't expired at now.
+func (s *shareStore) lookup(token string, now time.Time) (shareEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[token]
+	if !ok || !now.Before(entry.expires) {
+		return shareEntry{}, false
+	}
+	return entry, true
+}
+
+// removeExpired deletes the files whose links expired at now.
+func (s *shareStore) removeExpired(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for token, entry := range s.entries {
+		if now.Before(entry.expires) {
+			continue
+		}
+		if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error removing shared file '%s': %s", entry.path, err)
+		}
+		delete(s.entries, token)
+	}
+}
+
+// runJanitor removes expired files every interval until ctx is done.
+func (s *shareStore) runJanitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.removeExpired(now)
+		}
+	}
+}
+
+// ServeHTTP serves /share/<token>/<name> as an attachment.
+func (s *shareStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/share/"), "/")
+
+	entry, ok := s.lookup(token, time.Now())
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(entry.name)))
+	http.ServeFile(w, r, entry.path)
+}
+
+// sendShareLink shares the media file and posts the link to the chat. It
+// reports whether the link was sent.
+func sendShareLink(ctx context.Context, b *bot.Bot, chatID int64, media *Media) bool {
+	name := archiveName(media.Title, media.randomName) + filepath.Ext(media.Path)
+
+	link, expires, err := shares.Add(media.Path, name, time.Now())
+	if err != nil {
+		log.Printf("[%s]: %s", media.user, err)
+		return false
+	}
+
+	_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   fmt.Sprintf("Download link, valid until %s:\n%s", expires.UTC().Format("2006-01-02 15:04 MST"), link),
+	})
+	if err != nil {
+		log.Printf("[%s]: error sending download link: %s", media.user, err)
+		return false
+	}
+
+	log.Printf("[%s]: download link sent", media.user)
+	return true
+}
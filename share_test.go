@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShareLink(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"video.mp4", "https://example.com/share/abc/video.mp4"},
+		{"my video.mp4", "https://example.com/share/abc/my%20video.mp4"},
+		{"Кошки?.mp4", "https://example.com/share/abc/%D0%9A%D0%BE%D1%88%D0%BA%D0%B8%3F.mp4"},
+	}
+
+	for _, tt := range tests {
+		if got := shareLink("https://example.com", "abc", tt.name); got != tt.want {
+			t.Errorf("shareLink(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// newTestShareStore creates a store with a one hour TTL and a file to share.
+func newTestShareStore(t *testing.T) (*shareStore, string) {
+	t.Helper()
+
+	s, err := newShareStore(filepath.Join(t.TempDir(), "share"), "https://example.com/", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "abc.mp4")
+	if err := os.WriteFile(path, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return s, path
+}
+
+// shareToken extracts the token from a share link.
+func shareToken(link string) string {
+	token, _, _ := strings.Cut(strings.TrimPrefix(link, "https://example.com/share/"), "/")
+	return token
+}
+
+func TestShareStoreExpiry(t *testing.T) {
+	s, path := newTestShareStore(t)
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	link, expires, err := s.Add(path, "Cats.mp4", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(link, "https://example.com/share/") || !strings.HasSuffix(link, "/Cats.mp4") {
+		t.Errorf("link = %q", link)
+	}
+	if want := now.Add(time.Hour); !expires.Equal(want) {
+		t.Errorf("expires = %s, want %s", expires, want)
+	}
+	token := shareToken(link)
+
+	tests := []struct {
+		at   time.Duration
+		want bool
+	}{
+		{0, true},
+		{59 * time.Minute, true},
+		{time.Hour, false},
+		{2 * time.Hour, false},
+	}
+	for _, tt := range tests {
+		if _, ok := s.lookup(token, now.Add(tt.at)); ok != tt.want {
+			t.Errorf("lookup after %s = %v, want %v", tt.at, ok, tt.want)
+		}
+	}
+	if _, ok := s.lookup("unknown", now); ok {
+		t.Error("lookup of an unknown token succeeded")
+	}
+
+	entry, _ := s.lookup(token, now)
+	s.removeExpired(now.Add(30 * time.Minute))
+	if _, err := os.Stat(entry.path); err != nil {
+		t.Errorf("file removed before it expired: %s", err)
+	}
+	s.removeExpired(now.Add(time.Hour))
+	if _, err := os.Stat(entry.path); !os.IsNotExist(err) {
+		t.Errorf("expired file kept: %v", err)
+	}
+	if len(s.entries) != 0 {
+		t.Errorf("%d entries left after expiry", len(s.entries))
+	}
+
+	// the original download is untouched
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("shared file's source removed: %s", err)
+	}
+}
+
+func TestShareStoreServeHTTP(t *testing.T) {
+	s, path := newTestShareStore(t)
+
+	link, _, err := s.Add(path, "my video.mp4", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, _, err := s.Add(path, "old.mp4", time.Now().Add(-2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/share/" + shareToken(link) + "/my%20video.mp4", http.StatusOK},
+		{"/share/" + shareToken(link) + "/any-name.mp4", http.StatusOK},
+		{"/share/" + shareToken(expired) + "/old.mp4", http.StatusNotFound},
+		{"/share/unknown/video.mp4", http.StatusNotFound},
+		{"/share/../abc.mp4", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com"+tt.path, nil))
+
+		if w.Code != tt.status {
+			t.Errorf("GET %s: status %d, want %d", tt.path, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		if w.Body.String() != "video" {
+			t.Errorf("GET %s: body %q", tt.path, w.Body.String())
+		}
+		if got, want := w.Header().Get("Content-Disposition"), "attachment; filename*=UTF-8''my%20video.mp4"; got != want {
+			t.Errorf("GET %s: Content-Disposition %q, want %q", tt.path, got, want)
+		}
+	}
+}
+
+func TestNewShareStoreRemovesLeftovers(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "share")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "old.mp4"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newShareStore(dir, "https://example.com", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("share directory after start = %v, %v, want it empty", entries, err)
+	}
+}
+
+func TestSendShareLink(t *testing.T) {
+	oldShares := shares
+	defer func() { shares = oldShares }()
+
+	var path string
+	shares, path = newTestShareStore(t)
+	b := newTestBot(t)
+	media := &Media{user: "test", randomName: "abc", Path: path, Title: "Cats / Dogs"}
+
+	if !sendShareLink(context.Background(), b.Bot, 1, media) {
+		t.Fatal("sendShareLink failed")
+	}
+
+	texts := b.sentTexts()
+	if len(texts) != 1 || !strings.HasPrefix(texts[0], "Download link, valid until ") || !strings.HasSuffix(texts[0], "/Cats%20_%20Dogs.mp4") {
+		t.Errorf("sent %q", texts)
+	}
+
+	media.Path = filepath.Join(t.TempDir(), "missing.mp4")
+	if sendShareLink(context.Background(), b.Bot, 1, media) {
+		t.Error("sendShareLink succeeded for a missing file")
+	}
+}
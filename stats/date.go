@@ -0,0 +1,55 @@
+package stats
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ParseDateRange turns a "YYYY-MM-DD" or "YYYY-MM" argument into the
+// [from, to) bounds of that day or month in UTC. Two such dates separated
+// by a space give the range from the start of the first period to the end
+// of the second, both included. Periods that start after now are rejected.
+func ParseDateRange(arg string, now time.Time) (time.Time, time.Time, error) {
+	fields := strings.Fields(arg)
+	switch len(fields) {
+	case 1:
+		return parsePeriod(fields[0], now)
+	case 2:
+		from, _, err := parsePeriod(fields[0], now)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		end, to, err := parsePeriod(fields[1], now)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		if end.Before(from) {
+			return time.Time{}, time.Time{}, fmt.Errorf("end date '%s' is before start date '%s'", fields[1], fields[0])
+		}
+		return from, to, nil
+	default:
+		return time.Time{}, time.Time{}, fmt.Errorf("expected one or two dates")
+	}
+}
+
+// parsePeriod returns the bounds of a single day or month.
+func parsePeriod(arg string, now time.Time) (time.Time, time.Time, error) {
+	var from, to time.Time
+
+	if t, err := time.Parse("2006-01-02", arg); err == nil {
+		from = t
+		to = t.AddDate(0, 0, 1)
+	} else if t, err := time.Parse("2006-01", arg); err == nil {
+		from = t
+		to = t.AddDate(0, 1, 0)
+	} else {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid date '%s', expected YYYY-MM-DD or YYYY-MM", arg)
+	}
+
+	if from.After(now.UTC()) {
+		return time.Time{}, time.Time{}, fmt.Errorf("date '%s' is in the future", arg)
+	}
+
+	return from, to, nil
+}
@@ -0,0 +1,49 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDateRange(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	day := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		arg     string
+		from    time.Time
+		to      time.Time
+		wantErr bool
+	}{
+		{"2024-03-01", day(2024, 3, 1), day(2024, 3, 2), false},
+		{"2024-02", day(2024, 2, 1), day(2024, 3, 1), false},
+		{"2023-12", day(2023, 12, 1), day(2024, 1, 1), false},
+		{"2024-02-29", day(2024, 2, 29), day(2024, 3, 1), false},
+		{"2024-03-15", day(2024, 3, 15), day(2024, 3, 16), false},
+		{"2024-03", day(2024, 3, 1), day(2024, 4, 1), false},
+		{"2024-01 2024-02", day(2024, 1, 1), day(2024, 3, 1), false},
+		{"2024-01-10 2024-01-20", day(2024, 1, 10), day(2024, 1, 21), false},
+		{"2024-01 2024-01-05", day(2024, 1, 1), day(2024, 1, 6), false},
+		{"  2024-03-01  ", day(2024, 3, 1), day(2024, 3, 2), false},
+		{"2024-03-16", time.Time{}, time.Time{}, true},
+		{"2024-04", time.Time{}, time.Time{}, true},
+		{"2024-02 2024-01", time.Time{}, time.Time{}, true},
+		{"2024-01 2024-05", time.Time{}, time.Time{}, true},
+		{"2023-02-30", time.Time{}, time.Time{}, true},
+		{"2024/03/01", time.Time{}, time.Time{}, true},
+		{"yesterday", time.Time{}, time.Time{}, true},
+		{"", time.Time{}, time.Time{}, true},
+		{"2024-01 2024-02 2024-03", time.Time{}, time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		from, to, err := ParseDateRange(tt.arg, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseDateRange(%q) error = %v, want error %v", tt.arg, err, tt.wantErr)
+			continue
+		}
+		if !from.Equal(tt.from) || !to.Equal(tt.to) {
+			t.Errorf("ParseDateRange(%q) = %s, %s, want %s, %s", tt.arg, from, to, tt.from, tt.to)
+		}
+	}
+}
@@ -1,21 +1,39 @@
 package stats
 
 import (
+	"context"
 	"database/sql"
+	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
 
+// timestampLayout matches how SQLite's CURRENT_TIMESTAMP stores event
+// times, in UTC.
+const timestampLayout = "2006-01-02 15:04:05"
+
 var (
 	db      *sql.DB
 	once    sync.Once
 	dirBase string
+
+	// writeMu is held for reading by every write, so Close can wait for
+	// the writes in flight. Writes after Close fail with errClosed.
+	writeMu sync.RWMutex
+	closed  bool
 )
 
+var errClosed = errors.New("stats database is closed")
+
 // Init initializes the stats package with the given base directory
 func Init(dir string) {
 	dirBase = dir
@@ -28,11 +46,16 @@ func initDB() {
 
 	dbPath := filepath.Join(dirBase, "stats.db")
 
+	// busy_timeout applies per connection, so it goes in the DSN. WAL lets
+	// readers run during a write and is kept in the database file.
 	var err error
-	db, err = sql.Open("sqlite", dbPath)
+	db, err = sql.Open("sqlite", dbPath+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
 	if err != nil {
 		log.Fatalf("Error opening database: %v", err)
 	}
+	// SQLite allows one writer at a time, a single connection queues writes
+	// in Go instead of failing them with "database is locked"
+	db.SetMaxOpenConns(1)
 
 	_, err = db.Exec(`
 		CREATE TABLE IF NOT EXISTS events (
@@ -45,6 +68,145 @@ func initDB() {
 	if err != nil {
 		log.Fatalf("Error creating events table: %v", err)
 	}
+
+	if err := addColumnIfMissing(db, "events", "file_hash", "TEXT"); err != nil {
+		log.Fatalf("Error adding file_hash column: %v", err)
+	}
+	// the stats queries filter by time, and the download totals also by type
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_events_timestamp ON events (timestamp)"); err != nil {
+		log.Fatalf("Error creating events timestamp index: %v", err)
+	}
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_events_type_timestamp ON events (event_type, timestamp)"); err != nil {
+		log.Fatalf("Error creating events type index: %v", err)
+	}
+
+	if err := addColumnIfMissing(db, "events", "duration_ms", "INTEGER"); err != nil {
+		log.Fatalf("Error adding duration_ms column: %v", err)
+	}
+	if err := addColumnIfMissing(db, "events", "file_size", "INTEGER"); err != nil {
+		log.Fatalf("Error adding file_size column: %v", err)
+	}
+	if err := addColumnIfMissing(db, "events", "domain", "TEXT"); err != nil {
+		log.Fatalf("Error adding domain column: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS config (
+			key TEXT PRIMARY KEY,
+			value TEXT
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Error creating config table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS pending_requests (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat_id INTEGER,
+			username TEXT,
+			url TEXT,
+			mode TEXT,
+			status TEXT DEFAULT 'pending',
+			created DATETIME DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Error creating pending_requests table: %v", err)
+	}
+	if err := addColumnIfMissing(db, "pending_requests", "user_id", "INTEGER"); err != nil {
+		log.Fatalf("Error adding user_id column: %v", err)
+	}
+	if err := addColumnIfMissing(db, "pending_requests", "options", "TEXT"); err != nil {
+		log.Fatalf("Error adding options column: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_prefs (
+			user_id INTEGER PRIMARY KEY,
+			resolution INTEGER,
+			media_type TEXT,
+			language TEXT,
+			updated DATETIME DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Error creating user_prefs table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS users (
+			user_id INTEGER PRIMARY KEY,
+			chat_id INTEGER,
+			username TEXT,
+			first_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_seen DATETIME DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Error creating users table: %v", err)
+	}
+}
+
+// addColumnIfMissing adds a column to a table created by an older version
+// of the bot. SQLite has no ADD COLUMN IF NOT EXISTS.
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// closeDB stops new writes, waits up to timeout for the ones in flight and
+// closes the database.
+func closeDB(timeout time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		writeMu.Lock()
+		closed = true
+		writeMu.Unlock()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		return fmt.Errorf("timed out after %s waiting for pending writes", timeout)
+	}
+
+	if db == nil {
+		return nil
+	}
+	return db.Close()
+}
+
+func ping(ctx context.Context) error {
+	var one int
+	return getDB().QueryRowContext(ctx, "SELECT 1").Scan(&one)
 }
 
 func getDB() *sql.DB {
@@ -52,29 +214,268 @@ func getDB() *sql.DB {
 	return db
 }
 
-func addEvent(username, eventType string) error {
-	_, err := getDB().Exec("INSERT INTO events (username, event_type) VALUES (?, ?)", username, eventType)
+// busyAttempts is how many times a write that found the database locked
+// is tried, on top of the busy_timeout wait.
+const busyAttempts = 3
+
+// retryBusy runs write again when SQLite reports the database as locked,
+// which can happen when another process holds it.
+func retryBusy(write func() error) error {
+	var err error
+	for attempt := 1; attempt <= busyAttempts; attempt++ {
+		err = write()
+		if err == nil || !isBusy(err) {
+			return err
+		}
+		time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+	}
 	return err
 }
 
-func getStats(period string) (*Stats, error) {
-	stats := &Stats{
-		VideoRequests:        make(map[string]int),
-		AudioRequests:        make(map[string]int),
-		DownloadErrors:       make(map[string]int),
-		UnrecognizedCommands: make(map[string]int),
+func isBusy(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "SQLITE_BUSY")
+}
+
+func addEvent(username, eventType, domain string) error {
+	writeMu.RLock()
+	defer writeMu.RUnlock()
+	if closed {
+		return errClosed
+	}
+
+	return retryBusy(func() error {
+		_, err := getDB().Exec("INSERT INTO events (username, event_type, domain) VALUES (?, ?, NULLIF(?, ''))", username, eventType, domain)
+		return err
+	})
+}
+
+func addFileEvent(username, eventType, fileHash string) error {
+	writeMu.RLock()
+	defer writeMu.RUnlock()
+	if closed {
+		return errClosed
+	}
+
+	return retryBusy(func() error {
+		_, err := getDB().Exec("INSERT INTO events (username, event_type, file_hash) VALUES (?, ?, ?)", username, eventType, fileHash)
+		return err
+	})
+}
+
+func addDownloadEvent(username, mediaType, domain string, durationMs, fileSize int64) error {
+	writeMu.RLock()
+	defer writeMu.RUnlock()
+	if closed {
+		return errClosed
+	}
+
+	return retryBusy(func() error {
+		_, err := getDB().Exec("INSERT INTO events (username, event_type, domain, duration_ms, file_size) VALUES (?, ?, NULLIF(?, ''), ?, ?)",
+			username, "download:"+mediaType, domain, durationMs, fileSize)
+		return err
+	})
+}
+
+func exportCSV(w io.Writer) error {
+	rows, err := getDB().Query("SELECT id, username, event_type, strftime('%Y-%m-%d %H:%M:%S', timestamp) FROM events ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"id", "username", "event_type", "timestamp"}); err != nil {
+		return err
 	}
 
-	var timeConstraint string
+	for rows.Next() {
+		var id int64
+		var username, eventType sql.NullString
+		var timestamp string
+		if err := rows.Scan(&id, &username, &eventType, &timestamp); err != nil {
+			return err
+		}
+		if err := cw.Write([]string{strconv.FormatInt(id, 10), username.String, eventType.String, timestamp}); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// pruneBatchSize is how many events one delete removes, so inserts only
+// wait for a short transaction.
+const pruneBatchSize = 1000
+
+// pruneEvents deletes the events older than before and returns how many.
+func pruneEvents(before time.Time) (int64, error) {
+	cutoff := before.UTC().Format(timestampLayout)
+
+	var total int64
+	for {
+		n, err := pruneBatch(cutoff)
+		total += n
+		if err != nil || n < pruneBatchSize {
+			return total, err
+		}
+	}
+}
+
+func pruneBatch(cutoff string) (int64, error) {
+	writeMu.RLock()
+	defer writeMu.RUnlock()
+	if closed {
+		return 0, errClosed
+	}
+
+	res, err := getDB().Exec("DELETE FROM events WHERE id IN (SELECT id FROM events WHERE timestamp < ? LIMIT ?)", cutoff, pruneBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func getConfig(key string) (string, error) {
+	var value string
+	err := getDB().QueryRow("SELECT value FROM config WHERE key = ?", key).Scan(&value)
+	return value, err
+}
+
+func setConfig(key, value string) error {
+	writeMu.RLock()
+	defer writeMu.RUnlock()
+	if closed {
+		return errClosed
+	}
+
+	_, err := getDB().Exec("INSERT INTO config (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value", key, value)
+	return err
+}
+
+func upsertUser(userID, chatID int64, username string) error {
+	writeMu.RLock()
+	defer writeMu.RUnlock()
+	if closed {
+		return errClosed
+	}
+
+	_, err := getDB().Exec(`
+		INSERT INTO users (user_id, chat_id, username) VALUES (?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			chat_id = excluded.chat_id,
+			username = excluded.username,
+			last_seen = CURRENT_TIMESTAMP`,
+		userID, chatID, username)
+	return err
+}
+
+func getChatID(userID int64) (int64, error) {
+	var chatID int64
+	err := getDB().QueryRow("SELECT chat_id FROM users WHERE user_id = ?", userID).Scan(&chatID)
+	return chatID, err
+}
+
+func getUserPrefs(userID int64) (UserPrefs, error) {
+	var prefs UserPrefs
+	var resolution sql.NullInt64
+	var mediaType, language sql.NullString
+	err := getDB().QueryRow("SELECT resolution, media_type, language FROM user_prefs WHERE user_id = ?", userID).Scan(&resolution, &mediaType, &language)
+	if err != nil {
+		return prefs, err
+	}
+	prefs.Resolution = int(resolution.Int64)
+	prefs.MediaType = mediaType.String
+	prefs.Language = language.String
+	return prefs, nil
+}
+
+func setUserPrefs(userID int64, prefs UserPrefs) error {
+	writeMu.RLock()
+	defer writeMu.RUnlock()
+	if closed {
+		return errClosed
+	}
+
+	_, err := getDB().Exec(`
+		INSERT INTO user_prefs (user_id, resolution, media_type, language) VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			resolution = excluded.resolution,
+			media_type = excluded.media_type,
+			language = excluded.language,
+			updated = CURRENT_TIMESTAMP`,
+		userID, prefs.Resolution, prefs.MediaType, prefs.Language)
+	return err
+}
+
+// periodConstraint returns the condition on timestamp for a period name.
+// Any other name means all time.
+func periodConstraint(period string) string {
 	switch period {
 	case "day":
-		timeConstraint = "AND timestamp >= datetime('now', '-1 day')"
+		return "AND timestamp >= datetime('now', '-1 day')"
 	case "week":
-		timeConstraint = "AND timestamp >= datetime('now', '-7 days')"
+		return "AND timestamp >= datetime('now', '-7 days')"
 	case "month":
-		timeConstraint = "AND timestamp >= datetime('now', '-1 month')"
+		return "AND timestamp >= datetime('now', '-1 month')"
 	default:
-		timeConstraint = ""
+		return ""
+	}
+}
+
+func getStats(period string) (*Stats, error) {
+	return queryStats(periodConstraint(period))
+}
+
+// getDomainStats totals the events of period per domain, most requested
+// first. Events recorded before the domain column existed are left out.
+func getDomainStats(period string) ([]DomainStats, error) {
+	query := fmt.Sprintf(`
+		SELECT domain,
+			   SUM(CASE WHEN event_type IN ('video_request', 'audio_request') THEN 1 ELSE 0 END) as requests,
+			   SUM(CASE WHEN event_type = 'download_error' THEN 1 ELSE 0 END) as download_errors,
+			   SUM(CASE WHEN event_type = 'file_too_large' THEN 1 ELSE 0 END) as files_too_large,
+			   COALESCE(SUM(CASE WHEN event_type LIKE 'download:%%' THEN file_size END), 0) as bytes_served
+		FROM events
+		WHERE domain IS NOT NULL %s
+		GROUP BY domain
+		ORDER BY requests DESC, domain
+	`, periodConstraint(period))
+
+	rows, err := getDB().Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var domains []DomainStats
+	for rows.Next() {
+		var d DomainStats
+		if err := rows.Scan(&d.Domain, &d.Requests, &d.DownloadErrors, &d.FilesTooLarge, &d.BytesServed); err != nil {
+			return nil, err
+		}
+		domains = append(domains, d)
+	}
+	return domains, rows.Err()
+}
+
+// getStatsRange returns the stats for events in [from, to).
+func getStatsRange(from, to time.Time) (*Stats, error) {
+	return queryStats("AND timestamp >= ? AND timestamp < ?",
+		from.UTC().Format(timestampLayout), to.UTC().Format(timestampLayout))
+}
+
+func queryStats(timeConstraint string, args ...any) (*Stats, error) {
+	stats := &Stats{
+		VideoRequests:        make(map[string]int),
+		AudioRequests:        make(map[string]int),
+		DownloadErrors:       make(map[string]int),
+		UnrecognizedCommands: make(map[string]int),
+		FilesTooLarge:        make(map[string]int),
 	}
 
 	query := fmt.Sprintf(`
@@ -82,13 +483,14 @@ func getStats(period string) (*Stats, error) {
 			   SUM(CASE WHEN event_type = 'video_request' THEN 1 ELSE 0 END) as video_requests,
 			   SUM(CASE WHEN event_type = 'audio_request' THEN 1 ELSE 0 END) as audio_requests,
 			   SUM(CASE WHEN event_type = 'download_error' THEN 1 ELSE 0 END) as download_errors,
-			   SUM(CASE WHEN event_type = 'unrecognized_command' THEN 1 ELSE 0 END) as unrecognized_commands
+			   SUM(CASE WHEN event_type = 'unrecognized_command' THEN 1 ELSE 0 END) as unrecognized_commands,
+			   SUM(CASE WHEN event_type = 'file_too_large' THEN 1 ELSE 0 END) as files_too_large
 		FROM events
-		WHERE 1=1 %s
+		WHERE event_type IN ('video_request', 'audio_request', 'download_error', 'unrecognized_command', 'file_too_large') %s
 		GROUP BY username
 	`, timeConstraint)
 
-	rows, err := getDB().Query(query)
+	rows, err := getDB().Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -96,8 +498,8 @@ func getStats(period string) (*Stats, error) {
 
 	for rows.Next() {
 		var username string
-		var videoRequests, audioRequests, downloadErrors, unrecognizedCommands int
-		err := rows.Scan(&username, &videoRequests, &audioRequests, &downloadErrors, &unrecognizedCommands)
+		var videoRequests, audioRequests, downloadErrors, unrecognizedCommands, filesTooLarge int
+		err := rows.Scan(&username, &videoRequests, &audioRequests, &downloadErrors, &unrecognizedCommands, &filesTooLarge)
 		if err != nil {
 			return nil, err
 		}
@@ -106,7 +508,161 @@ func getStats(period string) (*Stats, error) {
 		stats.AudioRequests[username] = audioRequests
 		stats.DownloadErrors[username] = downloadErrors
 		stats.UnrecognizedCommands[username] = unrecognizedCommands
+		stats.FilesTooLarge[username] = filesTooLarge
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// rows from before duration_ms and file_size existed have NULLs, which
+	// COUNT, AVG and SUM skip
+	var avgMs sql.NullFloat64
+	var bytes sql.NullInt64
+	query = fmt.Sprintf(`
+		SELECT COUNT(duration_ms), AVG(duration_ms), SUM(file_size)
+		FROM events
+		WHERE event_type LIKE 'download:%%' %s
+	`, timeConstraint)
+	if err := getDB().QueryRow(query, args...).Scan(&stats.Downloads, &avgMs, &bytes); err != nil {
+		return nil, err
 	}
+	stats.AvgDownloadTime = time.Duration(avgMs.Float64 * float64(time.Millisecond))
+	stats.BytesServed = bytes.Int64
 
 	return stats, nil
 }
+
+// pruneRequests deletes the finished requests created before before and
+// returns how many. Requests still waiting or running are kept.
+func pruneRequests(before time.Time) (int64, error) {
+	writeMu.RLock()
+	defer writeMu.RUnlock()
+	if closed {
+		return 0, errClosed
+	}
+
+	res, err := getDB().Exec("DELETE FROM pending_requests WHERE created < ? AND status NOT IN (?, ?)",
+		before.UTC().Format(timestampLayout), RequestPending, RequestStarted)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func addPendingRequest(chatID, userID int64, username, url, mode, options string) (int64, error) {
+	writeMu.RLock()
+	defer writeMu.RUnlock()
+	if closed {
+		return 0, errClosed
+	}
+
+	res, err := getDB().Exec("INSERT INTO pending_requests (chat_id, user_id, username, url, mode, options) VALUES (?, ?, ?, ?, ?, ?)", chatID, userID, username, url, mode, options)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func setRequestStatus(id int64, status string) error {
+	writeMu.RLock()
+	defer writeMu.RUnlock()
+	if closed {
+		return errClosed
+	}
+
+	_, err := getDB().Exec("UPDATE pending_requests SET status = ? WHERE id = ?", status, id)
+	return err
+}
+
+// takePendingRequests returns the requests that never started and marks
+// them resumed, so they are picked up only once. Requests that started but
+// never finished are marked failed.
+func takePendingRequests() ([]PendingRequest, error) {
+	writeMu.RLock()
+	defer writeMu.RUnlock()
+	if closed {
+		return nil, errClosed
+	}
+
+	tx, err := getDB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id, chat_id, COALESCE(user_id, 0), username, url, mode, COALESCE(options, '') FROM pending_requests WHERE status = ? ORDER BY id", RequestPending)
+	if err != nil {
+		return nil, err
+	}
+
+	var requests []PendingRequest
+	for rows.Next() {
+		var r PendingRequest
+		if err := rows.Scan(&r.ID, &r.ChatID, &r.UserID, &r.Username, &r.URL, &r.Mode, &r.Options); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		requests = append(requests, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("UPDATE pending_requests SET status = ? WHERE status = ?", RequestResumed, RequestPending); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("UPDATE pending_requests SET status = ? WHERE status = ?", RequestFailed, RequestStarted); err != nil {
+		return nil, err
+	}
+
+	return requests, tx.Commit()
+}
+
+// requestColumns are the pending_requests columns scanRequest reads.
+// Requests saved before user_id and options were added have neither.
+const requestColumns = "id, chat_id, COALESCE(user_id, 0), username, url, mode, COALESCE(options, ''), status, strftime('%s', created)"
+
+// requestHistory returns the latest requests of the user, newest first, or
+// of everyone when everyone is set.
+func requestHistory(userID int64, everyone bool, limit int) ([]PendingRequest, error) {
+	query := "SELECT " + requestColumns + " FROM pending_requests"
+	var args []any
+	if !everyone {
+		query += " WHERE user_id = ?"
+		args = append(args, userID)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := getDB().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []PendingRequest
+	for rows.Next() {
+		r, err := scanRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, r)
+	}
+	return requests, rows.Err()
+}
+
+func getRequest(id int64) (PendingRequest, error) {
+	row := getDB().QueryRow("SELECT "+requestColumns+" FROM pending_requests WHERE id = ?", id)
+	return scanRequest(row)
+}
+
+func scanRequest(row interface{ Scan(...any) error }) (PendingRequest, error) {
+	var r PendingRequest
+	var created int64
+	if err := row.Scan(&r.ID, &r.ChatID, &r.UserID, &r.Username, &r.URL, &r.Mode, &r.Options, &r.Status, &created); err != nil {
+		return PendingRequest{}, err
+	}
+	r.Created = time.Unix(created, 0)
+	return r, nil
+}
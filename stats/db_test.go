@@ -0,0 +1,462 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// openTestDB points the package at a new database in a temporary directory
+// and closes it when the test ends.
+func openTestDB(t testing.TB) {
+	t.Helper()
+
+	dirBase = t.TempDir()
+	db = nil
+	once = sync.Once{}
+	closed = false
+	getDB()
+
+	t.Cleanup(func() {
+		if err := closeDB(5 * time.Second); err != nil {
+			t.Errorf("closing test database: %s", err)
+		}
+	})
+}
+
+func TestPruneRequests(t *testing.T) {
+	openTestDB(t)
+
+	old := time.Now().Add(-100 * 24 * time.Hour).UTC().Format(timestampLayout)
+	tests := []struct {
+		status string
+		old    bool
+		kept   bool
+	}{
+		{RequestDone, true, false},
+		{RequestFailed, true, false},
+		{RequestResumed, true, false},
+		{RequestPending, true, true},
+		{RequestStarted, true, true},
+		{RequestDone, false, true},
+		{RequestFailed, false, true},
+	}
+
+	ids := make([]int64, len(tests))
+	for i, tt := range tests {
+		id, err := addPendingRequest(1, 1, "user", "https://example.com", "video", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := setRequestStatus(id, tt.status); err != nil {
+			t.Fatal(err)
+		}
+		if tt.old {
+			if _, err := getDB().Exec("UPDATE pending_requests SET created = ? WHERE id = ?", old, id); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ids[i] = id
+	}
+
+	n, err := pruneRequests(time.Now().Add(-90 * 24 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("pruned %d requests, want 3", n)
+	}
+
+	for i, tt := range tests {
+		_, err := getRequest(ids[i])
+		if kept := err == nil; kept != tt.kept {
+			t.Errorf("%s request, old %v: kept = %v, want %v", tt.status, tt.old, kept, tt.kept)
+		}
+	}
+}
+
+func TestPruneRequestsAfterClose(t *testing.T) {
+	openTestDB(t)
+	if err := closeDB(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pruneRequests(time.Now()); err != errClosed {
+		t.Errorf("pruneRequests after close = %v, want %v", err, errClosed)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	openTestDB(t)
+
+	if value, ok := GetConfig("missing"); ok || value != "" {
+		t.Errorf("GetConfig(missing) = %q, %v, want not found", value, ok)
+	}
+
+	for _, value := range []string{"720", "1080"} {
+		if err := SetConfig("default_resolution", value); err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := GetConfig("default_resolution"); !ok || got != value {
+			t.Errorf("GetConfig after SetConfig(%q) = %q, %v", value, got, ok)
+		}
+	}
+
+	if err := closeDB(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetConfig("default_resolution", "480"); err != errClosed {
+		t.Errorf("SetConfig after close = %v, want %v", err, errClosed)
+	}
+}
+
+// addEventAt records an event as if it happened at ts.
+func addEventAt(t *testing.T, username, eventType string, ts time.Time) {
+	t.Helper()
+
+	if err := addEvent(username, eventType, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getDB().Exec("UPDATE events SET timestamp = ? WHERE id = (SELECT MAX(id) FROM events)", ts.UTC().Format(timestampLayout)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// nonZero drops the users without events from a Stats count.
+func nonZero(counts map[string]int) map[string]int {
+	res := make(map[string]int)
+	for username, n := range counts {
+		if n != 0 {
+			res[username] = n
+		}
+	}
+	return res
+}
+
+func TestGetStatsRange(t *testing.T) {
+	openTestDB(t)
+
+	day := func(d int, hour int) time.Time { return time.Date(2024, 3, d, hour, 0, 0, 0, time.UTC) }
+	addEventAt(t, "alice", "video_request", day(1, 0))
+	addEventAt(t, "alice", "video_request", day(1, 23))
+	addEventAt(t, "alice", "audio_request", day(2, 0))
+	addEventAt(t, "bob", "download_error", day(2, 12))
+	addEventAt(t, "bob", "video_request", day(3, 0))
+
+	tests := []struct {
+		from, to time.Time
+		video    map[string]int
+		audio    map[string]int
+		errors   map[string]int
+	}{
+		{day(1, 0), day(2, 0), map[string]int{"alice": 2}, map[string]int{}, map[string]int{}},
+		{day(2, 0), day(3, 0), map[string]int{}, map[string]int{"alice": 1}, map[string]int{"bob": 1}},
+		{day(1, 0), day(4, 0), map[string]int{"alice": 2, "bob": 1}, map[string]int{"alice": 1}, map[string]int{"bob": 1}},
+		{day(4, 0), day(5, 0), map[string]int{}, map[string]int{}, map[string]int{}},
+	}
+
+	for _, tt := range tests {
+		stats, err := getStatsRange(tt.from, tt.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(nonZero(stats.VideoRequests)) != fmt.Sprint(tt.video) ||
+			fmt.Sprint(nonZero(stats.AudioRequests)) != fmt.Sprint(tt.audio) ||
+			fmt.Sprint(nonZero(stats.DownloadErrors)) != fmt.Sprint(tt.errors) {
+			t.Errorf("getStatsRange(%s, %s) = video %v, audio %v, errors %v, want %v, %v, %v",
+				tt.from, tt.to, stats.VideoRequests, stats.AudioRequests, stats.DownloadErrors, tt.video, tt.audio, tt.errors)
+		}
+	}
+}
+
+func TestCloseWaitsForWrites(t *testing.T) {
+	openTestDB(t)
+
+	// a write in flight
+	writeMu.RLock()
+
+	done := make(chan error)
+	go func() { done <- closeDB(5 * time.Second) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("closeDB returned %v while a write was in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	writeMu.RUnlock()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := addEvent("alice", "video_request", ""); err != errClosed {
+		t.Errorf("addEvent after close = %v, want %v", err, errClosed)
+	}
+}
+
+func TestCloseTimeout(t *testing.T) {
+	openTestDB(t)
+
+	writeMu.RLock()
+	err := closeDB(20 * time.Millisecond)
+	writeMu.RUnlock()
+
+	if err == nil {
+		t.Error("closeDB didn't time out with a write in flight")
+	}
+}
+
+func TestTakePendingRequests(t *testing.T) {
+	openTestDB(t)
+
+	tests := []struct {
+		username string
+		status   string
+		taken    bool
+		after    string
+	}{
+		{"alice", RequestPending, true, RequestResumed},
+		{"bob", RequestStarted, false, RequestFailed},
+		{"carol", RequestDone, false, RequestDone},
+		{"dave", RequestPending, true, RequestResumed},
+	}
+
+	ids := make([]int64, len(tests))
+	for i, tt := range tests {
+		id, err := addPendingRequest(int64(i+1), int64(i+1), tt.username, "https://example.com/"+tt.username, "audio", `{"lang":"`+tt.username+`"}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.status != RequestPending {
+			if err := setRequestStatus(id, tt.status); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ids[i] = id
+	}
+
+	requests, err := takePendingRequests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var taken []string
+	for _, r := range requests {
+		taken = append(taken, r.Username)
+		if r.URL != "https://example.com/"+r.Username || r.Mode != "audio" || r.UserID != r.ChatID || r.Options != `{"lang":"`+r.Username+`"}` {
+			t.Errorf("request of %s: url %q, mode %q, user %d, options %q", r.Username, r.URL, r.Mode, r.UserID, r.Options)
+		}
+	}
+	if fmt.Sprint(taken) != "[alice dave]" {
+		t.Errorf("took requests of %v, want [alice dave]", taken)
+	}
+
+	for i, tt := range tests {
+		r, err := getRequest(ids[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Status != tt.after {
+			t.Errorf("%s request: status %q after taking, want %q", tt.status, r.Status, tt.after)
+		}
+	}
+
+	// taken only once
+	if requests, err := takePendingRequests(); err != nil || len(requests) != 0 {
+		t.Errorf("second takePendingRequests = %v, %v, want none", requests, err)
+	}
+}
+
+func TestAddColumnIfMissing(t *testing.T) {
+	openTestDB(t)
+
+	// a second call finds the column and leaves it alone
+	for i := 0; i < 2; i++ {
+		if err := addColumnIfMissing(getDB(), "config", "note", "TEXT"); err != nil {
+			t.Fatalf("call %d: %s", i+1, err)
+		}
+	}
+
+	if _, err := getDB().Exec("INSERT INTO config (key, value, note) VALUES ('k', 'v', 'n')"); err != nil {
+		t.Errorf("column not added: %s", err)
+	}
+}
+
+func TestAddFileEvent(t *testing.T) {
+	openTestDB(t)
+
+	const hash = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if err := addFileEvent("alice", "file_sent", hash); err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	if err := getDB().QueryRow("SELECT file_hash FROM events WHERE username = 'alice'").Scan(&got); err != nil || got != hash {
+		t.Errorf("stored hash %q, %v, want %q", got, err, hash)
+	}
+
+	// sent files aren't requests
+	stats, err := getStats("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stats.VideoRequests["alice"]; ok {
+		t.Errorf("file_sent event counted in the stats: %+v", stats)
+	}
+}
+
+func TestRequestHistory(t *testing.T) {
+	openTestDB(t)
+
+	// users 3 and 4 have no username
+	for i, userID := range []int64{1, 2, 1, 1, 3, 4} {
+		username := map[int64]string{1: "alice", 2: "bob"}[userID]
+		if _, err := addPendingRequest(1, userID, username, fmt.Sprintf("https://example.com/%d", i), "video", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		userID   int64
+		everyone bool
+		limit    int
+		want     string
+	}{
+		{1, false, 10, "[4 3 1]"},
+		{1, false, 2, "[4 3]"},
+		{2, false, 10, "[2]"},
+		{3, false, 10, "[5]"},
+		{4, false, 10, "[6]"},
+		{5, false, 10, "[]"},
+		{1, true, 10, "[6 5 4 3 2 1]"},
+		{0, true, 3, "[6 5 4]"},
+	}
+
+	for _, tt := range tests {
+		requests, err := requestHistory(tt.userID, tt.everyone, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []int64{}
+		for _, r := range requests {
+			ids = append(ids, r.ID)
+			if r.Status != RequestPending || r.Created.IsZero() {
+				t.Errorf("request %d: status %q, created %s", r.ID, r.Status, r.Created)
+			}
+		}
+		if got := fmt.Sprint(ids); got != tt.want {
+			t.Errorf("requestHistory(%d, %v, %d) = %s, want %s", tt.userID, tt.everyone, tt.limit, got, tt.want)
+		}
+	}
+
+	// saved before requests had a user and options
+	res, err := getDB().Exec("INSERT INTO pending_requests (chat_id, username, url, mode) VALUES (1, 'old', 'https://example.com/old', 'video')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	if r, err := getRequest(id); err != nil || r.UserID != 0 || r.Username != "old" || r.Options != "" {
+		t.Errorf("getRequest of a request without a user and options = %+v, %v", r, err)
+	}
+
+	if _, err := getRequest(99); err == nil {
+		t.Error("getRequest of a missing request succeeded")
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	openTestDB(t)
+
+	addEventAt(t, "alice", "video_request", time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC))
+	addEventAt(t, `bob, "the builder"`, "download_error", time.Date(2024, 3, 2, 11, 30, 15, 0, time.UTC))
+
+	var buf bytes.Buffer
+	if err := exportCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("export isn't valid CSV: %s", err)
+	}
+
+	want := [][]string{
+		{"id", "username", "event_type", "timestamp"},
+		{"1", "alice", "video_request", "2024-03-01 10:00:00"},
+		{"2", `bob, "the builder"`, "download_error", "2024-03-02 11:30:15"},
+	}
+	if fmt.Sprintf("%q", records) != fmt.Sprintf("%q", want) {
+		t.Errorf("exported %q, want %q", records, want)
+	}
+}
+
+func TestEventIndexes(t *testing.T) {
+	openTestDB(t)
+
+	tests := []struct {
+		name    string
+		columns string
+	}{
+		{"idx_events_timestamp", "timestamp"},
+		{"idx_events_type_timestamp", "event_type,timestamp"},
+	}
+
+	for _, tt := range tests {
+		var columns []string
+		rows, err := getDB().Query(fmt.Sprintf("PRAGMA index_info(%s)", tt.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var seqno, cid int
+			var name string
+			if err := rows.Scan(&seqno, &cid, &name); err != nil {
+				t.Fatal(err)
+			}
+			columns = append(columns, name)
+		}
+		rows.Close()
+
+		if got := strings.Join(columns, ","); got != tt.columns {
+			t.Errorf("index %s on %q, want %q", tt.name, got, tt.columns)
+		}
+	}
+}
+
+// BenchmarkGetStats measures the monthly stats over a year of events.
+func BenchmarkGetStats(b *testing.B) {
+	openTestDB(b)
+
+	const events = 100000
+	eventTypes := []string{"video_request", "audio_request", "download_error", "unrecognized_command", "file_sent"}
+	now := time.Now().UTC()
+
+	tx, err := getDB().Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	stmt, err := tx.Prepare("INSERT INTO events (username, event_type, timestamp) VALUES (?, ?, ?)")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < events; i++ {
+		ts := now.Add(-time.Duration(i) * 365 * 24 * time.Hour / events)
+		if _, err := stmt.Exec(fmt.Sprintf("user%d", i%50), eventTypes[i%len(eventTypes)], ts.Format(timestampLayout)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	stmt.Close()
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := getStats("month"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
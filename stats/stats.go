@@ -1,52 +1,333 @@
 package stats
 
-import "log"
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"time"
+)
 
 type Stats struct {
 	VideoRequests        map[string]int `json:"video_requests"`
 	AudioRequests        map[string]int `json:"audio_requests"`
 	DownloadErrors       map[string]int `json:"download_errors"`
 	UnrecognizedCommands map[string]int `json:"unrecognized_commands"`
+	FilesTooLarge        map[string]int `json:"files_too_large"`
+
+	// Downloads counts the files sent with a recorded duration, which
+	// AvgDownloadTime averages. BytesServed is their total size.
+	Downloads       int           `json:"downloads"`
+	AvgDownloadTime time.Duration `json:"avg_download_time"`
+	BytesServed     int64         `json:"bytes_served"`
 }
 
-func AddVideoRequest(username string) {
-	err := addEvent(username, "video_request")
+// AddVideoRequest records a video request for a link on domain. The Add
+// functions take domain "" when there is no link.
+func AddVideoRequest(username, domain string) {
+	err := addEvent(username, "video_request", domain)
 	if err != nil {
 		log.Printf("Error adding video request event to database: %v", err)
 	}
 }
 
-func AddAudioRequest(username string) {
-	err := addEvent(username, "audio_request")
+func AddAudioRequest(username, domain string) {
+	err := addEvent(username, "audio_request", domain)
 	if err != nil {
 		log.Printf("Error adding audio request event to database: %v", err)
 	}
 }
 
-func AddDownloadError(username string) {
-	err := addEvent(username, "download_error")
+func AddDownloadError(username, domain string) {
+	err := addEvent(username, "download_error", domain)
 	if err != nil {
 		log.Printf("Error adding download error event to database: %v", err)
 	}
 }
 
+// AddFileTooLarge records a download that was too large to send.
+func AddFileTooLarge(username, domain string) {
+	err := addEvent(username, "file_too_large", domain)
+	if err != nil {
+		log.Printf("Error adding file too large event to database: %v", err)
+	}
+}
+
+// AddDownload records a delivered file of mediaType from domain with how
+// long it took to download and its size.
+func AddDownload(username, mediaType, domain string, duration time.Duration, fileSize int64) {
+	err := addDownloadEvent(username, mediaType, domain, duration.Milliseconds(), fileSize)
+	if err != nil {
+		log.Printf("Error adding download event to database: %v", err)
+	}
+}
+
+// AddSentFile records the SHA-256 of a file sent to username.
+func AddSentFile(username, fileHash string) {
+	err := addFileEvent(username, "file_sent", fileHash)
+	if err != nil {
+		log.Printf("Error adding sent file event to database: %v", err)
+	}
+}
+
 func AddUnrecognizedCommand(username string) {
-	err := addEvent(username, "unrecognized_command")
+	err := addEvent(username, "unrecognized_command", "")
 	if err != nil {
 		log.Printf("Error adding unrecognized command event to database: %v", err)
 	}
 }
 
+// DomainStats are the totals of the links from one site.
+type DomainStats struct {
+	Domain         string
+	Requests       int
+	DownloadErrors int
+	FilesTooLarge  int
+	BytesServed    int64
+}
+
+// GetDomainStats returns the totals per domain for a period ("day",
+// "week", "month" or anything else for all time), most requested first.
+func GetDomainStats(period string) []DomainStats {
+	domains, err := getDomainStats(period)
+	if err != nil {
+		log.Printf("Error getting domain stats from database: %v", err)
+		return nil
+	}
+	return domains
+}
+
 func GetStats(period string) *Stats {
 	stats, err := getStats(period)
 	if err != nil {
 		log.Printf("Error getting stats from database: %v", err)
-		return &Stats{
-			VideoRequests:        make(map[string]int),
-			AudioRequests:        make(map[string]int),
-			DownloadErrors:       make(map[string]int),
-			UnrecognizedCommands: make(map[string]int),
-		}
+		return emptyStats()
 	}
 	return stats
 }
+
+func emptyStats() *Stats {
+	return &Stats{
+		VideoRequests:        make(map[string]int),
+		AudioRequests:        make(map[string]int),
+		DownloadErrors:       make(map[string]int),
+		UnrecognizedCommands: make(map[string]int),
+		FilesTooLarge:        make(map[string]int),
+	}
+}
+
+// GetStatsRange returns the stats for events between from (inclusive) and
+// to (exclusive).
+func GetStatsRange(from, to time.Time) *Stats {
+	stats, err := getStatsRange(from, to)
+	if err != nil {
+		log.Printf("Error getting stats range from database: %v", err)
+		return emptyStats()
+	}
+	return stats
+}
+
+// Statuses of a persisted download request.
+const (
+	RequestPending = "pending"
+	RequestStarted = "started"
+	RequestDone    = "done"
+	RequestFailed  = "failed"
+	RequestResumed = "resumed"
+)
+
+// PendingRequest is a download request saved so it survives a restart.
+type PendingRequest struct {
+	ID       int64
+	ChatID   int64
+	UserID   int64
+	Username string
+	URL      string
+	Mode     string
+
+	// Options are the download options besides the mode, in a format
+	// only the caller knows.
+	Options string
+
+	// Status and Created are only set by RequestHistory and GetRequest
+	Status  string
+	Created time.Time
+}
+
+// AddPendingRequest saves a request that is waiting for a download slot and
+// returns its ID, or 0 if it couldn't be saved.
+func AddPendingRequest(chatID, userID int64, username, url, mode, options string) int64 {
+	id, err := addPendingRequest(chatID, userID, username, url, mode, options)
+	if err != nil {
+		log.Printf("Error saving pending request: %v", err)
+		return 0
+	}
+	return id
+}
+
+// SetRequestStatus updates a saved request. ID 0 is ignored.
+func SetRequestStatus(id int64, status string) {
+	if id == 0 {
+		return
+	}
+	if err := setRequestStatus(id, status); err != nil {
+		log.Printf("Error updating pending request %d: %v", id, err)
+	}
+}
+
+// TakePendingRequests returns the requests left waiting by the previous run
+// and marks them so they aren't returned again.
+func TakePendingRequests() []PendingRequest {
+	requests, err := takePendingRequests()
+	if err != nil {
+		log.Printf("Error loading pending requests: %v", err)
+		return nil
+	}
+	return requests
+}
+
+// StartCleanup deletes events older than eventRetention and finished
+// requests older than requestRetention now and then once a day, until ctx
+// is done. A retention of 0 keeps them forever.
+func StartCleanup(ctx context.Context, eventRetention, requestRetention time.Duration) {
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
+
+		for {
+			if eventRetention > 0 {
+				n, err := pruneEvents(time.Now().Add(-eventRetention))
+				if err != nil {
+					log.Printf("Error pruning old events: %v", err)
+				} else if n > 0 {
+					log.Printf("Pruned %d events older than %s", n, eventRetention)
+				}
+			}
+
+			if requestRetention > 0 {
+				n, err := pruneRequests(time.Now().Add(-requestRetention))
+				if err != nil {
+					log.Printf("Error pruning old requests: %v", err)
+				} else if n > 0 {
+					log.Printf("Pruned %d finished requests older than %s", n, requestRetention)
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RequestHistory returns the latest limit requests of the user, newest
+// first, or everyone's when everyone is set.
+func RequestHistory(userID int64, everyone bool, limit int) []PendingRequest {
+	requests, err := requestHistory(userID, everyone, limit)
+	if err != nil {
+		log.Printf("Error loading request history: %v", err)
+		return nil
+	}
+	return requests
+}
+
+// GetRequest returns a saved request and whether it was found.
+func GetRequest(id int64) (PendingRequest, bool) {
+	r, err := getRequest(id)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error loading request %d: %v", id, err)
+		}
+		return PendingRequest{}, false
+	}
+	return r, true
+}
+
+// ExportCSV writes every event as CSV with the columns id, username,
+// event_type and timestamp.
+func ExportCSV(w io.Writer) error {
+	return exportCSV(w)
+}
+
+// GetConfig returns a persisted setting and whether it was found.
+func GetConfig(key string) (string, bool) {
+	value, err := getConfig(key)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error getting config value '%s' from database: %v", key, err)
+		}
+		return "", false
+	}
+	return value, true
+}
+
+// SetConfig persists a setting.
+func SetConfig(key, value string) error {
+	return setConfig(key, value)
+}
+
+// UpsertUser records the chat a user last wrote from and their current
+// username.
+func UpsertUser(userID, chatID int64, username string) error {
+	return upsertUser(userID, chatID, username)
+}
+
+// GetChatID returns the chat a user last wrote from and whether the user is
+// known.
+func GetChatID(userID int64) (int64, bool) {
+	chatID, err := getChatID(userID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error loading chat of user %d: %v", userID, err)
+		}
+		return 0, false
+	}
+	return chatID, true
+}
+
+// UserPrefs are a user's download defaults. Zero values mean the bot's own
+// defaults.
+type UserPrefs struct {
+	// Resolution is the maximum video height.
+	Resolution int
+	// MediaType is "video" or "audio", what a plain link downloads.
+	MediaType string
+	// Language is the base language of the preferred audio track.
+	Language string
+}
+
+// GetUserPrefs returns the defaults saved by a user, or zero values if
+// there are none.
+func GetUserPrefs(userID int64) UserPrefs {
+	prefs, err := getUserPrefs(userID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error loading preferences of user %d: %v", userID, err)
+		}
+		return UserPrefs{}
+	}
+	return prefs
+}
+
+// SetUserPrefs saves a user's defaults, replacing the previous ones.
+func SetUserPrefs(userID int64, prefs UserPrefs) error {
+	return setUserPrefs(userID, prefs)
+}
+
+// Ping checks that the database answers queries.
+func Ping(ctx context.Context) error {
+	return ping(ctx)
+}
+
+// Close waits up to timeout for pending writes and closes the database.
+// Events recorded afterwards are dropped.
+func Close(timeout time.Duration) {
+	if err := closeDB(timeout); err != nil {
+		log.Printf("Error closing stats database: %v", err)
+		return
+	}
+	log.Printf("Stats database closed")
+}
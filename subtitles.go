@@ -0,0 +1,63 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	subtitleTag        = regexp.MustCompile(`<[^>]*>`)
+	subtitleCueNumber  = regexp.MustCompile(`^\d+$`)
+	subtitleWhitespace = regexp.MustCompile(`\s+`)
+)
+
+// parseSubtitles turns a WebVTT or SRT file into plain text. Timings, cue
+// numbers, headers and styling tags are dropped, and the lines repeated by
+// auto-generated captions (which scroll the previous line along) are only
+// kept once.
+func parseSubtitles(content string) string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	lines := strings.Split(content, "\n")
+
+	var text []string
+	var previous string
+	skipBlock := false
+
+	for i, line := range lines {
+		line = strings.TrimSpace(strings.TrimPrefix(line, "\ufeff"))
+
+		if line == "" {
+			skipBlock = false
+			continue
+		}
+		if skipBlock {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "WEBVTT"),
+			strings.HasPrefix(line, "NOTE"),
+			strings.HasPrefix(line, "STYLE"),
+			strings.HasPrefix(line, "REGION"):
+			// header and metadata blocks run until the next empty line
+			skipBlock = true
+			continue
+		case strings.Contains(line, "-->"):
+			continue
+		case subtitleCueNumber.MatchString(line) && i+1 < len(lines) && strings.Contains(lines[i+1], "-->"):
+			continue
+		}
+
+		line = html.UnescapeString(subtitleTag.ReplaceAllString(line, ""))
+		line = strings.TrimSpace(subtitleWhitespace.ReplaceAllString(line, " "))
+		if line == "" || line == previous {
+			continue
+		}
+
+		text = append(text, line)
+		previous = line
+	}
+
+	return strings.Join(text, "\n")
+}
@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestParseSubtitles(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name: "webvtt",
+			content: "\ufeffWEBVTT\nKind: captions\nLanguage: en\n\n" +
+				"00:00:01.000 --> 00:00:03.000\nHello <b>world</b>\n\n" +
+				"00:00:03.000 --> 00:00:05.000 align:start position:0%\nSecond   line\n",
+			want: "Hello world\nSecond line",
+		},
+		{
+			name: "srt",
+			content: "1\r\n00:00:01,000 --> 00:00:03,000\r\nFirst\r\n\r\n" +
+				"2\r\n00:00:03,000 --> 00:00:05,000\r\n<i>Second</i>\r\n",
+			want: "First\nSecond",
+		},
+		{
+			name: "auto-generated captions repeat lines",
+			content: "WEBVTT\n\n00:00:00.000 --> 00:00:02.000\nthe quick\n\n" +
+				"00:00:02.000 --> 00:00:02.010\nthe quick\n\n" +
+				"00:00:02.010 --> 00:00:04.000\nthe quick\nbrown<00:00:02.500><c> fox</c>\n",
+			want: "the quick\nbrown fox",
+		},
+		{
+			name: "metadata blocks",
+			content: "WEBVTT\n\nNOTE this is\na comment\n\nSTYLE\n::cue { color: red }\n\n" +
+				"00:00:01.000 --> 00:00:02.000\nText\n",
+			want: "Text",
+		},
+		{
+			name:    "entities",
+			content: "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nTom &amp; Jerry &lt;3\n",
+			want:    "Tom & Jerry <3",
+		},
+		{
+			name:    "numbers that aren't cue numbers",
+			content: "1\n00:00:01,000 --> 00:00:02,000\n42\n",
+			want:    "42",
+		},
+		{
+			name:    "empty",
+			content: "WEBVTT\n\n",
+			want:    "",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := parseSubtitles(tt.content); got != tt.want {
+			t.Errorf("%s: parseSubtitles() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
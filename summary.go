@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/mkevac/markodownloadbot/stats"
+)
+
+const (
+	summaryDaily  = "daily"
+	summaryWeekly = "weekly"
+)
+
+// nextSummaryTime returns the first moment after now at which a summary is
+// due. clock is minutes since local midnight; weekly summaries go out on
+// Mondays.
+func nextSummaryTime(now time.Time, schedule string, clock int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), clock/60, clock%60, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+
+	if schedule == summaryWeekly {
+		for next.Weekday() != time.Monday {
+			next = next.AddDate(0, 0, 1)
+		}
+	}
+
+	return next
+}
+
+// runStatsSummary sends the admin a stats summary on schedule until ctx
+// is done.
+func runStatsSummary(ctx context.Context, b *bot.Bot, schedule string, clock int) {
+	for {
+		timer := time.NewTimer(time.Until(nextSummaryTime(time.Now(), schedule, clock)))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		chatID := adminChatID.Load()
+		if chatID == 0 {
+			log.Printf("Skipping stats summary, the admin chat is unknown")
+			continue
+		}
+
+		period, title := "day", "Daily Summary"
+		if schedule == summaryWeekly {
+			period, title = "week", "Weekly Summary"
+		}
+
+		if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:    chatID,
+			Text:      truncateFormatted(periodStatsMessage(title, stats.GetStats(period)), telegramMessageLimit, models.ParseModeMarkdown),
+			ParseMode: models.ParseModeMarkdown,
+		}); err != nil {
+			log.Printf("Error sending stats summary: %s", err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkevac/markodownloadbot/stats"
+)
+
+func TestNextSummaryTime(t *testing.T) {
+	// 2024-03-15 is a Friday
+	at := func(day, hour, minute int) time.Time { return time.Date(2024, 3, day, hour, minute, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		schedule string
+		clock    int
+		want     time.Time
+	}{
+		{"daily later today", at(15, 8, 0), summaryDaily, 9 * 60, at(15, 9, 0)},
+		{"daily exactly now", at(15, 9, 0), summaryDaily, 9 * 60, at(16, 9, 0)},
+		{"daily already passed", at(15, 10, 0), summaryDaily, 9 * 60, at(16, 9, 0)},
+		{"daily at midnight", at(15, 23, 59), summaryDaily, 0, at(16, 0, 0)},
+		{"daily across months", at(31, 23, 0), summaryDaily, 9*60 + 30, time.Date(2024, 4, 1, 9, 30, 0, 0, time.UTC)},
+		{"weekly from friday", at(15, 10, 0), summaryWeekly, 9 * 60, at(18, 9, 0)},
+		{"weekly monday before", at(18, 8, 0), summaryWeekly, 9 * 60, at(18, 9, 0)},
+		{"weekly monday after", at(18, 9, 30), summaryWeekly, 9 * 60, at(25, 9, 0)},
+		{"weekly sunday", at(17, 23, 0), summaryWeekly, 9 * 60, at(18, 9, 0)},
+	}
+
+	for _, tt := range tests {
+		if got := nextSummaryTime(tt.now, tt.schedule, tt.clock); !got.Equal(tt.want) {
+			t.Errorf("%s: nextSummaryTime(%s) = %s, want %s", tt.name, tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestAdminChatIDPersisted(t *testing.T) {
+	oldAdmin, oldChat := adminUsername, adminChatID.Load()
+	defer func() {
+		adminUsername = oldAdmin
+		adminChatID.Store(oldChat)
+		stats.SetConfig(adminChatIDConfigKey, "")
+	}()
+	adminUsername = "admin"
+	adminChatID.Store(0)
+
+	saveAdminChatID("alice", 5)
+	if got := adminChatID.Load(); got != 0 {
+		t.Errorf("chat of another user saved as the admin chat %d", got)
+	}
+
+	saveAdminChatID("admin", 42)
+	if got := adminChatID.Load(); got != 42 {
+		t.Errorf("admin chat = %d, want 42", got)
+	}
+
+	// after a restart
+	adminChatID.Store(0)
+	loadAdminChatID()
+	if got := adminChatID.Load(); got != 42 {
+		t.Errorf("admin chat after a restart = %d, want 42", got)
+	}
+
+	stats.SetConfig(adminChatIDConfigKey, "garbage")
+	adminChatID.Store(0)
+	loadAdminChatID()
+	if got := adminChatID.Load(); got != 0 {
+		t.Errorf("admin chat from an invalid saved value = %d, want 0", got)
+	}
+}
+
+func TestPeriodStatsMessage(t *testing.T) {
+	st := &stats.Stats{
+		VideoRequests:  map[string]int{"alice": 3, "bob": 1},
+		AudioRequests:  map[string]int{"alice": 2},
+		DownloadErrors: map[string]int{"bob": 1},
+		FilesTooLarge:  map[string]int{},
+	}
+
+	msg := periodStatsMessage("Daily Summary", st)
+	if want := "*Daily Summary:* V:`4` A:`2` E:`1` L:`0`\n\n*Detailed Stats \\- Daily Summary*"; !strings.HasPrefix(msg, want) {
+		t.Errorf("periodStatsMessage() = %q, want it to start with %q", msg, want)
+	}
+}
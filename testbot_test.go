@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot"
+)
+
+// botRequest is a Bot API call made by the code under test.
+type botRequest struct {
+	method string
+	fields map[string]string
+}
+
+// testBot is a bot talking to a fake Bot API server that records every
+// call and answers it successfully.
+type testBot struct {
+	*bot.Bot
+
+	mu       sync.Mutex
+	requests []botRequest
+	results  map[string]any
+	delays   map[string]time.Duration
+	files    map[string]string
+}
+
+// botError is a failed Bot API response, see testBot.respond.
+type botError struct {
+	code        int
+	description string
+}
+
+// boolResultMethods are the Bot API methods that return true instead of a
+// message.
+var boolResultMethods = map[string]bool{
+	"answerCallbackQuery": true,
+	"deleteMessage":       true,
+	"setMyCommands":       true,
+	"setWebhook":          true,
+	"deleteWebhook":       true,
+}
+
+func newTestBot(t *testing.T) *testBot {
+	t.Helper()
+
+	tb := &testBot{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := path.Base(r.URL.Path)
+		if strings.HasPrefix(r.URL.Path, "/file/") {
+			tb.mu.Lock()
+			content, ok := tb.files[method]
+			tb.mu.Unlock()
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			io.WriteString(w, content)
+			return
+		}
+
+		fields := make(map[string]string)
+		if err := r.ParseMultipartForm(1 << 20); err == nil {
+			for name, values := range r.MultipartForm.Value {
+				fields[name] = values[0]
+			}
+		}
+
+		tb.mu.Lock()
+		tb.requests = append(tb.requests, botRequest{method: method, fields: fields})
+		result, ok := tb.results[method]
+		delay := tb.delays[method]
+		tb.mu.Unlock()
+
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+
+		if err, isErr := result.(botError); isErr {
+			json.NewEncoder(w).Encode(map[string]any{"ok": false, "error_code": err.code, "description": err.description})
+			return
+		}
+		if !ok {
+			result = map[string]any{"message_id": 1, "date": 0, "chat": map[string]any{"id": 1, "type": "private"}}
+			if boolResultMethods[method] {
+				result = true
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+	}))
+	t.Cleanup(server.Close)
+
+	b, err := bot.New("test", bot.WithSkipGetMe(), bot.WithServerURL(server.URL),
+		bot.WithHTTPClient(botPollTimeout, newBotHTTPClient()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tb.Bot = b
+	return tb
+}
+
+// respond makes the server answer calls of method with result, or fail
+// them if result is a botError.
+func (tb *testBot) respond(method string, result any) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if tb.results == nil {
+		tb.results = make(map[string]any)
+	}
+	tb.results[method] = result
+}
+
+// delay makes the server wait d before answering calls of method, like a
+// slow upload.
+func (tb *testBot) delay(method string, d time.Duration) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if tb.delays == nil {
+		tb.delays = make(map[string]time.Duration)
+	}
+	tb.delays[method] = d
+}
+
+// serveFile makes the server serve content as the file with the given
+// name, like the Bot API's file downloads.
+func (tb *testBot) serveFile(name string, content string) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if tb.files == nil {
+		tb.files = make(map[string]string)
+	}
+	tb.files[name] = content
+}
+
+// sentTexts returns the texts of the messages sent so far.
+func (tb *testBot) sentTexts() []string {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	var texts []string
+	for _, r := range tb.requests {
+		if r.method == "sendMessage" {
+			texts = append(texts, r.fields["text"])
+		}
+	}
+	return texts
+}
+
+// calls returns the recorded calls of a Bot API method.
+func (tb *testBot) calls(method string) []botRequest {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	var calls []botRequest
+	for _, r := range tb.requests {
+		if r.method == method {
+			calls = append(calls, r)
+		}
+	}
+	return calls
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-telegram/bot/models"
+)
+
+// Telegram limits for message text and media captions, in characters.
+const (
+	telegramMessageLimit = 4096
+	telegramCaptionLimit = 1024
+)
+
+const ellipsis = "…"
+
+var partialHTMLEntity = regexp.MustCompile(`&#?[a-zA-Z0-9]*$`)
+
+// markdownSpecial are the characters MarkdownV2 requires to be escaped
+// outside of entities, the backslash included.
+const markdownSpecial = "\\_*[]()~`>#+-=|{}.!"
+
+// escapeMarkdown escapes s for use as plain text in a MarkdownV2 message,
+// e.g. a username like "john_doe.1". Numbers inside code spans need no
+// escaping.
+func escapeMarkdown(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(markdownSpecial, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// truncateText shortens plain text to at most max characters, appending an
+// ellipsis if anything was cut. It never splits a rune.
+func truncateText(s string, max int) string {
+	if max <= 0 || utf8.RuneCountInString(s) <= max {
+		return s
+	}
+
+	limit := max - utf8.RuneCountInString(ellipsis)
+	if limit <= 0 {
+		return ellipsis
+	}
+
+	return string([]rune(s)[:limit]) + ellipsis
+}
+
+// truncateFormatted is truncateText for text sent with parseMode. It drops
+// a tag, entity, link or escape cut in half and closes the entities left
+// open, so the result still parses. Parse modes other than HTML and
+// MarkdownV2 are cut as plain text.
+func truncateFormatted(s string, max int, parseMode models.ParseMode) string {
+	var repair func(string) (string, string)
+	switch parseMode {
+	case models.ParseModeHTML:
+		repair = repairHTML
+	case models.ParseModeMarkdown:
+		repair = repairMarkdown
+	default:
+		return truncateText(s, max)
+	}
+
+	if max <= 0 || utf8.RuneCountInString(s) <= max {
+		return s
+	}
+
+	runes := []rune(s)
+	limit := max - utf8.RuneCountInString(ellipsis)
+	for limit > 0 {
+		text, closing := repair(string(runes[:limit]))
+		res := text + ellipsis + closing
+		over := utf8.RuneCountInString(res) - max
+		if over <= 0 {
+			return res
+		}
+		// make room for the closing tags or markers
+		limit -= over
+	}
+	return ellipsis
+}
+
+// truncateCaption shortens s to the configured caption length.
+func truncateCaption(s string) string {
+	return truncateText(s, maxCaptionLength)
+}
+
+var htmlTag = regexp.MustCompile(`<(/?)([a-zA-Z][a-zA-Z0-9-]*)[^>]*>`)
+
+// repairHTML drops a tag or entity cut off at the end of s and returns the
+// rest along with the closing tags of the elements still open.
+func repairHTML(s string) (string, string) {
+	// unterminated tag, e.g. "<a href="
+	if i := strings.LastIndex(s, "<"); i >= 0 && !strings.Contains(s[i:], ">") {
+		s = s[:i]
+	}
+
+	// unterminated entity, e.g. "&am"
+	if loc := partialHTMLEntity.FindStringIndex(s); loc != nil {
+		s = s[:loc[0]]
+	}
+
+	var open []string
+	for _, m := range htmlTag.FindAllStringSubmatch(s, -1) {
+		name := strings.ToLower(m[2])
+		if m[1] == "" {
+			open = append(open, name)
+			continue
+		}
+		for i := len(open) - 1; i >= 0; i-- {
+			if open[i] == name {
+				open = open[:i]
+				break
+			}
+		}
+	}
+
+	var closing strings.Builder
+	for i := len(open) - 1; i >= 0; i-- {
+		closing.WriteString("</" + open[i] + ">")
+	}
+	return s, closing.String()
+}
+
+// markdownMarkers are the MarkdownV2 entity delimiters, longest first so
+// "__" isn't read as two "_".
+var markdownMarkers = []string{"```", "||", "__", "`", "_", "*", "~"}
+
+// repairMarkdown drops an escape or link cut off at the end of s and
+// returns the rest along with the markers closing the entities still open.
+func repairMarkdown(s string) (string, string) {
+	var open []string
+	// where the open link, and the open pre block, started
+	linkStart, link := -1, 0
+	preStart := -1
+
+	for i := 0; i < len(s); {
+		code := len(open) > 0 && (open[len(open)-1] == "`" || open[len(open)-1] == "```")
+
+		if s[i] == '\\' {
+			if i+1 == len(s) {
+				// dangling escape
+				s = s[:i]
+				break
+			}
+			_, size := utf8.DecodeRuneInString(s[i+1:])
+			i += 1 + size
+			continue
+		}
+
+		if code {
+			marker := open[len(open)-1]
+			if strings.HasPrefix(s[i:], marker) {
+				open = open[:len(open)-1]
+				preStart = -1
+				i += len(marker)
+				continue
+			}
+			i++
+			continue
+		}
+
+		if link == 2 {
+			// inside the URL of a link
+			if s[i] == ')' {
+				link, linkStart = 0, -1
+			}
+			i++
+			continue
+		}
+
+		switch {
+		case s[i] == '[' && link == 0:
+			linkStart, link = i, 1
+			i++
+			continue
+		case s[i] == ']' && link == 1 && strings.HasPrefix(s[i+1:], "("):
+			link = 2
+			i += 2
+			continue
+		}
+
+		matched := false
+		for _, marker := range markdownMarkers {
+			if !strings.HasPrefix(s[i:], marker) {
+				continue
+			}
+			matched = true
+			closed := false
+			for j := len(open) - 1; j >= 0; j-- {
+				if open[j] == marker {
+					open = append(open[:j], open[j+1:]...)
+					closed = true
+					break
+				}
+			}
+			if !closed {
+				if marker == "```" {
+					preStart = i
+				}
+				open = append(open, marker)
+			}
+			i += len(marker)
+			break
+		}
+		if !matched {
+			i++
+		}
+	}
+
+	// a link without its URL can't be closed, drop it
+	if link != 0 {
+		return repairMarkdown(s[:linkStart])
+	}
+	// neither can a pre block cut in its language line
+	if preStart >= 0 && !strings.Contains(s[preStart:], "\n") {
+		return repairMarkdown(s[:preStart])
+	}
+
+	var closing strings.Builder
+	for i := len(open) - 1; i >= 0; i-- {
+		closing.WriteString(open[i])
+	}
+	return s, closing.String()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestTruncateText(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		max  int
+		want string
+	}{
+		{"short", "hello", 10, "hello"},
+		{"exact", "hello", 5, "hello"},
+		{"no limit", "hello", 0, "hello"},
+		{"cut", "hello world", 8, "hello w…"},
+		{"only room for the ellipsis", "hello", 1, "…"},
+		{"cyrillic", "привет мир", 5, "прив…"},
+		{"emoji", "😀😃😄😁😆", 3, "😀😃…"},
+		{"cjk", "日本語のタイトル", 4, "日本語…"},
+		{"plain text keeps brackets", "a < b and c > d, x & y", 12, "a < b and c…"},
+		{"plain text keeps trailing <", "1 < 2 < 3 < 4", 9, "1 < 2 < …"},
+		{"plain text keeps backslash", `C:\dir\file`, 8, `C:\dir\…`},
+	}
+
+	for _, tt := range tests {
+		got := truncateText(tt.s, tt.max)
+		if got != tt.want {
+			t.Errorf("%s: truncateText(%q, %d) = %q, want %q", tt.name, tt.s, tt.max, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("%s: %q isn't valid UTF-8", tt.name, got)
+		}
+	}
+}
+
+func TestTruncateFormattedHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		max  int
+		want string
+	}{
+		{"short", "<b>hi</b>", 20, "<b>hi</b>"},
+		{"cut in a tag", "hello <a href=\"https://example.com\">link</a>", 12, "hello …"},
+		{"cut in an entity", "fish &amp; chips", 8, "fish …"},
+		{"cut in a numeric entity", "fish &#38; chips", 9, "fish …"},
+		{"whole entity kept", "a &amp; b c d e f", 9, "a &amp; …"},
+		{"open tag closed", "<b>bold text here</b>", 13, "<b>bold …</b>"},
+		{"nested tags closed", "<b><i>bold italic</i></b>", 20, "<b><i>bold …</i></b>"},
+		{"closed tag not closed again", "<b>x</b> and more text", 12, "<b>x</b> an…"},
+		{"cut in a closing tag", "<code>abc</code> d", 14, "<code>…</code>"},
+		{"link closed", "<a href=\"u\">some link text</a>", 20, "<a href=\"u\">som…</a>"},
+		{"cyrillic in tag", "<b>привет мир</b>", 13, "<b>приве…</b>"},
+	}
+
+	for _, tt := range tests {
+		got := truncateFormatted(tt.s, tt.max, models.ParseModeHTML)
+		if got != tt.want {
+			t.Errorf("%s: truncateFormatted(%q, %d) = %q, want %q", tt.name, tt.s, tt.max, got, tt.want)
+		}
+		if n := utf8.RuneCountInString(got); n > tt.max {
+			t.Errorf("%s: %q is %d characters, over %d", tt.name, got, n, tt.max)
+		}
+	}
+}
+
+func TestTruncateFormattedMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		max  int
+		want string
+	}{
+		{"short", "*hi*", 10, "*hi*"},
+		{"dangling escape", `abc\.def ghi`, 5, "abc…"},
+		{"escape kept whole", `ab\.cdef ghi`, 5, `ab\.…`},
+		{"escaped backslash kept", `ab\\cdef ghi`, 5, `ab\\…`},
+		{"bold closed", "*bold text here*", 8, "*bold …*"},
+		{"italic closed", "_italic text_", 6, "_ita…_"},
+		{"underline closed", "__underline text__", 9, "__unde…__"},
+		{"spoiler closed", "||spoiler text||", 9, "||spoi…||"},
+		{"nested closed in order", "*bold _italic text_*", 12, "*bold _it…_*"},
+		{"closed entity not closed again", "*x* and more text", 10, "*x* and m…"},
+		{"escaped marker isn't an entity", `\*not bold\* text`, 10, `\*not bol…`},
+		{"code closed", "`some code here`", 8, "`some …`"},
+		{"marker in code isn't an entity", "`a*b_c` more text", 6, "`a*b…`"},
+		{"pre closed", "```go\nfmt.Println()\n```", 16, "```go\nfmt.Pr…```"},
+		{"pre cut in its language", "text ```golang\ncode```", 10, "text …"},
+		{"link without url dropped", "see [the link](https://example.com) now", 20, "see …"},
+		{"link text cut dropped", "see [the link](https://example.com)", 9, "see …"},
+		{"whole link kept", "[a](u) and more text", 10, "[a](u) an…"},
+		{"cyrillic bold", "*привет мир*", 7, "*прив…*"},
+	}
+
+	for _, tt := range tests {
+		got := truncateFormatted(tt.s, tt.max, models.ParseModeMarkdown)
+		if got != tt.want {
+			t.Errorf("%s: truncateFormatted(%q, %d) = %q, want %q", tt.name, tt.s, tt.max, got, tt.want)
+		}
+		if n := utf8.RuneCountInString(got); n > tt.max {
+			t.Errorf("%s: %q is %d characters, over %d", tt.name, got, n, tt.max)
+		}
+	}
+}
+
+func TestTruncateFormattedPlain(t *testing.T) {
+	s := "a <b> c " + strings.Repeat("x", 20)
+	if got, want := truncateFormatted(s, 6, ""), truncateText(s, 6); got != want {
+		t.Errorf("truncateFormatted without a parse mode = %q, want %q", got, want)
+	}
+}
+
+func TestEscapeMarkdown(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"john", "john"},
+		{"john_doe.1", `john\_doe\.1`},
+		{"a*b[c]", `a\*b\[c\]`},
+		{`back\slash`, `back\\slash`},
+		{"привет!", `привет\!`},
+	}
+
+	for _, tt := range tests {
+		if got := escapeMarkdown(tt.s); got != tt.want {
+			t.Errorf("escapeMarkdown(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot/models"
+)
+
+// thumbnailSize is the largest side Telegram accepts for a thumbnail.
+const thumbnailSize = 320
+
+var thumbnailScale = fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", thumbnailSize, thumbnailSize)
+
+// thumbnailOffset is where the thumbnail frame is taken from, either a
+// fixed number of seconds or a percentage of the duration. The zero value
+// lets ffmpeg's thumbnail filter pick a representative frame.
+type thumbnailOffset struct {
+	Seconds float64
+	Percent float64
+}
+
+// parseThumbnailOffset parses THUMBNAIL_OFFSET values like "25%" or "12.5".
+func parseThumbnailOffset(s string) (thumbnailOffset, error) {
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		percent, err := strconv.ParseFloat(p, 64)
+		if err != nil || percent < 0 || percent >= 100 {
+			return thumbnailOffset{}, fmt.Errorf("invalid percentage '%s'", s)
+		}
+		return thumbnailOffset{Percent: percent}, nil
+	}
+
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil || seconds < 0 {
+		return thumbnailOffset{}, fmt.Errorf("invalid offset '%s'", s)
+	}
+	return thumbnailOffset{Seconds: seconds}, nil
+}
+
+// isSet reports whether an explicit offset was configured.
+func (o thumbnailOffset) isSet() bool {
+	return o.Seconds > 0 || o.Percent > 0
+}
+
+// seekTime returns the position in seconds to grab the frame at, for a
+// video of the given duration. Offsets past the end fall back to the
+// start, and a percentage of an unknown duration is 0.
+func (o thumbnailOffset) seekTime(duration int) float64 {
+	if o.Percent > 0 {
+		return float64(duration) * o.Percent / 100
+	}
+	if duration > 0 && o.Seconds >= float64(duration) {
+		return 0
+	}
+	return o.Seconds
+}
+
+// thumbnailArgs returns the ffmpeg command that writes a JPEG thumbnail of
+// input to output.
+func thumbnailArgs(input string, output string, offset thumbnailOffset, duration int) []string {
+	args := []string{"ffmpeg", "-y"}
+
+	filter := thumbnailScale
+	if offset.isSet() {
+		args = append(args, "-ss", strconv.FormatFloat(offset.seekTime(duration), 'f', 2, 64))
+	} else {
+		filter = "thumbnail," + filter
+	}
+
+	return append(args,
+		"-i", input,
+		"-vf", filter,
+		"-frames:v", "1",
+		"-q:v", "5",
+		output,
+	)
+}
+
+// generateThumbnail grabs a frame of the video as its thumbnail and
+// returns the path of the image. The caller removes it.
+func (media *Media) generateThumbnail(ctx context.Context) (string, error) {
+	path := filepath.Join(media.tmpDir, media.randomName+".thumb.jpg")
+
+	args := thumbnailArgs(media.Path, path, thumbnailOffsetSetting, int(media.Duration))
+	if _, err := runCommand(ctx, media.user, args); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("error generating thumbnail: %s", err)
+	}
+
+	return path, nil
+}
+
+// prepareCover turns the thumbnail yt-dlp wrote next to an audio download
+// into one Telegram accepts, which is small, and keeps it as the cover.
+// Without a thumbnail the audio is sent without a cover.
+func (media *Media) prepareCover(ctx context.Context) {
+	src := filepath.Join(media.tmpDir, media.randomName+".jpg")
+	if _, err := os.Stat(src); err != nil {
+		log.Printf("[%s]: no thumbnail for the audio", media.user)
+		return
+	}
+	defer os.Remove(src)
+
+	dst := filepath.Join(media.tmpDir, media.randomName+".cover.jpg")
+	args := []string{
+		"ffmpeg",
+		"-y",
+		"-i", src,
+		"-vf", thumbnailScale,
+		"-frames:v", "1",
+		"-q:v", "5",
+		dst,
+	}
+	if _, err := runCommand(ctx, media.user, args); err != nil {
+		log.Printf("[%s]: error resizing thumbnail: %s", media.user, err)
+		os.Remove(dst)
+		return
+	}
+
+	media.coverPath = dst
+}
+
+// thumbnailUpload opens the image at path to be uploaded as a thumbnail,
+// which Telegram doesn't accept as a file:// path. The returned function
+// closes the file.
+func thumbnailUpload(path string) (models.InputFile, func(), error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &models.InputFileUpload{Filename: filepath.Base(path), Data: f}, func() { f.Close() }, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseThumbnailOffset(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    thumbnailOffset
+		wantErr bool
+	}{
+		{"25%", thumbnailOffset{Percent: 25}, false},
+		{"0%", thumbnailOffset{}, false},
+		{"12.5", thumbnailOffset{Seconds: 12.5}, false},
+		{"0", thumbnailOffset{}, false},
+		{"100%", thumbnailOffset{}, true},
+		{"-5%", thumbnailOffset{}, true},
+		{"-1", thumbnailOffset{}, true},
+		{"abc", thumbnailOffset{}, true},
+		{"%", thumbnailOffset{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseThumbnailOffset(tt.s)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseThumbnailOffset(%q) = %+v, %v, want %+v, error %v", tt.s, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestThumbnailOffsetSeekTime(t *testing.T) {
+	tests := []struct {
+		offset   thumbnailOffset
+		duration int
+		want     float64
+	}{
+		{thumbnailOffset{Percent: 25}, 200, 50},
+		{thumbnailOffset{Percent: 25}, 0, 0},
+		{thumbnailOffset{Seconds: 12.5}, 60, 12.5},
+		{thumbnailOffset{Seconds: 12.5}, 0, 12.5},
+		{thumbnailOffset{Seconds: 60}, 60, 0},
+		{thumbnailOffset{Seconds: 90}, 60, 0},
+	}
+
+	for _, tt := range tests {
+		if got := tt.offset.seekTime(tt.duration); got != tt.want {
+			t.Errorf("%+v.seekTime(%d) = %v, want %v", tt.offset, tt.duration, got, tt.want)
+		}
+	}
+}
+
+func TestThumbnailArgs(t *testing.T) {
+	tests := []struct {
+		offset thumbnailOffset
+		want   string
+	}{
+		{thumbnailOffset{}, "ffmpeg -y -i in.mp4 -vf thumbnail,scale=320:320:force_original_aspect_ratio=decrease -frames:v 1 -q:v 5 out.jpg"},
+		{thumbnailOffset{Seconds: 5}, "ffmpeg -y -ss 5.00 -i in.mp4 -vf scale=320:320:force_original_aspect_ratio=decrease -frames:v 1 -q:v 5 out.jpg"},
+		{thumbnailOffset{Percent: 10}, "ffmpeg -y -ss 12.00 -i in.mp4 -vf scale=320:320:force_original_aspect_ratio=decrease -frames:v 1 -q:v 5 out.jpg"},
+	}
+
+	for _, tt := range tests {
+		if got := strings.Join(thumbnailArgs("in.mp4", "out.jpg", tt.offset, 120), " "); got != tt.want {
+			t.Errorf("thumbnailArgs with %+v =\n%s\nwant\n%s", tt.offset, got, tt.want)
+		}
+	}
+}
+
+func TestMediaGenerateThumbnail(t *testing.T) {
+	tests := []struct {
+		name    string
+		ffmpeg  string
+		wantErr bool
+	}{
+		{"generated", `for arg; do out=$arg; done; echo jpeg > "$out"`, false},
+		{"ffmpeg fails", `for arg; do out=$arg; done; echo partial > "$out"; exit 1`, true},
+	}
+
+	for _, tt := range tests {
+		fakeCommand(t, "ffmpeg", tt.ffmpeg)
+
+		dir := t.TempDir()
+		media := &Media{user: "test", tmpDir: dir, randomName: "abc", Path: filepath.Join(dir, "abc.mp4")}
+		want := filepath.Join(dir, "abc.thumb.jpg")
+
+		path, err := media.generateThumbnail(context.Background())
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: generateThumbnail succeeded", tt.name)
+			}
+			if _, err := os.Stat(want); !os.IsNotExist(err) {
+				t.Errorf("%s: failed thumbnail left behind", tt.name)
+			}
+			continue
+		}
+		if err != nil || path != want {
+			t.Errorf("%s: generateThumbnail = %q, %v, want %q", tt.name, path, err, want)
+		}
+	}
+}
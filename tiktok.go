@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// telegramMediaGroupLimit is the maximum number of items in an album.
+const telegramMediaGroupLimit = 10
+
+func isTikTokHost(host string) bool {
+	_, ok := matchHost(host, map[string]bool{"tiktok.com": true})
+	return ok
+}
+
+// isTikTokPhotoPost reports whether a TikTok link is a photo slideshow
+// rather than a video. Short links only reveal that in the metadata, either
+// through the resolved page URL or because no format has a video stream.
+func isTikTokPhotoPost(u *url.URL, meta *Metadata) bool {
+	if strings.Contains(u.Path, "/photo/") {
+		return true
+	}
+	if meta == nil {
+		return false
+	}
+	if strings.Contains(meta.WebpageURL, "/photo/") {
+		return true
+	}
+
+	if len(meta.Formats) == 0 {
+		return false
+	}
+	for _, format := range meta.Formats {
+		if format.VCodec != "none" {
+			return false
+		}
+	}
+	return true
+}
+
+// photoURLs returns the slideshow images listed in the metadata.
+func photoURLs(meta *Metadata) []string {
+	var res []string
+	for _, entry := range meta.Entries {
+		switch strings.ToLower(entry.Ext) {
+		case "jpg", "jpeg", "png", "webp":
+			if entry.URL != "" {
+				res = append(res, entry.URL)
+			}
+		}
+	}
+	return res
+}
+
+// buildPhotoMediaGroups splits the images into albums Telegram accepts.
+func buildPhotoMediaGroups(paths []string) [][]models.InputMedia {
+	var groups [][]models.InputMedia
+	for start := 0; start < len(paths); start += telegramMediaGroupLimit {
+		end := start + telegramMediaGroupLimit
+		if end > len(paths) {
+			end = len(paths)
+		}
+
+		group := make([]models.InputMedia, 0, end-start)
+		for _, path := range paths[start:end] {
+			group = append(group, &models.InputMediaPhoto{Media: "file://" + localPath(path)})
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// downloadPhoto saves the image at imageURL into tmpDir.
+func downloadPhoto(ctx context.Context, imageURL string, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// sendTikTokPhotoPost sends the images of a photo slideshow as albums,
+// followed by the background audio if enabled.
+func sendTikTokPhotoPost(ctx context.Context, b *bot.Bot, update *models.Update, input string, meta *Metadata, cookiesFile string) {
+	username := update.Message.From.Username
+	chatID := update.Message.Chat.ID
+
+	var paths []string
+	defer func() {
+		for _, path := range paths {
+			if err := os.Remove(path); err != nil {
+				log.Printf("Error removing photo: %s", err)
+			}
+		}
+	}()
+
+	if meta != nil {
+		for i, imageURL := range photoURLs(meta) {
+			path := filepath.Join(tmpDir, fmt.Sprintf("%s_%d.jpg", meta.ID, i))
+			if err := downloadPhoto(ctx, imageURL, path); err != nil {
+				log.Printf("[%s]: error downloading photo %d: %s", username, i, err)
+				continue
+			}
+			paths = append(paths, path)
+		}
+	}
+
+	if len(paths) == 0 {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   "This TikTok is a photo slideshow, and I couldn't get its pictures.",
+		})
+	}
+
+	for _, group := range buildPhotoMediaGroups(paths) {
+		if _, err := b.SendMediaGroup(ctx, &bot.SendMediaGroupParams{
+			ChatID: chatID,
+			Media:  group,
+		}); err != nil {
+			log.Printf("[%s]: error sending photos: %s", username, err)
+		}
+	}
+
+	if !tiktokPhotoAudio {
+		return
+	}
+
+	media, err := DownloadMedia(ctx, input, username, tmpDir, DownloadOptions{AudioOnly: true, CookiesFile: cookiesFile})
+	if err != nil {
+		log.Printf("[%s]: error downloading slideshow audio: %s", username, err)
+		return
+	}
+	defer func() {
+		if err := media.Delete(); err != nil {
+			log.Printf("Error removing audio file: %s", err)
+		}
+	}()
+
+	if _, err := sendMedia(ctx, b, chatID, media, true, nil); err != nil {
+		log.Printf("[%s]: error sending slideshow audio: %s", username, err)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestIsTikTokPhotoPost(t *testing.T) {
+	video := MetadataFormat{VCodec: "h264", ACodec: "aac"}
+	audio := MetadataFormat{VCodec: "none", ACodec: "mp3"}
+
+	tests := []struct {
+		name string
+		url  string
+		meta *Metadata
+		want bool
+	}{
+		{"photo url", "https://www.tiktok.com/@user/photo/123", nil, true},
+		{"video url", "https://www.tiktok.com/@user/video/123", nil, false},
+		{"short link to a photo", "https://vm.tiktok.com/abc/", &Metadata{WebpageURL: "https://www.tiktok.com/@user/photo/123"}, true},
+		{"audio formats only", "https://vm.tiktok.com/abc/", &Metadata{Formats: []MetadataFormat{audio}}, true},
+		{"has a video format", "https://vm.tiktok.com/abc/", &Metadata{Formats: []MetadataFormat{audio, video}}, false},
+		{"no formats", "https://vm.tiktok.com/abc/", &Metadata{}, false},
+	}
+
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		if got := isTikTokPhotoPost(u, tt.meta); got != tt.want {
+			t.Errorf("%s: isTikTokPhotoPost = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsTikTokHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"tiktok.com", true},
+		{"www.tiktok.com", true},
+		{"vm.tiktok.com", true},
+		{"nottiktok.com", false},
+		{"example.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := isTikTokHost(tt.host); got != tt.want {
+			t.Errorf("isTikTokHost(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestPhotoURLs(t *testing.T) {
+	meta := &Metadata{Entries: []Metadata{
+		{Ext: "jpg", URL: "https://example.com/1.jpg"},
+		{Ext: "mp3", URL: "https://example.com/audio.mp3"},
+		{Ext: "JPEG", URL: "https://example.com/2.jpeg"},
+		{Ext: "webp", URL: ""},
+		{Ext: "png", URL: "https://example.com/3.png"},
+	}}
+
+	want := "https://example.com/1.jpg https://example.com/2.jpeg https://example.com/3.png"
+	if got := strings.Join(photoURLs(meta), " "); got != want {
+		t.Errorf("photoURLs() = %q, want %q", got, want)
+	}
+}
+
+func TestBuildPhotoMediaGroups(t *testing.T) {
+	tests := []struct {
+		photos int
+		want   []int
+	}{
+		{0, nil},
+		{1, []int{1}},
+		{10, []int{10}},
+		{11, []int{10, 1}},
+		{23, []int{10, 10, 3}},
+	}
+
+	for _, tt := range tests {
+		var paths []string
+		for i := 0; i < tt.photos; i++ {
+			paths = append(paths, fmt.Sprintf("/tmp/%d.jpg", i))
+		}
+
+		groups := buildPhotoMediaGroups(paths)
+		var sizes []int
+		for _, group := range groups {
+			sizes = append(sizes, len(group))
+		}
+		if fmt.Sprint(sizes) != fmt.Sprint(tt.want) {
+			t.Errorf("%d photos: album sizes %v, want %v", tt.photos, sizes, tt.want)
+		}
+		if len(groups) > 0 {
+			if photo, ok := groups[0][0].(*models.InputMediaPhoto); !ok || photo.Media != "file:///tmp/0.jpg" {
+				t.Errorf("%d photos: first item = %#v", tt.photos, groups[0][0])
+			}
+		}
+	}
+}
+
+func TestSendTikTokPhotoPost(t *testing.T) {
+	oldTmp, oldAudio := tmpDir, tiktokPhotoAudio
+	defer func() { tmpDir, tiktokPhotoAudio = oldTmp, oldAudio }()
+	tiktokPhotoAudio = false
+
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/missing") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("jpeg"))
+	}))
+	defer images.Close()
+
+	tests := []struct {
+		name   string
+		urls   []string
+		albums int
+		notice bool
+	}{
+		{"one album", []string{"/1.jpg", "/2.jpg"}, 1, false},
+		{"two albums", []string{"/1", "/2", "/3", "/4", "/5", "/6", "/7", "/8", "/9", "/10", "/11"}, 2, false},
+		{"failed photo skipped", []string{"/1.jpg", "/missing.jpg"}, 1, false},
+		{"no photos", []string{"/missing.jpg"}, 0, true},
+	}
+
+	for _, tt := range tests {
+		b := newTestBot(t)
+		tmpDir = t.TempDir()
+
+		meta := &Metadata{ID: "123"}
+		for _, u := range tt.urls {
+			meta.Entries = append(meta.Entries, Metadata{Ext: "jpg", URL: images.URL + u})
+		}
+		update := &models.Update{Message: &models.Message{
+			From: &models.User{ID: 1, Username: "test"},
+			Chat: models.Chat{ID: 1},
+		}}
+
+		sendTikTokPhotoPost(context.Background(), b.Bot, update, "https://www.tiktok.com/@user/photo/123", meta, "")
+
+		if got := len(b.calls("sendMediaGroup")); got != tt.albums {
+			t.Errorf("%s: sent %d albums, want %d", tt.name, got, tt.albums)
+		}
+		if notice := len(b.sentTexts()) > 0; notice != tt.notice {
+			t.Errorf("%s: sent %q, want a notice %v", tt.name, b.sentTexts(), tt.notice)
+		}
+		if entries, _ := os.ReadDir(tmpDir); len(entries) != 0 {
+			t.Errorf("%s: photos left in tmpDir: %v", tt.name, entries)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// requiredTools are the programs every download needs.
+var requiredTools = []string{"yt-dlp", "ffmpeg", "ffprobe"}
+
+// toolVersionArgs is the option each tool prints its version with.
+var toolVersionArgs = map[string]string{
+	"yt-dlp":  "--version",
+	"ffmpeg":  "-version",
+	"ffprobe": "-version",
+}
+
+// toolVersionTTL is how long /version reuses the versions it found. yt-dlp
+// may be updated while the bot runs, but not every minute.
+const toolVersionTTL = 5 * time.Minute
+
+var (
+	toolVersionsMu sync.RWMutex
+	// toolVersions holds the versions found by tool name, as of
+	// toolVersionsChecked.
+	toolVersions        = map[string]string{}
+	toolVersionsChecked time.Time
+)
+
+// getToolVersion returns the last version found of tool, or "".
+func getToolVersion(tool string) string {
+	toolVersionsMu.RLock()
+	defer toolVersionsMu.RUnlock()
+	return toolVersions[tool]
+}
+
+// refreshToolVersions runs the tools again for their versions if the ones
+// found are older than toolVersionTTL.
+func refreshToolVersions(ctx context.Context) {
+	toolVersionsMu.RLock()
+	fresh := time.Since(toolVersionsChecked) < toolVersionTTL
+	toolVersionsMu.RUnlock()
+	if fresh {
+		return
+	}
+
+	versions := map[string]string{}
+	for _, tool := range requiredTools {
+		version, err := toolVersion(ctx, tool)
+		if err != nil {
+			log.Printf("Error getting %s version: %s", tool, err)
+			continue
+		}
+		versions[tool] = version
+	}
+
+	toolVersionsMu.Lock()
+	toolVersions = versions
+	toolVersionsChecked = time.Now()
+	toolVersionsMu.Unlock()
+}
+
+// toolVersion runs tool to get its version: the only line yt-dlp prints,
+// or the version from ffmpeg's "ffmpeg version 6.1.1 Copyright ..." line.
+func toolVersion(ctx context.Context, tool string) (string, error) {
+	out, err := runCommand(ctx, "system", []string{tool, toolVersionArgs[tool]})
+	if err != nil {
+		return "", err
+	}
+
+	line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	if fields := strings.Fields(line); len(fields) >= 3 && fields[1] == "version" {
+		return fields[2], nil
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// checkTools looks up the required tools and logs their paths and
+// versions. It returns an error naming the tools that are missing.
+func checkTools(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var missing []string
+	for _, tool := range requiredTools {
+		path, err := exec.LookPath(tool)
+		if err != nil {
+			missing = append(missing, tool)
+			continue
+		}
+
+		version, err := toolVersion(ctx, tool)
+		if err != nil {
+			log.Printf("Found %s at %s, but couldn't get its version: %s", tool, path, err)
+			continue
+		}
+		log.Printf("Found %s %s at %s", tool, version, path)
+
+		toolVersionsMu.Lock()
+		toolVersions[tool] = version
+		toolVersionsMu.Unlock()
+	}
+
+	toolVersionsMu.Lock()
+	toolVersionsChecked = time.Now()
+	toolVersionsMu.Unlock()
+
+	if len(missing) > 0 {
+		return fmt.Errorf("not found in PATH: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
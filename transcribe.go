@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/mkevac/markodownloadbot/stats"
+)
+
+// transcribeInputPlaceholder marks where TRANSCRIBE_COMMAND takes the audio
+// file. Without it the path is appended as the last argument.
+const transcribeInputPlaceholder = "{input}"
+
+// transcribeArgs builds the speech-to-text command line for the audio file
+// at path.
+func transcribeArgs(command []string, path string) []string {
+	args := make([]string, 0, len(command)+1)
+	replaced := false
+	for _, arg := range command {
+		if strings.Contains(arg, transcribeInputPlaceholder) {
+			arg = strings.ReplaceAll(arg, transcribeInputPlaceholder, path)
+			replaced = true
+		}
+		args = append(args, arg)
+	}
+	if !replaced {
+		args = append(args, path)
+	}
+	return args
+}
+
+// runTranscriber runs TRANSCRIBE_COMMAND on the audio file and returns the
+// text it printed to stdout.
+func runTranscriber(ctx context.Context, user string, path string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, transcribeTimeout)
+	defer cancel()
+
+	out, err := runCommand(ctx, user, transcribeArgs(transcribeCommand, path))
+	if err != nil {
+		return "", fmt.Errorf("speech-to-text command failed with %s", err)
+	}
+
+	text := strings.TrimSpace(string(out))
+	if text == "" {
+		return "", fmt.Errorf("speech-to-text command printed no text")
+	}
+	return text, nil
+}
+
+// transcribeTooLongMessage tells the user the audio is over
+// TRANSCRIBE_MAX_MINUTES.
+func transcribeTooLongMessage() string {
+	return fmt.Sprintf("The audio is too long to transcribe, the limit is %d minutes.", int(transcribeMaxDuration.Minutes()))
+}
+
+func transcribeHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		log.Println("Received transcribe command with nil Message")
+		return
+	}
+	username := update.Message.From.Username
+	chatID := update.Message.Chat.ID
+	log.Printf("[%s]: received message: '%s'", username, update.Message.Text)
+
+	if len(transcribeCommand) == 0 {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   "Transcription is not enabled on this bot.",
+		})
+		return
+	}
+
+	if !downloadAllowed(ctx, b, update.Message, true) {
+		return
+	}
+
+	input, err := cleanupAndVerifyInput(strings.TrimPrefix(update.Message.Text, "/transcribe"))
+	if err != nil {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   "Usage: /transcribe <link>",
+		})
+		return
+	}
+
+	stats.AddAudioRequest(username, hostOf(input))
+
+	cookiesFile := defaultCookiesFile()
+
+	// refuse long audio before downloading it, the duration is checked
+	// again afterwards when the metadata couldn't be fetched
+	meta, err := FetchMetadata(ctx, input, username, cookiesFile)
+	if err != nil {
+		log.Printf("[%s]: error fetching metadata: %s", username, err)
+	}
+	if meta != nil && meta.Duration > transcribeMaxDuration.Seconds() {
+		log.Printf("[%s]: %s is %.0f seconds, too long to transcribe", username, input, meta.Duration)
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   transcribeTooLongMessage(),
+		})
+		return
+	}
+
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   "Transcribing, this may take a while...",
+	})
+
+	// DownloadMedia waits for a download slot like any other download
+	media, err := DownloadMedia(ctx, input, username, tmpDir, DownloadOptions{
+		AudioOnly:   true,
+		CookiesFile: cookiesFile,
+		Metadata:    meta,
+		OnQueued: func(position int) {
+			b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: chatID,
+				Text:   queuedMessage(position),
+			})
+		},
+	})
+	if err != nil {
+		log.Printf("[%s]: error downloading audio to transcribe: %s", username, err)
+		stats.AddDownloadError(username, hostOf(input))
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   truncateText(fmt.Sprintf("I couldn't download the audio: %s", err), telegramMessageLimit),
+		})
+		return
+	}
+	defer func() {
+		if err := media.Delete(); err != nil {
+			log.Printf("Error removing audio file: %s", err)
+		}
+	}()
+
+	if float64(media.Duration) > transcribeMaxDuration.Seconds() {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   transcribeTooLongMessage(),
+		})
+		return
+	}
+
+	// speech-to-text is at least as heavy as a video conversion
+	if err := conversionLimiter.Acquire(ctx); err != nil {
+		return
+	}
+	text, err := runTranscriber(ctx, username, media.Path)
+	conversionLimiter.Release()
+
+	if err != nil {
+		log.Printf("[%s]: %s", username, err)
+		stats.AddDownloadError(username, hostOf(input))
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   "I'm sorry, the transcription failed.",
+		})
+		return
+	}
+
+	sendTranscriptText(ctx, b, chatID, media, text)
+}
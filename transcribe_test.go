@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestTranscribeArgs(t *testing.T) {
+	tests := []struct {
+		command []string
+		want    string
+	}{
+		{[]string{"whisper"}, "whisper /tmp/a.m4a"},
+		{[]string{"whisper", "--model", "base"}, "whisper --model base /tmp/a.m4a"},
+		{[]string{"stt", "-i", "{input}", "-o", "-"}, "stt -i /tmp/a.m4a -o -"},
+		{[]string{"stt", "--file={input}"}, "stt --file=/tmp/a.m4a"},
+	}
+
+	for _, tt := range tests {
+		if got := strings.Join(transcribeArgs(tt.command, "/tmp/a.m4a"), " "); got != tt.want {
+			t.Errorf("transcribeArgs(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestRunTranscriber(t *testing.T) {
+	oldCommand, oldTimeout := transcribeCommand, transcribeTimeout
+	defer func() { transcribeCommand, transcribeTimeout = oldCommand, oldTimeout }()
+	transcribeCommand = []string{"stt", "--file={input}"}
+	transcribeTimeout = time.Minute
+
+	tests := []struct {
+		name   string
+		script string
+		want   string
+		err    string
+	}{
+		{"text", `echo "  hello from $1  "`, "hello from --file=/tmp/a.m4a", ""},
+		{"no text", `echo "   "`, "", "printed no text"},
+		{"failure", `echo oops >&2; exit 1`, "", "speech-to-text command failed"},
+	}
+
+	for _, tt := range tests {
+		fakeCommand(t, "stt", tt.script)
+
+		got, err := runTranscriber(context.Background(), "alice", "/tmp/a.m4a")
+		if got != tt.want {
+			t.Errorf("%s: runTranscriber = %q, want %q", tt.name, got, tt.want)
+		}
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: error %v, want one containing %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestTranscribeHandlerRefusesLongAudioBeforeDownloading(t *testing.T) {
+	oldCommand, oldMax, oldCache := transcribeCommand, transcribeMaxDuration, metaCache
+	oldDownloads := downloadLimiter
+	defer func() {
+		transcribeCommand, transcribeMaxDuration, metaCache = oldCommand, oldMax, oldCache
+		downloadLimiter = oldDownloads
+	}()
+
+	transcribeCommand = []string{"whisper"}
+	transcribeMaxDuration = 30 * time.Minute
+	metaCache = newMetadataCache(time.Minute, 10)
+	// no download slot is free, so a download would hang the test
+	downloadLimiter = newLimiter(1)
+	downloadLimiter.Acquire(context.Background())
+
+	const link = "https://example.com/watch?v=long"
+	metaCache.Put(metadataCacheKey(link, defaultCookiesFile()), &Metadata{Duration: 2 * 3600}, time.Now())
+
+	b := newTestBot(t)
+	update := &models.Update{Message: &models.Message{
+		From: &models.User{ID: 1, Username: "alice"},
+		Chat: models.Chat{ID: 1},
+		Text: "/transcribe " + link,
+	}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	transcribeHandler(ctx, b.Bot, update)
+
+	texts := b.sentTexts()
+	if len(texts) != 1 || texts[0] != transcribeTooLongMessage() {
+		t.Errorf("sent %q, want only %q", texts, transcribeTooLongMessage())
+	}
+	if ctx.Err() != nil {
+		t.Error("the handler waited for a download slot")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-telegram/bot"
+)
+
+// sendTranscript sends the plain text of the media's subtitles, as a message
+// if it fits or as a text document otherwise.
+func sendTranscript(ctx context.Context, b *bot.Bot, chatID int64, media *Media) {
+	if media.SubtitlePath == "" {
+		return
+	}
+
+	buf, err := os.ReadFile(media.SubtitlePath)
+	if err != nil {
+		log.Printf("[%s]: error reading subtitles: %s", media.user, err)
+		return
+	}
+
+	transcript := parseSubtitles(string(buf))
+	if transcript == "" {
+		log.Printf("[%s]: subtitles contain no text", media.user)
+		return
+	}
+
+	sendTranscriptText(ctx, b, chatID, media, transcript)
+}
+
+// sendTranscriptText sends transcript as a message if it fits or as a text
+// document named after the media otherwise.
+func sendTranscriptText(ctx context.Context, b *bot.Bot, chatID int64, media *Media, transcript string) {
+	transcript = truncateText(transcript, transcriptMaxLength)
+
+	text := "Transcript:\n\n" + transcript
+	if len([]rune(text)) <= telegramMessageLimit {
+		b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   text,
+		})
+		return
+	}
+
+	path := filepath.Join(media.tmpDir, media.randomName+".transcript.txt")
+	if err := os.WriteFile(path, []byte(transcript), 0644); err != nil {
+		log.Printf("[%s]: error writing transcript: %s", media.user, err)
+		return
+	}
+	defer os.Remove(path)
+
+	caption := "Transcript"
+	if media.Title != "" {
+		caption = fmt.Sprintf("Transcript: %s", strings.TrimSpace(media.Title))
+	}
+
+	if _, err := sendAsDocument(ctx, b, chatID, path, caption); err != nil {
+		log.Printf("[%s]: error sending transcript: %s", media.user, err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSendTranscript(t *testing.T) {
+	oldMax := transcriptMaxLength
+	defer func() { transcriptMaxLength = oldMax }()
+	transcriptMaxLength = 20000
+
+	tests := []struct {
+		name     string
+		content  string
+		message  string
+		document bool
+	}{
+		{"short", "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nHello\n", "Transcript:\n\nHello", false},
+		{"long", "1\n00:00:01,000 --> 00:00:02,000\n" + strings.Repeat("word ", 1000) + "\n", "", true},
+		{"no text", "WEBVTT\n\n", "", false},
+	}
+
+	for _, tt := range tests {
+		b := newTestBot(t)
+		dir := t.TempDir()
+		path := filepath.Join(dir, "abc.en.vtt")
+		if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		media := &Media{user: "test", tmpDir: dir, randomName: "abc", Title: "Cats", SubtitlePath: path}
+
+		sendTranscript(context.Background(), b.Bot, 1, media)
+
+		texts := b.sentTexts()
+		if tt.message == "" && len(texts) > 0 || tt.message != "" && (len(texts) != 1 || texts[0] != tt.message) {
+			t.Errorf("%s: sent messages %q, want %q", tt.name, texts, tt.message)
+		}
+
+		documents := b.calls("sendDocument")
+		if got := len(documents) == 1; got != tt.document || len(documents) > 1 {
+			t.Errorf("%s: sent %d documents, want a document %v", tt.name, len(documents), tt.document)
+		}
+		if tt.document && len(documents) == 1 && documents[0].fields["caption"] != "Transcript: Cats" {
+			t.Errorf("%s: document caption = %q", tt.name, documents[0].fields["caption"])
+		}
+		if _, err := os.Stat(filepath.Join(dir, "abc.transcript.txt")); !os.IsNotExist(err) {
+			t.Errorf("%s: transcript file left behind", tt.name)
+		}
+	}
+}
+
+func TestMediaFindSubtitles(t *testing.T) {
+	dir := t.TempDir()
+	media := &Media{tmpDir: dir, randomName: "abc"}
+
+	if got := media.findSubtitles(); got != "" {
+		t.Errorf("findSubtitles() without subtitles = %q", got)
+	}
+
+	for _, name := range []string{"abc.mp4", "abc.en.srt", "other.en.vtt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := media.findSubtitles(), filepath.Join(dir, "abc.en.srt"); got != want {
+		t.Errorf("findSubtitles() = %q, want %q", got, want)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "abc.en.vtt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := media.findSubtitles(), filepath.Join(dir, "abc.en.vtt"); got != want {
+		t.Errorf("findSubtitles() with vtt and srt = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// trackingParams are query parameters that only track who shared a link or
+// where it was clicked, on any site. Parameters starting with "utm_" are
+// removed as well.
+var trackingParams = map[string]bool{
+	"fbclid": true,
+	"gclid":  true,
+	"igshid": true,
+	"igsh":   true,
+	"mc_cid": true,
+	"mc_eid": true,
+}
+
+// urlNormalizers rewrite the links of a site into the form yt-dlp handles
+// best. They are looked up like hostFormats.
+var urlNormalizers = map[string]func(u *url.URL){
+	"youtube.com":   normalizeYouTubeURL,
+	"youtu.be":      normalizeYouTubeURL,
+	"tiktok.com":    normalizeTikTokURL,
+	"instagram.com": normalizeInstagramURL,
+	"instagr.am":    normalizeInstagramURL,
+}
+
+// normalizeURL rewrites u in place and reports whether anything changed.
+// Links are left as they were otherwise, since re-encoding them could
+// change them in ways some sites don't accept.
+func normalizeURL(u *url.URL) bool {
+	before := u.String()
+
+	if normalize, ok := matchHost(u.Host, urlNormalizers); ok {
+		normalize(u)
+	}
+	removeQueryParams(u, func(name string) bool {
+		return trackingParams[name] || strings.HasPrefix(name, "utm_")
+	})
+
+	return u.String() != before
+}
+
+// removeQueryParams deletes the query parameters for which remove returns
+// true. The query is only re-encoded when something was removed.
+func removeQueryParams(u *url.URL, remove func(name string) bool) {
+	if u.RawQuery == "" {
+		return
+	}
+
+	query := u.Query()
+	removed := false
+	for name := range query {
+		if remove(name) {
+			query.Del(name)
+			removed = true
+		}
+	}
+	if removed {
+		u.RawQuery = query.Encode()
+	}
+}
+
+// normalizeYouTubeURL turns short links like "https://youtu.be/ID?si=abc"
+// and mobile links into "https://www.youtube.com/watch?v=ID", keeping
+// parameters that matter, like the start time or the playlist.
+func normalizeYouTubeURL(u *url.URL) {
+	host := strings.ToLower(u.Hostname())
+
+	if host == "youtu.be" {
+		id := strings.Trim(u.Path, "/")
+		if id == "" || strings.Contains(id, "/") {
+			return
+		}
+		query := u.Query()
+		query.Set("v", id)
+		u.Path = "/watch"
+		u.RawQuery = query.Encode()
+	}
+
+	if host == "youtu.be" || host == "m.youtube.com" || host == "youtube.com" {
+		u.Host = "www.youtube.com"
+	}
+	u.Scheme = "https"
+
+	removeQueryParams(u, func(name string) bool {
+		switch name {
+		case "si", "feature", "pp", "ab_channel":
+			return true
+		}
+		return false
+	})
+}
+
+// normalizeTikTokURL drops the query of TikTok links, which only carries
+// share tracking like "_r", "_t" or "is_from_webapp", and uses the desktop
+// host for mobile links. Short links like "https://vt.tiktok.com/ZS.../"
+// keep their host: the video they point to is only known by following
+// their redirect, which yt-dlp does.
+func normalizeTikTokURL(u *url.URL) {
+	if strings.EqualFold(u.Hostname(), "m.tiktok.com") {
+		u.Host = "www.tiktok.com"
+	}
+	u.Scheme = "https"
+	u.RawQuery = ""
+	u.Fragment = ""
+}
+
+// normalizeInstagramURL turns share links like
+// "https://instagr.am/reel/abc/?igsh=xyz" into
+// "https://www.instagram.com/reel/abc/". Posts and reels are identified by
+// their path alone, the query only tracks who shared them.
+func normalizeInstagramURL(u *url.URL) {
+	u.Scheme = "https"
+	u.Host = "www.instagram.com"
+	u.RawQuery = ""
+	u.Fragment = ""
+}
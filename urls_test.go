@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestNormalizeInstagramURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    string
+		changed bool
+	}{
+		{"https://www.instagram.com/reel/abc/", "https://www.instagram.com/reel/abc/", false},
+		{"https://instagram.com/p/abc/?igsh=xyz", "https://www.instagram.com/p/abc/", true},
+		{"https://instagr.am/reel/abc/?igsh=xyz", "https://www.instagram.com/reel/abc/", true},
+		{"http://www.instagram.com/reel/abc/#comments", "https://www.instagram.com/reel/abc/", true},
+	}
+
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		changed := normalizeURL(u)
+		if u.String() != tt.want || changed != tt.changed {
+			t.Errorf("normalizeURL(%q) = %q, %v, want %q, %v", tt.url, u, changed, tt.want, tt.changed)
+		}
+		if got := lookupHostFormat(u.Host); got != instagramFormat {
+			t.Errorf("format for %s = %+v, want the Instagram format", u.Host, got)
+		}
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    string
+		changed bool
+	}{
+		// tracking parameters on any site
+		{"https://example.com/v/1", "https://example.com/v/1", false},
+		{"https://example.com/v/1?b=2&a=1", "https://example.com/v/1?b=2&a=1", false},
+		{"https://example.com/v/1?utm_source=tg&utm_medium=share&id=5", "https://example.com/v/1?id=5", true},
+		{"https://example.com/v/1?fbclid=abc", "https://example.com/v/1", true},
+		{"https://example.com/v/1?gclid=abc&x=1", "https://example.com/v/1?x=1", true},
+
+		// YouTube
+		{"https://youtu.be/dQw4w9WgXcQ?si=abc", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", true},
+		{"https://youtu.be/dQw4w9WgXcQ?t=42", "https://www.youtube.com/watch?t=42&v=dQw4w9WgXcQ", true},
+		{"https://m.youtube.com/watch?v=dQw4w9WgXcQ&feature=share", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", true},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&list=PL1&pp=xyz", "https://www.youtube.com/watch?list=PL1&v=dQw4w9WgXcQ", true},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", false},
+		{"https://youtu.be/", "https://youtu.be/", false},
+
+		// TikTok
+		{"https://www.tiktok.com/@user/video/123?is_from_webapp=1&_r=1", "https://www.tiktok.com/@user/video/123", true},
+		{"https://m.tiktok.com/v/123.html?_t=abc", "https://www.tiktok.com/v/123.html", true},
+		{"https://vt.tiktok.com/ZSabc/", "https://vt.tiktok.com/ZSabc/", false},
+	}
+
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		changed := normalizeURL(u)
+		if u.String() != tt.want || changed != tt.changed {
+			t.Errorf("normalizeURL(%q) = %q, %v, want %q, %v", tt.url, u, changed, tt.want, tt.changed)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/mkevac/markodownloadbot/stats"
+)
+
+// knownUser is what was last saved about a user, so unchanged users don't
+// cost a write per message.
+type knownUser struct {
+	chatID   int64
+	username string
+}
+
+var knownUsers sync.Map // user ID -> knownUser
+
+// saveUser writes a user to the stats database.
+var saveUser = stats.UpsertUser
+
+// recordUser saves the chat and username of the message's sender when they
+// changed, so users can be messaged later, e.g. to deliver a download after
+// a restart.
+func recordUser(msg *models.Message) {
+	if msg == nil || msg.From == nil || msg.From.ID == 0 {
+		return
+	}
+
+	user := knownUser{chatID: msg.Chat.ID, username: msg.From.Username}
+	if saved, ok := knownUsers.Load(msg.From.ID); ok && saved.(knownUser) == user {
+		return
+	}
+
+	// a user that couldn't be saved is tried again with their next message
+	if err := saveUser(msg.From.ID, user.chatID, user.username); err != nil {
+		log.Printf("[%s]: error saving user %d: %s", user.username, msg.From.ID, err)
+		return
+	}
+	knownUsers.Store(msg.From.ID, user)
+}
+
+// recordUserMiddleware records the sender of every message the bot handles.
+func recordUserMiddleware(next bot.HandlerFunc) bot.HandlerFunc {
+	return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		recordUser(update.Message)
+		next(ctx, b, update)
+	}
+}
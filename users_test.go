@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestRecordUser(t *testing.T) {
+	oldSave := saveUser
+	defer func() { saveUser = oldSave }()
+
+	var saved []knownUser
+	fail := false
+	saveUser = func(userID, chatID int64, username string) error {
+		if fail {
+			return fmt.Errorf("database is locked")
+		}
+		saved = append(saved, knownUser{chatID: chatID, username: username})
+		return nil
+	}
+
+	message := func(userID, chatID int64, username string) *models.Message {
+		return &models.Message{
+			From: &models.User{ID: userID, Username: username},
+			Chat: models.Chat{ID: chatID},
+		}
+	}
+
+	const id = 424242
+	defer knownUsers.Delete(int64(id))
+
+	tests := []struct {
+		name  string
+		msg   *models.Message
+		fail  bool
+		saves int
+	}{
+		{"nil message", nil, false, 0},
+		{"no sender", &models.Message{}, false, 0},
+		{"new user", message(id, 1, "alice"), false, 1},
+		{"unchanged user", message(id, 1, "alice"), false, 1},
+		{"new username", message(id, 1, "alice2"), false, 2},
+		{"new chat fails to save", message(id, 2, "alice2"), true, 2},
+		{"retried with the next message", message(id, 2, "alice2"), false, 3},
+		{"then cached", message(id, 2, "alice2"), false, 3},
+	}
+
+	for _, tt := range tests {
+		fail = tt.fail
+		recordUser(tt.msg)
+		if len(saved) != tt.saves {
+			t.Fatalf("%s: %d saves, want %d", tt.name, len(saved), tt.saves)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// version is the bot's version, set when building with
+// -ldflags "-X main.version=1.2.3".
+var version = "dev"
+
+// versionHandler answers /version with the versions of the bot and the
+// tools it runs, for bug reports.
+func versionHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		log.Println("Received version command with nil Message")
+		return
+	}
+	log.Printf("[%s]: received version command", update.Message.From.Username)
+
+	refreshToolVersions(ctx)
+
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("MarkoDownloadBot %s\n", version))
+	for _, tool := range requiredTools {
+		v := getToolVersion(tool)
+		if v == "" {
+			v = "not found"
+		}
+		text.WriteString(fmt.Sprintf("%s %s\n", tool, v))
+	}
+
+	b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   text.String(),
+	})
+}
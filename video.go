@@ -2,27 +2,106 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+// DownloadOptions describes how a URL is downloaded.
+type DownloadOptions struct {
+	AudioOnly   bool
+	CookiesFile string
+
+	// Section limits the download to a part of the media.
+	Section *Section
+
+	// Resolution overrides the default resolution when not 0.
+	Resolution int
+
+	// Voice converts the audio to opus so it is sent as a voice message.
+	// Only used with AudioOnly.
+	Voice bool
+
+	// Language is the base language, like "de", of the audio track to
+	// prefer when there are several.
+	Language string
+
+	// GIF converts the video to an animated GIF of at most
+	// GIF_MAX_SECONDS. Ignored for audio downloads.
+	GIF bool
+
+	// WithAudio also sends the audio track as a separate file, extracted
+	// from the downloaded video. Ignored for audio downloads.
+	WithAudio bool
+
+	// OnQueued is called with the place in the queue when all download
+	// slots are taken, and OnStart once the download got a slot.
+	OnQueued func(position int)
+	OnStart  func()
+
+	// OnProgress is called with the percentage yt-dlp reports while
+	// downloading, and OnDownloaded once yt-dlp has finished.
+	OnProgress   func(percent float64)
+	OnDownloaded func()
+
+	// Metadata is what is known about the URL before downloading, if
+	// anything. It lets yt-dlp skip recoding files that are already mp4.
+	Metadata *Metadata
+
+	// VideoPassword unlocks password-protected videos. It is never logged.
+	VideoPassword string
+
+	// OnDelivered is called once the media was sent to the user. It is
+	// only used by handleDownload.
+	OnDelivered func()
+
+	// playlistItem marks the items downloadPlaylist hands to
+	// handleDownload. They were rate limited as one request already, and
+	// the request message is deleted once all of them were sent.
+	playlistItem bool
+
+	// FormatID is a format reported by /formats. It replaces the site's
+	// format selection when set, so Metadata, which describes the default
+	// format, can't skip recoding.
+	FormatID string
+}
+
 type Media struct {
-	Width    int            `json:"width"`
-	Height   int            `json:"height"`
-	Duration CustomDuration `json:"duration_string"`
-	VCodec   string         `json:"vcodec"`
-	ACodec   string         `json:"acodec"`
-	Path     string
-	FileName string
+	Width      int            `json:"width"`
+	Height     int            `json:"height"`
+	Duration   CustomDuration `json:"duration_string"`
+	VCodec     string         `json:"vcodec"`
+	ACodec     string         `json:"acodec"`
+	Title      string         `json:"title"`
+	Uploader   string         `json:"uploader"`
+	UploadDate string         `json:"upload_date"`
+	Path       string
+	FileName   string
+
+	// SubtitlePath is the subtitle file downloaded alongside the media when
+	// transcripts are enabled.
+	SubtitlePath string `json:"-"`
+
+	// ReducedBitrate is the bitrate in kbps the audio was re-encoded at to
+	// fit the size limit, or 0 if it wasn't.
+	ReducedBitrate int `json:"-"`
+
+	// SupportsStreaming is set when the final file is an mp4 with the moov
+	// atom up front, so Telegram clients can seek before it is fully loaded.
+	SupportsStreaming bool `json:"-"`
 
 	randomName  string
 	tmpDir      string
@@ -31,8 +110,38 @@ type Media struct {
 	user        string
 	cookiesFile string
 	audioOnly   bool
+	section     *Section
+	resolution  int
+	remuxOnly   bool
+	voice       bool
+	gif         bool
+	coverPath   string
+	onQueued    func(int)
+	onStart     func()
+	onProgress  func(float64)
+	password    string
+	formatID    string
+	attempt     formatAttempt
+	language    string
+	interlaced  bool
+	rotation    int
+	frameRate   float64
+	probe       *FFProbeOutput
+
+	// videoTrack and audioTrack are the streams analyzeMedia picked, which
+	// are the ones kept when converting. audioTracks counts the audio
+	// streams in the download.
+	videoTrack  *FFProbeStream
+	audioTrack  *FFProbeStream
+	audioTracks int
 }
 
+// maxDownloadAttempts is how many times a download that produced an
+// invalid file is tried.
+const maxDownloadAttempts = 2
+
+var errInvalidDownload = errors.New("download produced an invalid file")
+
 type CustomDuration int
 
 func (d *CustomDuration) UnmarshalJSON(b []byte) error {
@@ -84,14 +193,27 @@ func (d *CustomDuration) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func DownloadMedia(mediaUrl string, user string, tmpDir string, cookiesFile string, audioOnly bool) (*Media, error) {
+func DownloadMedia(ctx context.Context, mediaUrl string, user string, tmpDir string, opts DownloadOptions) (*Media, error) {
+	audioOnly := opts.AudioOnly
+
 	res := &Media{
 		tmpDir:      tmpDir,
 		url:         mediaUrl,
 		randomName:  uuid.New().String(),
 		user:        user,
-		cookiesFile: cookiesFile,
+		cookiesFile: opts.CookiesFile,
 		audioOnly:   audioOnly,
+		section:     opts.Section,
+		resolution:  opts.Resolution,
+		remuxOnly:   !audioOnly && opts.FormatID == "" && (opts.GIF || opts.Metadata.isMP4Compatible()),
+		voice:       audioOnly && opts.Voice,
+		gif:         !audioOnly && opts.GIF,
+		onQueued:    opts.OnQueued,
+		onStart:     opts.OnStart,
+		onProgress:  opts.OnProgress,
+		password:    opts.VideoPassword,
+		formatID:    opts.FormatID,
+		language:    opts.Language,
 	}
 
 	u, err := url.Parse(mediaUrl)
@@ -100,20 +222,18 @@ func DownloadMedia(mediaUrl string, user string, tmpDir string, cookiesFile stri
 	}
 	res.parsedUrl = u
 
-	commandString := res.getCommandString()
-
-	log.Printf("[%s]: executing command: '%s'", res.user, strings.Join(commandString, " "))
-
-	cmd := exec.Command(commandString[0], commandString[1:]...)
-	var out bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &stderr
+	// the files are in use until the media is deleted or archived, or
+	// until this returns an error
+	activeDownloads.Add(res.randomName)
+	ok := false
+	defer func() {
+		if !ok {
+			activeDownloads.Remove(res.randomName)
+		}
+	}()
 
-	if err := cmd.Run(); err != nil {
-		log.Printf("Output: %s\n", out.String())
-		log.Printf("Error: %s\n", stderr.String())
-		return nil, fmt.Errorf("command execution failed with %s", err)
+	if res.remuxOnly {
+		log.Printf("[%s]: source is already mp4 with h264 and aac, not recoding", user)
 	}
 
 	if audioOnly {
@@ -122,27 +242,271 @@ func DownloadMedia(mediaUrl string, user string, tmpDir string, cookiesFile stri
 		res.Path = filepath.Join(tmpDir, res.randomName+".mp4")
 	}
 
+	if err := res.download(ctx); err != nil {
+		return nil, err
+	}
+
+	if opts.OnDownloaded != nil {
+		opts.OnDownloaded()
+	}
+
 	if err := res.populateInfo(); err != nil {
 		return nil, fmt.Errorf("error populating info: %s", err)
 	}
 
+	if res.section != nil {
+		// info.json describes the whole media, let ffprobe measure the part
+		res.Duration = 0
+	}
+
+	if sendTranscripts {
+		res.SubtitlePath = res.findSubtitles()
+	}
+
+	if err := res.analyzeMedia(ctx); err != nil {
+		log.Printf("[%s]: error analyzing media: %s", res.user, err)
+	}
+
 	if audioOnly {
 		log.Printf("[%s]: audio format '%s'", res.user, res.ACodec)
+
+		if !res.voice {
+			res.prepareCover(ctx)
+		}
+
+		if normalizeAudio {
+			res.normalizeLoudness(ctx)
+		}
+
+		if res.voice {
+			if err := res.convertToVoice(ctx); err != nil {
+				return nil, err
+			}
+		} else if err := res.fitAudioToSize(ctx, maxFileSize); err != nil {
+			return nil, err
+		}
+	} else if res.gif {
+		if err := res.convertToGIF(ctx); err != nil {
+			return nil, err
+		}
 	} else {
 		log.Printf("[%s]: video format '%s'", res.user, res.VCodec)
 
-		if strings.HasPrefix(res.VCodec, "av01") || strings.HasPrefix(res.VCodec, "vp09") {
-			log.Printf("[%s]: video codec is not supported by iOS, converting video", res.user)
-			if err := res.convert(); err != nil {
-				return nil, fmt.Errorf("error converting video: %s", err)
+		if reason := res.conversionReason(); reason != "" {
+			log.Printf("[%s]: %s, converting video", res.user, reason)
+			if err := conversionLimiter.Acquire(ctx); err != nil {
+				return nil, fmt.Errorf("gave up waiting for a conversion slot: %s", err)
+			}
+			err := res.convert(ctx)
+			conversionLimiter.Release()
+			conversionsTotal.Inc()
+			if err != nil {
+				return nil, fmt.Errorf("error converting video: %w", err)
+			}
+		} else if res.audioTracks > 1 {
+			if err := res.keepAudioTrack(ctx); err != nil {
+				return nil, err
 			}
 		}
+
+		res.SupportsStreaming = isFastStart(res.Path)
 	}
 
+	ok = true
 	return res, nil
 }
 
+// download runs yt-dlp while holding a download slot, retrying when the
+// result is invalid. The slot is released before any conversion starts.
+func (media *Media) download(ctx context.Context) error {
+	if err := downloadLimiter.AcquireQueued(ctx, media.onQueued); err != nil {
+		return fmt.Errorf("gave up waiting for a download slot: %s", err)
+	}
+	defer downloadLimiter.Release()
+
+	if media.onStart != nil {
+		media.onStart()
+	}
+
+	start := time.Now()
+	defer func() { downloadDuration.Observe(time.Since(start).Seconds()) }()
+
+	for attempt := 1; ; attempt++ {
+		if err := media.runDownloadWithFallbacks(ctx); err != nil {
+			return err
+		}
+
+		if media.noNewItems() {
+			return errNoNewItems
+		}
+
+		err := media.validateDownload(ctx)
+		if err == nil {
+			return nil
+		}
+
+		media.removeLeftovers()
+		if attempt == maxDownloadAttempts {
+			return err
+		}
+		log.Printf("[%s]: %s, retrying (attempt %d of %d)", media.user, err, attempt+1, maxDownloadAttempts)
+	}
+}
+
+// defaultFormatFallbacks is the default of FORMAT_FALLBACKS.
+const defaultFormatFallbacks = "bv*+ba/b,best"
+
+// formatAttempt is one format selection a download is tried with.
+type formatAttempt struct {
+	format string
+	sort   string
+	// simplified leaves the format to yt-dlp and skips the optional
+	// extras, like subtitles, that can make a download fail.
+	simplified bool
+}
+
+func (a formatAttempt) String() string {
+	switch {
+	case a.simplified:
+		return "simplified"
+	case a.format == "" && a.sort == "":
+		return "yt-dlp default"
+	case a.sort == "":
+		return a.format
+	default:
+		return fmt.Sprintf("%s, sorted by %s", a.format, a.sort)
+	}
+}
+
+// formatAttempts returns the format selections to try in order: the site's
+// own, the FORMAT_FALLBACKS and finally a simplified download. A format
+// picked with /format is tried alone.
+func (media *Media) formatAttempts() []formatAttempt {
+	if media.formatID != "" {
+		return []formatAttempt{{format: media.formatID}}
+	}
+
+	host := lookupHostFormat(media.parsedUrl.Host)
+	site := formatAttempt{}
+	fallbackSort := ""
+	if media.audioOnly {
+		site.format = host.AudioFormat
+	} else {
+		site.format = host.Format
+		if host.Sort != "" {
+			site.sort = strings.ReplaceAll(host.Sort, "{res}", strconv.Itoa(media.maxResolution()))
+		} else if media.resolution > 0 {
+			// an explicitly requested resolution applies to any site
+			site.sort = "res:" + strconv.Itoa(media.resolution)
+		}
+		fallbackSort = "res:" + strconv.Itoa(media.maxResolution())
+	}
+
+	var attempts []formatAttempt
+	if media.language != "" {
+		attempts = append(attempts, formatAttempt{format: languageFormat(media.language, media.audioOnly), sort: fallbackSort})
+	}
+	attempts = append(attempts, site)
+	for _, format := range formatFallbacks {
+		attempt := formatAttempt{format: format, sort: fallbackSort}
+		if attempt != site {
+			attempts = append(attempts, attempt)
+		}
+	}
+	return append(attempts, formatAttempt{simplified: true})
+}
+
+// finalErrorCategories are failures that another format won't fix.
+var finalErrorCategories = map[errorCategory]bool{
+	errorUnsupported: true,
+	errorUnavailable: true,
+	errorPrivate:     true,
+	errorMembersOnly: true,
+	errorPassword:    true,
+	errorTimeout:     true,
+}
+
+// runDownloadWithFallbacks runs yt-dlp with each of the format attempts
+// until one succeeds. If all fail, the errors of every attempt are
+// returned together.
+func (media *Media) runDownloadWithFallbacks(ctx context.Context) error {
+	attempts := media.formatAttempts()
+
+	var errs []error
+	for i, attempt := range attempts {
+		media.attempt = attempt
+		log.Printf("[%s]: downloading with format '%s' (attempt %d of %d)", media.user, attempt, i+1, len(attempts))
+
+		err := media.runDownload(ctx)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("format '%s': %w", attempt, err))
+
+		var dlErr *DownloadError
+		if ctx.Err() != nil || (errors.As(err, &dlErr) && finalErrorCategories[dlErr.Category]) {
+			break
+		}
+		if i+1 < len(attempts) {
+			log.Printf("[%s]: download with format '%s' failed: %s", media.user, attempt, err)
+			media.removeLeftovers()
+		}
+	}
+
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errors.Join(errs...)
+}
+
+// runDownload runs yt-dlp once, killing it after DOWNLOAD_TIMEOUT_MINUTES.
+// The partial files of a download that timed out are removed.
+func (media *Media) runDownload(ctx context.Context) error {
+	dlCtx, cancel := context.WithTimeout(ctx, downloadTimeout)
+	defer cancel()
+
+	_, err := runCommandLines(dlCtx, media.user, media.getCommandString(), media.progressLine)
+	if err == nil || (media.usesDownloadArchive() && isMaxDownloadsExit(err)) {
+		return nil
+	}
+
+	if ctx.Err() == nil && dlCtx.Err() == context.DeadlineExceeded {
+		media.removeLeftovers()
+		return newTimeoutError("yt-dlp", downloadTimeout)
+	}
+	return newDownloadError(err)
+}
+
+// progressLine passes yt-dlp's progress to onProgress.
+func (media *Media) progressLine(line string) {
+	if media.onProgress == nil {
+		return
+	}
+	if percent, ok := parseProgress(line); ok {
+		media.onProgress(percent)
+	}
+}
+
+// removeExtras deletes the files kept next to the media, like subtitles
+// and the audio cover.
+func (media *Media) removeExtras() {
+	if media.coverPath != "" {
+		if err := os.Remove(media.coverPath); err != nil {
+			log.Printf("error deleting cover: %s", err)
+		}
+	}
+
+	if media.SubtitlePath != "" {
+		if err := os.Remove(media.SubtitlePath); err != nil {
+			log.Printf("error deleting subtitles: %s", err)
+		}
+	}
+}
+
 func (media *Media) Delete() error {
+	defer activeDownloads.Remove(media.randomName)
+	media.removeExtras()
+
 	if err := os.Remove(media.Path); err != nil {
 		return fmt.Errorf("error deleting file: %s", err)
 	}
@@ -150,6 +514,18 @@ func (media *Media) Delete() error {
 	return nil
 }
 
+// findSubtitles returns the subtitle file yt-dlp wrote next to the media,
+// or "" if there is none.
+func (media *Media) findSubtitles() string {
+	for _, ext := range []string{"vtt", "srt"} {
+		matches, err := filepath.Glob(filepath.Join(media.tmpDir, media.randomName+".*."+ext))
+		if err == nil && len(matches) > 0 {
+			return matches[0]
+		}
+	}
+	return ""
+}
+
 func (media *Media) GetFileSize() (int64, error) {
 	info, err := os.Stat(media.Path)
 	if err != nil {
@@ -158,58 +534,295 @@ func (media *Media) GetFileSize() (int64, error) {
 	return info.Size(), nil
 }
 
-func (media *Media) convert() error {
+func (media *Media) convert(ctx context.Context) error {
 	// we need to use ffmpeg to do some conversions
 	// this is the command to do that:
 	// ffmpeg -i downloaded_video.mp4 -c:v libx264 -c:a aac -strict -2 -movflags +faststart -vf "scale=1080:-2" -b:v 5000k output_video.mp4
 
 	outputPath := filepath.Join(media.tmpDir, media.randomName+"_converted.mp4")
 
+	strategy := media.determineConversionStrategy()
+
+	// the fallback gets what is left of the same time limit
+	convCtx, cancel := context.WithTimeout(ctx, ffmpegTimeout)
+	defer cancel()
+
+	err := media.encode(convCtx, outputPath, strategy)
+	if err != nil && strategy.HWAccel != "" && convCtx.Err() == nil {
+		log.Printf("[%s]: %s encoding failed, falling back to software encoding: %s", media.user, strategy.HWAccel, err)
+		strategy.HWAccel = ""
+		err = media.encode(convCtx, outputPath, strategy)
+	}
+	if err != nil {
+		if err := os.Remove(outputPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("error deleting partial conversion: %s", err)
+		}
+		if ctx.Err() == nil && convCtx.Err() == context.DeadlineExceeded {
+			return newTimeoutError("ffmpeg", ffmpegTimeout)
+		}
+		return err
+	}
+
+	media.Path = outputPath
+	media.FileName = media.randomName + "_converted.mp4"
+
+	if err := os.Remove(filepath.Join(media.tmpDir, media.randomName+".mp4")); err != nil {
+		log.Printf("error deleting original file: %s", err)
+	}
+
+	return nil
+}
+
+// encode runs ffmpeg to convert the media to outputPath.
+func (media *Media) encode(ctx context.Context, outputPath string, strategy conversionStrategy) error {
+	// two-pass encoding only makes sense when targeting a bitrate, and the
+	// hardware encoders do their own rate control
+	if twoPassEncoding && !strategy.isCRF() && strategy.HWAccel == "" {
+		passLogFile := filepath.Join(media.tmpDir, media.randomName+"_pass")
+		defer media.removePassLogs(passLogFile)
+
+		if _, err := runCommand(ctx, media.user, media.convertArgs(outputPath, strategy, 1, passLogFile)); err != nil {
+			return err
+		}
+		_, err := runCommand(ctx, media.user, media.convertArgs(outputPath, strategy, 2, passLogFile))
+		return err
+	}
+
+	_, err := runCommand(ctx, media.user, media.convertArgs(outputPath, strategy, 0, ""))
+	return err
+}
+
+// convertArgs builds the ffmpeg command line for the conversion. pass is 0
+// for single-pass encoding, or 1 or 2 for the passes of two-pass encoding,
+// which share the statistics in passLogFile. The first pass only analyzes
+// the video, so it skips audio and discards its output instead of writing
+// to outputPath.
+func (media *Media) convertArgs(outputPath string, strategy conversionStrategy, pass int, passLogFile string) []string {
 	var cmdSlice []string
 
 	cmdSlice = append(cmdSlice, "ffmpeg")
+	cmdSlice = append(cmdSlice, "-y")
+	if strategy.HWAccel == hwaccelVAAPI {
+		cmdSlice = append(cmdSlice, "-vaapi_device")
+		cmdSlice = append(cmdSlice, vaapiDevice)
+	}
 	cmdSlice = append(cmdSlice, "-i")
 	cmdSlice = append(cmdSlice, media.Path)
-	cmdSlice = append(cmdSlice, "-c:v")
-	cmdSlice = append(cmdSlice, "libx264")
+	cmdSlice = append(cmdSlice, media.streamMapArgs()...)
+	cmdSlice = append(cmdSlice, videoCodecArgs(strategy, convertPreset)...)
+	cmdSlice = append(cmdSlice, "-vf")
+	filters := videoWatermark.filterGraph(media.videoFilters())
+	if strategy.HWAccel == hwaccelVAAPI {
+		// the filters run in software, the frames are uploaded afterwards
+		filters += ",format=nv12,hwupload"
+	}
+	cmdSlice = append(cmdSlice, filters)
+	if fps := frameRateCap(media.frameRate, maxFPS); fps > 0 {
+		cmdSlice = append(cmdSlice, "-r")
+		cmdSlice = append(cmdSlice, strconv.Itoa(fps))
+	}
+
+	if pass > 0 {
+		cmdSlice = append(cmdSlice, "-pass")
+		cmdSlice = append(cmdSlice, strconv.Itoa(pass))
+		cmdSlice = append(cmdSlice, "-passlogfile")
+		cmdSlice = append(cmdSlice, passLogFile)
+	}
+
+	if pass == 1 {
+		cmdSlice = append(cmdSlice, "-an")
+		cmdSlice = append(cmdSlice, "-f")
+		cmdSlice = append(cmdSlice, "mp4")
+		cmdSlice = append(cmdSlice, os.DevNull)
+		return cmdSlice
+	}
+
 	cmdSlice = append(cmdSlice, "-c:a")
 	cmdSlice = append(cmdSlice, "aac")
 	cmdSlice = append(cmdSlice, "-strict")
 	cmdSlice = append(cmdSlice, "-2")
 	cmdSlice = append(cmdSlice, "-movflags")
 	cmdSlice = append(cmdSlice, "+faststart")
-	cmdSlice = append(cmdSlice, "-vf")
-	cmdSlice = append(cmdSlice, "scale=1080:-2")
-	cmdSlice = append(cmdSlice, "-b:v")
-	cmdSlice = append(cmdSlice, "5000k")
 	cmdSlice = append(cmdSlice, outputPath)
 
-	log.Printf("[%s]: executing command: '%s'", media.user, strings.Join(cmdSlice, " "))
+	return cmdSlice
+}
 
-	cmd := exec.Command(cmdSlice[0], cmdSlice[1:]...)
-	var out bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &stderr
+// streamMapArgs returns the ffmpeg options that keep exactly the video and
+// audio streams analyzeMedia picked, so the stream that was analyzed is the
+// one that is encoded. It returns nil to leave the choice to ffmpeg when
+// the file wasn't analyzed.
+func (media *Media) streamMapArgs() []string {
+	if media.videoTrack == nil {
+		return nil
+	}
 
-	if err := cmd.Run(); err != nil {
-		log.Printf("Output: %s\n", out.String())
-		log.Printf("Error: %s\n", stderr.String())
-		return err
+	args := []string{"-map", "0:" + strconv.Itoa(media.videoTrack.Index)}
+	if media.audioTrack != nil {
+		args = append(args, "-map", "0:"+strconv.Itoa(media.audioTrack.Index))
 	}
+	return args
+}
 
-	media.Path = outputPath
-	media.FileName = media.randomName + "_converted.mp4"
+// removePassLogs deletes the statistics files written by two-pass encoding.
+func (media *Media) removePassLogs(passLogFile string) {
+	matches, err := filepath.Glob(passLogFile + "*")
+	if err != nil {
+		return
+	}
 
-	if err := os.Remove(filepath.Join(media.tmpDir, media.randomName+".mp4")); err != nil {
-		log.Printf("error deleting original file: %s", err)
+	for _, match := range matches {
+		if err := os.Remove(match); err != nil {
+			log.Printf("error deleting pass log file: %s", err)
+		}
+	}
+}
+
+// validateDownload catches downloads that yt-dlp reported as successful
+// but that left an empty or unreadable file behind.
+func (media *Media) validateDownload(ctx context.Context) error {
+	size, err := media.GetFileSize()
+	if err != nil {
+		return fmt.Errorf("%w: %s", errInvalidDownload, err)
+	}
+	if size == 0 {
+		return fmt.Errorf("%w: file is empty", errInvalidDownload)
+	}
+
+	probe, err := runFFProbe(ctx, media.user, media.Path)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errInvalidDownload, err)
+	}
+	if len(probe.Streams) == 0 {
+		return fmt.Errorf("%w: no media streams found", errInvalidDownload)
+	}
+
+	media.probe = probe
+	return nil
+}
+
+// removeLeftovers deletes every file belonging to this download.
+func (media *Media) removeLeftovers() {
+	matches, err := filepath.Glob(filepath.Join(media.tmpDir, media.randomName+"*"))
+	if err != nil {
+		return
+	}
+
+	for _, match := range matches {
+		if err := os.Remove(match); err != nil {
+			log.Printf("error deleting file: %s", err)
+		}
+	}
+}
+
+// videoFilters returns the ffmpeg -vf filter chain used when converting.
+// ffmpeg applies the display rotation while decoding and drops it from the
+// output, so the filters see frames in the displayed orientation, which
+// media.Width and media.Height already describe.
+func (media *Media) videoFilters() []string {
+	var filters []string
+
+	if media.interlaced {
+		filters = append(filters, "yadif")
+	}
+
+	filters = append(filters, fmt.Sprintf("scale=%d:-2", convertWidth))
+
+	if padAspectRatio != nil {
+		if pad := padFilter(media.Width, media.Height, convertWidth, *padAspectRatio); pad != "" {
+			filters = append(filters, pad)
+		}
+	}
+
+	if customFilters != "" {
+		filters = append(filters, customFilters)
+	}
+
+	return filters
+}
+
+// conversionReason explains why the video has to be converted, or returns
+// "" if it can be sent as is.
+func (media *Media) conversionReason() string {
+	if strings.HasPrefix(media.VCodec, "av01") || strings.HasPrefix(media.VCodec, "vp09") {
+		return "video codec is not supported by iOS"
+	}
+
+	if padAspectRatio != nil && padFilter(media.Width, media.Height, convertWidth, *padAspectRatio) != "" {
+		return "video has to be padded to the configured aspect ratio"
+	}
+
+	if videoWatermark != nil {
+		return "watermark is configured"
+	}
+
+	// Telegram clients don't always honor the display matrix
+	if media.rotation != 0 {
+		return "video is rotated"
+	}
+
+	return ""
+}
+
+// analyzeMedia probes the downloaded file and fills in the dimensions and
+// duration if info.json lacked them, so Telegram can show a proper player.
+// It also records properties that affect conversion.
+func (media *Media) analyzeMedia(ctx context.Context) error {
+	probe := media.probe
+	if probe == nil {
+		var err error
+		if probe, err = runFFProbe(ctx, media.user, media.Path); err != nil {
+			return err
+		}
+	}
+
+	if stream := probe.bestVideoStream(); stream != nil {
+		media.videoTrack = stream
+		if media.Width == 0 || media.Height == 0 {
+			media.Width = stream.Width
+			media.Height = stream.Height
+		}
+
+		media.rotation = stream.rotation()
+		if media.rotation == 90 || media.rotation == 270 {
+			// the frame is stored sideways, Telegram needs the dimensions
+			// as displayed
+			media.Width = stream.Height
+			media.Height = stream.Width
+		}
+		if media.rotation != 0 {
+			log.Printf("[%s]: video is rotated by %d degrees, displayed as %dx%d", media.user, media.rotation, media.Width, media.Height)
+		}
+
+		if fps, ok := parseFrameRate(stream.RFrameRate); ok {
+			media.frameRate = fps
+		}
+
+		media.interlaced = isInterlaced(stream.FieldOrder)
+		if media.interlaced {
+			log.Printf("[%s]: video is interlaced (field order '%s')", media.user, stream.FieldOrder)
+		}
+	}
+
+	media.audioTrack = probe.selectBestAudioStream(media.language)
+	media.audioTracks = len(probe.audioStreams())
+	if media.audioTracks > 1 {
+		log.Printf("[%s]: %d audio tracks, keeping stream %d (language '%s')", media.user, media.audioTracks, media.audioTrack.Index, media.audioTrack.Tags["language"])
+	}
+
+	if media.Duration == 0 {
+		media.Duration = CustomDuration(math.Round(probe.duration()))
+		log.Printf("[%s]: duration taken from ffprobe: %ds", media.user, media.Duration)
 	}
 
 	return nil
 }
 
 func (media *Media) populateInfo() error {
-	jsonPath := filepath.Join(media.tmpDir, media.randomName+".info.json")
+	jsonPath, err := findInfoJSON(media.tmpDir, media.randomName)
+	if err != nil {
+		return err
+	}
 
 	buf, err := os.ReadFile(jsonPath)
 	if err != nil {
@@ -227,6 +840,95 @@ func (media *Media) populateInfo() error {
 	return nil
 }
 
+// findInfoJSON returns the info.json yt-dlp wrote for randomName. It is
+// usually <randomName>.info.json, but some extractors and yt-dlp versions
+// add to the name, so any <randomName>*.info.json is accepted as well.
+func findInfoJSON(dir string, randomName string) (string, error) {
+	exact := filepath.Join(dir, randomName+".info.json")
+	if _, err := os.Stat(exact); err == nil {
+		return exact, nil
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, randomName+"*.info.json"))
+	if err != nil {
+		return "", fmt.Errorf("error looking for json file: %s", err)
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("error reading json file '%s': not found", exact)
+	}
+
+	sort.Strings(matches)
+	return matches[0], nil
+}
+
+// commandError is returned by runCommand when the command fails. It keeps
+// the command's stderr for classification.
+type commandError struct {
+	err    error
+	stderr string
+}
+
+func (e *commandError) Error() string {
+	return e.err.Error()
+}
+
+func (e *commandError) Unwrap() error {
+	return e.err
+}
+
+// runCommand executes cmdSlice and returns its stdout. The output of a
+// failed command is logged.
+func runCommand(ctx context.Context, user string, cmdSlice []string) ([]byte, error) {
+	return runCommandLines(ctx, user, cmdSlice, nil)
+}
+
+// runCommandLines is runCommand that also passes every line of stdout to
+// onLine as soon as it is written, if onLine isn't nil.
+func runCommandLines(ctx context.Context, user string, cmdSlice []string, onLine func(string)) ([]byte, error) {
+	log.Printf("[%s]: executing command: '%s'", user, strings.Join(redactArgs(cmdSlice), " "))
+
+	cmd := exec.CommandContext(ctx, cmdSlice[0], cmdSlice[1:]...)
+	out := &lineWriter{onLine: onLine}
+	if onLine == nil {
+		out.onLine = func(string) {}
+	}
+	var stderr bytes.Buffer
+	cmd.Stdout = out
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		log.Printf("Output: %s\n", out.out.String())
+		log.Printf("Error: %s\n", stderr.String())
+		return out.out.Bytes(), &commandError{err: err, stderr: stderr.String()}
+	}
+
+	return out.out.Bytes(), nil
+}
+
+// videoPasswordFlag is the yt-dlp option whose value redactArgs hides.
+const videoPasswordFlag = "--video-password"
+
+// redactArgs returns a copy of cmdSlice that is safe to log.
+func redactArgs(cmdSlice []string) []string {
+	res := make([]string, len(cmdSlice))
+	copy(res, cmdSlice)
+	for i := 0; i < len(res)-1; i++ {
+		if res[i] == videoPasswordFlag {
+			res[i+1] = "***"
+		}
+	}
+	return res
+}
+
+// maxResolution is the resolution asked for with this download, or the
+// default one.
+func (media *Media) maxResolution() int {
+	if media.resolution > 0 {
+		return media.resolution
+	}
+	return getDefaultResolution()
+}
+
 func (media *Media) getCommandString() []string {
 	var res []string
 
@@ -236,6 +938,16 @@ func (media *Media) getCommandString() []string {
 		res = append(res, "-x")
 		res = append(res, "--audio-format")
 		res = append(res, "mp3")
+		if !media.voice {
+			res = append(res, "--write-thumbnail")
+			res = append(res, "--convert-thumbnails")
+			res = append(res, "jpg")
+		}
+	} else if media.remuxOnly {
+		// the container is fixed cheaply, conversionReason catches
+		// anything the format turned out to need after all
+		res = append(res, "--remux-video")
+		res = append(res, "mp4")
 	} else {
 		res = append(res, "--recode-video")
 		res = append(res, "mp4")
@@ -243,18 +955,56 @@ func (media *Media) getCommandString() []string {
 
 	res = append(res, "--write-info-json")
 
-	if media.parsedUrl.Host == "www.youtube.com" || media.parsedUrl.Host == "youtube.com" || media.parsedUrl.Host == "youtu.be" {
-		if !media.audioOnly {
-			res = append(res, "-f")
-			res = append(res, "bv[filesize<=1700M]+ba[filesize<=300M]")
-			res = append(res, "-S")
-			res = append(res, "ext,res:720")
-		}
+	if media.onProgress != nil {
+		res = append(res, "--newline")
+		res = append(res, "--progress")
 	}
 
-	if strings.Contains(media.parsedUrl.Host, "tiktok.com") {
+	if sendTranscripts && !media.attempt.simplified {
+		res = append(res, "--write-subs")
+		res = append(res, "--write-auto-subs")
+		res = append(res, "--sub-langs")
+		res = append(res, transcriptLangs)
+		res = append(res, "--sub-format")
+		res = append(res, "vtt/srt/best")
+	}
+
+	if media.section != nil {
+		res = append(res, "--download-sections")
+		res = append(res, media.section.downloadSectionsArg())
+		res = append(res, "--force-keyframes-at-cuts")
+	}
+
+	if downloadRateLimit != "" {
+		res = append(res, "-r")
+		res = append(res, downloadRateLimit)
+	}
+
+	if referer := refererFor(media.parsedUrl, autoReferer); referer != "" {
+		res = append(res, "--referer")
+		res = append(res, referer)
+	}
+
+	if media.password != "" {
+		res = append(res, videoPasswordFlag)
+		res = append(res, media.password)
+	}
+
+	if media.attempt.format != "" {
 		res = append(res, "-f")
-		res = append(res, "b[url!^=\"https://www.tiktok.com/\"]")
+		res = append(res, media.attempt.format)
+	}
+	if media.attempt.sort != "" {
+		res = append(res, "-S")
+		res = append(res, media.attempt.sort)
+	}
+
+	if media.usesDownloadArchive() {
+		// one new item per request, the rest is fetched next time
+		res = append(res, "--download-archive")
+		res = append(res, downloadArchivePath(downloadArchiveDir, media.user))
+		res = append(res, "--max-downloads")
+		res = append(res, "1")
 	}
 
 	res = append(res, "-o")
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMediaDownloadWaitsForDownloadSlot(t *testing.T) {
+	oldDownloads, oldConversions := downloadLimiter, conversionLimiter
+	defer func() { downloadLimiter, conversionLimiter = oldDownloads, oldConversions }()
+	downloadLimiter = newLimiter(1)
+	conversionLimiter = newLimiter(1)
+
+	if err := downloadLimiter.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer downloadLimiter.Release()
+
+	// conversions don't wait for downloads
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := conversionLimiter.Acquire(ctx); err != nil {
+		t.Fatalf("conversion slot not free while downloads are busy: %s", err)
+	}
+	conversionLimiter.Release()
+
+	started := false
+	queued := 0
+	media := &Media{
+		user:     "test",
+		onStart:  func() { started = true },
+		onQueued: func(position int) { queued = position },
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := media.download(ctx)
+	if err == nil || !strings.Contains(err.Error(), "download slot") {
+		t.Fatalf("download = %v, want an error about the download slot", err)
+	}
+	if started {
+		t.Error("onStart called without a download slot")
+	}
+	if queued != 1 {
+		t.Errorf("queued at position %d, want 1", queued)
+	}
+	if n := downloadLimiter.active; n != 1 {
+		t.Errorf("%d download slots held, want only the test's", n)
+	}
+}
+
+func TestMediaStreamMapArgs(t *testing.T) {
+	tests := []struct {
+		name  string
+		video *FFProbeStream
+		audio *FFProbeStream
+		want  []string
+	}{
+		{"not analyzed", nil, nil, nil},
+		{"video only", &FFProbeStream{Index: 0}, nil, []string{"-map", "0:0"}},
+		{"video and audio", &FFProbeStream{Index: 1}, &FFProbeStream{Index: 3}, []string{"-map", "0:1", "-map", "0:3"}},
+		{"audio without video", nil, &FFProbeStream{Index: 2}, nil},
+	}
+
+	for _, tt := range tests {
+		media := &Media{videoTrack: tt.video, audioTrack: tt.audio}
+		if got := media.streamMapArgs(); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: streamMapArgs() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMediaVideoFiltersDeinterlace(t *testing.T) {
+	oldPad, oldCustom := padAspectRatio, customFilters
+	defer func() { padAspectRatio, customFilters = oldPad, oldCustom }()
+	padAspectRatio, customFilters = nil, ""
+
+	tests := []struct {
+		interlaced bool
+		want       string
+	}{
+		{false, fmt.Sprintf("scale=%d:-2", convertWidth)},
+		{true, fmt.Sprintf("yadif,scale=%d:-2", convertWidth)},
+	}
+
+	for _, tt := range tests {
+		media := &Media{Width: 1920, Height: 1080, interlaced: tt.interlaced}
+		if got := strings.Join(media.videoFilters(), ","); got != tt.want {
+			t.Errorf("interlaced %v: videoFilters() = %q, want %q", tt.interlaced, got, tt.want)
+		}
+	}
+}
+
+func TestMediaConvertArgsPasses(t *testing.T) {
+	oldPad, oldCustom, oldWatermark := padAspectRatio, customFilters, videoWatermark
+	oldPreset, oldFPS := convertPreset, maxFPS
+	defer func() {
+		padAspectRatio, customFilters, videoWatermark = oldPad, oldCustom, oldWatermark
+		convertPreset, maxFPS = oldPreset, oldFPS
+	}()
+	padAspectRatio, customFilters, videoWatermark = nil, "", nil
+	convertPreset, maxFPS = "", 0
+
+	media := &Media{Path: "/tmp/in.webm", Width: 1920, Height: 1080}
+	strategy := conversionStrategy{Bitrate: 2000}
+	common := fmt.Sprintf("ffmpeg -y -i /tmp/in.webm -c:v libx264 -b:v 2000k -vf scale=%d:-2", convertWidth)
+	output := "-c:a aac -strict -2 -movflags +faststart /tmp/out.mp4"
+
+	tests := []struct {
+		pass int
+		want string
+	}{
+		{0, common + " " + output},
+		{1, common + " -pass 1 -passlogfile /tmp/passlog -an -f mp4 " + os.DevNull},
+		{2, common + " -pass 2 -passlogfile /tmp/passlog " + output},
+	}
+
+	for _, tt := range tests {
+		got := strings.Join(media.convertArgs("/tmp/out.mp4", strategy, tt.pass, "/tmp/passlog"), " ")
+		if got != tt.want {
+			t.Errorf("pass %d: convertArgs() =\n%s\nwant\n%s", tt.pass, got, tt.want)
+		}
+	}
+}
+
+func TestMediaRemovePassLogs(t *testing.T) {
+	dir := t.TempDir()
+	passLog := filepath.Join(dir, "abc.passlog")
+	for _, name := range []string{"abc.passlog-0.log", "abc.passlog-0.log.mbtree", "abc.mp4"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	(&Media{}).removePassLogs(passLog)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "abc.mp4" {
+		t.Errorf("files left after removePassLogs: %v", entries)
+	}
+}
+
+func TestMediaGetCommandStringRateLimit(t *testing.T) {
+	oldRateLimit := downloadRateLimit
+	defer func() { downloadRateLimit = oldRateLimit }()
+
+	u, _ := url.Parse("https://example.com/video")
+	media := &Media{url: u.String(), parsedUrl: u, tmpDir: "/tmp", randomName: "abc"}
+
+	tests := []struct {
+		rateLimit string
+		want      string
+	}{
+		{"", ""},
+		{"5M", "-r 5M"},
+	}
+
+	for _, tt := range tests {
+		downloadRateLimit = tt.rateLimit
+		got := strings.Join(media.getCommandString(), " ")
+		if tt.want == "" && strings.Contains(got, " -r ") || tt.want != "" && !strings.Contains(got, " "+tt.want+" ") {
+			t.Errorf("rate limit %q: getCommandString() = %q", tt.rateLimit, got)
+		}
+	}
+}
+
+func TestMediaValidateDownload(t *testing.T) {
+	oldTimeout := ffmpegTimeout
+	defer func() { ffmpegTimeout = oldTimeout }()
+	ffmpegTimeout = time.Minute
+
+	tests := []struct {
+		name    string
+		content string
+		ffprobe string
+		wantErr string
+	}{
+		{"valid", "data", `echo '{"streams": [{"index": 0, "codec_type": "video"}]}'`, ""},
+		{"missing", "", "", "no such file"},
+		{"empty", "-", "", "file is empty"},
+		{"unreadable", "data", "echo 'Invalid data found' >&2; exit 1", "ffprobe failed"},
+		{"no streams", "data", `echo '{"streams": []}'`, "no media streams"},
+	}
+
+	for _, tt := range tests {
+		fakeCommand(t, "ffprobe", tt.ffprobe)
+
+		dir := t.TempDir()
+		media := &Media{user: "test", tmpDir: dir, randomName: "abc", Path: filepath.Join(dir, "abc.mp4")}
+		switch tt.content {
+		case "":
+		case "-":
+			os.WriteFile(media.Path, nil, 0644)
+		default:
+			os.WriteFile(media.Path, []byte(tt.content), 0644)
+		}
+
+		err := media.validateDownload(context.Background())
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: validateDownload: %s", tt.name, err)
+			} else if media.probe == nil {
+				t.Errorf("%s: probe result not kept", tt.name)
+			}
+			continue
+		}
+		if !errors.Is(err, errInvalidDownload) || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: validateDownload error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMediaRemoveLeftovers(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"abc.mp4", "abc.info.json", "abc.en.vtt", "other.mp4"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	(&Media{tmpDir: dir, randomName: "abc"}).removeLeftovers()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "other.mp4" {
+		t.Errorf("files left after removeLeftovers: %v", entries)
+	}
+}
+
+func TestMediaAnalyzeMediaRotation(t *testing.T) {
+	oldPad, oldWatermark := padAspectRatio, videoWatermark
+	defer func() { padAspectRatio, videoWatermark = oldPad, oldWatermark }()
+	padAspectRatio, videoWatermark = nil, nil
+
+	tests := []struct {
+		rotate        string
+		width, height int
+		reason        string
+	}{
+		{"", 1920, 1080, ""},
+		{"90", 1080, 1920, "video is rotated"},
+		{"180", 1920, 1080, "video is rotated"},
+		{"270", 1080, 1920, "video is rotated"},
+	}
+
+	for _, tt := range tests {
+		stream := FFProbeStream{CodecType: "video", Width: 1920, Height: 1080, Tags: map[string]string{}}
+		if tt.rotate != "" {
+			stream.Tags["rotate"] = tt.rotate
+		}
+		media := &Media{user: "test", VCodec: "avc1", Duration: 10, probe: &FFProbeOutput{Streams: []FFProbeStream{stream}}}
+
+		if err := media.analyzeMedia(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if media.Width != tt.width || media.Height != tt.height {
+			t.Errorf("rotate %q: dimensions %dx%d, want %dx%d", tt.rotate, media.Width, media.Height, tt.width, tt.height)
+		}
+		if got := media.conversionReason(); got != tt.reason {
+			t.Errorf("rotate %q: conversionReason() = %q, want %q", tt.rotate, got, tt.reason)
+		}
+	}
+}
+
+func TestMediaVideoFiltersCustom(t *testing.T) {
+	oldPad, oldCustom := padAspectRatio, customFilters
+	defer func() { padAspectRatio, customFilters = oldPad, oldCustom }()
+	padAspectRatio = &aspectRatio{1, 1}
+	customFilters = "eq=contrast=1.1,hqdn3d"
+
+	media := &Media{Width: 1920, Height: 1080, interlaced: true}
+	want := fmt.Sprintf("yadif,scale=%d:-2,pad=1080:1080:(ow-iw)/2:(oh-ih)/2:black,eq=contrast=1.1,hqdn3d", convertWidth)
+	if got := strings.Join(media.videoFilters(), ","); got != want {
+		t.Errorf("videoFilters() = %q, want the custom filters last: %q", got, want)
+	}
+}
+
+func TestMediaGetCommandStringRemux(t *testing.T) {
+	u, _ := url.Parse("https://example.com/video")
+
+	tests := []struct {
+		name  string
+		media Media
+		want  string
+	}{
+		{"recode", Media{}, "--recode-video mp4"},
+		{"remux", Media{remuxOnly: true}, "--remux-video mp4"},
+		{"audio", Media{audioOnly: true, remuxOnly: true}, "-x --audio-format mp3"},
+	}
+
+	for _, tt := range tests {
+		media := tt.media
+		media.url, media.parsedUrl, media.tmpDir, media.randomName = u.String(), u, "/tmp", "abc"
+		got := strings.Join(media.getCommandString(), " ")
+		if !strings.HasPrefix(got, "yt-dlp "+tt.want+" ") {
+			t.Errorf("%s: getCommandString() = %q, want it to start with %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMediaConvertArgsFrameRate(t *testing.T) {
+	oldPad, oldCustom, oldWatermark := padAspectRatio, customFilters, videoWatermark
+	oldPreset, oldFPS := convertPreset, maxFPS
+	defer func() {
+		padAspectRatio, customFilters, videoWatermark = oldPad, oldCustom, oldWatermark
+		convertPreset, maxFPS = oldPreset, oldFPS
+	}()
+	padAspectRatio, customFilters, videoWatermark = nil, "", nil
+	convertPreset = ""
+
+	tests := []struct {
+		frameRate float64
+		maxFPS    int
+		want      string
+	}{
+		{60, 0, ""},
+		{60, 30, "-r 30"},
+		{25, 30, ""},
+	}
+
+	for _, tt := range tests {
+		maxFPS = tt.maxFPS
+		media := &Media{Path: "/tmp/in.webm", Width: 1920, Height: 1080, frameRate: tt.frameRate}
+		got := strings.Join(media.convertArgs("/tmp/out.mp4", conversionStrategy{Bitrate: 2000}, 0, ""), " ")
+		if tt.want == "" && strings.Contains(got, " -r ") || tt.want != "" && !strings.Contains(got, " "+tt.want+" ") {
+			t.Errorf("%v fps, cap %d: convertArgs() = %q", tt.frameRate, tt.maxFPS, got)
+		}
+	}
+}
+
+func TestFindInfoJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  string
+	}{
+		{"exact", []string{"abc.info.json", "abc.1.info.json"}, "abc.info.json"},
+		{"renamed", []string{"abc.NA.info.json"}, "abc.NA.info.json"},
+		{"first of several", []string{"abc.2.info.json", "abc.1.info.json"}, "abc.1.info.json"},
+		{"other download", []string{"other.info.json"}, ""},
+		{"none", nil, ""},
+	}
+
+	for _, tt := range tests {
+		dir := t.TempDir()
+		for _, name := range tt.files {
+			if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got, err := findInfoJSON(dir, "abc")
+		if tt.want == "" {
+			if err == nil || !strings.Contains(err.Error(), "not found") {
+				t.Errorf("%s: findInfoJSON = %q, %v, want not found", tt.name, got, err)
+			}
+		} else if err != nil || got != filepath.Join(dir, tt.want) {
+			t.Errorf("%s: findInfoJSON = %q, %v, want %s", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestMediaConvertArgsVAAPI(t *testing.T) {
+	oldPad, oldCustom, oldWatermark := padAspectRatio, customFilters, videoWatermark
+	oldPreset, oldFPS, oldDevice := convertPreset, maxFPS, vaapiDevice
+	defer func() {
+		padAspectRatio, customFilters, videoWatermark = oldPad, oldCustom, oldWatermark
+		convertPreset, maxFPS, vaapiDevice = oldPreset, oldFPS, oldDevice
+	}()
+	padAspectRatio, customFilters, videoWatermark = nil, "", nil
+	convertPreset, maxFPS, vaapiDevice = "", 0, "/dev/dri/renderD128"
+
+	media := &Media{Path: "/tmp/in.webm", Width: 1920, Height: 1080}
+	got := strings.Join(media.convertArgs("/tmp/out.mp4", conversionStrategy{CRF: 28, HWAccel: hwaccelVAAPI}, 0, ""), " ")
+	want := fmt.Sprintf("ffmpeg -y -vaapi_device /dev/dri/renderD128 -i /tmp/in.webm -c:v h264_vaapi -qp 28 -vf scale=%d:-2,format=nv12,hwupload ", convertWidth)
+	if !strings.HasPrefix(got, want) {
+		t.Errorf("convertArgs() =\n%s\nwant it to start with\n%s", got, want)
+	}
+}
+
+func TestMediaConvertHWAccelFallback(t *testing.T) {
+	oldMode, oldHWAccel, oldTwoPass, oldTimeout := convertMode, ffmpegHWAccel, twoPassEncoding, ffmpegTimeout
+	oldPad, oldCustom, oldWatermark := padAspectRatio, customFilters, videoWatermark
+	oldMaxSize, oldMin, oldMax := maxFileSize, minVideoBitrate, maxVideoBitrate
+	defer func() {
+		convertMode, ffmpegHWAccel, twoPassEncoding, ffmpegTimeout = oldMode, oldHWAccel, oldTwoPass, oldTimeout
+		padAspectRatio, customFilters, videoWatermark = oldPad, oldCustom, oldWatermark
+		maxFileSize, minVideoBitrate, maxVideoBitrate = oldMaxSize, oldMin, oldMax
+	}()
+	twoPassEncoding, ffmpegTimeout = true, time.Minute
+	maxFileSize, minVideoBitrate, maxVideoBitrate = 50*1024*1024, defaultMinVideoBitrate, defaultMaxVideoBitrate
+	padAspectRatio, customFilters, videoWatermark = nil, "", nil
+
+	const encoder = `for arg; do
+	if [ "$prev" = "-i" ]; then in=$arg; fi
+	if [ "$prev" = "-c:v" ]; then enc=$arg; fi
+	prev=$arg
+	out=$arg
+done
+echo "$enc" >> "$(dirname "$in")/encoders"
+`
+	tests := []struct {
+		name     string
+		mode     string
+		hwaccel  string
+		ffmpeg   string
+		encoders string
+		wantErr  bool
+	}{
+		{"software", convertModeCRF, "", encoder + `echo x > "$out"`, "libx264", false},
+		{"software two-pass", convertModeBitrate, "", encoder + `echo x > "$out"`, "libx264 libx264", false},
+		{"hardware", convertModeBitrate, hwaccelNVENC, encoder + `echo x > "$out"`, "h264_nvenc", false},
+		{"hardware fails", convertModeCRF, hwaccelNVENC, encoder + `[ "$enc" = h264_nvenc ] && exit 1; echo x > "$out"`, "h264_nvenc libx264", false},
+		{"both fail", convertModeCRF, hwaccelVAAPI, encoder + "exit 1", "h264_vaapi libx264", true},
+	}
+
+	for _, tt := range tests {
+		fakeCommand(t, "ffmpeg", tt.ffmpeg)
+		convertMode, ffmpegHWAccel = tt.mode, tt.hwaccel
+
+		dir := t.TempDir()
+		media := &Media{user: "test", tmpDir: dir, randomName: "abc", Path: filepath.Join(dir, "abc.mp4"), Width: 1920, Height: 1080, Duration: 60}
+		if err := os.WriteFile(media.Path, []byte("video"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		err := media.convert(context.Background())
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: convert error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+
+		data, _ := os.ReadFile(filepath.Join(dir, "encoders"))
+		if got := strings.Join(strings.Fields(string(data)), " "); got != tt.encoders {
+			t.Errorf("%s: encoders run %q, want %q", tt.name, got, tt.encoders)
+		}
+		if !tt.wantErr && media.Path != filepath.Join(dir, "abc_converted.mp4") {
+			t.Errorf("%s: media at %s after converting", tt.name, media.Path)
+		}
+	}
+}
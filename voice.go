@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// voiceBitrate is plenty for speech in a mono opus stream.
+const voiceBitrate = "48k"
+
+// voiceArgs returns the ffmpeg command that turns input into a mono opus
+// stream in an ogg container, the format Telegram plays as a voice message.
+func voiceArgs(input string, output string) []string {
+	return []string{
+		"ffmpeg",
+		"-y",
+		"-i", input,
+		"-vn",
+		"-map_metadata", "-1",
+		"-c:a", "libopus",
+		"-b:a", voiceBitrate,
+		"-ac", "1",
+		"-application", "voip",
+		"-f", "ogg",
+		output,
+	}
+}
+
+// convertToVoice replaces the downloaded audio with an ogg opus file.
+// Audio longer than VOICE_MAX_MINUTES is rejected.
+func (media *Media) convertToVoice(ctx context.Context) error {
+	if max := int(voiceMaxDuration.Seconds()); int(media.Duration) > max {
+		return fmt.Errorf("audio is too long for a voice message, the limit is %d minutes", max/60)
+	}
+
+	outputPath := filepath.Join(media.tmpDir, media.randomName+".ogg")
+
+	if err := conversionLimiter.Acquire(ctx); err != nil {
+		return fmt.Errorf("gave up waiting for a conversion slot: %s", err)
+	}
+	_, err := runCommand(ctx, media.user, voiceArgs(media.Path, outputPath))
+	conversionLimiter.Release()
+
+	if err != nil {
+		os.Remove(outputPath)
+		return fmt.Errorf("error converting to voice: %s", err)
+	}
+
+	if err := os.Remove(media.Path); err != nil {
+		log.Printf("error deleting original file: %s", err)
+	}
+	media.Path = outputPath
+	media.FileName = filepath.Base(outputPath)
+	media.ReducedBitrate = 0
+
+	return nil
+}
+
+// voiceParams returns the parameters for sending media as a voice message.
+func voiceParams(chatID int64, media *Media) *bot.SendVoiceParams {
+	return &bot.SendVoiceParams{
+		ChatID:   chatID,
+		Voice:    &models.InputFileString{Data: "file://" + localPath(media.Path)},
+		Duration: (int)(media.Duration),
+		Caption:  media.caption(),
+	}
+}
+
+func voiceHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.Message == nil {
+		log.Println("Received voice command with nil Message")
+		return
+	}
+	input := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/voice"))
+	handleDownload(ctx, b, update, input, DownloadOptions{AudioOnly: true, Voice: true}, "")
+}
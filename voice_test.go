@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMediaConvertToVoice(t *testing.T) {
+	oldConversions, oldMax := conversionLimiter, voiceMaxDuration
+	defer func() { conversionLimiter, voiceMaxDuration = oldConversions, oldMax }()
+	conversionLimiter = newLimiter(1)
+	voiceMaxDuration = 10 * time.Minute
+
+	tests := []struct {
+		name     string
+		ffmpeg   string
+		duration int
+		wantErr  string
+	}{
+		{"converted", `for arg; do out=$arg; done; echo opus > "$out"`, 60, ""},
+		{"at the limit", `for arg; do out=$arg; done; echo opus > "$out"`, 600, ""},
+		{"too long", "", 601, "limit is 10 minutes"},
+		{"ffmpeg fails", `for arg; do out=$arg; done; echo partial > "$out"; exit 1`, 60, "error converting to voice"},
+	}
+
+	for _, tt := range tests {
+		fakeCommand(t, "ffmpeg", tt.ffmpeg)
+
+		dir := t.TempDir()
+		source := filepath.Join(dir, "abc.mp3")
+		if err := os.WriteFile(source, []byte("mp3"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		media := &Media{user: "test", tmpDir: dir, randomName: "abc", Path: source, FileName: "abc.mp3",
+			Duration: CustomDuration(tt.duration), ReducedBitrate: 96}
+
+		err := media.convertToVoice(context.Background())
+		voicePath := filepath.Join(dir, "abc.ogg")
+
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: convertToVoice error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			if media.Path != source {
+				t.Errorf("%s: path changed to %s", tt.name, media.Path)
+			}
+			if _, err := os.Stat(voicePath); !os.IsNotExist(err) {
+				t.Errorf("%s: failed conversion left its output behind", tt.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: convertToVoice: %s", tt.name, err)
+			continue
+		}
+		if media.Path != voicePath || media.FileName != "abc.ogg" || media.ReducedBitrate != 0 {
+			t.Errorf("%s: media at %s named %s, reduced bitrate %d", tt.name, media.Path, media.FileName, media.ReducedBitrate)
+		}
+		if _, err := os.Stat(source); !os.IsNotExist(err) {
+			t.Errorf("%s: original audio not removed", tt.name)
+		}
+	}
+}
+
+func TestSendMediaVoice(t *testing.T) {
+	b := newTestBot(t)
+
+	path := filepath.Join(t.TempDir(), "abc.ogg")
+	if err := os.WriteFile(path, []byte("opus"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	media := &Media{Path: path, FileName: "abc.ogg", Duration: 42, voice: true, audioOnly: true}
+
+	if _, err := sendMedia(context.Background(), b.Bot, 1, media, true, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	voices := b.calls("sendVoice")
+	if len(voices) != 1 || len(b.calls("sendAudio")) != 0 {
+		t.Fatalf("sent %d voice messages and %d audios, want one voice message", len(voices), len(b.calls("sendAudio")))
+	}
+	if got := voices[0].fields["duration"]; got != "42" {
+		t.Errorf("voice duration %q, want 42", got)
+	}
+}
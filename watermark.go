@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// watermarkMargin is the distance of the watermark from the frame edges.
+const watermarkMargin = 10
+
+// watermark describes an optional overlay added to converted videos.
+type watermark struct {
+	Text     string
+	Image    string
+	Font     string
+	Position string
+	Opacity  float64
+}
+
+// watermarkPositions maps a position to the x and y expressions for
+// drawtext, whose text size is tw x th, and for overlay, whose image size
+// is w x h on a frame of W x H.
+var watermarkPositions = map[string][4]string{
+	"top-left":     {"%[1]d", "%[1]d", "%[1]d", "%[1]d"},
+	"top-right":    {"w-tw-%[1]d", "%[1]d", "W-w-%[1]d", "%[1]d"},
+	"bottom-left":  {"%[1]d", "h-th-%[1]d", "%[1]d", "H-h-%[1]d"},
+	"bottom-right": {"w-tw-%[1]d", "h-th-%[1]d", "W-w-%[1]d", "H-h-%[1]d"},
+}
+
+// escapeDrawtext makes text safe inside a single-quoted drawtext value.
+func escapeDrawtext(text string) string {
+	text = strings.ReplaceAll(text, `\`, `\\`)
+	return strings.ReplaceAll(text, "'", "’")
+}
+
+// textFilter returns the drawtext filter for a text watermark.
+func (w *watermark) textFilter() string {
+	pos := watermarkPositions[w.Position]
+	filter := fmt.Sprintf("drawtext=text='%s':expansion=none:fontcolor=white@%.2f:fontsize=h/20:borderw=2:bordercolor=black@%.2f:x=%s:y=%s",
+		escapeDrawtext(w.Text), w.Opacity, w.Opacity,
+		fmt.Sprintf(pos[0], watermarkMargin), fmt.Sprintf(pos[1], watermarkMargin))
+	if w.Font != "" {
+		filter += fmt.Sprintf(":fontfile='%s'", escapeDrawtext(w.Font))
+	}
+	return filter
+}
+
+// filterGraph joins the conversion filters and adds the watermark. A text
+// watermark is one more filter in the chain; an image is loaded with the
+// movie source and overlaid, which needs a graph with labeled pads.
+func (w *watermark) filterGraph(filters []string) string {
+	if w == nil {
+		return strings.Join(filters, ",")
+	}
+
+	if w.Image == "" {
+		return strings.Join(append(filters, w.textFilter()), ",")
+	}
+
+	pos := watermarkPositions[w.Position]
+	return fmt.Sprintf("[in]%s[base];movie='%s',format=rgba,colorchannelmixer=aa=%.2f[wm];[base][wm]overlay=%s:%s[out]",
+		strings.Join(filters, ","), escapeDrawtext(w.Image), w.Opacity,
+		fmt.Sprintf(pos[2], watermarkMargin), fmt.Sprintf(pos[3], watermarkMargin))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEscapeDrawtext(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"@marko", "@marko"},
+		{"it's mine", "it’s mine"},
+		{`C:\logo`, `C:\\logo`},
+	}
+
+	for _, tt := range tests {
+		if got := escapeDrawtext(tt.text); got != tt.want {
+			t.Errorf("escapeDrawtext(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestWatermarkFilterGraph(t *testing.T) {
+	filters := []string{"yadif", "scale=720:-2"}
+
+	tests := []struct {
+		name string
+		w    *watermark
+		want string
+	}{
+		{"none", nil, "yadif,scale=720:-2"},
+		{"text", &watermark{Text: "@marko", Position: "bottom-right", Opacity: 0.5},
+			"yadif,scale=720:-2,drawtext=text='@marko':expansion=none:fontcolor=white@0.50:fontsize=h/20:borderw=2:bordercolor=black@0.50:x=w-tw-10:y=h-th-10"},
+		{"text with a font", &watermark{Text: "it's", Font: "/fonts/a.ttf", Position: "top-left", Opacity: 1},
+			"yadif,scale=720:-2,drawtext=text='it’s':expansion=none:fontcolor=white@1.00:fontsize=h/20:borderw=2:bordercolor=black@1.00:x=10:y=10:fontfile='/fonts/a.ttf'"},
+		{"image", &watermark{Image: "/logo.png", Position: "top-right", Opacity: 0.3},
+			"[in]yadif,scale=720:-2[base];movie='/logo.png',format=rgba,colorchannelmixer=aa=0.30[wm];[base][wm]overlay=W-w-10:10[out]"},
+		{"image bottom left", &watermark{Image: "/logo.png", Position: "bottom-left", Opacity: 0.5},
+			"[in]yadif,scale=720:-2[base];movie='/logo.png',format=rgba,colorchannelmixer=aa=0.50[wm];[base][wm]overlay=10:H-h-10[out]"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.w.filterGraph(filters); got != tt.want {
+			t.Errorf("%s: filterGraph() =\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadWatermark(t *testing.T) {
+	oldWatermark := videoWatermark
+	defer func() { videoWatermark = oldWatermark }()
+
+	image := filepath.Join(t.TempDir(), "logo.png")
+	if err := os.WriteFile(image, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		text     string
+		image    string
+		position string
+		opacity  string
+		want     *watermark
+	}{
+		{"unset", "", "", "", "", nil},
+		{"text", "@marko", "", "", "", &watermark{Text: "@marko", Position: "bottom-right", Opacity: 0.5}},
+		{"position and opacity", "@marko", "", "top-left", "0.8", &watermark{Text: "@marko", Position: "top-left", Opacity: 0.8}},
+		{"invalid position and opacity", "@marko", "", "middle", "2", &watermark{Text: "@marko", Position: "bottom-right", Opacity: 0.5}},
+		{"image", "", image, "", "", &watermark{Image: image, Position: "bottom-right", Opacity: 0.5}},
+		{"missing image", "", image + ".missing", "", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("WATERMARK_TEXT", tt.text)
+		t.Setenv("WATERMARK_IMAGE", tt.image)
+		t.Setenv("WATERMARK_POSITION", tt.position)
+		t.Setenv("WATERMARK_OPACITY", tt.opacity)
+		videoWatermark = nil
+
+		loadWatermark()
+
+		switch {
+		case tt.want == nil && videoWatermark != nil:
+			t.Errorf("%s: watermark %+v, want none", tt.name, *videoWatermark)
+		case tt.want != nil && (videoWatermark == nil || *videoWatermark != *tt.want):
+			t.Errorf("%s: watermark %+v, want %+v", tt.name, videoWatermark, *tt.want)
+		}
+	}
+}
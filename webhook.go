@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+)
+
+// Values of BOT_MODE.
+const (
+	botModePolling = "polling"
+	botModeWebhook = "webhook"
+)
+
+// webhookPath is where the webhook listener receives updates.
+const webhookPath = "/webhook"
+
+// webhookSecretHeader carries the secret token Telegram sends with every
+// webhook request.
+const webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// checkWebhookSecret passes on only the requests that carry secret in
+// webhookSecretHeader. The library's handler doesn't check it itself.
+func checkWebhookSecret(secret string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := r.Header.Get(webhookSecretHeader)
+		if subtle.ConstantTimeCompare([]byte(got), []byte(secret)) != 1 {
+			log.Printf("Rejected webhook request from %s without a valid secret", r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// startWebhook serves updates on WEBHOOK_LISTEN, separate from the file
+// server on :8080, and tells Telegram to send them to WEBHOOK_URL.
+func startWebhook(ctx context.Context, b *bot.Bot) error {
+	mux := http.NewServeMux()
+	mux.Handle(webhookPath, checkWebhookSecret(webhookSecret, b.WebhookHandler()))
+
+	server := &http.Server{Addr: webhookListen, Handler: mux}
+	listener, err := net.Listen("tcp", webhookListen)
+	if err != nil {
+		return fmt.Errorf("error listening for the webhook on %s: %s", webhookListen, err)
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Webhook listener stopped: %s", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	go b.StartWebhook(ctx)
+
+	ok, err := b.SetWebhook(ctx, &bot.SetWebhookParams{
+		URL:         strings.TrimRight(webhookURL, "/") + webhookPath,
+		SecretToken: webhookSecret,
+	})
+	if err != nil {
+		return fmt.Errorf("error setting webhook: %s", err)
+	}
+	if !ok {
+		return fmt.Errorf("SetWebhook did not return true")
+	}
+	return nil
+}
+
+// deleteWebhook switches the bot back to polling, so a version of the bot
+// started without BOT_MODE=webhook gets updates. It runs after ctx was
+// cancelled, so it uses its own.
+func deleteWebhook(b *bot.Bot) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := b.DeleteWebhook(ctx, &bot.DeleteWebhookParams{}); err != nil {
+		log.Printf("Error deleting webhook: %s", err)
+		return
+	}
+	log.Println("Webhook deleted")
+}
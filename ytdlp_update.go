@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/mkevac/markodownloadbot/stats"
+)
+
+// ytDlpReleaseURL is the GitHub API endpoint describing the latest yt-dlp
+// release.
+var ytDlpReleaseURL = "https://api.github.com/repos/yt-dlp/yt-dlp/releases/latest"
+
+const (
+	// ytDlpNotifiedConfigKey saves the release the admin was last told
+	// about, so restarts don't repeat the message.
+	ytDlpNotifiedConfigKey = "ytdlp_update_notified"
+
+	ytDlpUpdateInterval = 24 * time.Hour
+)
+
+// latestYtDlpVersion returns the version of the latest yt-dlp release, e.g.
+// "2024.08.06".
+func latestYtDlpVersion(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ytDlpReleaseURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error fetching the latest yt-dlp release: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error fetching the latest yt-dlp release: %s", resp.Status)
+	}
+
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return "", fmt.Errorf("error parsing the latest yt-dlp release: %s", err)
+	}
+	return strings.TrimPrefix(release.TagName, "v"), nil
+}
+
+// olderVersion reports whether yt-dlp version a is older than b. Versions
+// are dates like "2024.08.06", nightly builds add the time, e.g.
+// "2024.08.06.232712".
+func olderVersion(a, b string) bool {
+	pa, pb := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		na, errA := strconv.Atoi(pa[i])
+		nb, errB := strconv.Atoi(pb[i])
+		if errA != nil || errB != nil {
+			return pa[i] < pb[i]
+		}
+		if na != nb {
+			return na < nb
+		}
+	}
+	return len(pa) < len(pb)
+}
+
+// updateYtDlp runs "yt-dlp -U". yt-dlp installed with a package manager,
+// like in the Docker image, can't update itself and says so.
+func updateYtDlp(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	out, err := runCommand(ctx, "system", []string{"yt-dlp", "-U"})
+	if err != nil {
+		log.Printf("Error updating yt-dlp: %s", err)
+		return
+	}
+	log.Printf("yt-dlp -U: %s", strings.TrimSpace(string(out)))
+
+	// /version looks the versions up again
+	toolVersionsMu.Lock()
+	toolVersionsChecked = time.Time{}
+	toolVersionsMu.Unlock()
+}
+
+// checkYtDlpVersion tells the admin when a newer yt-dlp was released than
+// the one installed, once per release.
+func checkYtDlpVersion(ctx context.Context, b *bot.Bot) {
+	installed, err := toolVersion(ctx, "yt-dlp")
+	if err != nil {
+		log.Printf("Error getting yt-dlp version: %s", err)
+		return
+	}
+
+	latest, err := latestYtDlpVersion(ctx)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	if !olderVersion(installed, latest) {
+		log.Printf("yt-dlp %s is up to date", installed)
+		return
+	}
+	log.Printf("yt-dlp %s is outdated, the latest release is %s", installed, latest)
+
+	if notified, _ := stats.GetConfig(ytDlpNotifiedConfigKey); notified == latest || adminChatID.Load() == 0 {
+		return
+	}
+
+	sendMessageToAdmin(ctx, b, fmt.Sprintf("yt-dlp %s is outdated, %s was released. Downloads from YouTube and other sites may break until it's updated.", installed, latest))
+	if err := stats.SetConfig(ytDlpNotifiedConfigKey, latest); err != nil {
+		log.Printf("Error saving yt-dlp update notification: %s", err)
+	}
+}
+
+// runYtDlpUpdates updates yt-dlp and checks for newer releases, as enabled
+// by YTDLP_AUTOUPDATE and YTDLP_UPDATE_CHECK, once a day until ctx is
+// done. The first run is right after startup.
+func runYtDlpUpdates(ctx context.Context, b *bot.Bot) {
+	ticker := time.NewTicker(ytDlpUpdateInterval)
+	defer ticker.Stop()
+
+	for {
+		if ytDlpAutoUpdate {
+			updateYtDlp(ctx)
+		}
+		if ytDlpUpdateCheck {
+			checkYtDlpVersion(ctx, b)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
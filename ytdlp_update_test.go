@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkevac/markodownloadbot/stats"
+)
+
+func TestOlderVersion(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"2024.08.06", "2024.08.06", false},
+		{"2024.07.25", "2024.08.06", true},
+		{"2024.08.06", "2024.07.25", false},
+		{"2023.12.30", "2024.01.01", true},
+		{"2024.8.6", "2024.08.06", false},
+		{"2024.08.06", "2024.08.06.232712", true},
+		{"2024.08.06.232712", "2024.08.06", false},
+		{"2024.08.06.100000", "2024.08.06.232712", true},
+	}
+
+	for _, tt := range tests {
+		if got := olderVersion(tt.a, tt.b); got != tt.want {
+			t.Errorf("olderVersion(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// fakeReleaseServer answers yt-dlp release lookups with status and body.
+func fakeReleaseServer(t *testing.T, status int, body string) {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	oldURL := ytDlpReleaseURL
+	ytDlpReleaseURL = server.URL
+	t.Cleanup(func() { ytDlpReleaseURL = oldURL })
+}
+
+func TestLatestYtDlpVersion(t *testing.T) {
+	tests := []struct {
+		status  int
+		body    string
+		want    string
+		wantErr string
+	}{
+		{http.StatusOK, `{"tag_name": "2024.08.06"}`, "2024.08.06", ""},
+		{http.StatusOK, `{"tag_name": "v2024.08.06"}`, "2024.08.06", ""},
+		{http.StatusForbidden, `{"message": "rate limited"}`, "", "403 Forbidden"},
+		{http.StatusOK, `not json`, "", "error parsing"},
+	}
+
+	for _, tt := range tests {
+		fakeReleaseServer(t, tt.status, tt.body)
+
+		got, err := latestYtDlpVersion(context.Background())
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%d %s: latestYtDlpVersion error = %v, want %q", tt.status, tt.body, err, tt.wantErr)
+			}
+		} else if err != nil || got != tt.want {
+			t.Errorf("%d %s: latestYtDlpVersion = %q, %v, want %q", tt.status, tt.body, got, err, tt.want)
+		}
+	}
+}
+
+func TestCheckYtDlpVersion(t *testing.T) {
+	oldChat := adminChatID.Load()
+	defer func() {
+		adminChatID.Store(oldChat)
+		stats.SetConfig(ytDlpNotifiedConfigKey, "")
+	}()
+	adminChatID.Store(99)
+	stats.SetConfig(ytDlpNotifiedConfigKey, "")
+
+	tests := []struct {
+		name      string
+		installed string
+		latest    string
+		notified  bool
+	}{
+		{"up to date", "2024.08.06", "2024.08.06", false},
+		{"outdated", "2024.07.25", "2024.08.06", true},
+		{"already told", "2024.07.25", "2024.08.06", false},
+		{"newer release", "2024.07.25", "2024.09.01", true},
+	}
+
+	for _, tt := range tests {
+		fakeCommand(t, "yt-dlp", "echo "+tt.installed)
+		fakeReleaseServer(t, http.StatusOK, `{"tag_name": "`+tt.latest+`"}`)
+		b := newTestBot(t)
+
+		checkYtDlpVersion(context.Background(), b.Bot)
+
+		texts := b.sentTexts()
+		if notified := len(texts) > 0; notified != tt.notified {
+			t.Errorf("%s: sent %q, want a notification %v", tt.name, texts, tt.notified)
+		} else if notified && !strings.Contains(texts[0], tt.installed+" is outdated, "+tt.latest+" was released") {
+			t.Errorf("%s: sent %q", tt.name, texts[0])
+		}
+	}
+
+	if notified, _ := stats.GetConfig(ytDlpNotifiedConfigKey); notified != "2024.09.01" {
+		t.Errorf("last notified release %q, want 2024.09.01", notified)
+	}
+}
+
+func TestUpdateYtDlp(t *testing.T) {
+	defer func() {
+		toolVersionsMu.Lock()
+		toolVersionsChecked = time.Time{}
+		toolVersionsMu.Unlock()
+	}()
+
+	tests := []struct {
+		script string
+		reset  bool
+	}{
+		{`[ "$1" = -U ] && echo "Updated yt-dlp to stable@2024.08.06"`, true},
+		{"echo 'ERROR: You installed yt-dlp with pip' >&2; exit 1", false},
+	}
+
+	for _, tt := range tests {
+		fakeCommand(t, "yt-dlp", tt.script)
+		checked := time.Now()
+		toolVersionsMu.Lock()
+		toolVersionsChecked = checked
+		toolVersionsMu.Unlock()
+
+		updateYtDlp(context.Background())
+
+		toolVersionsMu.RLock()
+		reset := toolVersionsChecked.IsZero()
+		toolVersionsMu.RUnlock()
+		if reset != tt.reset {
+			t.Errorf("%q: versions looked up again = %v, want %v", tt.script, reset, tt.reset)
+		}
+	}
+}